@@ -0,0 +1,411 @@
+/*
+Package implements append-only log of storage mutations for recovery after crash.
+
+Log observes mutations of embedded cache and writes them as commands of ascii protocol with "noreply" and absolute
+expiration timestamps (see protocol.EncodeMutation), so replay on startup restores original expiration.
+Durability is defined by fsync policy: "always" syncs file after each mutation (before method of cache returns,
+but after lock of storage is released, so concurrent mutations share syncs), "everysec" syncs it once per second
+in background and "no" leaves it to operating system.
+
+When log grows RewritePercentage percents beyond its size after the last rewrite (and exceeds RewriteMinSize),
+it is rewritten in background from snapshot of cache: snapshot is read in batches and written to temporary file
+along with mutations made meanwhile, and temporary file atomically replaces the log.
+
+If the last command of log is incomplete (e.g. process was killed while writing it), replay truncates it.
+*/
+package aof
+
+import (
+	"bufio"
+	"embedded"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"tools"
+	"tools/cache"
+	"tools/protocol"
+)
+
+// Fsync policies.
+const (
+	FSYNC_ALWAYS = "always"
+	FSYNC_EVERYSEC = "everysec"
+	FSYNC_NO = "no"
+)
+
+const (
+	// Defines default growth of log (in percents of its size after the last rewrite), which triggers rewrite.
+	DEFAULT_REWRITE_PERCENTAGE = 100
+	// Defines default minimal size of log, which may be rewritten.
+	DEFAULT_REWRITE_MIN_SIZE = 64 * 1024 * 1024
+	// Suffix of temporary file of rewrite.
+	REWRITE_SUFFIX = ".rewrite"
+)
+
+var (
+	// Passed fsync policy isn't supported.
+	ErrUnknownPolicy = errors.New("Unknown fsync policy.")
+	// Rewrite was requested while another one is running.
+	ErrRewriteInProgress = errors.New("Rewrite of append-only log is already in progress.")
+	// Log was closed.
+	ErrClosed = errors.New("Append-only log is closed.")
+)
+
+// Structure of append-only log.
+type Log struct {
+	path string
+	policy string
+	cache *embedded.Cache
+	file *os.File
+	size int64
+	base_size int64 // size after the last rewrite or replay
+	dirty bool // there are writes, which weren't synced yet
+	rewriting bool
+	rewrites uint64
+	truncated int64 // number of bytes of incomplete tail discarded by replay
+	last_error error
+	closed bool
+	done chan bool
+	mutex sync.Mutex
+	// Growth of log in percents of its base size, which triggers rewrite; zero disables automatic rewrite.
+	RewritePercentage int
+	// Minimal size of log, which may be rewritten automatically.
+	RewriteMinSize int64
+}
+
+// Function opens log at passed path (creating it if it's missing), replays its mutations into passed cache
+// and starts to append new mutations of cache to it. Cache is supposed to be empty and unused before.
+func Open(path string, policy string, cache *embedded.Cache) (*Log, error) {
+	if !tools.In(policy, []string{FSYNC_ALWAYS, FSYNC_EVERYSEC, FSYNC_NO}) {
+		return nil, ErrUnknownPolicy
+	}
+	file, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log := &Log{
+		path: path,
+		policy: policy,
+		cache: cache,
+		file: file,
+		done: make(chan bool),
+		RewritePercentage: DEFAULT_REWRITE_PERCENTAGE,
+		RewriteMinSize: DEFAULT_REWRITE_MIN_SIZE,
+	}
+	if err := log.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(log.size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	os.Remove(path + REWRITE_SUFFIX) // leftover of interrupted rewrite
+	cache.Subscribe(log)
+	if policy == FSYNC_EVERYSEC {
+		go log.syncLoop()
+	}
+	return log, nil
+}
+
+// Private method, which applies commands of log to cache. Incomplete command at the end of log is truncated,
+// any other malformed command fails replay.
+func (l *Log) replay() error {
+	reader := bufio.NewReader(l.file)
+	var offset int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return l.truncate(offset)
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		n, err := protocol.ApplyMutation(strings.TrimSuffix(line, "\r\n"), reader, l.cache)
+		if err == io.ErrUnexpectedEOF {
+			return l.truncate(offset)
+		} else if err != nil {
+			return errors.New("Append-only log is corrupted at offset " + tools.IntToString(offset) + ": " + err.Error())
+		}
+		offset += int64(len(line) + n)
+	}
+	l.size = offset
+	l.base_size = offset
+	return nil
+}
+
+// Private method, which discards incomplete tail of log starting from passed offset.
+func (l *Log) truncate(offset int64) error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.truncated = info.Size() - offset
+	l.size = offset
+	l.base_size = offset
+	return nil
+}
+
+// Implementation of embedded.Observer: appends mutation to log.
+// It's called under lock of storage, so mutations are written in order of their application.
+func (l *Log) Mutated(mutation *embedded.Mutation) {
+	data := protocol.EncodeMutation(mutation)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		l.last_error = err
+		return
+	}
+	l.dirty = true
+	if !l.rewriting && l.RewritePercentage > 0 && l.size >= l.RewriteMinSize &&
+	   l.size >= l.base_size + l.base_size * int64(l.RewritePercentage) / 100 {
+		l.rewriting = true
+		go l.rewrite()
+	}
+}
+
+// Implementation of embedded.Committer: syncs written mutations, if policy is "always".
+func (l *Log) Committed() {
+	if l.policy != FSYNC_ALWAYS {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.closed {
+		l.sync()
+	}
+}
+
+// Private method, which syncs file. Mutex should be held by caller.
+func (l *Log) sync() {
+	if !l.dirty {
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		l.last_error = err
+		return
+	}
+	l.dirty = false
+}
+
+// Private method, which syncs file once per second until log will be closed.
+func (l *Log) syncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mutex.Lock()
+			l.sync()
+			l.mutex.Unlock()
+		}
+	}
+}
+
+// Structure, which collects mutations made during rewrite.
+type rewriteBuffer struct {
+	data []byte
+	collected int // number of collected bytes, including taken ones
+	taken int
+	mutex sync.Mutex
+}
+
+// Implementation of embedded.Observer.
+func (b *rewriteBuffer) Mutated(mutation *embedded.Mutation) {
+	data := protocol.EncodeMutation(mutation)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.data = append(b.data, data...)
+	b.collected += len(data)
+}
+
+// Private method, which takes collected data up to passed number of collected bytes
+// (or all collected data, if it's negative).
+func (b *rewriteBuffer) take(until int) []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if until < 0 {
+		until = b.collected
+	}
+	data := b.data[ : until - b.taken]
+	b.data = b.data[until - b.taken : ]
+	b.taken = until
+	return data
+}
+
+// Function rewrites log from snapshot of cache and waits for its completion.
+func (l *Log) Rewrite() error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return ErrClosed
+	}
+	if l.rewriting {
+		l.mutex.Unlock()
+		return ErrRewriteInProgress
+	}
+	l.rewriting = true
+	l.mutex.Unlock()
+	return l.rewrite()
+}
+
+// Private method, which does rewrite. Flag rewriting should be set by caller.
+func (l *Log) rewrite() error {
+	err := l.doRewrite()
+	l.mutex.Lock()
+	l.rewriting = false
+	if err != nil {
+		l.last_error = err
+	} else {
+		l.rewrites ++
+	}
+	l.mutex.Unlock()
+	return err
+}
+
+// Private method, which writes snapshot and mutations made meanwhile to temporary file and replaces log by it.
+// Snapshot is read in batches; mutations made before batch was read are written before it, since it reflects them.
+// Lock of storage is held only to read headers of each batch and to write the tail of mutations and replace log.
+func (l *Log) doRewrite() error {
+	temp_path := l.path + REWRITE_SUFFIX
+	temp, err := os.Create(temp_path)
+	if err != nil {
+		return err
+	}
+	buffer := new(rewriteBuffer)
+	keys := l.cache.Subscribe(buffer)
+	defer l.cache.Unsubscribe(buffer)
+	writer := bufio.NewWriter(temp)
+	var size int64
+	for start := 0; start < len(keys); start += embedded.SNAPSHOT_BATCH {
+		end := start + embedded.SNAPSHOT_BATCH
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var barrier int
+		batch := l.cache.Snapshot(keys[start : end], func() { barrier = buffer.collected })
+		n, _ := writer.Write(buffer.take(barrier))
+		size += int64(n)
+		for _, item := range batch {
+			n, _ := writer.Write(protocol.EncodeMutation(item.Mutation()))
+			size += int64(n)
+		}
+	}
+	// mutations collected while snapshot was written go to file before lock is taken, so only the tail is left.
+	n, _ := writer.Write(buffer.take(-1))
+	size += int64(n)
+	if err = writer.Flush(); err == nil {
+		err = temp.Sync()
+	}
+	if err != nil {
+		temp.Close()
+		os.Remove(temp_path)
+		return err
+	}
+	// mutations are blocked while the tail is written and log is replaced.
+	l.cache.Locked(func(_ *cache.LRUCache) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if l.closed {
+			err = ErrClosed
+			return
+		}
+		if tail := buffer.take(-1); len(tail) > 0 {
+			var n int
+			n, err = temp.Write(tail)
+			size += int64(n)
+			if err == nil {
+				err = temp.Sync()
+			}
+		}
+		if err == nil {
+			err = os.Rename(temp_path, l.path)
+		}
+		if err != nil {
+			return
+		}
+		syncDir(l.path)
+		l.file.Close()
+		l.file = temp
+		l.size = size
+		l.base_size = size
+		l.dirty = false
+	})
+	if err != nil {
+		temp.Close()
+		os.Remove(temp_path)
+	}
+	return err
+}
+
+// Function syncs directory of passed file, so renaming of file becomes durable.
+func syncDir(path string) {
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+}
+
+// Function syncs and closes log; mutations made after it aren't written.
+func (l *Log) Close() error {
+	l.cache.Unsubscribe(l)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.closed = true
+	close(l.done)
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// Function returns lines of statistic of log: fsync policy, current size and size after the last rewrite,
+// number of rewrites and its state, amount of truncated bytes and the last error.
+func (l *Log) Stats() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	result := []string{
+		"aof_path " + l.path,
+		"aof_fsync " + l.policy,
+		"aof_size " + tools.IntToString(l.size),
+		"aof_base_size " + tools.IntToString(l.base_size),
+		"aof_rewrites " + tools.UIntToString(l.rewrites),
+		"aof_rewrite_in_progress " + boolToString(l.rewriting),
+		"aof_truncated_bytes " + tools.IntToString(l.truncated),
+	}
+	if l.last_error != nil {
+		result = append(result, "aof_last_error " + l.last_error.Error())
+	}
+	return result
+}
+
+// Function converts boolean value into "1" or "0".
+func boolToString(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
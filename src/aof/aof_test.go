@@ -0,0 +1,220 @@
+package aof
+
+import (
+	"testing"
+	"embedded"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"tools"
+)
+
+// Function returns path of log in new temporary directory.
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "aof")
+	if err != nil {
+		t.Fatalf("Temporary directory wasn't created: %s", err)
+	}
+	return filepath.Join(dir, "appendonly.aof"), func() { os.RemoveAll(dir) }
+}
+
+func TestReplay(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	cache := embedded.New(1 << 20)
+	log, err := Open(path, FSYNC_ALWAYS, cache)
+	if err != nil {
+		t.Fatalf("Log wasn't opened: %s", err)
+	}
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	cache.Set(&embedded.Item{Key: "key", Value: []byte("value"), Flags: 42, Expiration: expiration})
+	// mutations of "always" policy are synced, when method of cache returns.
+	if log.dirty {
+		t.Fatalf("Mutation wasn't synced")
+	}
+	cache.Set(&embedded.Item{Key: "counter", Value: []byte("10")})
+	cache.Increment("counter", 5)
+	cache.Set(&embedded.Item{Key: "deleted", Value: []byte("value")})
+	cache.Delete("deleted")
+	cache.Set(&embedded.Item{Key: "expired", Value: []byte("value"), TTL: time.Hour})
+	cache.TouchAt("expired", time.Now().Add(-time.Second))
+	if err := log.Close(); err != nil {
+		t.Fatalf("Log wasn't closed: %s", err)
+	}
+	cache.Set(&embedded.Item{Key: "unlogged", Value: []byte("value")})
+
+	restored := embedded.New(1 << 20)
+	log, err = Open(path, FSYNC_NO, restored)
+	if err != nil {
+		t.Fatalf("Log wasn't replayed: %s", err)
+	}
+	defer log.Close()
+	item, err := restored.Get("key")
+	if err != nil || string(item.Value) != "value" || item.Flags != 42 || !item.Expiration.Equal(expiration) {
+		t.Fatalf("Item wasn't restored with original expiration: %v, %s", item, err)
+	}
+	if item, err := restored.Get("counter"); err != nil || string(item.Value) != "15" {
+		t.Fatalf("Unexpected value of counter: %v, %s", item, err)
+	}
+	for _, key := range []string{"deleted", "expired", "unlogged"} {
+		if _, err := restored.Get(key); err != embedded.ErrNotFound {
+			t.Fatalf("Item %s shouldn't be restored", key)
+		}
+	}
+}
+
+func TestTruncatedTail(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	complete := "set key 0 0 5 noreply\r\nvalue\r\n"
+	for _, tail := range []string{"set other 0 0 5 noreply\r\nval", "delete ot"} {
+		if err := ioutil.WriteFile(path, []byte(complete + tail), 0644); err != nil {
+			t.Fatalf("Log wasn't written: %s", err)
+		}
+		cache := embedded.New(1 << 20)
+		log, err := Open(path, FSYNC_NO, cache)
+		if err != nil {
+			t.Fatalf("Truncated log wasn't recovered: %s", err)
+		}
+		if _, err := cache.Get("key"); err != nil {
+			t.Fatalf("Complete command wasn't replayed")
+		}
+		if !strings.Contains(strings.Join(log.Stats(), ","), "aof_truncated_bytes " + tools.IntToString(int64(len(tail)))) {
+			t.Fatalf("Unexpected statistic: %v", log.Stats())
+		}
+		cache.Delete("key")
+		log.Close()
+		data, _ := ioutil.ReadFile(path)
+		if string(data) != complete + "delete key noreply\r\n" {
+			t.Fatalf("Incomplete tail wasn't truncated: %q", data)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("set key 0 0 5 noreply\r\nvalue!!\r\n" + complete), 0644); err != nil {
+		t.Fatalf("Log wasn't written: %s", err)
+	}
+	if _, err := Open(path, FSYNC_NO, embedded.New(1 << 20)); err == nil {
+		t.Fatalf("Corrupted log was replayed")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	cache := embedded.New(1 << 20)
+	log, err := Open(path, FSYNC_EVERYSEC, cache)
+	if err != nil {
+		t.Fatalf("Log wasn't opened: %s", err)
+	}
+	log.RewriteMinSize = 4096
+	for i := 0; i < 1000; i ++ {
+		cache.Set(&embedded.Item{Key: "key", Value: []byte("value")})
+	}
+	// automatic rewrite runs in background.
+	for i := 0; i < 200 && !strings.Contains(strings.Join(log.Stats(), ","), "aof_rewrite_in_progress 0"); i ++ {
+		time.Sleep(time.Millisecond * time.Duration(10))
+	}
+	if strings.Contains(strings.Join(log.Stats(), ","), "aof_rewrites 0") {
+		t.Fatalf("Log wasn't rewritten automatically: %v", log.Stats())
+	}
+	if err := log.Rewrite(); err != nil {
+		t.Fatalf("Log wasn't rewritten: %s", err)
+	}
+	cache.Set(&embedded.Item{Key: "other", Value: []byte("value")})
+	log.Close()
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "set key 0 0 5 noreply\r\nvalue\r\nset other 0 0 5 noreply\r\nvalue\r\n" {
+		t.Fatalf("Unexpected content of rewritten log: %q", data)
+	}
+	if _, err := os.Stat(path + REWRITE_SUFFIX); !os.IsNotExist(err) {
+		t.Fatalf("Temporary file wasn't removed")
+	}
+}
+
+func TestConcatenation(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	cache := embedded.New(4 << 20)
+	log, err := Open(path, FSYNC_NO, cache)
+	if err != nil {
+		t.Fatalf("Log wasn't opened: %s", err)
+	}
+	large := []byte(strings.Repeat("v", tools.CHUNK_SIZE * 2))
+	cache.Set(&embedded.Item{Key: "large", Value: large})
+	cache.Set(&embedded.Item{Key: "small", Value: []byte("value")})
+	base := log.size
+	for i := 0; i < 100; i ++ {
+		cache.Append("large", []byte("!"))
+		cache.Prepend("small", []byte("?"))
+	}
+	// only concatenated data is logged, not the whole value.
+	if written := log.size - base; written != 100 * int64(len("append large 0 0 1 noreply\r\n!\r\n") +
+														len("prepend small 0 0 1 noreply\r\n?\r\n")) {
+		t.Fatalf("Unexpected amount of logged bytes: %d", written)
+	}
+	log.Close()
+
+	restored := embedded.New(4 << 20)
+	log, err = Open(path, FSYNC_NO, restored)
+	if err != nil {
+		t.Fatalf("Log wasn't replayed: %s", err)
+	}
+	defer log.Close()
+	if item, err := restored.Get("large"); err != nil || string(item.Value) != string(large) + strings.Repeat("!", 100) {
+		t.Fatalf("Unexpected value of large item: %s", err)
+	}
+	if item, err := restored.Get("small"); err != nil || string(item.Value) != strings.Repeat("?", 100) + "value" {
+		t.Fatalf("Unexpected value of small item: %s", err)
+	}
+}
+
+func TestRewriteDuringMutations(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	cache := embedded.New(64 << 20)
+	log, err := Open(path, FSYNC_NO, cache)
+	if err != nil {
+		t.Fatalf("Log wasn't opened: %s", err)
+	}
+	keys := embedded.SNAPSHOT_BATCH * 40
+	value := []byte(strings.Repeat("v", 1000))
+	for i := 0; i < keys; i ++ {
+		cache.Set(&embedded.Item{Key: "key" + strconv.Itoa(i), Value: value})
+	}
+	// appends aren't idempotent, so ones made during rewrite should be logged exactly once.
+	done := make(chan error)
+	go func() {
+		done <- log.Rewrite()
+	}()
+	for round := 0; ; round ++ {
+		select {
+		case err = <-done:
+		default:
+			cache.Append("key" + strconv.Itoa(round * 97 % keys), []byte("!"))
+			continue
+		}
+		break
+	}
+	if err != nil {
+		t.Fatalf("Log wasn't rewritten: %s", err)
+	}
+	cache.Append("key0", []byte("?"))
+	log.Close()
+
+	restored := embedded.New(64 << 20)
+	log, err = Open(path, FSYNC_NO, restored)
+	if err != nil {
+		t.Fatalf("Log wasn't replayed: %s", err)
+	}
+	defer log.Close()
+	for i := 0; i < keys; i ++ {
+		key := "key" + strconv.Itoa(i)
+		expected, _ := cache.Get(key)
+		if item, err := restored.Get(key); err != nil || string(item.Value) != string(expected.Value) {
+			t.Fatalf("Item %s wasn't restored: %v, %v, %s", key, item, expected, err)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+/*
+Package implements load generator for MemoranGo (and other memcached compatible servers), which gives
+reproducible performance numbers.
+
+Benchmark drives server through several connections; each of them sends batches of pipelined requests
+(depth of pipelining is configurable) and waits for all responses of batch. Operations are chosen randomly
+according to their weights: "get", "set", "multiget" (get of several keys) and "incr". Keys are taken from
+fixed key space with uniform or Zipf distribution, sizes of values are distributed uniformly in configured
+range. Latency of request is time between sending of its batch and reading of its response. Random generators
+of connections are seeded from configured seed, so the same configuration produces the same sequence of
+requests.
+*/
+package bench
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tools/stat"
+)
+
+// Operations of benchmark.
+const (
+	GET = "get"
+	SET = "set"
+	MULTIGET = "multiget"
+	INCR = "incr"
+)
+
+// Distributions of keys.
+const (
+	UNIFORM = "uniform"
+	ZIPF = "zipf"
+)
+
+// Quantiles of latency, which are reported.
+var Quantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// Error, which is returned when configuration of benchmark is invalid.
+var ErrInvalidConfig = errors.New("Invalid configuration of benchmark.")
+
+// Configuration of benchmark.
+type Config struct {
+	// Address of server ("host:port").
+	Address string
+	// Number of connections and depth of pipelining of each of them.
+	Connections int
+	Pipeline int
+	// Benchmark stops after duration or after number of requests, if it isn't zero.
+	Duration time.Duration
+	Requests uint64
+	// Weights of operations by their names.
+	Mix map[string] int
+	// Number of keys, their prefix and distribution (UNIFORM or ZIPF with passed exponent, which is greater than 1).
+	Keys int
+	KeyPrefix string
+	Distribution string
+	ZipfExponent float64
+	// Range of sizes of values in bytes.
+	ValueSize int
+	ValueSizeMax int
+	// Number of keys requested by single multiget.
+	MultiGetSize int
+	// Limit of duration of single batch.
+	Timeout time.Duration
+	// Stores every key (and counters for incr) before measurement, so gets hit.
+	Populate bool
+	Seed int64
+}
+
+// Function returns default configuration of benchmark of server with passed address.
+func DefaultConfig(address string) Config {
+	return Config{
+		Address: address,
+		Connections: 4,
+		Pipeline: 1,
+		Duration: 10 * time.Second,
+		Mix: map[string] int{GET: 90, SET: 10},
+		Keys: 10000,
+		KeyPrefix: "bench:",
+		Distribution: UNIFORM,
+		ZipfExponent: 1.1,
+		ValueSize: 100,
+		ValueSizeMax: 100,
+		MultiGetSize: 10,
+		Timeout: 5 * time.Second,
+		Seed: 1,
+	}
+}
+
+// Function parses mix of operations like "get=80,set=15,multiget=3,incr=2".
+func ParseMix(mix string) (map[string] int, error) {
+	result := make(map[string] int)
+	for _, part := range strings.Split(mix, ",") {
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) != 2 {
+			return nil, ErrInvalidConfig
+		}
+		weight, err := strconv.Atoi(pair[1])
+		if err != nil || weight < 0 {
+			return nil, ErrInvalidConfig
+		}
+		switch pair[0] {
+		case GET, SET, MULTIGET, INCR:
+			result[pair[0]] = weight
+		default:
+			return nil, ErrInvalidConfig
+		}
+	}
+	return result, nil
+}
+
+// Function checks configuration.
+func (c *Config) validate() error {
+	var total = 0
+	for _, weight := range c.Mix {
+		total += weight
+	}
+	if len(c.Address) == 0 || c.Connections <= 0 || c.Pipeline <= 0 || total <= 0 || c.Keys <= 0 ||
+	   (c.Duration <= 0 && c.Requests == 0) || c.ValueSize < 0 || c.ValueSizeMax < c.ValueSize ||
+	   (c.Mix[MULTIGET] > 0 && c.MultiGetSize <= 0) || c.Timeout <= 0 {
+		return ErrInvalidConfig
+	}
+	if c.Distribution != UNIFORM && (c.Distribution != ZIPF || c.ZipfExponent <= 1) {
+		return ErrInvalidConfig
+	}
+	return nil
+}
+
+// Report of operation.
+type OperationReport struct {
+	Requests uint64 `json:"requests"`
+	Errors uint64 `json:"errors"`
+	Mean time.Duration `json:"mean_ns"`
+	// Latencies of Quantiles.
+	Latencies []time.Duration `json:"latencies_ns"`
+}
+
+// Report of benchmark.
+type Report struct {
+	Duration time.Duration `json:"duration_ns"`
+	Requests uint64 `json:"requests"`
+	// Number of requests per second.
+	Throughput float64 `json:"throughput"`
+	// Failed requests: errors of server, unexpected responses and network errors.
+	Errors uint64 `json:"errors"`
+	// Found and missed keys of gets and multigets (and incremented and missed counters).
+	Hits uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Quantiles []float64 `json:"quantiles"`
+	Operations map[string] *OperationReport `json:"operations"`
+}
+
+// Function formats report as text.
+func (r *Report) Text() string {
+	var result strings.Builder
+	result.WriteString("Duration: " + r.Duration.Round(time.Millisecond).String() +
+					   ", requests: " + strconv.FormatUint(r.Requests, 10) +
+					   ", throughput: " + strconv.FormatFloat(r.Throughput, 'f', 1, 64) + " req/s" +
+					   ", errors: " + strconv.FormatUint(r.Errors, 10) + "\n")
+	result.WriteString("Hits: " + strconv.FormatUint(r.Hits, 10) + ", misses: " + strconv.FormatUint(r.Misses, 10) + "\n")
+	var names []string
+	for name := range r.Operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	header := []string{"OPERATION", "REQUESTS", "ERRORS", "MEAN"}
+	for _, quantile := range r.Quantiles {
+		header = append(header, "P" + strconv.FormatFloat(quantile * 100, 'f', -1, 64))
+	}
+	rows := [][]string{header}
+	for _, name := range names {
+		operation := r.Operations[name]
+		row := []string{name, strconv.FormatUint(operation.Requests, 10), strconv.FormatUint(operation.Errors, 10),
+						operation.Mean.String()}
+		for _, latency := range operation.Latencies {
+			row = append(row, latency.String())
+		}
+		rows = append(rows, row)
+	}
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(row) - 1 {
+				cell += strings.Repeat(" ", widths[i] - len(cell) + 2)
+			}
+			result.WriteString(cell)
+		}
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// Statistic of operation collected by all connections. Latency is recorded for answered requests only.
+type operation struct {
+	latency stat.CommandLatency
+	requests uint64
+	errors uint64
+}
+
+// State of running benchmark.
+type benchmark struct {
+	config Config
+	operations map[string] *operation
+	names []string // names of operations for choosing by weight
+	weights []int // accumulated weights of operations
+	value []byte // source of values
+	issued uint64 // number of issued requests
+	deadline time.Time
+	hits uint64
+	misses uint64
+}
+
+// Function runs benchmark with passed configuration and returns its report.
+func Run(config Config) (*Report, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	b := &benchmark{config: config, operations: make(map[string] *operation)}
+	for _, name := range []string{GET, SET, MULTIGET, INCR} {
+		if weight := config.Mix[name]; weight > 0 {
+			var previous = 0
+			if len(b.weights) > 0 {
+				previous = b.weights[len(b.weights) - 1]
+			}
+			b.names = append(b.names, name)
+			b.weights = append(b.weights, previous + weight)
+			b.operations[name] = new(operation)
+		}
+	}
+	b.value = make([]byte, config.ValueSizeMax)
+	random := rand.New(rand.NewSource(config.Seed))
+	for i := range b.value {
+		b.value[i] = byte('a' + random.Intn(26))
+	}
+	if config.Populate {
+		if err := b.populate(); err != nil {
+			return nil, err
+		}
+	}
+	started := time.Now()
+	if config.Duration > 0 {
+		b.deadline = started.Add(config.Duration)
+	}
+	var wait sync.WaitGroup
+	errs := make(chan error, config.Connections)
+	for i := 0; i < config.Connections; i ++ {
+		wait.Add(1)
+		go func(id int) {
+			defer wait.Done()
+			if err := b.worker(id); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wait.Wait()
+	duration := time.Since(started)
+	select {
+	case err := <-errs:
+		// nothing was measured, e.g. server is unavailable.
+		if b.requests() == 0 {
+			return nil, err
+		}
+	default:
+	}
+	return b.report(duration), nil
+}
+
+// Private method, which returns number of requests made by all connections.
+func (b *benchmark) requests() uint64 {
+	var total uint64
+	for _, op := range b.operations {
+		total += atomic.LoadUint64(&op.requests)
+	}
+	return total
+}
+
+// Private method, which builds report of finished benchmark.
+func (b *benchmark) report(duration time.Duration) *Report {
+	report := &Report{Duration: duration, Requests: b.requests(), Hits: atomic.LoadUint64(&b.hits),
+					  Misses: atomic.LoadUint64(&b.misses), Quantiles: Quantiles,
+					  Operations: make(map[string] *OperationReport)}
+	for name, op := range b.operations {
+		errors := atomic.LoadUint64(&op.errors)
+		report.Errors += errors
+		report.Operations[name] = &OperationReport{Requests: atomic.LoadUint64(&op.requests), Errors: errors,
+												   Mean: op.latency.Mean(), Latencies: op.latency.Quantiles(Quantiles...)}
+	}
+	if duration > 0 {
+		report.Throughput = float64(report.Requests) / duration.Seconds()
+	}
+	return report
+}
+
+// Private method, which returns key with passed index.
+func (b *benchmark) key(index uint64) string {
+	return b.config.KeyPrefix + strconv.FormatUint(index, 10)
+}
+
+// Private method, which returns key of counter with passed index.
+func (b *benchmark) counter(index uint64) string {
+	return b.config.KeyPrefix + "counter:" + strconv.FormatUint(index, 10)
+}
+
+// Private method, which stores every key and counter of key space through single connection.
+func (b *benchmark) populate() error {
+	connection, err := net.DialTimeout("tcp", b.config.Address, b.config.Timeout)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	reader, writer := bufio.NewReader(connection), bufio.NewWriter(connection)
+	var batch = 0
+	for i := 0; i < b.config.Keys; i ++ {
+		writer.WriteString("set " + b.key(uint64(i)) + " 0 0 " + strconv.Itoa(b.config.ValueSizeMax) + " noreply\r\n")
+		writer.Write(b.value)
+		writer.WriteString("\r\n")
+		if b.config.Mix[INCR] > 0 {
+			writer.WriteString("set " + b.counter(uint64(i)) + " 0 0 1 noreply\r\n0\r\n")
+		}
+		// let's check progress of server from time to time, so its buffers don't overflow.
+		if batch ++; batch == 1000 || i == b.config.Keys - 1 {
+			batch = 0
+			connection.SetDeadline(time.Now().Add(b.config.Timeout))
+			writer.WriteString("version\r\n")
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if line, err := reader.ReadString('\n'); err != nil {
+				return err
+			} else if !strings.HasPrefix(line, "VERSION") {
+				return errors.New("Unexpected response of server: " + strings.TrimSpace(line))
+			}
+		}
+	}
+	return nil
+}
+
+// Structure of request sent in batch.
+type request struct {
+	operation string
+	keys int // number of requested keys
+}
+
+// Private method, which returns size of the next batch (up to passed one), reserving budget of requests
+// for it; zero means that benchmark is finished.
+func (b *benchmark) reserve(size int) int {
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return 0
+	}
+	if b.config.Requests == 0 {
+		return size
+	}
+	issued := atomic.AddUint64(&b.issued, uint64(size))
+	if issued <= b.config.Requests {
+		return size
+	}
+	if issued - uint64(size) < b.config.Requests {
+		return int(b.config.Requests - (issued - uint64(size)))
+	}
+	return 0
+}
+
+// Private method, which runs connection with passed id until benchmark is finished.
+// Connection is reopened after network error. Returns error if connection couldn't be opened.
+func (b *benchmark) worker(id int) error {
+	random := rand.New(rand.NewSource(b.config.Seed + int64(id) + 1))
+	var zipf *rand.Zipf
+	if b.config.Distribution == ZIPF {
+		zipf = rand.NewZipf(random, b.config.ZipfExponent, 1, uint64(b.config.Keys - 1))
+	}
+	next := func() uint64 {
+		if zipf != nil {
+			return zipf.Uint64()
+		}
+		return uint64(random.Intn(b.config.Keys))
+	}
+	for {
+		connection, err := net.DialTimeout("tcp", b.config.Address, b.config.Timeout)
+		if err != nil {
+			return err
+		}
+		err = b.run(connection, random, next)
+		connection.Close()
+		if err == nil {
+			return nil
+		}
+	}
+}
+
+// Private method, which sends batches of requests through passed connection until benchmark is finished.
+// Returns network error, after which connection should be reopened.
+func (b *benchmark) run(connection net.Conn, random *rand.Rand, next func() uint64) error {
+	reader, writer := bufio.NewReader(connection), bufio.NewWriter(connection)
+	batch := make([]request, 0, b.config.Pipeline)
+	for {
+		size := b.reserve(b.config.Pipeline)
+		if size == 0 {
+			return nil
+		}
+		batch = batch[ : 0]
+		for i := 0; i < size; i ++ {
+			weight := random.Intn(b.weights[len(b.weights) - 1])
+			name := b.names[sort.SearchInts(b.weights, weight + 1)]
+			switch name {
+			case GET:
+				writer.WriteString("get " + b.key(next()) + "\r\n")
+				batch = append(batch, request{operation: name, keys: 1})
+			case MULTIGET:
+				writer.WriteString("get")
+				for k := 0; k < b.config.MultiGetSize; k ++ {
+					writer.WriteString(" " + b.key(next()))
+				}
+				writer.WriteString("\r\n")
+				batch = append(batch, request{operation: name, keys: b.config.MultiGetSize})
+			case SET:
+				size := b.config.ValueSize + random.Intn(b.config.ValueSizeMax - b.config.ValueSize + 1)
+				writer.WriteString("set " + b.key(next()) + " 0 0 " + strconv.Itoa(size) + "\r\n")
+				writer.Write(b.value[ : size])
+				writer.WriteString("\r\n")
+				batch = append(batch, request{operation: name})
+			case INCR:
+				writer.WriteString("incr " + b.counter(next()) + " 1\r\n")
+				batch = append(batch, request{operation: name, keys: 1})
+			}
+		}
+		connection.SetDeadline(time.Now().Add(b.config.Timeout))
+		started := time.Now()
+		if err := writer.Flush(); err != nil {
+			b.fail(batch)
+			return err
+		}
+		for i, r := range batch {
+			hits, ok, err := b.response(reader, r)
+			if err != nil {
+				b.fail(batch[i : ])
+				return err
+			}
+			op := b.operations[r.operation]
+			op.latency.Record(time.Since(started), 0, 0)
+			atomic.AddUint64(&op.requests, 1)
+			if !ok {
+				atomic.AddUint64(&op.errors, 1)
+			}
+			atomic.AddUint64(&b.hits, uint64(hits))
+			atomic.AddUint64(&b.misses, uint64(r.keys - hits))
+		}
+	}
+}
+
+// Private method, which records requests of passed batch as failed ones.
+func (b *benchmark) fail(batch []request) {
+	for _, r := range batch {
+		op := b.operations[r.operation]
+		atomic.AddUint64(&op.requests, 1)
+		atomic.AddUint64(&op.errors, 1)
+	}
+}
+
+// Private method, which reads response to passed request. Returns number of found keys, false if response
+// is error or unexpected one, and network error if response couldn't be read.
+func (b *benchmark) response(reader *bufio.Reader, r request) (int, bool, error) {
+	var hits = 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return hits, false, err
+		}
+		switch {
+		case r.operation == SET:
+			return 0, line == "STORED\r\n", nil
+		case r.operation == INCR:
+			if line == "NOT_FOUND\r\n" {
+				return 0, true, nil
+			}
+			_, err := strconv.ParseUint(strings.TrimSpace(line), 10, 64)
+			return 1, err == nil, nil
+		case line == "END\r\n":
+			return hits, true, nil
+		case strings.HasPrefix(line, "VALUE "):
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return hits, false, nil
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return hits, false, nil
+			}
+			// data block and its terminator
+			if _, err = io.CopyN(ioutil.Discard, reader, int64(size) + 2); err != nil {
+				return hits, false, err
+			}
+			hits ++
+		default:
+			return hits, false, nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package bench
+
+import (
+	"reflect"
+	"server"
+	"strings"
+	"testing"
+)
+
+func TestMixParsing(t *testing.T) {
+	mix, err := ParseMix("get=80,set=15,multiget=3,incr=2")
+	if err != nil || !reflect.DeepEqual(mix, map[string] int{GET: 80, SET: 15, MULTIGET: 3, INCR: 2}) {
+		t.Fatalf("Unexpected mix: %v, %v", mix, err)
+	}
+	for _, invalid := range []string{"", "get", "get=-1", "delete=1", "get=x"} {
+		if _, err := ParseMix(invalid); err != ErrInvalidConfig {
+			t.Fatalf("Invalid mix %q was accepted.", invalid)
+		}
+	}
+	config := DefaultConfig("127.0.0.1:11211")
+	config.Mix = map[string] int{GET: 0}
+	if _, err := Run(config); err != ErrInvalidConfig {
+		t.Fatalf("Mix without operations was accepted.")
+	}
+	config = DefaultConfig("127.0.0.1:11211")
+	config.Distribution, config.ZipfExponent = ZIPF, 1
+	if _, err := Run(config); err != ErrInvalidConfig {
+		t.Fatalf("Invalid exponent of Zipf distribution was accepted.")
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	srv := server.NewServer("60015", "", "", 1024, false, false, 0, 16 << 20)
+	if err := srv.RunServer(); err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer srv.StopServer()
+	config := DefaultConfig("127.0.0.1:60015")
+	config.Connections, config.Pipeline = 3, 8
+	config.Duration, config.Requests = 0, 1000
+	config.Mix = map[string] int{GET: 5, SET: 2, MULTIGET: 2, INCR: 1}
+	config.Keys, config.ValueSize, config.ValueSizeMax = 100, 10, 1000
+	config.Distribution = ZIPF
+	config.Populate = true
+	report, err := Run(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var requests uint64
+	for name, operation := range report.Operations {
+		if operation.Requests == 0 || operation.Errors != 0 || len(operation.Latencies) != len(Quantiles) ||
+		   operation.Latencies[0] > operation.Latencies[len(Quantiles) - 1] {
+			t.Fatalf("Unexpected report of %s: %v", name, operation)
+		}
+		requests += operation.Requests
+	}
+	if report.Requests != 1000 || requests != 1000 || report.Errors != 0 || report.Hits == 0 || report.Misses != 0 ||
+	   report.Throughput <= 0 {
+		t.Fatalf("Unexpected report: %v", report)
+	}
+	text := report.Text()
+	if !strings.Contains(text, "requests: 1000,") || !strings.Contains(text, "OPERATION  REQUESTS  ERRORS  MEAN") ||
+	   !strings.Contains(text, "P99.9") || !strings.Contains(text, "\nmultiget ") {
+		t.Fatalf("Unexpected text of report: %s", text)
+	}
+	config.Address = "127.0.0.1:60016"
+	if _, err := Run(config); err == nil {
+		t.Fatalf("Benchmark of unavailable server succeeded.")
+	}
+}
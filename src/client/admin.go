@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"tools/protocol"
+)
+
+// Structure of metadata of stored item, which is returned by Metadump.
+type Meta struct {
+	Key string `json:"key"`
+	// Expiration timestamp; -1 means no expiration.
+	Expiration int64 `json:"exp"`
+	// Timestamp of last access (of storing for MemoranGo).
+	LastAccess int64 `json:"la"`
+	Cas uint64 `json:"cas"`
+	Fetched bool `json:"fetch"`
+	Size int `json:"size"`
+}
+
+// Function parses line of "lru_crawler metadump" response. Unknown fields are ignored.
+func parseMeta(line string) (*Meta, error) {
+	meta := new(Meta)
+	for _, field := range strings.Fields(line) {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			return nil, ErrBadResponse
+		}
+		var err error
+		switch pair[0] {
+		case "key":
+			meta.Key, err = url.QueryUnescape(pair[1])
+		case "exp":
+			meta.Expiration, err = strconv.ParseInt(pair[1], 10, 64)
+		case "la":
+			meta.LastAccess, err = strconv.ParseInt(pair[1], 10, 64)
+		case "cas":
+			meta.Cas, err = strconv.ParseUint(pair[1], 10, 64)
+		case "fetch":
+			meta.Fetched = pair[1] == "yes"
+		case "size":
+			meta.Size, err = strconv.Atoi(pair[1])
+		}
+		if err != nil {
+			return nil, ErrBadResponse
+		}
+	}
+	if len(meta.Key) == 0 {
+		return nil, ErrBadResponse
+	}
+	return meta, nil
+}
+
+// Function requests metadata of all items of server ("lru_crawler metadump all") and calls passed function
+// with each of them. Timeout of client limits reading of each line rather than the whole dump, so dump of large
+// storage isn't interrupted, and passed function may take time (e.g. to fetch values of items by this client).
+func (c *Client) Metadump(ctx context.Context, fn func(*Meta)) error {
+	return c.do(ctx, func(cn *conn) error {
+		cn.writer.WriteString("lru_crawler metadump all\r\n")
+		if err := cn.writer.Flush(); err != nil {
+			return err
+		}
+		for {
+			cn.setDeadline(c.deadline(ctx))
+			line, err := readLine(cn)
+			if err != nil {
+				return err
+			}
+			if line + "\r\n" == protocol.END {
+				return nil
+			}
+			if err := responseError(line); err != nil {
+				return err
+			}
+			meta, err := parseMeta(line)
+			if err != nil {
+				return err
+			}
+			fn(meta)
+		}
+	})
+}
+
+// Function controls LRU crawler of server by "lru_crawler" command with passed arguments,
+// e.g. "enable", "disable", "sleep <microseconds>" or "tocrawl <items>".
+func (c *Client) Crawler(ctx context.Context, args ...string) error {
+	return c.do(ctx, func(cn *conn) error {
+		_, err := roundTrip(cn, strings.Join(append([]string{"lru_crawler"}, args...), " "), nil, protocol.OK)
+		return err
+	})
+}
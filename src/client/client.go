@@ -0,0 +1,472 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tools/protocol"
+)
+
+const (
+	// Defines default limit of duration of single call.
+	DEFAULT_TIMEOUT = time.Second
+	// Defines default number of idle connections kept by client.
+	DEFAULT_MAX_IDLE_CONNS = 8
+	// Defines the maximal length of key.
+	MAX_KEY_LENGTH = 250
+	// Defines the maximal number of keys requested by single command of pipelined multi-get.
+	MAX_KEYS_PER_GET = 100
+)
+
+// Errors, which correspond to negative responses of server.
+var (
+	// Item wasn't stored, since condition of add, replace, append or prepend command wasn't met.
+	ErrNotStored = errors.New("memorango: item not stored")
+	// Item was modified since it was fetched, so compare-and-swap failed.
+	ErrExists = errors.New("memorango: item exists")
+	// Item is missing.
+	ErrNotFound = errors.New("memorango: item not found")
+	// Server responded with ERROR: command is unknown or its arguments are invalid.
+	ErrUnknownCommand = errors.New("memorango: server doesn't recognize command")
+	// Key is empty, too long or contains whitespaces or control characters.
+	ErrMalformedKey = errors.New("memorango: malformed key")
+	// Client was closed.
+	ErrClosed = errors.New("memorango: client is closed")
+	// Response of server doesn't satisfy protocol.
+	ErrBadResponse = errors.New("memorango: unexpected response of server")
+)
+
+// Error, which is returned when server responds with SERVER_ERROR.
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "memorango: server error: " + e.Message
+}
+
+// Error, which is returned when server responds with CLIENT_ERROR.
+type ClientError struct {
+	Message string
+}
+
+func (e *ClientError) Error() string {
+	return "memorango: client error: " + e.Message
+}
+
+// Structure of item stored in server.
+type Item struct {
+	// Key of item, which satisfies protocol: up to 250 bytes without whitespaces and control characters.
+	Key string
+	// Value of item.
+	Value []byte
+	// Arbitrary flags stored along with value.
+	Flags uint32
+	// Expiration time: either number of seconds from now (up to 30 days) or UNIX timestamp; zero means no expiration.
+	Expiration int32
+	// Unique id of item's version, which is filled by Gets and required by CompareAndSwap.
+	Cas uint64
+}
+
+// Client of single server.
+type Client struct {
+	address string
+	// Limit of duration of each call; context may limit it further.
+	Timeout time.Duration
+	// Maximal number of idle connections kept in the pool.
+	MaxIdleConns int
+	idle []*conn
+	closed bool
+	mutex sync.Mutex
+}
+
+// Function creates client of server with passed address ("host:port").
+// Connections will be opened on demand.
+func New(address string) *Client {
+	return &Client{
+		address: address,
+		Timeout: DEFAULT_TIMEOUT,
+		MaxIdleConns: DEFAULT_MAX_IDLE_CONNS,
+	}
+}
+
+// Getter for address field.
+func (c *Client) Address() string {
+	return c.address
+}
+
+// Function closes all idle connections; calls made after it return ErrClosed.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.closed = true
+	c.mutex.Unlock()
+	for _, cn := range idle {
+		cn.net_conn.Close()
+	}
+	return nil
+}
+
+// Function checks whether key is valid.
+func legalKey(key string) bool {
+	if len(key) == 0 || len(key) > MAX_KEY_LENGTH {
+		return false
+	}
+	for i := 0; i < len(key); i ++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Function reads single line of response and returns it without terminator.
+func readLine(cn *conn) (string, error) {
+	line, err := cn.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", ErrBadResponse
+	}
+	return line[ : len(line) - 2], nil
+}
+
+// Function converts error responses of server into errors.
+// It returns nil if passed line isn't an error response.
+func responseError(line string) error {
+	response := line + "\r\n"
+	switch {
+	case response == protocol.ERROR_TEMP:
+		return ErrUnknownCommand
+	case response == protocol.NOT_STORED:
+		return ErrNotStored
+	case response == protocol.EXIST:
+		return ErrExists
+	case response == protocol.NOT_FOUND:
+		return ErrNotFound
+	case strings.HasPrefix(line, "CLIENT_ERROR"):
+		return &ClientError{Message: strings.TrimSpace(strings.TrimPrefix(line, "CLIENT_ERROR"))}
+	case strings.HasPrefix(line, "SERVER_ERROR"):
+		return &ServerError{Message: strings.TrimSpace(strings.TrimPrefix(line, "SERVER_ERROR"))}
+	}
+	return nil
+}
+
+// Function sends passed command line (and data block if it isn't nil) and reads single line of response.
+// If response equals to expected one, nil is returned, otherwise corresponding error.
+func roundTrip(cn *conn, command string, data []byte, expected string) (string, error) {
+	cn.writer.WriteString(command + "\r\n")
+	if data != nil {
+		cn.writer.Write(data)
+		cn.writer.WriteString("\r\n")
+	}
+	if err := cn.writer.Flush(); err != nil {
+		return "", err
+	}
+	line, err := readLine(cn)
+	if err != nil {
+		return "", err
+	}
+	if len(expected) > 0 && line + "\r\n" == expected {
+		return line, nil
+	}
+	if err := responseError(line); err != nil {
+		return line, err
+	}
+	if len(expected) > 0 {
+		return line, ErrBadResponse
+	}
+	return line, nil
+}
+
+// Function reads response to retrieval command and calls passed function with each item.
+func readItems(cn *conn, fn func(*Item)) error {
+	for {
+		line, err := readLine(cn)
+		if err != nil {
+			return err
+		}
+		if line + "\r\n" == protocol.END {
+			return nil
+		}
+		if !strings.HasPrefix(line, protocol.VALUE_PREFIX) {
+			if err := responseError(line); err != nil {
+				return err
+			}
+			return ErrBadResponse
+		}
+		// VALUE <key> <flags> <bytes> [<cas unique>]
+		fields := strings.Fields(line[len(protocol.VALUE_PREFIX) : ])
+		if len(fields) < 3 || len(fields) > 4 {
+			return ErrBadResponse
+		}
+		item := &Item{Key: fields[0]}
+		flags, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return ErrBadResponse
+		}
+		item.Flags = uint32(flags)
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size < 0 {
+			return ErrBadResponse
+		}
+		if len(fields) == 4 {
+			if item.Cas, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+				return ErrBadResponse
+			}
+		}
+		item.Value = make([]byte, size + 2)
+		if _, err := io.ReadFull(cn.reader, item.Value); err != nil {
+			return err
+		}
+		if string(item.Value[size : ]) != "\r\n" {
+			return ErrBadResponse
+		}
+		item.Value = item.Value[ : size]
+		fn(item)
+	}
+}
+
+// Private method, which retrieves items by command "get" or "gets".
+// Keys are split into batches, which are written to connection at once, and only then responses are read.
+func (c *Client) retrieve(ctx context.Context, command string, keys []string) (map[string] *Item, error) {
+	for _, key := range keys {
+		if !legalKey(key) {
+			return nil, ErrMalformedKey
+		}
+	}
+	result := make(map[string] *Item, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+	err := c.do(ctx, func(cn *conn) error {
+		var batches = 0
+		for start := 0; start < len(keys); start += MAX_KEYS_PER_GET {
+			end := start + MAX_KEYS_PER_GET
+			if end > len(keys) {
+				end = len(keys)
+			}
+			cn.writer.WriteString(command + " " + strings.Join(keys[start : end], " ") + "\r\n")
+			batches ++
+		}
+		if err := cn.writer.Flush(); err != nil {
+			return err
+		}
+		for ; batches > 0; batches -- {
+			if err := readItems(cn, func(item *Item) { result[item.Key] = item }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Function retrieves item by key. If item is missing, ErrNotFound is returned.
+func (c *Client) Get(ctx context.Context, key string) (*Item, error) {
+	items, err := c.retrieve(ctx, "get", []string{key})
+	if err != nil {
+		return nil, err
+	}
+	if item, exists := items[key]; exists {
+		return item, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Function retrieves item by key along with its unique id, which is required by CompareAndSwap.
+func (c *Client) Gets(ctx context.Context, key string) (*Item, error) {
+	items, err := c.retrieve(ctx, "gets", []string{key})
+	if err != nil {
+		return nil, err
+	}
+	if item, exists := items[key]; exists {
+		return item, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Function retrieves many items by pipelined requests. Missing items are absent in returned map.
+func (c *Client) GetMulti(ctx context.Context, keys []string) (map[string] *Item, error) {
+	return c.retrieve(ctx, "get", keys)
+}
+
+// Function retrieves many items along with their unique ids by pipelined requests. Missing items are absent
+// in returned map.
+func (c *Client) GetsMulti(ctx context.Context, keys []string) (map[string] *Item, error) {
+	return c.retrieve(ctx, "gets", keys)
+}
+
+// Private method, which sends one of storage commands.
+func (c *Client) store(ctx context.Context, command string, item *Item) error {
+	if !legalKey(item.Key) {
+		return ErrMalformedKey
+	}
+	line := command + " " + item.Key + " " + strconv.FormatUint(uint64(item.Flags), 10) + " " +
+			strconv.FormatInt(int64(item.Expiration), 10) + " " + strconv.Itoa(len(item.Value))
+	if command == "cas" {
+		line += " " + strconv.FormatUint(item.Cas, 10)
+	}
+	value := item.Value
+	if value == nil {
+		value = []byte{}
+	}
+	return c.do(ctx, func(cn *conn) error {
+		_, err := roundTrip(cn, line, value, protocol.STORED)
+		return err
+	})
+}
+
+// Function stores item unconditionally.
+func (c *Client) Set(ctx context.Context, item *Item) error {
+	return c.store(ctx, "set", item)
+}
+
+// Function stores item only if it is missing; otherwise ErrNotStored is returned.
+func (c *Client) Add(ctx context.Context, item *Item) error {
+	return c.store(ctx, "add", item)
+}
+
+// Function stores item only if it does exist; otherwise ErrNotStored is returned.
+func (c *Client) Replace(ctx context.Context, item *Item) error {
+	return c.store(ctx, "replace", item)
+}
+
+// Function appends value of passed item to the existing one; flags and expiration are ignored.
+func (c *Client) Append(ctx context.Context, item *Item) error {
+	return c.store(ctx, "append", item)
+}
+
+// Function prepends value of passed item to the existing one; flags and expiration are ignored.
+func (c *Client) Prepend(ctx context.Context, item *Item) error {
+	return c.store(ctx, "prepend", item)
+}
+
+// Function stores item only if it wasn't modified since it was fetched by Gets.
+// Returns ErrExists if item was modified and ErrNotFound if it is missing.
+func (c *Client) CompareAndSwap(ctx context.Context, item *Item) error {
+	return c.store(ctx, "cas", item)
+}
+
+// Function deletes item by key. If item is missing, ErrNotFound is returned.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	if !legalKey(key) {
+		return ErrMalformedKey
+	}
+	return c.do(ctx, func(cn *conn) error {
+		_, err := roundTrip(cn, "delete " + key, nil, protocol.DELETED)
+		return err
+	})
+}
+
+// Function updates expiration time of item. If item is missing, ErrNotFound is returned.
+func (c *Client) Touch(ctx context.Context, key string, expiration int32) error {
+	if !legalKey(key) {
+		return ErrMalformedKey
+	}
+	return c.do(ctx, func(cn *conn) error {
+		_, err := roundTrip(cn, "touch " + key + " " + strconv.FormatInt(int64(expiration), 10), nil, protocol.TOUCHED)
+		return err
+	})
+}
+
+// Private method, which sends incr or decr command and returns new value of item.
+func (c *Client) fold(ctx context.Context, command string, key string, delta uint64) (uint64, error) {
+	if !legalKey(key) {
+		return 0, ErrMalformedKey
+	}
+	var result uint64
+	err := c.do(ctx, func(cn *conn) error {
+		line, err := roundTrip(cn, command + " " + key + " " + strconv.FormatUint(delta, 10), nil, "")
+		if err != nil {
+			return err
+		}
+		result, err = strconv.ParseUint(line, 10, 64)
+		if err != nil {
+			return ErrBadResponse
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Function increases numeric value of item by delta and returns new value.
+func (c *Client) Increment(ctx context.Context, key string, delta uint64) (uint64, error) {
+	return c.fold(ctx, "incr", key, delta)
+}
+
+// Function decreases numeric value of item by delta and returns new value.
+func (c *Client) Decrement(ctx context.Context, key string, delta uint64) (uint64, error) {
+	return c.fold(ctx, "decr", key, delta)
+}
+
+// Function invalidates all items of server.
+func (c *Client) FlushAll(ctx context.Context) error {
+	return c.do(ctx, func(cn *conn) error {
+		_, err := roundTrip(cn, "flush_all", nil, protocol.OK)
+		return err
+	})
+}
+
+// Function returns version string of server.
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var version string
+	err := c.do(ctx, func(cn *conn) error {
+		line, err := roundTrip(cn, "version", nil, "")
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, protocol.VERSION_PREFIX) {
+			return ErrBadResponse
+		}
+		version = line[len(protocol.VERSION_PREFIX) : ]
+		return nil
+	})
+	return version, err
+}
+
+// Function requests statistic of server; passed arguments define sub command, e.g. "settings" or "items".
+// Each "STAT <name> <value>" line is returned as map entry.
+func (c *Client) Stats(ctx context.Context, args ...string) (map[string] string, error) {
+	result := make(map[string] string)
+	err := c.do(ctx, func(cn *conn) error {
+		cn.writer.WriteString(strings.Join(append([]string{"stats"}, args...), " ") + "\r\n")
+		if err := cn.writer.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := readLine(cn)
+			if err != nil {
+				return err
+			}
+			if line + "\r\n" == protocol.END {
+				return nil
+			}
+			if !strings.HasPrefix(line, protocol.STAT_PREFIX) {
+				if err := responseError(line); err != nil {
+					return err
+				}
+				return ErrBadResponse
+			}
+			fields := strings.SplitN(line[len(protocol.STAT_PREFIX) : ], " ", 2)
+			if len(fields) == 2 {
+				result[fields[0]] = fields[1]
+			} else {
+				result[fields[0]] = ""
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
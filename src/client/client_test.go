@@ -0,0 +1,306 @@
+package client
+
+import (
+	"testing"
+	"context"
+	"net"
+	"sync"
+	"time"
+	"strconv"
+	"server"
+	"tools/protocol"
+)
+
+var test_port = "60100"
+var test_address = "127.0.0.1:" + test_port
+
+func runServer(t *testing.T) *server.Server {
+	srv := server.NewServer(test_port, "", "", 1024, false, false, 0, 1 << 20)
+	srv.RunServer()
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while goroutines will start
+	return srv
+}
+
+func TestClientStorageCommands(t *testing.T){
+	srv := runServer(t)
+	defer srv.StopServer()
+	client := New(test_address)
+	defer client.Close()
+	ctx := context.Background()
+	if err := client.Set(ctx, &Item{Key: "key", Value: []byte("value"), Flags: 42}); err != nil {
+		t.Fatalf("Unexpected error of set: %s", err)
+	}
+	item, err := client.Get(ctx, "key")
+	if err != nil || string(item.Value) != "value" || item.Flags != 42 {
+		t.Fatalf("Unexpected result of get: %v, %s", item, err)
+	}
+	if err := client.Add(ctx, &Item{Key: "key", Value: []byte("other")}); err != ErrNotStored {
+		t.Fatalf("Add of existed item should fail: %v", err)
+	}
+	if err := client.Replace(ctx, &Item{Key: "missed", Value: []byte("other")}); err != ErrNotStored {
+		t.Fatalf("Replace of missed item should fail: %v", err)
+	}
+	if err := client.Append(ctx, &Item{Key: "key", Value: []byte("_tail")}); err != nil {
+		t.Fatalf("Unexpected error of append: %s", err)
+	}
+	if err := client.Prepend(ctx, &Item{Key: "key", Value: []byte("head_")}); err != nil {
+		t.Fatalf("Unexpected error of prepend: %s", err)
+	}
+	item, err = client.Get(ctx, "key")
+	if err != nil || string(item.Value) != "head_value_tail" {
+		t.Fatalf("Unexpected result of get: %v, %s", item, err)
+	}
+	if err := client.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Unexpected error of delete: %s", err)
+	}
+	if err := client.Delete(ctx, "key"); err != ErrNotFound {
+		t.Fatalf("Delete of missed item should fail: %v", err)
+	}
+	if _, err := client.Get(ctx, "key"); err != ErrNotFound {
+		t.Fatalf("Get of missed item should fail: %v", err)
+	}
+	if version, err := client.Version(ctx); err != nil || len(version) == 0 {
+		t.Fatalf("Unexpected result of version: %q, %s", version, err)
+	}
+	stats, err := client.Stats(ctx)
+	if err != nil || len(stats["pid"]) == 0 {
+		t.Fatalf("Unexpected result of stats: %v, %s", stats, err)
+	}
+	if err := client.FlushAll(ctx); err != nil {
+		t.Fatalf("Unexpected error of flush_all: %s", err)
+	}
+}
+
+func TestClientCompareAndSwap(t *testing.T){
+	srv := runServer(t)
+	defer srv.StopServer()
+	client := New(test_address)
+	defer client.Close()
+	ctx := context.Background()
+	client.Set(ctx, &Item{Key: "key", Value: []byte("value")})
+	item, err := client.Gets(ctx, "key")
+	if err != nil || item.Cas == 0 {
+		t.Fatalf("Unexpected result of gets: %v, %s", item, err)
+	}
+	item.Value = []byte("new_value")
+	if err := client.CompareAndSwap(ctx, item); err != nil {
+		t.Fatalf("Unexpected error of cas: %s", err)
+	}
+	if err := client.CompareAndSwap(ctx, item); err == nil {
+		t.Fatalf("Cas with outdated unique should fail")
+	}
+	item, err = client.Get(ctx, "key")
+	if err != nil || string(item.Value) != "new_value" {
+		t.Fatalf("Unexpected result of get: %v, %s", item, err)
+	}
+}
+
+func TestClientIncrementAndTouch(t *testing.T){
+	srv := runServer(t)
+	defer srv.StopServer()
+	client := New(test_address)
+	defer client.Close()
+	ctx := context.Background()
+	client.Set(ctx, &Item{Key: "counter", Value: []byte("10")})
+	if value, err := client.Increment(ctx, "counter", 5); err != nil || value != 15 {
+		t.Fatalf("Unexpected result of incr: %d, %s", value, err)
+	}
+	if value, err := client.Decrement(ctx, "counter", 3); err != nil || value != 12 {
+		t.Fatalf("Unexpected result of decr: %d, %s", value, err)
+	}
+	if _, err := client.Increment(ctx, "missed", 1); err != ErrNotFound {
+		t.Fatalf("Incr of missed item should fail: %v", err)
+	}
+	if err := client.Touch(ctx, "counter", 100); err != nil {
+		t.Fatalf("Unexpected error of touch: %s", err)
+	}
+	if err := client.Touch(ctx, "missed", 100); err != ErrNotFound {
+		t.Fatalf("Touch of missed item should fail: %v", err)
+	}
+}
+
+func TestClientGetMulti(t *testing.T){
+	srv := runServer(t)
+	defer srv.StopServer()
+	client := New(test_address)
+	defer client.Close()
+	ctx := context.Background()
+	var keys []string
+	for i := 0; i < 250; i ++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		if i % 2 == 0 {
+			client.Set(ctx, &Item{Key: key, Value: []byte(strconv.Itoa(i))})
+		}
+	}
+	items, err := client.GetMulti(ctx, keys)
+	if err != nil || len(items) != 125 {
+		t.Fatalf("Unexpected result of multi-get: %d items, %s", len(items), err)
+	}
+	for key, item := range items {
+		if "key" + string(item.Value) != key {
+			t.Fatalf("Wrong value of %s: %s", key, item.Value)
+		}
+	}
+	items, err = client.GetsMulti(ctx, keys[ : 4])
+	if err != nil || len(items) != 2 || items["key2"] == nil || items["key2"].Cas == 0 {
+		t.Fatalf("Unexpected result of multi-gets: %v, %s", items, err)
+	}
+}
+
+func TestClientConcurrentUsage(t *testing.T){
+	srv := runServer(t)
+	defer srv.StopServer()
+	client := New(test_address)
+	defer client.Close()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	var errs = make(chan error, 16)
+	for i := 0; i < 16; i ++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key" + strconv.Itoa(i)
+			for j := 0; j < 20; j ++ {
+				if err := client.Set(ctx, &Item{Key: key, Value: []byte(strconv.Itoa(j))}); err != nil {
+					errs <- err
+					return
+				}
+				item, err := client.Get(ctx, key)
+				if err != nil || string(item.Value) != strconv.Itoa(j) {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Concurrent call failed: %v", err)
+	}
+	client.mutex.Lock()
+	idle := len(client.idle)
+	client.mutex.Unlock()
+	if idle == 0 || idle > client.MaxIdleConns {
+		t.Fatalf("Unexpected number of idle connections: %d", idle)
+	}
+}
+
+func TestClientDeadline(t *testing.T){
+	// listener, which accepts connections but never responds
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listener wasn't established: %s", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer connection.Close()
+		}
+	}()
+	client := New(listener.Addr().String())
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond * 50)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Get(ctx, "key"); err == nil {
+		t.Fatalf("Call should fail due to deadline")
+	}
+	if time.Since(start) > time.Millisecond * 500 {
+		t.Fatalf("Deadline of context was ignored: %s", time.Since(start))
+	}
+	client.Timeout = time.Millisecond * 50
+	start = time.Now()
+	if _, err := client.Get(context.Background(), "key"); err == nil {
+		t.Fatalf("Call should fail due to timeout")
+	}
+	if time.Since(start) > time.Millisecond * 500 {
+		t.Fatalf("Timeout of client was ignored: %s", time.Since(start))
+	}
+}
+
+func TestClientMalformedKeyAndClosing(t *testing.T){
+	client := New(test_address)
+	ctx := context.Background()
+	for _, key := range []string{"", "with space", "with\nnewline", string(make([]byte, MAX_KEY_LENGTH + 1))} {
+		if _, err := client.Get(ctx, key); err != ErrMalformedKey {
+			t.Fatalf("Key %q should be rejected: %v", key, err)
+		}
+	}
+	client.Close()
+	if _, err := client.Get(ctx, "key"); err != ErrClosed {
+		t.Fatalf("Closed client should fail: %v", err)
+	}
+}
+
+func TestClientCancellationAfterSuccess(t *testing.T){
+	srv := runServer(t)
+	defer srv.StopServer()
+	client := New(test_address)
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	err := client.do(ctx, func(cn *conn) error {
+		_, err := roundTrip(cn, "set key 0 0 5", []byte("value"), protocol.STORED)
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Successful call was reported as failed: %s", err)
+	}
+	if len(client.idle) != 1 {
+		t.Fatalf("Connection wasn't reused after successful call.")
+	}
+	if item, err := client.Get(context.Background(), "key"); err != nil || string(item.Value) != "value" {
+		t.Fatalf("Unexpected result of reused connection: %v, %v", item, err)
+	}
+}
+
+func TestClientAdministration(t *testing.T){
+	srv := runServer(t)
+	defer srv.StopServer()
+	client := New(test_address)
+	defer client.Close()
+	ctx := context.Background()
+	client.Set(ctx, &Item{Key: "key", Value: []byte("value")})
+	client.Set(ctx, &Item{Key: "other", Value: []byte("other value"), Expiration: 3600})
+	client.Get(ctx, "other")
+	var metas []*Meta
+	if err := client.Metadump(ctx, func(meta *Meta) { metas = append(metas, meta) }); err != nil {
+		t.Fatalf("Unexpected error of metadump: %s", err)
+	}
+	if len(metas) != 2 || metas[0].Key != "key" || metas[0].Expiration != -1 || metas[0].Fetched || metas[0].Size != 5 ||
+	   metas[1].Key != "other" || metas[1].Expiration <= time.Now().Unix() || !metas[1].Fetched {
+		t.Fatalf("Unexpected metadata: %v", metas)
+	}
+	// timeout limits reading of each line of dump rather than the whole dump.
+	for i := 0; i < 500; i ++ {
+		client.Set(ctx, &Item{Key: "key" + strconv.Itoa(i), Value: []byte("value")})
+	}
+	client.Timeout = 200 * time.Millisecond
+	metas = nil
+	err := client.Metadump(ctx, func(meta *Meta) {
+		time.Sleep(time.Millisecond)
+		if _, err := client.Get(ctx, meta.Key); err == nil {
+			metas = append(metas, meta)
+		}
+	})
+	if err != nil || len(metas) != 502 {
+		t.Fatalf("Slow metadump was interrupted: %d items, %v", len(metas), err)
+	}
+	client.Timeout = DEFAULT_TIMEOUT
+	if err := client.Crawler(ctx, "tocrawl", "10"); err != nil {
+		t.Fatalf("Unexpected error of crawler: %s", err)
+	}
+	if _, ok := client.Crawler(ctx, "sleep", "-1").(*ClientError); !ok {
+		t.Fatalf("Invalid sleep of crawler was accepted.")
+	}
+	if meta, err := parseMeta("key=a%20b exp=-1 la=1 cas=2 fetch=no cls=1 size=3"); err != nil || meta.Key != "a b" || meta.Cas != 2 {
+		t.Fatalf("Unexpected parsing of metadata: %v, %v", meta, err)
+	}
+}
@@ -0,0 +1,387 @@
+/*
+Package cluster implements client of several MemoranGo nodes, which distributes keys among them by consistent
+hashing compatible with ketama of libmemcached.
+
+Each node is served by single-node client of package client. Node is marked as dead after FailureLimit consecutive
+failures (network errors and timeouts, but not negative responses of server); its points are removed from
+continuum, so only keys of dead node are moved to other nodes. Dead node is retried after backoff, which starts
+from RetryTimeout and doubles after each unsuccessful retry up to MaxRetryTimeout.
+*/
+package cluster
+
+import (
+	"client"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// Defines default number of consecutive failures, after which node is marked as dead.
+	DEFAULT_FAILURE_LIMIT = 2
+	// Defines default duration, after which dead node is retried first time.
+	DEFAULT_RETRY_TIMEOUT = time.Second
+	// Defines default upper limit of backoff of dead node.
+	DEFAULT_MAX_RETRY_TIMEOUT = time.Minute
+)
+
+// Error, which is returned when all nodes of cluster are dead.
+var ErrNoServers = errors.New("memorango: no alive servers")
+
+// Structure of single node of cluster.
+type node struct {
+	address string
+	client *client.Client
+	failures int
+	dead bool
+	retry_at time.Time
+	backoff time.Duration
+}
+
+// Client of several nodes.
+type Cluster struct {
+	// Number of consecutive failures, after which node is marked as dead.
+	FailureLimit int
+	// Initial backoff of dead node.
+	RetryTimeout time.Duration
+	// Maximal backoff of dead node.
+	MaxRetryTimeout time.Duration
+	nodes []*node
+	ring []point
+	mutex sync.Mutex
+}
+
+// Function creates client of nodes with passed addresses ("host:port").
+func New(addresses ...string) *Cluster {
+	cluster := &Cluster{
+		FailureLimit: DEFAULT_FAILURE_LIMIT,
+		RetryTimeout: DEFAULT_RETRY_TIMEOUT,
+		MaxRetryTimeout: DEFAULT_MAX_RETRY_TIMEOUT,
+	}
+	for _, address := range addresses {
+		cluster.nodes = append(cluster.nodes, &node{address: address, client: client.New(address)})
+	}
+	cluster.ring = buildRing(cluster.nodes)
+	return cluster
+}
+
+// Function sets timeout of calls of every node.
+func (c *Cluster) SetTimeout(timeout time.Duration) {
+	for _, n := range c.nodes {
+		n.client.Timeout = timeout
+	}
+}
+
+// Function returns address of node, which currently owns passed key, or empty string if all nodes are dead.
+func (c *Cluster) Node(key string) string {
+	n, err := c.pick(key)
+	if err != nil {
+		return ""
+	}
+	return n.address
+}
+
+// Function returns addresses of nodes, which aren't marked as dead.
+func (c *Cluster) Alive() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.revive(time.Now())
+	var result []string
+	for _, n := range c.nodes {
+		if !n.dead {
+			result = append(result, n.address)
+		}
+	}
+	return result
+}
+
+// Function closes connections of all nodes.
+func (c *Cluster) Close() error {
+	for _, n := range c.nodes {
+		n.client.Close()
+	}
+	return nil
+}
+
+// Private method, which returns dead nodes with expired backoff into continuum.
+// Mutex should be held by caller.
+func (c *Cluster) revive(now time.Time) {
+	var changed = false
+	for _, n := range c.nodes {
+		if n.dead && !now.Before(n.retry_at) {
+			n.dead = false
+			changed = true
+		}
+	}
+	if changed {
+		c.rebuild()
+	}
+}
+
+// Private method, which rebuilds continuum of alive nodes. Mutex should be held by caller.
+func (c *Cluster) rebuild() {
+	var alive []*node
+	for _, n := range c.nodes {
+		if !n.dead {
+			alive = append(alive, n)
+		}
+	}
+	c.ring = buildRing(alive)
+}
+
+// Private method, which returns node owning passed key.
+func (c *Cluster) pick(key string) (*node, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.revive(time.Now())
+	n := lookup(c.ring, hashKey(key))
+	if n == nil {
+		return nil, ErrNoServers
+	}
+	return n, nil
+}
+
+// Private method, which resets counter of failures of node after successful call.
+func (c *Cluster) succeed(n *node) {
+	c.mutex.Lock()
+	n.failures = 0
+	n.backoff = 0
+	c.mutex.Unlock()
+}
+
+// Private method, which records failure of node. Returns true if node was marked as dead,
+// i.e. call can be retried with another node.
+func (c *Cluster) fail(n *node) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if n.dead {
+		return true
+	}
+	n.failures ++
+	if n.failures < c.FailureLimit {
+		return false
+	}
+	if n.backoff == 0 {
+		n.backoff = c.RetryTimeout
+	} else if n.backoff *= 2; n.backoff > c.MaxRetryTimeout {
+		n.backoff = c.MaxRetryTimeout
+	}
+	// retry of revived node should mark it as dead after single failure.
+	n.failures = c.FailureLimit - 1
+	n.dead = true
+	n.retry_at = time.Now().Add(n.backoff)
+	c.rebuild()
+	return true
+}
+
+// Function defines whether passed error of call means failure of node.
+// Negative responses of server, errors of request and cancellation of context don't.
+func failure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	switch err {
+	case client.ErrNotStored, client.ErrExists, client.ErrNotFound, client.ErrUnknownCommand,
+			client.ErrMalformedKey, client.ErrClosed:
+		return false
+	}
+	var server_error *client.ServerError
+	var client_error *client.ClientError
+	return !errors.As(err, &server_error) && !errors.As(err, &client_error)
+}
+
+// Private method, which executes passed function with client of node owning the key.
+// If node dies during the call, call is repeated with the new owner.
+func (c *Cluster) exec(ctx context.Context, key string, fn func(*client.Client) error) error {
+	var err error
+	for attempt := 0; attempt <= len(c.nodes); attempt ++ {
+		var n *node
+		if n, err = c.pick(key); err != nil {
+			return err
+		}
+		err = fn(n.client)
+		if !failure(ctx, err) {
+			c.succeed(n)
+			return err
+		}
+		if !c.fail(n) {
+			return err
+		}
+	}
+	return err
+}
+
+// Function retrieves item by key.
+func (c *Cluster) Get(ctx context.Context, key string) (item *client.Item, err error) {
+	err = c.exec(ctx, key, func(cl *client.Client) error {
+		item, err = cl.Get(ctx, key)
+		return err
+	})
+	return item, err
+}
+
+// Function retrieves item by key along with its unique id.
+func (c *Cluster) Gets(ctx context.Context, key string) (item *client.Item, err error) {
+	err = c.exec(ctx, key, func(cl *client.Client) error {
+		item, err = cl.Gets(ctx, key)
+		return err
+	})
+	return item, err
+}
+
+// Function retrieves many items: keys are grouped by nodes, which are requested concurrently.
+// Keys of nodes died during the call are requested again from their new owners.
+func (c *Cluster) GetMulti(ctx context.Context, keys []string) (map[string] *client.Item, error) {
+	result := make(map[string] *client.Item, len(keys))
+	for attempt := 0; len(keys) > 0; attempt ++ {
+		groups := make(map[*node] []string)
+		for _, key := range keys {
+			n, err := c.pick(key)
+			if err != nil {
+				return nil, err
+			}
+			groups[n] = append(groups[n], key)
+		}
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		var first_error error
+		var retried []string
+		for n, group := range groups {
+			wg.Add(1)
+			go func(n *node, group []string) {
+				defer wg.Done()
+				items, err := n.client.GetMulti(ctx, group)
+				if failure(ctx, err) && c.fail(n) && attempt < len(c.nodes) {
+					mutex.Lock()
+					retried = append(retried, group...)
+					mutex.Unlock()
+					return
+				}
+				if err == nil {
+					c.succeed(n)
+				}
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					if first_error == nil {
+						first_error = err
+					}
+					return
+				}
+				for key, item := range items {
+					result[key] = item
+				}
+			}(n, group)
+		}
+		wg.Wait()
+		if first_error != nil {
+			return nil, first_error
+		}
+		keys = retried
+	}
+	return result, nil
+}
+
+// Function stores item unconditionally.
+func (c *Cluster) Set(ctx context.Context, item *client.Item) error {
+	return c.exec(ctx, item.Key, func(cl *client.Client) error {
+		return cl.Set(ctx, item)
+	})
+}
+
+// Function stores item only if it is missing.
+func (c *Cluster) Add(ctx context.Context, item *client.Item) error {
+	return c.exec(ctx, item.Key, func(cl *client.Client) error {
+		return cl.Add(ctx, item)
+	})
+}
+
+// Function stores item only if it does exist.
+func (c *Cluster) Replace(ctx context.Context, item *client.Item) error {
+	return c.exec(ctx, item.Key, func(cl *client.Client) error {
+		return cl.Replace(ctx, item)
+	})
+}
+
+// Function appends value of passed item to the existing one.
+func (c *Cluster) Append(ctx context.Context, item *client.Item) error {
+	return c.exec(ctx, item.Key, func(cl *client.Client) error {
+		return cl.Append(ctx, item)
+	})
+}
+
+// Function prepends value of passed item to the existing one.
+func (c *Cluster) Prepend(ctx context.Context, item *client.Item) error {
+	return c.exec(ctx, item.Key, func(cl *client.Client) error {
+		return cl.Prepend(ctx, item)
+	})
+}
+
+// Function stores item only if it wasn't modified since it was fetched by Gets.
+func (c *Cluster) CompareAndSwap(ctx context.Context, item *client.Item) error {
+	return c.exec(ctx, item.Key, func(cl *client.Client) error {
+		return cl.CompareAndSwap(ctx, item)
+	})
+}
+
+// Function deletes item by key.
+func (c *Cluster) Delete(ctx context.Context, key string) error {
+	return c.exec(ctx, key, func(cl *client.Client) error {
+		return cl.Delete(ctx, key)
+	})
+}
+
+// Function updates expiration time of item.
+func (c *Cluster) Touch(ctx context.Context, key string, expiration int32) error {
+	return c.exec(ctx, key, func(cl *client.Client) error {
+		return cl.Touch(ctx, key, expiration)
+	})
+}
+
+// Function increases numeric value of item by delta and returns new value.
+func (c *Cluster) Increment(ctx context.Context, key string, delta uint64) (value uint64, err error) {
+	err = c.exec(ctx, key, func(cl *client.Client) error {
+		value, err = cl.Increment(ctx, key, delta)
+		return err
+	})
+	return value, err
+}
+
+// Function decreases numeric value of item by delta and returns new value.
+func (c *Cluster) Decrement(ctx context.Context, key string, delta uint64) (value uint64, err error) {
+	err = c.exec(ctx, key, func(cl *client.Client) error {
+		value, err = cl.Decrement(ctx, key, delta)
+		return err
+	})
+	return value, err
+}
+
+// Function invalidates items of every alive node. Returns the first error of nodes.
+func (c *Cluster) FlushAll(ctx context.Context) error {
+	var first_error error
+	c.mutex.Lock()
+	c.revive(time.Now())
+	var alive []*node
+	for _, n := range c.nodes {
+		if !n.dead {
+			alive = append(alive, n)
+		}
+	}
+	c.mutex.Unlock()
+	if len(alive) == 0 {
+		return ErrNoServers
+	}
+	for _, n := range alive {
+		err := n.client.FlushAll(ctx)
+		if failure(ctx, err) {
+			c.fail(n)
+		} else if err == nil {
+			c.succeed(n)
+		}
+		if err != nil && first_error == nil {
+			first_error = err
+		}
+	}
+	return first_error
+}
@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"testing"
+	"context"
+	"strconv"
+	"time"
+	"client"
+	"server"
+)
+
+var test_ports = []string{"60200", "60201", "60202"}
+
+func runServers(t *testing.T) ([]*server.Server, []string) {
+	var servers []*server.Server
+	var addresses []string
+	for _, port := range test_ports {
+		srv := server.NewServer(port, "", "", 1024, false, false, 0, 1 << 20)
+		srv.RunServer()
+		servers = append(servers, srv)
+		addresses = append(addresses, "127.0.0.1:" + port)
+	}
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while goroutines will start
+	return servers, addresses
+}
+
+func TestRingDistribution(t *testing.T) {
+	cluster := New("127.0.0.1:60200", "127.0.0.1:60201", "127.0.0.1:60202")
+	if len(cluster.ring) != 3 * POINTS_PER_SERVER {
+		t.Fatalf("Unexpected size of continuum: %d", len(cluster.ring))
+	}
+	var counts = make(map[string] int)
+	var owners = make(map[string] string)
+	for i := 0; i < 3000; i ++ {
+		key := "key" + strconv.Itoa(i)
+		owners[key] = cluster.Node(key)
+		counts[owners[key]] ++
+	}
+	for address, count := range counts {
+		if count < 500 {
+			t.Fatalf("Keys are distributed unevenly: %s owns %d keys", address, count)
+		}
+	}
+	// removal of node should move keys of this node only.
+	dead := cluster.nodes[1]
+	dead.dead = true
+	dead.retry_at = time.Now().Add(time.Hour)
+	cluster.rebuild()
+	for key, owner := range owners {
+		current := cluster.Node(key)
+		if owner != dead.address && current != owner {
+			t.Fatalf("Key %s moved from alive node %s to %s", key, owner, current)
+		}
+		if current == dead.address {
+			t.Fatalf("Key %s is still owned by dead node", key)
+		}
+	}
+	if pointsPrefix("host:11211") != "host" || pointsPrefix("host:11212") != "host:11212" {
+		t.Fatalf("Unexpected names of points")
+	}
+}
+
+func TestClusterCommands(t *testing.T) {
+	servers, addresses := runServers(t)
+	for _, srv := range servers {
+		defer srv.StopServer()
+	}
+	cluster := New(addresses...)
+	defer cluster.Close()
+	ctx := context.Background()
+	var keys []string
+	for i := 0; i < 100; i ++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		if err := cluster.Set(ctx, &client.Item{Key: key, Value: []byte(strconv.Itoa(i))}); err != nil {
+			t.Fatalf("Unexpected error of set: %s", err)
+		}
+	}
+	// statistic is fetched by protocol, since servers are still running.
+	for _, address := range addresses {
+		node := client.New(address)
+		stats, err := node.Stats(ctx)
+		node.Close()
+		if err != nil || stats["cmd_set"] == "0" || stats["cmd_set"] == "" {
+			t.Fatalf("Keys weren't distributed among servers: %v, %v", stats["cmd_set"], err)
+		}
+	}
+	items, err := cluster.GetMulti(ctx, keys)
+	if err != nil || len(items) != len(keys) {
+		t.Fatalf("Unexpected result of multi-get: %d items, %s", len(items), err)
+	}
+	if _, err := cluster.Increment(ctx, "key5", 5); err != nil {
+		t.Fatalf("Unexpected error of incr: %s", err)
+	}
+	if item, err := cluster.Get(ctx, "key5"); err != nil || string(item.Value) != "10" {
+		t.Fatalf("Unexpected result of get: %v, %s", item, err)
+	}
+	if err := cluster.Delete(ctx, "key5"); err != nil {
+		t.Fatalf("Unexpected error of delete: %s", err)
+	}
+	if _, err := cluster.Get(ctx, "key5"); err != client.ErrNotFound {
+		t.Fatalf("Negative response shouldn't be failure: %v", err)
+	}
+	if err := cluster.FlushAll(ctx); err != nil {
+		t.Fatalf("Unexpected error of flush_all: %s", err)
+	}
+	if len(cluster.Alive()) != len(addresses) {
+		t.Fatalf("Nodes were marked as dead by negative responses")
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	servers, addresses := runServers(t)
+	for _, srv := range servers {
+		defer srv.StopServer()
+	}
+	cluster := New(addresses...)
+	defer cluster.Close()
+	cluster.FailureLimit = 1
+	cluster.RetryTimeout = time.Millisecond * 100
+	cluster.SetTimeout(time.Millisecond * 200)
+	ctx := context.Background()
+	var key string
+	for i := 0; ; i ++ {
+		key = "key" + strconv.Itoa(i)
+		if cluster.Node(key) == addresses[0] {
+			break
+		}
+	}
+	servers[0].StopServer()
+	time.Sleep(time.Millisecond * time.Duration(10))
+	// the call is repeated with the new owner of key after failure of dead node.
+	if err := cluster.Set(ctx, &client.Item{Key: key, Value: []byte("value")}); err != nil {
+		t.Fatalf("Call wasn't moved to alive node: %s", err)
+	}
+	if len(cluster.Alive()) != 2 || cluster.Node(key) == addresses[0] {
+		t.Fatalf("Failed node wasn't marked as dead: %v", cluster.Alive())
+	}
+	if item, err := cluster.Get(ctx, key); err != nil || string(item.Value) != "value" {
+		t.Fatalf("Unexpected result of get: %v, %s", item, err)
+	}
+	// after backoff node is retried; it is still unavailable, so backoff is doubled.
+	time.Sleep(cluster.RetryTimeout)
+	if err := cluster.Set(ctx, &client.Item{Key: key, Value: []byte("value")}); err != nil {
+		t.Fatalf("Call wasn't moved to alive node: %s", err)
+	}
+	if cluster.nodes[0].backoff != cluster.RetryTimeout * 2 || !cluster.nodes[0].dead {
+		t.Fatalf("Unexpected backoff of dead node: %s", cluster.nodes[0].backoff)
+	}
+	// when node is back, keys return to it.
+	servers[0] = server.NewServer(test_ports[0], "", "", 1024, false, false, 0, 1 << 20)
+	servers[0].RunServer()
+	time.Sleep(cluster.RetryTimeout * 2)
+	if _, err := cluster.Get(ctx, key); err != client.ErrNotFound {
+		t.Fatalf("Key wasn't moved back to revived node: %v", err)
+	}
+	if len(cluster.Alive()) != 3 || cluster.nodes[0].backoff != 0 {
+		t.Fatalf("Node wasn't revived: %v", cluster.Alive())
+	}
+}
+
+func TestClusterWithoutServers(t *testing.T) {
+	cluster := New("127.0.0.1:60209")
+	defer cluster.Close()
+	cluster.FailureLimit = 1
+	ctx := context.Background()
+	if _, err := cluster.Get(ctx, "key"); err != ErrNoServers {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := cluster.Get(ctx, "key"); err != ErrNoServers {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
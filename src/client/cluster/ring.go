@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"crypto/md5"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Number of points of continuum per server, same as ketama of libmemcached.
+	POINTS_PER_SERVER = 160
+	// Number of points derived from single md5 digest.
+	POINTS_PER_HASH = 4
+	// Port, which is omitted from names of points, same as in libmemcached.
+	DEFAULT_PORT = "11211"
+)
+
+// Point of continuum, which belongs to the node.
+type point struct {
+	hash uint32
+	node *node
+}
+
+// Function computes hash of key, which defines its position on continuum.
+func hashKey(key string) uint32 {
+	digest := md5.Sum([]byte(key))
+	return ketamaHash(digest, 0)
+}
+
+// Function extracts 4-byte little-endian hash from digest with passed index of alignment.
+func ketamaHash(digest [md5.Size]byte, alignment int) uint32 {
+	return uint32(digest[3 + alignment * 4]) << 24 | uint32(digest[2 + alignment * 4]) << 16 |
+			uint32(digest[1 + alignment * 4]) << 8 | uint32(digest[alignment * 4])
+}
+
+// Function returns prefix of names of node's points: "host" for default port, otherwise "host:port".
+func pointsPrefix(address string) string {
+	if host, port, found := strings.Cut(address, ":"); found && port == DEFAULT_PORT {
+		return host
+	}
+	return address
+}
+
+// Function builds continuum of passed nodes. Each node gets POINTS_PER_SERVER points,
+// so removal of node moves only keys, which belonged to it.
+func buildRing(nodes []*node) []point {
+	ring := make([]point, 0, len(nodes) * POINTS_PER_SERVER)
+	for _, n := range nodes {
+		prefix := pointsPrefix(n.address)
+		for i := 0; i < POINTS_PER_SERVER / POINTS_PER_HASH; i ++ {
+			digest := md5.Sum([]byte(prefix + "-" + strconv.Itoa(i)))
+			for alignment := 0; alignment < POINTS_PER_HASH; alignment ++ {
+				ring = append(ring, point{hash: ketamaHash(digest, alignment), node: n})
+			}
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	return ring
+}
+
+// Function returns node, which owns passed hash: the first point clockwise from it.
+func lookup(ring []point, hash uint32) *node {
+	if len(ring) == 0 {
+		return nil
+	}
+	index := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= hash
+	})
+	if index == len(ring) {
+		index = 0
+	}
+	return ring[index].node
+}
@@ -0,0 +1,18 @@
+/*
+Package client implements Go client of MemoranGo (and any other memcached compatible server), which speaks
+plain text ascii protocol (TODO: binary).
+
+Client can be initialized by New function with address of server. Client keeps pool of idle connections,
+so it's safe to use single instance from many goroutines; connections are opened on demand and
+reused, unless an error made their state undefined.
+
+Every command receives context.Context, which defines its deadline and may cancel it; besides, each call is limited
+by Timeout field of client. Multi-get requests are pipelined: all of them are sent before reading of responses.
+
+Negative responses of server are returned as typed errors: ErrNotStored, ErrExists, ErrNotFound,
+ErrUnknownCommand, *ServerError and *ClientError.
+
+Besides storage commands, client provides administrative ones: Stats, Metadump (metadata of all items)
+and Crawler (control of LRU crawler).
+*/
+package client
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Structure of single connection to server with buffered reader and writer.
+type conn struct {
+	net_conn net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	mutex sync.Mutex
+	cancelled bool // context of the current call was cancelled, so deadline mustn't be extended
+}
+
+// Private method of connection, which sets deadline of its input/output, unless context of the current call
+// was cancelled (see cancel).
+func (cn *conn) setDeadline(deadline time.Time) {
+	cn.mutex.Lock()
+	defer cn.mutex.Unlock()
+	if !cn.cancelled {
+		cn.net_conn.SetDeadline(deadline)
+	}
+}
+
+// Private method of connection, which interrupts its pending input/output after cancellation of context.
+func (cn *conn) cancel() {
+	cn.mutex.Lock()
+	defer cn.mutex.Unlock()
+	cn.cancelled = true
+	cn.net_conn.SetDeadline(time.Unix(1, 0))
+}
+
+// Private method of connection, which allows to set its deadline again after cancellation of context
+// of the previous call. Deadline itself is set by the next call.
+func (cn *conn) resume() {
+	cn.mutex.Lock()
+	defer cn.mutex.Unlock()
+	cn.cancelled = false
+}
+
+// Private method of client, which returns deadline of input/output: the earliest of context's deadline
+// and client's timeout from now.
+func (c *Client) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(c.Timeout)
+	if ctx_deadline, ok := ctx.Deadline(); ok && ctx_deadline.Before(deadline) {
+		deadline = ctx_deadline
+	}
+	return deadline
+}
+
+// Private method of client, which returns idle connection from the pool or opens new one.
+func (c *Client) acquire(ctx context.Context) (*conn, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	if length := len(c.idle); length > 0 {
+		cn := c.idle[length - 1]
+		c.idle = c.idle[ : length - 1]
+		c.mutex.Unlock()
+		return cn, nil
+	}
+	c.mutex.Unlock()
+	dialer := net.Dialer{Timeout: c.Timeout}
+	net_conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{
+		net_conn: net_conn,
+		reader: bufio.NewReader(net_conn),
+		writer: bufio.NewWriter(net_conn),
+	}, nil
+}
+
+// Private method of client, which returns connection to the pool, if it's possible to reuse it after passed error,
+// and there is a room for it. Otherwise connection is closed.
+func (c *Client) release(cn *conn, err error) {
+	if !resumable(err) {
+		cn.net_conn.Close()
+		return
+	}
+	c.mutex.Lock()
+	if c.closed || len(c.idle) >= c.MaxIdleConns {
+		c.mutex.Unlock()
+		cn.net_conn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+	c.mutex.Unlock()
+}
+
+// Private method of client, which executes passed function with pooled connection.
+// Deadline of connection is the earliest of context's deadline and client's timeout;
+// cancellation of context interrupts pending input/output. Call, which succeeded before cancellation,
+// isn't reported as failed.
+func (c *Client) do(ctx context.Context, fn func(*conn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cn, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	cn.setDeadline(c.deadline(ctx))
+	cancelled := make(chan bool)
+	stop := context.AfterFunc(ctx, func() {
+		cn.cancel()
+		close(cancelled)
+	})
+	err = fn(cn)
+	if !stop() {
+		<-cancelled
+		if err == nil {
+			// call succeeded before cancellation, so its result is returned and connection may be reused.
+			cn.resume()
+		} else {
+			// context was cancelled during the call, so state of connection is undefined.
+			cn.net_conn.Close()
+			if !resumable(err) {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+	c.release(cn, err)
+	return err
+}
+
+// Function defines whether connection can be reused after passed error.
+// It's possible when whole response was read, i.e. server answered with one of known negative responses.
+// Errors of client's request may leave unread data block in the stream, so connection isn't reused after them.
+func resumable(err error) bool {
+	return err == nil || err == ErrNotStored || err == ErrExists || err == ErrNotFound
+}
@@ -0,0 +1,134 @@
+/*
+Package implements configuration file of server.
+
+File consists of "<option> = <value>" lines; empty lines and lines starting with "#" are ignored.
+Option is name of command-line flag (without leading dash) or its long alias for single-letter flags
+(e.g. "memory" for "m"); value may be quoted. Options passed on command line take precedence over file.
+*/
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Long aliases of single-letter flags.
+var aliases = map[string] string{
+	"port": "p",
+	"memory": "m",
+	"daemonize": "d",
+	"listen": "l",
+	"max-connections": "c",
+	"udp-port": "U",
+	"disable-cas": "C",
+	"disable-flush": "F",
+	"help": "h",
+	"verbose": "v",
+	"deep-verbose": "vv",
+	"prefix-delimiter": "D",
+	"pidfile": "P",
+	"user": "u",
+}
+
+// Function returns long name of flag: its alias or name itself.
+func longName(name string) string {
+	for alias, flag_name := range aliases {
+		if flag_name == name {
+			return alias
+		}
+	}
+	return name
+}
+
+// Structure of configuration file: its path and values of options by names of flags.
+type Config struct {
+	Path string
+	Values map[string] string
+}
+
+// Function reads configuration file at passed path. Returns error if file can't be read or has invalid line.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config := &Config{Path: path, Values: make(map[string] string)}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number ++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.Index(line, "=")
+		if separator <= 0 {
+			return nil, errors.New(path + ":" + strconv.Itoa(number) + ": expected <option> = <value>")
+		}
+		name := strings.TrimSpace(line[ : separator])
+		value := strings.TrimSpace(line[separator + 1 : ])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		if flag_name, exists := aliases[name]; exists {
+			name = flag_name
+		}
+		config.Values[name] = value
+	}
+	return config, scanner.Err()
+}
+
+// Function sets values of configuration to flags of passed set, except flags, which were set explicitly
+// (see Explicit). Returns error if option is unknown or its value is invalid.
+func (c *Config) Apply(flags *flag.FlagSet, explicit map[string] bool) error {
+	for name, value := range c.Values {
+		if flags.Lookup(name) == nil {
+			return errors.New(c.Path + ": unknown option " + name)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return errors.New(c.Path + ": invalid value of " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Function returns names of flags of passed set, which were set explicitly (e.g. on command line).
+func Explicit(flags *flag.FlagSet) map[string] bool {
+	explicit := make(map[string] bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// Function returns effective values of all flags of passed set (except "h") by their long names,
+// where dashes are replaced with underscores, as they're shown by "stats settings".
+func Effective(flags *flag.FlagSet) map[string] string {
+	values := make(map[string] string)
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name != "h" {
+			values[strings.Replace(longName(f.Name), "-", "_", -1)] = f.Value.String()
+		}
+	})
+	return values
+}
+
+// Function returns sorted names (by their long names) of options, whose values differ in passed effective
+// configurations (see Effective).
+func Changed(previous map[string] string, current map[string] string) []string {
+	var names []string
+	for name, value := range current {
+		if previous[name] != value {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "memorango_config")
+	if err != nil {
+		t.Fatalf("Impossible to create file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# comment\n\nmemory = 64\nport=11212\nprefix-delimiter = \" \"\naof-fsync = always\n")
+	file.Close()
+	c, err := Load(file.Name())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := map[string] string{"m": "64", "p": "11212", "D": " ", "aof-fsync": "always"}
+	if !reflect.DeepEqual(c.Values, expected) {
+		t.Fatalf("Unexpected values: %v", c.Values)
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	memory := flags.Int("m", 0, "")
+	port := flags.String("p", "11211", "")
+	delimiter := flags.String("D", ":", "")
+	fsync := flags.String("aof-fsync", "everysec", "")
+	flags.Bool("h", false, "")
+	flags.Parse([]string{"-p", "11213"})
+	before := Effective(flags)
+	if err := c.Apply(flags, Explicit(flags)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if *memory != 64 || *port != "11213" || *delimiter != " " || *fsync != "always" {
+		t.Fatalf("Unexpected options: %d %s %q %s", *memory, *port, *delimiter, *fsync)
+	}
+	after := Effective(flags)
+	if _, exists := after["help"]; exists || after["memory"] != "64" || after["aof_fsync"] != "always" {
+		t.Fatalf("Unexpected effective configuration: %v", after)
+	}
+	if changed := Changed(before, after); !reflect.DeepEqual(changed, []string{"aof_fsync", "memory", "prefix_delimiter"}) {
+		t.Fatalf("Unexpected changed options: %v", changed)
+	}
+	c.Values["unknown"] = "1"
+	if c.Apply(flags, nil) == nil {
+		t.Fatalf("Unknown option was accepted.")
+	}
+	delete(c.Values, "unknown")
+	c.Values["m"] = "many"
+	if c.Apply(flags, nil) == nil {
+		t.Fatalf("Invalid value was accepted.")
+	}
+}
+
+func TestConfigInvalidLine(t *testing.T) {
+	file, err := ioutil.TempFile("", "memorango_config")
+	if err != nil {
+		t.Fatalf("Impossible to create file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("memory = 64\nverbose\n")
+	file.Close()
+	if _, err := Load(file.Name()); err == nil || err.Error() != file.Name() + ":2: expected <option> = <value>" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := Load(file.Name() + ".missing"); err == nil {
+		t.Fatalf("Missing file was loaded.")
+	}
+}
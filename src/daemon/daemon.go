@@ -0,0 +1,73 @@
+/*
+Package implements running of server as daemon: detaching from terminal, pidfile, dropping of privileges
+and notifications of systemd (readiness and watchdog).
+
+Process is detached by re-execution of its binary in new session (setsid) with standard input redirected
+from /dev/null and output redirected to log file, so parent may exit as soon as daemon is started.
+*/
+package daemon
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Function starts copy of current binary with passed arguments as daemon: in new session, without
+// controlling terminal, with output appended to file at passed path (output is discarded if path is empty).
+// Returns pid of started daemon.
+func Start(args []string, log_path string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	if len(log_path) == 0 {
+		log_path = os.DevNull
+	}
+	output, err := os.OpenFile(log_path, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer output.Close()
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	// daemon isn't waited by parent, so its resources are released right away.
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// Function switches process to user with passed name (or numeric id) and its primary group.
+// Privileges should be dropped after all privileged resources (e.g. listeners on low ports) are acquired.
+func SetUser(name string) error {
+	account, err := user.Lookup(name)
+	if err != nil {
+		if account, err = user.LookupId(name); err != nil {
+			return errors.New("Unknown user " + name + ".")
+		}
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(account.Gid)
+	if err != nil {
+		return err
+	}
+	// group should be changed first, since it's impossible after changing of user.
+	if err = syscall.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Function creates fake notification socket of systemd and points NOTIFY_SOCKET to it.
+func testNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "memorango_notify")
+	if err != nil {
+		t.Fatalf("Impossible to create directory: %s", err)
+	}
+	path := filepath.Join(dir, "notify")
+	socket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Impossible to create socket: %s", err)
+	}
+	os.Setenv(NOTIFY_SOCKET, path)
+	return socket, func() {
+		os.Unsetenv(NOTIFY_SOCKET)
+		socket.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Function reads the next notification from fake socket.
+func testNotification(t *testing.T, socket *net.UnixConn) string {
+	buffer := make([]byte, 1024)
+	socket.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := socket.Read(buffer)
+	if err != nil {
+		t.Fatalf("Notification wasn't received: %s", err)
+	}
+	return string(buffer[ : n])
+}
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv(NOTIFY_SOCKET)
+	if sent, err := Notify(READY); sent || err != nil {
+		t.Fatalf("Notification was sent without socket: %t, %v", sent, err)
+	}
+	socket, cleanup := testNotifySocket(t)
+	defer cleanup()
+	if sent, err := Notify(READY + "\nMAINPID=1"); !sent || err != nil {
+		t.Fatalf("Notification wasn't sent: %v", err)
+	}
+	if state := testNotification(t, socket); state != READY + "\nMAINPID=1" {
+		t.Fatalf("Unexpected notification: %q", state)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	socket, cleanup := testNotifySocket(t)
+	defer cleanup()
+	defer os.Unsetenv(WATCHDOG_USEC)
+	defer os.Unsetenv(WATCHDOG_PID)
+	os.Unsetenv(WATCHDOG_USEC)
+	if interval, err := WatchdogInterval(); interval != 0 || err != nil {
+		t.Fatalf("Unexpected interval: %s, %v", interval, err)
+	}
+	os.Setenv(WATCHDOG_USEC, "20000")
+	os.Setenv(WATCHDOG_PID, strconv.Itoa(os.Getpid() + 1))
+	if interval, _ := WatchdogInterval(); interval != 0 {
+		t.Fatalf("Watchdog of another process was accepted.")
+	}
+	os.Setenv(WATCHDOG_PID, strconv.Itoa(os.Getpid()))
+	if interval, _ := WatchdogInterval(); interval != 20 * time.Millisecond {
+		t.Fatalf("Unexpected interval: %s", interval)
+	}
+	done := make(chan bool)
+	defer close(done)
+	if started, err := StartWatchdog(func() bool { return true }, done); !started || err != nil {
+		t.Fatalf("Watchdog wasn't started: %v", err)
+	}
+	for i := 0; i < 2; i ++ {
+		if state := testNotification(t, socket); state != WATCHDOG {
+			t.Fatalf("Unexpected notification: %q", state)
+		}
+	}
+}
+
+func TestPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memorango_pid")
+	if err != nil {
+		t.Fatalf("Impossible to create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "memorango.pid")
+	if _, locked := Locked(path); locked {
+		t.Fatalf("Missing pidfile is locked.")
+	}
+	pidfile, err := Lock(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != strconv.Itoa(os.Getpid()) + "\n" {
+		t.Fatalf("Unexpected content of pidfile: %q", content)
+	}
+	if pid, locked := Locked(path); !locked || pid != os.Getpid() {
+		t.Fatalf("Lock of pidfile wasn't detected: %d, %t", pid, locked)
+	}
+	if _, err := Lock(path); err != ErrLocked {
+		t.Fatalf("Locked pidfile was locked again: %v", err)
+	}
+	if err := pidfile.Remove(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Pidfile wasn't removed.")
+	}
+	if pidfile, err = Lock(path); err != nil {
+		t.Fatalf("Released pidfile wasn't locked: %s", err)
+	}
+	pidfile.Remove()
+}
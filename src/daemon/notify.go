@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Notifications of systemd (see sd_notify(3)).
+	READY = "READY=1"
+	STOPPING = "STOPPING=1"
+	RELOADING = "RELOADING=1"
+	WATCHDOG = "WATCHDOG=1"
+	// Variables of environment, which are set by systemd.
+	NOTIFY_SOCKET = "NOTIFY_SOCKET"
+	WATCHDOG_USEC = "WATCHDOG_USEC"
+	WATCHDOG_PID = "WATCHDOG_PID"
+)
+
+// Function sends passed state to systemd (e.g. READY), like sd_notify does. Returns false without error
+// if process isn't run by systemd with notification socket.
+func Notify(state string) (bool, error) {
+	name := os.Getenv(NOTIFY_SOCKET)
+	if len(name) == 0 {
+		return false, nil
+	}
+	if name[0] == '@' {
+		// abstract socket
+		name = "\x00" + name[1 : ]
+	}
+	connection, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer connection.Close()
+	if _, err = connection.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Function returns interval of systemd watchdog or zero if watchdog isn't enabled for current process.
+func WatchdogInterval() (time.Duration, error) {
+	value := os.Getenv(WATCHDOG_USEC)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	usec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, err
+	}
+	if pid := os.Getenv(WATCHDOG_PID); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		// watchdog is meant for another process.
+		return 0, nil
+	}
+	return time.Duration(usec) * time.Microsecond, nil
+}
+
+// Function starts goroutine, which notifies systemd watchdog twice per its interval while passed check
+// of health returns true, until done channel is closed. Returns false if watchdog isn't enabled.
+func StartWatchdog(healthy func() bool, done <-chan bool) (bool, error) {
+	interval, err := WatchdogInterval()
+	if err != nil || interval == 0 {
+		return false, err
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if healthy() {
+					Notify(WATCHDOG)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return true, nil
+}
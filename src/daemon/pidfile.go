@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Error, which is returned when pidfile is locked by another running process.
+var ErrLocked = errors.New("Pidfile is locked by another process.")
+
+// Structure of pidfile, which is locked while process is running, so another process can't be started
+// with the same pidfile.
+type PidFile struct {
+	path string
+	file *os.File
+}
+
+// Function creates (or reuses) pidfile at passed path, locks it and writes pid of current process into it.
+// Returns ErrLocked if file is locked by another process.
+func Lock(path string) (*PidFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX | syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	if err = file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid()) + "\n"), 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &PidFile{path: path, file: file}, nil
+}
+
+// Function returns pid of process, which holds lock of pidfile at passed path, and true
+// or zero and false if pidfile doesn't exist or isn't locked.
+func Locked(path string) (int, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH | syscall.LOCK_NB); err == nil {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return 0, false
+	}
+	content, _ := ioutil.ReadAll(file)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return pid, true
+}
+
+// Getter for path field.
+func (p *PidFile) Path() string {
+	return p.path
+}
+
+// Function removes pidfile and releases its lock.
+func (p *PidFile) Remove() error {
+	err := os.Remove(p.path)
+	p.file.Close()
+	return err
+}
@@ -0,0 +1,222 @@
+/*
+Package implements portable file format of dumps of items, which are used for migrating contents of cache
+between servers.
+
+File starts with header: magic "MGDUMP" and version (big-endian uint16). Then items follow, each of them
+is record of type 'I': length of key (uint16), key, flags (uint32), absolute expiration timestamp (int64,
+zero means no expiration), unique id (uint64), length of value (uint32) and value. File ends with trailer
+of type 'E': number of items (uint64) and CRC-32 (IEEE) of all preceding bytes (uint32). All numbers are
+big-endian. Reader reports file without valid trailer as truncated, so partial dumps are detected.
+*/
+package dumpfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// Magic bytes at the beginning of file.
+	MAGIC = "MGDUMP"
+	// Version of format, which is written by Writer.
+	VERSION = 1
+	// Defines the maximal length of key.
+	MAX_KEY_LENGTH = 250
+	// Types of records.
+	ITEM_RECORD = 'I'
+	END_RECORD = 'E'
+)
+
+// Errors of reading of dump.
+var (
+	ErrFormat = errors.New("File isn't dump of MemoranGo.")
+	ErrVersion = errors.New("Unsupported version of dump.")
+	ErrTruncated = errors.New("Dump is truncated.")
+	ErrChecksum = errors.New("Checksum of dump mismatches.")
+	ErrCorrupted = errors.New("Dump is corrupted.")
+)
+
+// Structure of dumped item.
+type Item struct {
+	Key string
+	Flags uint32
+	// Absolute expiration timestamp; zero means no expiration.
+	Exptime int64
+	// Unique id of item in source server; servers assign new ids on restore.
+	Cas uint64
+	Value []byte
+}
+
+// Writer of dump.
+type Writer struct {
+	writer *bufio.Writer
+	checksum hash.Hash32
+	count uint64
+}
+
+// Function creates writer of dump into passed stream and writes header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	checksum := crc32.NewIEEE()
+	writer := &Writer{writer: bufio.NewWriter(io.MultiWriter(w, checksum)), checksum: checksum}
+	writer.writer.WriteString(MAGIC)
+	if err := binary.Write(writer.writer, binary.BigEndian, uint16(VERSION)); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Function writes passed item into dump.
+func (w *Writer) Write(item *Item) error {
+	if len(item.Key) == 0 || len(item.Key) > MAX_KEY_LENGTH {
+		return errors.New("Invalid key of item.")
+	}
+	w.writer.WriteByte(ITEM_RECORD)
+	binary.Write(w.writer, binary.BigEndian, uint16(len(item.Key)))
+	w.writer.WriteString(item.Key)
+	binary.Write(w.writer, binary.BigEndian, item.Flags)
+	binary.Write(w.writer, binary.BigEndian, item.Exptime)
+	binary.Write(w.writer, binary.BigEndian, item.Cas)
+	binary.Write(w.writer, binary.BigEndian, uint32(len(item.Value)))
+	if _, err := w.writer.Write(item.Value); err != nil {
+		return err
+	}
+	w.count ++
+	return nil
+}
+
+// Function writes trailer of dump and flushes it. Underlying stream isn't closed.
+func (w *Writer) Close() error {
+	w.writer.WriteByte(END_RECORD)
+	binary.Write(w.writer, binary.BigEndian, w.count)
+	// checksum covers everything written before it, so buffered data should reach it first.
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	binary.Write(w.writer, binary.BigEndian, w.checksum.Sum32())
+	return w.writer.Flush()
+}
+
+// Getter for count field: number of written items.
+func (w *Writer) Count() uint64 {
+	return w.count
+}
+
+// Reader of dump.
+type Reader struct {
+	reader *bufio.Reader
+	checksum hash.Hash32
+	count uint64
+	finished bool
+}
+
+// Function creates reader of dump from passed stream and checks its header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{reader: bufio.NewReader(r), checksum: crc32.NewIEEE()}
+	header := make([]byte, len(MAGIC) + 2)
+	if _, err := io.ReadFull(reader.reader, header); err != nil {
+		return nil, ErrFormat
+	}
+	reader.checksum.Write(header)
+	if string(header[ : len(MAGIC)]) != MAGIC {
+		return nil, ErrFormat
+	}
+	if binary.BigEndian.Uint16(header[len(MAGIC) : ]) != VERSION {
+		return nil, ErrVersion
+	}
+	return reader, nil
+}
+
+// Private method, which reads exactly passed number of bytes and adds them to checksum.
+// Unexpected end of stream is reported as ErrTruncated. Buffer grows while data is read,
+// so corrupted length doesn't allocate memory in advance.
+func (r *Reader) read(size int) ([]byte, error) {
+	var data bytes.Buffer
+	n, err := io.CopyN(&data, r.reader, int64(size))
+	if err != nil {
+		if err == io.EOF || n < int64(size) {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+	r.checksum.Write(data.Bytes())
+	return data.Bytes(), nil
+}
+
+// Function reads the next item of dump. Returns io.EOF after trailer, if dump is complete and its checksum
+// matches, otherwise ErrTruncated, ErrChecksum or ErrCorrupted.
+func (r *Reader) Read() (*Item, error) {
+	if r.finished {
+		return nil, io.EOF
+	}
+	kind, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	switch kind[0] {
+	case ITEM_RECORD:
+		data, err := r.read(2)
+		if err != nil {
+			return nil, err
+		}
+		key_length := int(binary.BigEndian.Uint16(data))
+		if key_length == 0 || key_length > MAX_KEY_LENGTH {
+			return nil, ErrCorrupted
+		}
+		if data, err = r.read(key_length + 4 + 8 + 8 + 4); err != nil {
+			return nil, err
+		}
+		item := &Item{
+			Key: string(data[ : key_length]),
+			Flags: binary.BigEndian.Uint32(data[key_length : ]),
+			Exptime: int64(binary.BigEndian.Uint64(data[key_length + 4 : ])),
+			Cas: binary.BigEndian.Uint64(data[key_length + 12 : ]),
+		}
+		if item.Value, err = r.read(int(binary.BigEndian.Uint32(data[key_length + 20 : ]))); err != nil {
+			return nil, err
+		}
+		r.count ++
+		return item, nil
+	case END_RECORD:
+		data, err := r.read(8)
+		if err != nil {
+			return nil, err
+		}
+		sum := r.checksum.Sum32()
+		stored := make([]byte, 4)
+		if _, err = io.ReadFull(r.reader, stored); err != nil {
+			return nil, ErrTruncated
+		}
+		if binary.BigEndian.Uint32(stored) != sum {
+			return nil, ErrChecksum
+		}
+		if binary.BigEndian.Uint64(data) != r.count {
+			return nil, ErrCorrupted
+		}
+		if _, err = r.reader.ReadByte(); err != io.EOF {
+			return nil, ErrCorrupted
+		}
+		r.finished = true
+		return nil, io.EOF
+	}
+	return nil, ErrCorrupted
+}
+
+// Function reads the whole dump from passed stream and checks it. Returns number of items.
+func Verify(r io.Reader) (uint64, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := reader.Read(); err == io.EOF {
+			return reader.count, nil
+		} else if err != nil {
+			return reader.count, err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package dumpfile
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Function returns dump of passed items.
+func testDump(t *testing.T, items ...*Item) []byte {
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, item := range items {
+		if err := writer.Write(item); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestDumpReading(t *testing.T) {
+	items := []*Item{{Key: "key", Flags: 42, Exptime: 1700000000, Cas: 7, Value: []byte("value")},
+					 {Key: "empty", Value: []byte{}}}
+	data := testDump(t, items...)
+	if !bytes.HasPrefix(data, []byte("MGDUMP\x00\x01I")) {
+		t.Fatalf("Unexpected header: %q", data[ : 9])
+	}
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, expected := range items {
+		item, err := reader.Read()
+		if err != nil || item.Key != expected.Key || item.Flags != expected.Flags || item.Exptime != expected.Exptime ||
+		   item.Cas != expected.Cas || !bytes.Equal(item.Value, expected.Value) {
+			t.Fatalf("Unexpected item: %v, %v", item, err)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Fatalf("Unexpected end of dump: %v", err)
+	}
+	if count, err := Verify(bytes.NewReader(data)); count != 2 || err != nil {
+		t.Fatalf("Unexpected verification: %d, %v", count, err)
+	}
+	if writer, _ := NewWriter(&bytes.Buffer{}); writer.Write(&Item{Key: ""}) == nil {
+		t.Fatalf("Empty key was accepted.")
+	}
+}
+
+func TestDumpDamages(t *testing.T) {
+	data := testDump(t, &Item{Key: "key", Value: []byte("value")}, &Item{Key: "other", Value: []byte("value")})
+	for length := 8; length < len(data); length ++ {
+		if _, err := Verify(bytes.NewReader(data[ : length])); err != ErrTruncated {
+			t.Fatalf("Truncation at %d wasn't detected: %v", length, err)
+		}
+	}
+	damaged := append([]byte{}, data...)
+	damaged[len(damaged) - 10] ^= 1
+	if _, err := Verify(bytes.NewReader(damaged)); err != ErrChecksum {
+		t.Fatalf("Damage wasn't detected: %v", err)
+	}
+	if _, err := Verify(bytes.NewReader(append(append([]byte{}, data...), 0))); err != ErrCorrupted {
+		t.Fatalf("Garbage after trailer wasn't detected: %v", err)
+	}
+	version := append([]byte{}, data...)
+	version[7] = 2
+	if _, err := NewReader(bytes.NewReader(version)); err != ErrVersion {
+		t.Fatalf("Unsupported version was accepted: %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("VALUE key 0 5\r\n"))); err != ErrFormat {
+		t.Fatalf("Invalid format was accepted: %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(nil)); err != ErrFormat {
+		t.Fatalf("Empty file was accepted: %v", err)
+	}
+	// item with corrupted length of key
+	corrupted := append([]byte("MGDUMP\x00\x01I\x00\x00"), data[11 : ]...)
+	if _, err := Verify(bytes.NewReader(corrupted)); err != ErrCorrupted {
+		t.Fatalf("Corrupted item was accepted: %v", err)
+	}
+	if !reflect.DeepEqual(data, testDump(t, &Item{Key: "key", Value: []byte("value")}, &Item{Key: "other", Value: []byte("value")})) {
+		t.Fatalf("Dump isn't deterministic.")
+	}
+}
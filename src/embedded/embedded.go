@@ -0,0 +1,686 @@
+/*
+Package embedded provides in-process access to MemoranGo storage without network layer.
+
+Cache wraps LRUCache and implements semantics of memcached commands: storage commands (Set, Add, Replace, Append,
+Prepend, CompareAndSwap), counters (Increment, Decrement), Touch, Delete and FlushAll. All methods are safe for
+concurrent use. Handlers of network protocol are built on the same API, so both paths share behavior.
+
+Values are kept as passed: slices passed to storage methods and returned by Get mustn't be modified.
+*/
+package embedded
+
+import (
+	"errors"
+	"strconv"
+	"time"
+	"tools"
+	"tools/cache"
+)
+
+// Defines number of keys returned by Subscribe, which are supposed to be passed to Snapshot at once.
+const SNAPSHOT_BATCH = 256
+
+var (
+	// Item is missing or expired.
+	ErrNotFound = errors.New("embedded: item not found")
+	// Item wasn't stored, since condition of add, replace, append or prepend wasn't met.
+	ErrNotStored = errors.New("embedded: item not stored")
+	// Item was modified since its unique id was fetched.
+	ErrExists = errors.New("embedded: item exists")
+	// There is no room for item, even after eviction of least recently used ones.
+	ErrNoMemory = errors.New("embedded: not enough memory")
+	// Value of item isn't decimal representation of unsigned 64-bit integer.
+	ErrNotNumeric = errors.New("embedded: value isn't numeric")
+)
+
+// Structure of item.
+type Item struct {
+	Key string
+	Value []byte
+	// Arbitrary flags stored along with value.
+	Flags uint32
+	// Time to live of item; zero means no expiration, negative value expires item immediately.
+	TTL time.Duration
+	// Absolute expiration time, which takes precedence over TTL if isn't zero.
+	// Items returned by Get have it filled (zero if item never expires).
+	Expiration time.Time
+	// Unique id of item's version, which is required by CompareAndSwap.
+	Cas uint64
+	// Value as chain of chunks, which is used by storage methods instead of Value if it isn't nil.
+	// Items returned by GetChunked have it filled instead of Value.
+	Chunks [][]byte
+	// Tags of item, which allow to invalidate groups of items by InvalidateTag.
+	// Storage methods replace tags of item, except Append, Prepend, Increment, Decrement and Touch, which keep them.
+	Tags []string
+}
+
+// Function returns expiration timestamp of item for storage, where zero means no expiration.
+func (item *Item) exptime() int64 {
+	if !item.Expiration.IsZero() {
+		return item.Expiration.Unix()
+	}
+	if item.TTL == 0 {
+		return 0
+	}
+	return time.Now().Add(item.TTL).Unix()
+}
+
+// Function returns "set" mutation, which stores item, e.g. for writing of snapshot.
+func (item *Item) Mutation() *Mutation {
+	return &Mutation{Command: "set", Key: item.Key, Value: item.Value, Chunks: item.Chunks, Flags: item.Flags,
+					 Expiration: item.Expiration, Tags: item.Tags}
+}
+
+// Function builds data of storage from value or chunks of item.
+func (item *Item) data() cache.Cacheable {
+	return newData(item.Key, item.Value, item.Chunks)
+}
+
+// Function builds data of storage from value or, if it isn't nil, chain of chunks.
+// Values larger than tools.CHUNK_SIZE are kept as chains of chunks.
+func newData(key string, value []byte, chunks [][]byte) cache.Cacheable {
+	if chunks == nil {
+		if value == nil {
+			value = []byte{}
+		}
+		if len(value) <= tools.CHUNK_SIZE {
+			return tools.NewStoredData(value, key)
+		}
+		chunks = tools.Chunk(value)
+	}
+	data := tools.NewChunkedData(chunks, key)
+	if data.Size() > tools.CHUNK_SIZE {
+		return data
+	}
+	return tools.NewStoredData(data.Value(), key)
+}
+
+// Function builds Item from item of storage without value: it's read by caller from data of item after lock
+// is released, since reading may be long (value is decompressed or read from extstore).
+// Stored data isn't modified in place, only replaced, so it may be read without lock.
+func newItem(stored *cache.LRUCacheItem) *Item {
+	item := &Item{
+		Key: stored.Cacheable.Key(),
+		Flags: uint32(stored.Flags),
+		Cas: uint64(stored.Cas_unique),
+		Tags: append([]string(nil), stored.Tags()...),
+	}
+	item.Expiration = expiration(stored.Exptime)
+	return item
+}
+
+// Mutation of storage, which is passed to observers.
+type Mutation = cache.Mutation
+
+// Interface of observer of mutations.
+// Mutated is called under lock of storage in order of mutations, so it mustn't block or access the cache.
+// Expiration and eviction of items aren't reported.
+type Observer = cache.Observer
+
+// Interface of observer, which completes handling of mutations without lock of storage, e.g. syncs them to disk.
+// Committed is called after lock is released by method of cache, which could make mutations, before it returns.
+type Committer = cache.Committer
+
+// Concurrency-safe cache.
+type Cache struct {
+	storage *cache.LRUCache
+}
+
+// Function creates cache, which is allowed to keep values of passed total size (bytes).
+// Returns nil if capacity is invalid.
+func New(capacity int64) *Cache {
+	storage := cache.New(capacity)
+	if storage == nil {
+		return nil
+	}
+	return &Cache{storage: storage}
+}
+
+// Function builds cache over existing storage. Any number of caches may wrap the same storage:
+// access is synchronized by lock of storage itself, and observers are kept by storage, so mutations made
+// through any of them are reported to all observers.
+func Wrap(storage *cache.LRUCache) *Cache {
+	return &Cache{storage: storage}
+}
+
+// Function registers observer of mutations, which are made through this cache or any other wrapper
+// of the same storage. Keys of alive items (from the least recently used one) are collected atomically with
+// registration and returned; their values are read by Snapshot, so lock isn't held for reading of all values.
+func (c *Cache) Subscribe(observer Observer) []string {
+	c.storage.Lock()
+	defer c.storage.Unlock()
+	c.storage.AddObserver(observer)
+	return c.keys()
+}
+
+// Function returns keys of alive items (from the least recently used one), so they may be read by batches
+// without holding of lock for all of them (see Snapshot).
+func (c *Cache) Keys() []string {
+	c.storage.Lock()
+	defer c.storage.Unlock()
+	return c.keys()
+}
+
+// Private method, which collects keys of alive items. Lock of storage should be held by caller.
+func (c *Cache) keys() []string {
+	var keys []string
+	now := time.Now().Unix()
+	c.storage.Walk(func(stored *cache.LRUCacheItem) {
+		if alive(c.storage, stored, now) {
+			keys = append(keys, stored.Cacheable.Key())
+		}
+	})
+	return keys
+}
+
+// Function reads alive items with passed keys (missing ones are skipped), e.g. batch of keys returned
+// by Subscribe. Passed barrier is called under lock along with collecting of items, so observer may mark position
+// in its stream of mutations: items reflect all mutations notified before it and none of notified after it.
+// Values are read (from extstore or decompressed) after the lock is released; large values are returned as
+// chains of chunks (Chunks field).
+func (c *Cache) Snapshot(keys []string, barrier func()) []*Item {
+	items := make([]*Item, 0, len(keys))
+	data := make([]cache.Cacheable, 0, len(keys))
+	c.storage.Lock()
+	now := time.Now().Unix()
+	for _, key := range keys {
+		if stored := c.storage.Peek(key); stored != nil && alive(c.storage, stored, now) {
+			items = append(items, newItem(stored))
+			data = append(data, stored.Cacheable)
+		}
+	}
+	if barrier != nil {
+		barrier()
+	}
+	c.storage.Unlock()
+	snapshot := items[ : 0]
+	for i, item := range items {
+		if chunked, ok := data[i].(tools.Chunked); ok {
+			item.Chunks = chunked.Chunks()
+		} else if item.Value = tools.ExtractStoredData(data[i]); item.Value == nil {
+			continue // value was lost by extstore
+		}
+		snapshot = append(snapshot, item)
+	}
+	return snapshot
+}
+
+// Function checks that passed item of storage isn't expired or flushed with its namespace.
+func alive(storage *cache.LRUCache, stored *cache.LRUCacheItem, now int64) bool {
+	return (stored.Exptime == 0 || stored.Exptime >= now) && !storage.Stale(stored)
+}
+
+// Function discards registration of observer.
+func (c *Cache) Unsubscribe(observer Observer) {
+	c.storage.Lock()
+	defer c.storage.Unlock()
+	c.storage.RemoveObserver(observer)
+}
+
+// Private method, which returns true if storage has observers of mutations. Lock should be held by caller.
+func (c *Cache) observed() bool {
+	return len(c.storage.Observers()) > 0
+}
+
+// Private method, which passes mutation to observers. Lock should be held by caller.
+func (c *Cache) notify(mutation *Mutation) {
+	for _, observer := range c.storage.Observers() {
+		observer.Mutated(mutation)
+	}
+}
+
+// Private method, which releases lock of storage after mutation and lets committers among observers
+// complete its handling.
+func (c *Cache) unlock() {
+	observers := c.storage.Observers()
+	c.storage.Unlock()
+	for _, observer := range observers {
+		if committer, ok := observer.(Committer); ok {
+			committer.Committed()
+		}
+	}
+}
+
+// Function converts expiration timestamp of storage into time.
+func expiration(exptime int64) time.Time {
+	if exptime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(exptime, 0)
+}
+
+// Getter for storage field.
+func (c *Cache) Storage() *cache.LRUCache {
+	return c.storage
+}
+
+// Function executes passed function with exclusive access to the storage,
+// e.g. for reading its statistic or managing its crawler.
+func (c *Cache) Locked(fn func(storage *cache.LRUCache)) {
+	c.storage.Lock()
+	defer c.storage.Unlock()
+	fn(c.storage)
+}
+
+// Private method, which returns alive item of storage. Lock should be held by caller.
+// Items stored without unique id get it on first retrieval.
+func (c *Cache) get(key string) *cache.LRUCacheItem {
+	stored := c.storage.Get(key)
+	if stored == nil {
+		return nil
+	}
+	if _, ok := stored.Cacheable.(tools.Valuable); !ok {
+		return nil
+	}
+	if stored.Cas_unique == 0 {
+		stored.Cas_unique = tools.GenerateCasId()
+	}
+	return stored
+}
+
+// Private method, which stores value with new unique id. Lock should be held by caller.
+// Value isn't compressed, since it's supposed to be small (e.g. counter).
+func (c *Cache) set(key string, value []byte, flags uint32, exptime int64, tags []string) error {
+	data := newData(key, value, nil)
+	return c.store(data, data, flags, exptime, tags)
+}
+
+// Private method, which returns compressed version of passed data, if compression of storage is turned on
+// and data is large enough. It's called before lock is taken.
+func (c *Cache) compress(data cache.Cacheable) cache.Cacheable {
+	return c.storage.Compression().Compress(data)
+}
+
+// Private method, which stores compressed version of data (or data itself) with new unique id and tags.
+// Lock should be held by caller. Observers receive data uncompressed and chunked data as chain of chunks.
+func (c *Cache) store(data cache.Cacheable, compressed cache.Cacheable, flags uint32, exptime int64,
+					  tags []string) error {
+	if err := c.put(compressed, flags, exptime, tags); err != nil {
+		return err
+	}
+	if c.observed() {
+		mutation := &Mutation{Command: "set", Key: data.Key(), Flags: flags, Expiration: expiration(exptime), Tags: tags}
+		if chunked, ok := data.(tools.Chunked); ok {
+			mutation.Chunks = chunked.Chunks()
+		} else {
+			mutation.Value = tools.ExtractStoredData(data)
+		}
+		c.notify(mutation)
+	}
+	return nil
+}
+
+// Private method, which stores data with new unique id and tags without notification of observers.
+// Lock should be held by caller.
+func (c *Cache) put(data cache.Cacheable, flags uint32, exptime int64, tags []string) error {
+	if !c.storage.Set(data, int(flags), exptime, tools.GenerateCasId()) {
+		return ErrNoMemory
+	}
+	c.storage.Tag(data.Key(), tags)
+	return nil
+}
+
+// Function retrieves item by key. Returns ErrNotFound if item is missing or expired.
+func (c *Cache) Get(key string) (*Item, error) {
+	c.storage.Lock()
+	stored := c.get(key)
+	if stored == nil {
+		c.storage.Unlock()
+		return nil, ErrNotFound
+	}
+	item, data := newItem(stored), stored.Cacheable
+	c.storage.Unlock()
+	if item.Value = tools.ExtractStoredData(data); item.Value == nil {
+		return nil, ErrNotFound // value was lost by extstore
+	}
+	return item, nil
+}
+
+// Function does the same as Get, but returns value of item as chain of chunks (Chunks field) without joining it,
+// so large values may be written out without copying. Value field of returned item is nil.
+func (c *Cache) GetChunked(key string) (*Item, error) {
+	c.storage.Lock()
+	stored := c.get(key)
+	if stored == nil {
+		c.storage.Unlock()
+		return nil, ErrNotFound
+	}
+	item, data := newItem(stored), stored.Cacheable
+	c.storage.Unlock()
+	if item.Chunks = tools.ExtractChunks(data); item.Chunks == nil {
+		return nil, ErrNotFound // value was lost by extstore
+	}
+	return item, nil
+}
+
+// Function stores item unconditionally.
+func (c *Cache) Set(item *Item) error {
+	data := item.data()
+	compressed := c.compress(data)
+	c.storage.Lock()
+	defer c.unlock()
+	return c.store(data, compressed, item.Flags, item.exptime(), item.Tags)
+}
+
+// Function stores item only if it is missing; otherwise ErrNotStored is returned.
+func (c *Cache) Add(item *Item) error {
+	data := item.data()
+	compressed := c.compress(data)
+	c.storage.Lock()
+	defer c.unlock()
+	if c.get(item.Key) != nil {
+		return ErrNotStored
+	}
+	return c.store(data, compressed, item.Flags, item.exptime(), item.Tags)
+}
+
+// Function stores item only if it does exist; otherwise ErrNotStored is returned.
+func (c *Cache) Replace(item *Item) error {
+	data := item.data()
+	compressed := c.compress(data)
+	c.storage.Lock()
+	defer c.unlock()
+	if c.get(item.Key) == nil {
+		return ErrNotStored
+	}
+	return c.store(data, compressed, item.Flags, item.exptime(), item.Tags)
+}
+
+// Private method, which concatenates existing value with passed chunks of data.
+// Large values are concatenated as chains of chunks, so existing value isn't copied.
+// Value, which is compressed (or moved to extstore) or should be compressed, is concatenated without lock:
+// if item was modified meanwhile, its new version is concatenated again.
+// Observers receive only concatenated data.
+func (c *Cache) concat(key string, data [][]byte, prepend bool) error {
+	compression := c.storage.Compression()
+	c.storage.Lock()
+	defer c.unlock()
+	for {
+		stored := c.get(key)
+		if stored == nil {
+			return ErrNotStored
+		}
+		var result cache.Cacheable
+		_, plain := stored.Cacheable.(tools.StoredData)
+		_, chunked := stored.Cacheable.(tools.ChunkedData)
+		if compression == nil && (plain || chunked) {
+			result = concatenated(stored.Cacheable, data, prepend)
+		} else {
+			existed, cas := stored.Cacheable, stored.Cas_unique
+			c.storage.Unlock()
+			result = compression.Compress(joined(existed, data, prepend))
+			c.storage.Lock()
+			if stored = c.get(key); stored == nil || stored.Cas_unique != cas {
+				continue
+			}
+		}
+		if err := c.put(result, uint32(stored.Flags), stored.Exptime, stored.Tags()); err != nil {
+			return err
+		}
+		break
+	}
+	if c.observed() {
+		mutation := &Mutation{Command: "append", Key: key, Chunks: data}
+		if prepend {
+			mutation.Command = "prepend"
+		}
+		c.notify(mutation)
+	}
+	return nil
+}
+
+// Function builds data of storage from passed value concatenated with passed chunks of data.
+// Chunks of chunked value are shared with result, so only the latest version of value should be passed
+// and lock should be held by caller.
+func concatenated(existed cache.Cacheable, data [][]byte, prepend bool) cache.Cacheable {
+	if chunked, ok := existed.(tools.ChunkedData); ok {
+		return chunked.Concat(data, prepend)
+	}
+	value := tools.ExtractStoredData(existed)
+	var size = len(value)
+	for _, chunk := range data {
+		size += len(chunk)
+	}
+	if size > tools.CHUNK_SIZE {
+		chunked := tools.NewChunkedData([][]byte{value}, existed.Key())
+		return chunked.Concat(data, prepend)
+	}
+	return joined(existed, data, prepend)
+}
+
+// Function builds data of storage from passed value concatenated with passed chunks of data into new byte-string,
+// so it may be called without lock.
+func joined(existed cache.Cacheable, data [][]byte, prepend bool) cache.Cacheable {
+	chunks := make([][]byte, 0, len(data) + 1)
+	if !prepend {
+		chunks = append(chunks, tools.ExtractStoredData(existed))
+	}
+	chunks = append(chunks, data...)
+	if prepend {
+		chunks = append(chunks, tools.ExtractStoredData(existed))
+	}
+	var size = 0
+	for _, chunk := range chunks {
+		size += len(chunk)
+	}
+	value := make([]byte, 0, size)
+	for _, chunk := range chunks {
+		value = append(value, chunk...)
+	}
+	return newData(existed.Key(), value, nil)
+}
+
+// Function appends passed data to value of existing item, keeping its flags and expiration.
+// Returns ErrNotStored if item is missing.
+func (c *Cache) Append(key string, data []byte) error {
+	return c.concat(key, [][]byte{data}, false)
+}
+
+// Function prepends passed data to value of existing item, keeping its flags and expiration.
+// Returns ErrNotStored if item is missing.
+func (c *Cache) Prepend(key string, data []byte) error {
+	return c.concat(key, [][]byte{data}, true)
+}
+
+// Function appends passed chain of chunks to value of existing item, keeping its flags and expiration.
+// Returns ErrNotStored if item is missing.
+func (c *Cache) AppendChunks(key string, chunks [][]byte) error {
+	return c.concat(key, chunks, false)
+}
+
+// Function prepends passed chain of chunks to value of existing item, keeping its flags and expiration.
+// Returns ErrNotStored if item is missing.
+func (c *Cache) PrependChunks(key string, chunks [][]byte) error {
+	return c.concat(key, chunks, true)
+}
+
+// Function stores item only if its unique id (Cas field) matches the stored one.
+// Returns ErrNotFound if item is missing and ErrExists if it was modified.
+func (c *Cache) CompareAndSwap(item *Item) error {
+	data := item.data()
+	compressed := c.compress(data)
+	c.storage.Lock()
+	defer c.unlock()
+	stored := c.storage.Get(item.Key)
+	if stored == nil {
+		return ErrNotFound
+	}
+	if stored.Cas_unique == 0 || uint64(stored.Cas_unique) != item.Cas {
+		return ErrExists
+	}
+	return c.store(data, compressed, item.Flags, item.exptime(), item.Tags)
+}
+
+// Private method, which changes numeric value of item by delta.
+// Values are 64-bit unsigned integers, as memcached's protocol.txt defines: overflow of increment wraps around
+// the 64 bit mark, underflow of decrement is caught and gives 0.
+func (c *Cache) fold(key string, delta uint64, increment bool) (uint64, error) {
+	c.storage.Lock()
+	defer c.unlock()
+	stored := c.get(key)
+	if stored == nil {
+		return 0, ErrNotFound
+	}
+	value, err := strconv.ParseUint(string(tools.ExtractStoredData(stored.Cacheable)), 10, 64)
+	if err != nil {
+		return 0, ErrNotNumeric
+	}
+	if increment {
+		value += delta
+	} else if delta > value {
+		value = 0
+	} else {
+		value -= delta
+	}
+	if err := c.set(key, []byte(strconv.FormatUint(value, 10)), uint32(stored.Flags), stored.Exptime,
+							 stored.Tags()); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// Function increases numeric value of item by delta and returns new value.
+func (c *Cache) Increment(key string, delta uint64) (uint64, error) {
+	return c.fold(key, delta, true)
+}
+
+// Function decreases numeric value of item by delta (but not below zero) and returns new value.
+func (c *Cache) Decrement(key string, delta uint64) (uint64, error) {
+	return c.fold(key, delta, false)
+}
+
+// Function updates time to live of item. Returns ErrNotFound if item is missing.
+func (c *Cache) Touch(key string, ttl time.Duration) error {
+	item := Item{TTL: ttl}
+	return c.touch(key, item.exptime())
+}
+
+// Function updates absolute expiration time of item; zero time means no expiration.
+// Returns ErrNotFound if item is missing.
+func (c *Cache) TouchAt(key string, expiration time.Time) error {
+	item := Item{Expiration: expiration}
+	return c.touch(key, item.exptime())
+}
+
+// Private method, which updates expiration timestamp of item.
+func (c *Cache) touch(key string, exptime int64) error {
+	c.storage.Lock()
+	defer c.unlock()
+	stored := c.get(key)
+	if stored == nil {
+		return ErrNotFound
+	}
+	if !c.storage.Set(stored.Cacheable, stored.Flags, exptime, stored.Cas_unique) {
+		return ErrNoMemory
+	}
+	if c.observed() {
+		c.notify(&Mutation{Command: "touch", Key: key, Flags: uint32(stored.Flags), Expiration: expiration(exptime),
+						   Tags: stored.Tags()})
+	}
+	return nil
+}
+
+// Function deletes item by key and revokes its lease (see LeaseGet). Returns ErrNotFound if item is missing.
+func (c *Cache) Delete(key string) error {
+	c.storage.Lock()
+	defer c.unlock()
+	c.storage.Leases().Revoke(key)
+	if c.get(key) == nil {
+		return ErrNotFound
+	}
+	c.storage.Flush(key)
+	if c.observed() {
+		c.notify(&Mutation{Command: "delete", Key: key})
+	}
+	return nil
+}
+
+// Function discards all items and revokes all leases.
+func (c *Cache) FlushAll() {
+	c.storage.Lock()
+	defer c.unlock()
+	c.storage.FlushAll()
+	c.storage.Leases().RevokeAll()
+	if c.observed() {
+		c.notify(&Mutation{Command: "flush_all"})
+	}
+}
+
+// Function invalidates all items of passed namespace (keys, which start with name of namespace followed by
+// delimiter of storage). Returns false if namespaces of storage are turned off.
+func (c *Cache) FlushNamespace(name string) bool {
+	c.storage.Lock()
+	defer c.unlock()
+	if !c.storage.FlushNamespace(name) {
+		return false
+	}
+	if c.observed() {
+		c.notify(&Mutation{Command: "ns_flush", Key: name})
+	}
+	return true
+}
+
+// Function discards all items with passed tag and returns their number.
+func (c *Cache) InvalidateTag(tag string) int {
+	c.storage.Lock()
+	defer c.unlock()
+	count := c.storage.InvalidateTag(tag)
+	if c.observed() {
+		c.notify(&Mutation{Command: "invalidate_tag", Key: tag})
+	}
+	return count
+}
+
+// Result of LeaseGet.
+type Lease struct {
+	// Retrieved item or nil if it's missing.
+	Item *Item
+	// Token of granted lease, which should be passed to LeaseSet along with recomputed value;
+	// zero if lease wasn't granted.
+	Token uint64
+	// Item is stale: it's still alive, but in grace period of leases, so it should be recomputed.
+	Stale bool
+}
+
+// Function retrieves item by key with lease semantics, which prevents concurrent recomputation of missed
+// or stale items. The first client, which misses item or gets stale one, receives token of lease;
+// other clients receive stale item without token or, if item is missing, neither item nor token (they should
+// wait and retry). Lease is released by LeaseSet with its token or by timeout of leases of storage.
+func (c *Cache) LeaseGet(key string) *Lease {
+	c.storage.Lock()
+	result := new(Lease)
+	var data cache.Cacheable
+	stored := c.get(key)
+	if stored != nil {
+		result.Item, data = newItem(stored), stored.Cacheable
+		result.Stale = c.storage.Leases().Stale(stored)
+	}
+	if stored == nil || result.Stale {
+		result.Token = uint64(c.storage.Leases().Acquire(key, result.Stale))
+	}
+	c.storage.Unlock()
+	if result.Item != nil {
+		if result.Item.Value = tools.ExtractStoredData(data); result.Item.Value == nil {
+			result.Item = nil // value was lost by extstore
+		}
+	}
+	return result
+}
+
+// Function stores item with token of lease received from LeaseGet and releases the lease.
+// Expiration of item is extended by grace period of leases, so value stays available to other clients,
+// while it's recomputed next time. Returns ErrNotStored if lease was lost (timed out or revoked by deletion).
+func (c *Cache) LeaseSet(item *Item, token uint64) error {
+	data := item.data()
+	compressed := c.compress(data)
+	c.storage.Lock()
+	defer c.unlock()
+	if !c.storage.Leases().Release(item.Key, int64(token)) {
+		return ErrNotStored
+	}
+	exptime := item.exptime()
+	if exptime != 0 {
+		exptime += c.storage.Leases().Grace
+	}
+	return c.store(data, compressed, item.Flags, exptime, item.Tags)
+}
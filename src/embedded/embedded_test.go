@@ -0,0 +1,325 @@
+package embedded
+
+import (
+	"testing"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tools"
+	"tools/cache"
+)
+
+func TestCacheInitialization(t *testing.T){
+	if New(0) != nil {
+		t.Fatalf("Cache with invalid capacity was created")
+	}
+	cache := New(42)
+	if cache == nil || cache.Storage() == nil || cache.Storage().Capacity() != 42 {
+		t.Fatalf("Unexpected initialization of cache")
+	}
+}
+
+func TestCacheStorageCommands(t *testing.T){
+	cache := New(1024)
+	if err := cache.Set(&Item{Key: "key", Value: []byte("value"), Flags: 42}); err != nil {
+		t.Fatalf("Unexpected error of set: %s", err)
+	}
+	item, err := cache.Get("key")
+	if err != nil || string(item.Value) != "value" || item.Flags != 42 || item.Cas == 0 || !item.Expiration.IsZero() {
+		t.Fatalf("Unexpected result of get: %v, %s", item, err)
+	}
+	if err := cache.Add(&Item{Key: "key", Value: []byte("other")}); err != ErrNotStored {
+		t.Fatalf("Add of existed item should fail: %v", err)
+	}
+	if err := cache.Add(&Item{Key: "key1", Value: []byte("other")}); err != nil {
+		t.Fatalf("Unexpected error of add: %s", err)
+	}
+	if err := cache.Replace(&Item{Key: "key2", Value: []byte("other")}); err != ErrNotStored {
+		t.Fatalf("Replace of missed item should fail: %v", err)
+	}
+	if err := cache.Append("key", []byte("_tail")); err != nil {
+		t.Fatalf("Unexpected error of append: %s", err)
+	}
+	if err := cache.Prepend("key", []byte("head_")); err != nil {
+		t.Fatalf("Unexpected error of prepend: %s", err)
+	}
+	if err := cache.Append("key2", []byte("_tail")); err != ErrNotStored {
+		t.Fatalf("Append to missed item should fail: %v", err)
+	}
+	item, err = cache.Get("key")
+	if err != nil || string(item.Value) != "head_value_tail" || item.Flags != 42 {
+		t.Fatalf("Unexpected result of get: %v, %s", item, err)
+	}
+	if err := cache.Delete("key"); err != nil {
+		t.Fatalf("Unexpected error of delete: %s", err)
+	}
+	if err := cache.Delete("key"); err != ErrNotFound {
+		t.Fatalf("Delete of missed item should fail: %v", err)
+	}
+	cache.FlushAll()
+	if _, err := cache.Get("key1"); err != ErrNotFound {
+		t.Fatalf("Item wasn't flushed: %v", err)
+	}
+	if err := cache.Set(&Item{Key: "key", Value: make([]byte, 2048)}); err != ErrNoMemory {
+		t.Fatalf("Item, which exceeds capacity, was stored: %v", err)
+	}
+}
+
+func TestCacheCompareAndSwap(t *testing.T){
+	cache := New(1024)
+	if err := cache.CompareAndSwap(&Item{Key: "key", Value: []byte("value"), Cas: 42}); err != ErrNotFound {
+		t.Fatalf("Cas of missed item should fail: %v", err)
+	}
+	cache.Set(&Item{Key: "key", Value: []byte("value")})
+	item, _ := cache.Get("key")
+	item.Value = []byte("new_value")
+	if err := cache.CompareAndSwap(item); err != nil {
+		t.Fatalf("Unexpected error of cas: %s", err)
+	}
+	if err := cache.CompareAndSwap(item); err != ErrExists {
+		t.Fatalf("Cas with outdated unique id should fail: %v", err)
+	}
+	if item, _ = cache.Get("key"); string(item.Value) != "new_value" {
+		t.Fatalf("Unexpected value: %s", item.Value)
+	}
+}
+
+func TestCacheCounters(t *testing.T){
+	cache := New(1024)
+	cache.Set(&Item{Key: "counter", Value: []byte("10"), Flags: 1})
+	if value, err := cache.Increment("counter", 5); err != nil || value != 15 {
+		t.Fatalf("Unexpected result of increment: %d, %s", value, err)
+	}
+	if value, err := cache.Decrement("counter", 20); err != nil || value != 0 {
+		t.Fatalf("Decrement should stop at zero: %d, %s", value, err)
+	}
+	cache.Set(&Item{Key: "counter", Value: []byte("18446744073709551615")})
+	if value, err := cache.Increment("counter", 2); err != nil || value != 1 {
+		t.Fatalf("Increment should wrap around: %d, %s", value, err)
+	}
+	if _, err := cache.Increment("missed", 1); err != ErrNotFound {
+		t.Fatalf("Increment of missed item should fail: %v", err)
+	}
+	cache.Set(&Item{Key: "text", Value: []byte("3.14")})
+	if _, err := cache.Increment("text", 1); err != ErrNotNumeric {
+		t.Fatalf("Increment of non-numeric value should fail: %v", err)
+	}
+}
+
+func TestCacheExpiration(t *testing.T){
+	cache := New(1024)
+	cache.Set(&Item{Key: "expired", Value: []byte("value"), TTL: -time.Second})
+	if _, err := cache.Get("expired"); err != ErrNotFound {
+		t.Fatalf("Item with negative TTL should be expired: %v", err)
+	}
+	cache.Set(&Item{Key: "key", Value: []byte("value"), TTL: time.Hour})
+	item, err := cache.Get("key")
+	if err != nil || item.Expiration.Before(time.Now().Add(time.Minute * 59)) {
+		t.Fatalf("Unexpected expiration: %v, %s", item, err)
+	}
+	expiration := time.Now().Add(time.Hour * 2).Truncate(time.Second)
+	if err := cache.TouchAt("key", expiration); err != nil {
+		t.Fatalf("Unexpected error of touch: %s", err)
+	}
+	if item, _ = cache.Get("key"); !item.Expiration.Equal(expiration) {
+		t.Fatalf("Expiration wasn't updated: %v", item.Expiration)
+	}
+	if err := cache.Touch("key", -time.Second); err != nil {
+		t.Fatalf("Unexpected error of touch: %s", err)
+	}
+	if _, err := cache.Get("key"); err != ErrNotFound {
+		t.Fatalf("Touched item should be expired: %v", err)
+	}
+	if err := cache.Touch("key", time.Second); err != ErrNotFound {
+		t.Fatalf("Touch of missed item should fail: %v", err)
+	}
+}
+
+func TestCacheConcurrentUsage(t *testing.T){
+	cache := New(1 << 20)
+	cache.Set(&Item{Key: "counter", Value: []byte("0")})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i ++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j ++ {
+				cache.Increment("counter", 1)
+				key := "key" + strconv.Itoa(i) + "_" + strconv.Itoa(j)
+				cache.Set(&Item{Key: key, Value: []byte(key)})
+				cache.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if item, err := cache.Get("counter"); err != nil || string(item.Value) != "800" {
+		t.Fatalf("Increments were lost: %v, %s", item, err)
+	}
+}
+
+func TestCacheChunkedValues(t *testing.T){
+	cache := New(64 << 20)
+	value := make([]byte, tools.CHUNK_SIZE + 1)
+	cache.Set(&Item{Key: "large", Value: value})
+	cache.Set(&Item{Key: "log", Chunks: [][]byte{[]byte("begin;")}})
+	for i := 0; i < 1000; i ++ {
+		if err := cache.Append("log", make([]byte, 1024)); err != nil {
+			t.Fatalf("Value wasn't appended: %s", err)
+		}
+	}
+	cache.Prepend("log", []byte("head;"))
+	cache.AppendChunks("large", [][]byte{[]byte("ab"), []byte("cd")})
+	item, err := cache.GetChunked("log")
+	if err != nil || item.Value != nil || len(item.Chunks) != 3 {
+		t.Fatalf("Unexpected chunks of item: %v", err)
+	}
+	item, _ = cache.Get("log")
+	if len(item.Value) != 5 + 6 + 1000 * 1024 || string(item.Value[ : 11]) != "head;begin;" {
+		t.Fatalf("Unexpected value: %d bytes", len(item.Value))
+	}
+	if item, _ := cache.Get("large"); len(item.Value) != len(value) + 4 || string(item.Value[len(value) : ]) != "abcd" {
+		t.Fatalf("Unexpected value of large item")
+	}
+	if used := 64 << 20 - cache.Storage().Capacity(); used != int64(len(value) + 4 + 5 + 6 + 1000 * 1024) {
+		t.Fatalf("Unexpected amount of used memory: %d", used)
+	}
+}
+
+func TestCacheTags(t *testing.T){
+	cache := New(1 << 20)
+	cache.Set(&Item{Key: "user:1", Value: []byte("1"), Tags: []string{"user", "group:1"}})
+	cache.Set(&Item{Key: "user:2", Value: []byte("2"), Tags: []string{"user"}})
+	cache.Set(&Item{Key: "counter", Value: []byte("10"), Tags: []string{"user"}})
+	cache.Append("user:1", []byte("1"))
+	cache.Increment("counter", 1)
+	if item, err := cache.Get("user:1"); err != nil || !reflect.DeepEqual(item.Tags, []string{"user", "group:1"}) {
+		t.Fatalf("Tags weren't kept by append: %v, %s", item, err)
+	}
+	cache.Set(&Item{Key: "user:2", Value: []byte("2")})
+	if n := cache.InvalidateTag("user"); n != 2 {
+		t.Fatalf("Unexpected number of invalidated items: %d", n)
+	}
+	if _, err := cache.Get("counter"); err != ErrNotFound {
+		t.Fatalf("Tags weren't kept by increment.")
+	}
+	if item, err := cache.Get("user:2"); err != nil || len(item.Tags) != 0 {
+		t.Fatalf("Tags weren't replaced by set: %v, %s", item, err)
+	}
+}
+
+func TestCacheLeases(t *testing.T){
+	cache := New(1 << 20)
+	first := cache.LeaseGet("key")
+	if first.Item != nil || first.Token == 0 {
+		t.Fatalf("Lease wasn't granted on miss: %v", first)
+	}
+	if second := cache.LeaseGet("key"); second.Item != nil || second.Token != 0 {
+		t.Fatalf("Lease was granted twice: %v", second)
+	}
+	if err := cache.LeaseSet(&Item{Key: "key", Value: []byte("value")}, first.Token + 1); err != ErrNotStored {
+		t.Fatalf("Value was stored with invalid token: %s", err)
+	}
+	if err := cache.LeaseSet(&Item{Key: "key", Value: []byte("value"), TTL: time.Minute}, first.Token); err != nil {
+		t.Fatalf("Value wasn't stored with token: %s", err)
+	}
+	if hit := cache.LeaseGet("key"); hit.Item == nil || string(hit.Item.Value) != "value" || hit.Token != 0 || hit.Stale {
+		t.Fatalf("Unexpected result of hit: %v", hit)
+	}
+	cache.Storage().Leases().Grace = 120
+	stale := cache.LeaseGet("key")
+	if stale.Item == nil || !stale.Stale || stale.Token == 0 {
+		t.Fatalf("Lease wasn't granted for stale item: %v", stale)
+	}
+	if other := cache.LeaseGet("key"); other.Item == nil || !other.Stale || other.Token != 0 {
+		t.Fatalf("Stale item wasn't returned without lease: %v", other)
+	}
+	cache.Delete("key")
+	if err := cache.LeaseSet(&Item{Key: "key", Value: []byte("value")}, stale.Token); err != ErrNotStored {
+		t.Fatalf("Value was stored with revoked lease: %s", err)
+	}
+}
+
+// Observer, which collects names of mutated keys.
+type keysObserver struct {
+	keys []string
+}
+
+func (o *keysObserver) Mutated(mutation *Mutation) {
+	o.keys = append(o.keys, mutation.Key)
+}
+
+func TestCacheSnapshot(t *testing.T){
+	cache := New(4 << 20)
+	cache.Set(&Item{Key: "first", Value: []byte("1"), Flags: 42, Tags: []string{"tag"}})
+	cache.Set(&Item{Key: "expired", Value: []byte("1"), TTL: -time.Second})
+	cache.Set(&Item{Key: "large", Value: make([]byte, tools.CHUNK_SIZE + 1)})
+	cache.Set(&Item{Key: "deleted", Value: []byte("1")})
+	observer := new(keysObserver)
+	keys := cache.Subscribe(observer)
+	if !reflect.DeepEqual(keys, []string{"first", "large", "deleted"}) {
+		t.Fatalf("Unexpected keys of snapshot: %v", keys)
+	}
+	cache.Delete("deleted")
+	cache.Append("first", []byte("2"))
+	var barrier int
+	snapshot := cache.Snapshot(keys, func() { barrier = len(observer.keys) })
+	cache.Append("first", []byte("3"))
+	if barrier != 2 || len(observer.keys) != 3 || len(snapshot) != 2 {
+		t.Fatalf("Unexpected snapshot: %v, %d", snapshot, barrier)
+	}
+	if item := snapshot[0]; item.Key != "first" || string(item.Value) != "12" || item.Flags != 42 ||
+	   !reflect.DeepEqual(item.Tags, []string{"tag"}) {
+		t.Fatalf("Unexpected item of snapshot: %v", item)
+	}
+	if item := snapshot[1]; item.Value != nil || len(item.Chunks) != 2 || item.Mutation().Command != "set" {
+		t.Fatalf("Large value wasn't returned as chunks: %v", item)
+	}
+	wrapper := Wrap(cache.Storage())
+	wrapper.Set(&Item{Key: "wrapped", Value: []byte("1")})
+	if len(observer.keys) != 4 || observer.keys[3] != "wrapped" {
+		t.Fatalf("Mutation made through other wrapper wasn't reported: %v", observer.keys)
+	}
+	wrapper.Unsubscribe(observer)
+	cache.Delete("first")
+	if len(observer.keys) != 4 {
+		t.Fatalf("Unsubscribed observer was notified")
+	}
+}
+
+func TestCacheCompression(t *testing.T){
+	storage := New(64 << 20)
+	compression, _ := cache.NewCompression(100, 1)
+	storage.Storage().SetCompression(compression)
+	large := []byte(strings.Repeat("large value ", tools.CHUNK_SIZE / 6))
+	json := []byte(strings.Repeat(`{"id":1,"name":"value"},`, 50))
+	storage.Set(&Item{Key: "large", Value: large})
+	storage.Set(&Item{Key: "json", Value: json})
+	// values, including chunked one, are stored compressed.
+	if used := 64 << 20 - storage.Storage().Capacity(); used >= int64(len(large) + len(json)) / 10 {
+		t.Fatalf("Values weren't compressed: %d bytes are used", used)
+	}
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i ++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 10; j ++ {
+				storage.Append("json", []byte("!"))
+			}
+		}()
+	}
+	wait.Wait()
+	storage.Prepend("large", []byte("head;"))
+	if item, err := storage.Get("json"); err != nil || string(item.Value) != string(json) + strings.Repeat("!", 100) {
+		t.Fatalf("Unexpected value of compressed item: %s", err)
+	}
+	if item, err := storage.GetChunked("large"); err != nil || len(item.Chunks) != 1 ||
+	   string(item.Chunks[0]) != "head;" + string(large) {
+		t.Fatalf("Unexpected value of compressed chunked item: %s", err)
+	}
+	if !strings.Contains(strings.Join(compression.Stats(), ","), "compression_items 103,") {
+		t.Fatalf("Unexpected statistic: %v", compression.Stats())
+	}
+}
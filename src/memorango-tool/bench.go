@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bench"
+	"flag"
+	"fmt"
+)
+
+// Private method, which runs benchmark of the first server with configuration passed in arguments.
+func (t *tool) bench(args []string) error {
+	config := bench.DefaultConfig(t.servers[0])
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(t.output)
+	flags.IntVar(&config.Connections, "connections", config.Connections, "Number of connections.")
+	flags.IntVar(&config.Pipeline, "pipeline", config.Pipeline, "Number of pipelined requests of each connection.")
+	flags.DurationVar(&config.Duration, "duration", config.Duration, "Duration of benchmark.")
+	flags.Uint64Var(&config.Requests, "requests", 0, "Number of requests, after which benchmark stops; 0 means no limit.")
+	mix := flags.String("mix", "get=90,set=10", "Weights of operations: get, set, multiget and incr.")
+	flags.IntVar(&config.Keys, "keys", config.Keys, "Number of keys.")
+	flags.StringVar(&config.KeyPrefix, "key-prefix", config.KeyPrefix, "Prefix of keys.")
+	flags.StringVar(&config.Distribution, "distribution", config.Distribution, "Distribution of keys: uniform or zipf.")
+	flags.Float64Var(&config.ZipfExponent, "zipf-exponent", config.ZipfExponent, "Exponent of Zipf distribution (greater than 1).")
+	flags.IntVar(&config.ValueSize, "value-size", config.ValueSize, "Minimal size of values in bytes.")
+	value_size_max := flags.Int("value-size-max", 0, "Maximal size of values in bytes; default is -value-size.")
+	flags.IntVar(&config.MultiGetSize, "multiget-size", config.MultiGetSize, "Number of keys of multiget.")
+	flags.BoolVar(&config.Populate, "populate", false, "Store every key before benchmark.")
+	flags.Int64Var(&config.Seed, "seed", config.Seed, "Seed of random generators.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return ErrUsage
+	}
+	var err error
+	if config.Mix, err = bench.ParseMix(*mix); err != nil {
+		return err
+	}
+	config.ValueSizeMax = config.ValueSize
+	if *value_size_max > 0 {
+		config.ValueSizeMax = *value_size_max
+	}
+	if config.Requests > 0 && !t.explicit(flags, "duration") {
+		config.Duration = 0
+	}
+	config.Timeout = t.timeout
+	report, err := bench.Run(config)
+	if err != nil {
+		return err
+	}
+	if t.json {
+		return t.printJSON(report)
+	}
+	fmt.Fprint(t.output, report.Text())
+	return nil
+}
+
+// Function returns true if flag with passed name was set explicitly.
+func (t *tool) explicit(flags *flag.FlagSet, name string) bool {
+	var result = false
+	flags.Visit(func(f *flag.Flag) {
+		result = result || f.Name == name
+	})
+	return result
+}
@@ -0,0 +1,407 @@
+/*
+Command memorango-tool is administrative client of MemoranGo (and other memcached compatible servers).
+
+usage:
+	memorango-tool [-s <host:port>[,<host:port>...]] [-json] [-timeout <duration>] <command> [<args>]
+
+Commands:
+	stats [-diff <duration>] [<sub command>]   table of statistic of servers; with -diff the second sample is taken
+	                                           after passed duration and changes of numeric counters are shown
+	settings                                   table of settings of servers ("stats settings")
+	dump [-o <file>]                           metadata of all items of servers ("lru_crawler metadump all");
+	                                           with -o all live items are dumped into file
+	restore [-rate <items/s>] <file>           stores items of dump into servers, honoring their remaining TTLs
+	bench [<flags>]                            drives the first server with generated load and reports
+	                                           throughput, latency percentiles and errors (see -h of bench)
+	keys [-prefix <prefix>]                    keys of all items of servers, which start with passed prefix
+	flush [-y]                                 invalidates all items of servers after confirmation
+	get <key> [<file>]                         writes value of item to file (or standard output)
+	set [-flags <n>] [-exptime <n>] <key> <file>   stores content of file (or standard input for "-") as item
+	crawler enable|disable|sleep <mcs>|tocrawl <n>   controls LRU crawler of servers
+
+Items of get and set are distributed among servers as client/cluster does. With -json reports are printed
+as JSON for scripting.
+*/
+package main
+
+import (
+	"bufio"
+	"client"
+	"client/cluster"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error, which is returned when command or its arguments are invalid.
+var ErrUsage = errors.New("Invalid command or arguments; run memorango-tool -h for usage.")
+
+// Structure of invocation of tool: servers, format of output and streams.
+type tool struct {
+	servers []string
+	json bool
+	timeout time.Duration
+	input *bufio.Reader
+	output io.Writer
+}
+
+// Function runs tool with passed command line arguments (without name of binary).
+func run(args []string, input io.Reader, output io.Writer) error {
+	flags := flag.NewFlagSet("memorango-tool", flag.ContinueOnError)
+	flags.SetOutput(output)
+	servers := flags.String("s", "127.0.0.1:11211", "Comma separated addresses of servers.")
+	json_output := flags.Bool("json", false, "Print reports as JSON.")
+	timeout := flags.Duration("timeout", time.Second, "Limit of duration of each request.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return ErrUsage
+	}
+	t := &tool{servers: strings.Split(*servers, ","), json: *json_output, timeout: *timeout,
+			   input: bufio.NewReader(input), output: output}
+	command, args := flags.Arg(0), flags.Args()[1 : ]
+	switch command {
+	case "stats":
+		return t.stats(args)
+	case "settings":
+		return t.stats(append([]string{"settings"}, args...))
+	case "dump":
+		return t.dump(args)
+	case "keys":
+		return t.keys(args)
+	case "flush":
+		return t.flush(args)
+	case "get":
+		return t.get(args)
+	case "set":
+		return t.set(args)
+	case "crawler":
+		return t.crawler(args)
+	case "restore":
+		return t.restore(args)
+	case "bench":
+		return t.bench(args)
+	}
+	return ErrUsage
+}
+
+// Private method, which calls passed function with client of each server.
+func (t *tool) each(fn func(c *client.Client) error) error {
+	for _, address := range t.servers {
+		c := client.New(address)
+		c.Timeout = t.timeout
+		err := fn(c)
+		c.Close()
+		if err != nil {
+			return errors.New(address + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Private method, which prints passed value as JSON.
+func (t *tool) printJSON(value interface{}) error {
+	encoder := json.NewEncoder(t.output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// Private method, which prints table with passed header, aligning its columns.
+func (t *tool) printTable(header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, row := range append([][]string{header}, rows...) {
+		var line string
+		for i, cell := range row {
+			if i < len(row) - 1 {
+				cell += strings.Repeat(" ", widths[i] - len(cell) + 2)
+			}
+			line += cell
+		}
+		fmt.Fprintln(t.output, line)
+	}
+}
+
+// Function returns changes of numeric values between two samples of statistic.
+// Values, which aren't numbers, are omitted.
+func diff(before map[string] string, after map[string] string) map[string] float64 {
+	result := make(map[string] float64)
+	for name, value := range after {
+		current, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		previous, err := strconv.ParseFloat(before[name], 64)
+		if err != nil {
+			continue
+		}
+		result[name] = current - previous
+	}
+	return result
+}
+
+// Function formats number without redundant fraction.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Private method, which prints statistic of servers: passed arguments are flags and sub command of "stats".
+func (t *tool) stats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	flags.SetOutput(t.output)
+	interval := flags.Duration("diff", 0, "Show changes of counters during passed duration.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	samples := make(map[string] map[string] string)
+	sample := func() error {
+		return t.each(func(c *client.Client) error {
+			values, err := c.Stats(context.Background(), flags.Args()...)
+			samples[c.Address()] = values
+			return err
+		})
+	}
+	if err := sample(); err != nil {
+		return err
+	}
+	var changes map[string] map[string] float64
+	if *interval > 0 {
+		before := samples
+		samples = make(map[string] map[string] string)
+		time.Sleep(*interval)
+		if err := sample(); err != nil {
+			return err
+		}
+		changes = make(map[string] map[string] float64)
+		for address, values := range samples {
+			changes[address] = diff(before[address], values)
+		}
+	}
+	if t.json {
+		if changes != nil {
+			return t.printJSON(changes)
+		}
+		return t.printJSON(samples)
+	}
+	unique := make(map[string] bool)
+	for _, values := range samples {
+		for name := range values {
+			unique[name] = true
+		}
+	}
+	var names []string
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var rows [][]string
+	for _, name := range names {
+		row := []string{name}
+		for _, address := range t.servers {
+			cell := samples[address][name]
+			if delta, exists := changes[address][name]; exists && delta > 0 {
+				cell += " (+" + formatNumber(delta) + ")"
+			} else if exists && delta < 0 {
+				cell += " (" + formatNumber(delta) + ")"
+			}
+			row = append(row, cell)
+		}
+		rows = append(rows, row)
+	}
+	t.printTable(append([]string{"NAME"}, t.servers...), rows)
+	return nil
+}
+
+// Structure of metadata of item with address of its server.
+type serverMeta struct {
+	Server string `json:"server"`
+	*client.Meta
+}
+
+// Private method, which collects metadata of items of all servers, which keys start with passed prefix.
+func (t *tool) metadump(prefix string) ([]serverMeta, error) {
+	var result []serverMeta
+	err := t.each(func(c *client.Client) error {
+		return c.Metadump(context.Background(), func(meta *client.Meta) {
+			if strings.HasPrefix(meta.Key, prefix) {
+				result = append(result, serverMeta{Server: c.Address(), Meta: meta})
+			}
+		})
+	})
+	return result, err
+}
+
+// Private method, which prints metadata of all items of servers.
+// With -o flag items are dumped into file (see dumpItems).
+func (t *tool) dump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	flags.SetOutput(t.output)
+	path := flags.String("o", "", "Dump items with values into file at passed path.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return ErrUsage
+	}
+	if len(*path) > 0 {
+		return t.dumpItems(*path)
+	}
+	metas, err := t.metadump("")
+	if err != nil {
+		return err
+	}
+	if t.json {
+		if metas == nil {
+			metas = []serverMeta{}
+		}
+		return t.printJSON(metas)
+	}
+	var rows [][]string
+	for _, meta := range metas {
+		rows = append(rows, []string{meta.Server, meta.Key, strconv.FormatInt(meta.Expiration, 10),
+									 strconv.FormatInt(meta.LastAccess, 10), strconv.FormatUint(meta.Cas, 10),
+									 strconv.FormatBool(meta.Fetched), strconv.Itoa(meta.Size)})
+	}
+	t.printTable([]string{"SERVER", "KEY", "EXP", "LA", "CAS", "FETCHED", "SIZE"}, rows)
+	return nil
+}
+
+// Private method, which prints keys of items of servers, which start with prefix passed in arguments.
+func (t *tool) keys(args []string) error {
+	flags := flag.NewFlagSet("keys", flag.ContinueOnError)
+	flags.SetOutput(t.output)
+	prefix := flags.String("prefix", "", "Print only keys with passed prefix.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return ErrUsage
+	}
+	metas, err := t.metadump(*prefix)
+	if err != nil {
+		return err
+	}
+	keys := []string{}
+	for _, meta := range metas {
+		keys = append(keys, meta.Key)
+	}
+	sort.Strings(keys)
+	if t.json {
+		return t.printJSON(keys)
+	}
+	for _, key := range keys {
+		fmt.Fprintln(t.output, key)
+	}
+	return nil
+}
+
+// Private method, which invalidates all items of servers; confirmation is asked unless -y flag is passed.
+func (t *tool) flush(args []string) error {
+	flags := flag.NewFlagSet("flush", flag.ContinueOnError)
+	flags.SetOutput(t.output)
+	confirmed := flags.Bool("y", false, "Don't ask for confirmation.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*confirmed {
+		fmt.Fprintf(t.output, "Flush all items of %s? [y/N] ", strings.Join(t.servers, ", "))
+		answer, _ := t.input.ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return errors.New("Flushing was cancelled.")
+		}
+	}
+	return t.each(func(c *client.Client) error {
+		return c.FlushAll(context.Background())
+	})
+}
+
+// Private method, which returns client of cluster of servers.
+func (t *tool) cluster() *cluster.Cluster {
+	c := cluster.New(t.servers...)
+	c.SetTimeout(t.timeout)
+	return c
+}
+
+// Private method, which writes value of item with key passed in arguments to file (or standard output).
+func (t *tool) get(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return ErrUsage
+	}
+	c := t.cluster()
+	defer c.Close()
+	item, err := c.Get(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		return ioutil.WriteFile(args[1], item.Value, 0644)
+	}
+	if t.json {
+		return t.printJSON(item)
+	}
+	_, err = t.output.Write(item.Value)
+	return err
+}
+
+// Private method, which stores content of file (or standard input for "-") passed in arguments as item.
+func (t *tool) set(args []string) error {
+	flags := flag.NewFlagSet("set", flag.ContinueOnError)
+	flags.SetOutput(t.output)
+	item_flags := flags.Uint("flags", 0, "Flags of item.")
+	exptime := flags.Int("exptime", 0, "Expiration time of item: seconds from now (up to 30 days) or UNIX timestamp.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return ErrUsage
+	}
+	var value []byte
+	var err error
+	if flags.Arg(1) == "-" {
+		value, err = ioutil.ReadAll(t.input)
+	} else {
+		value, err = ioutil.ReadFile(flags.Arg(1))
+	}
+	if err != nil {
+		return err
+	}
+	c := t.cluster()
+	defer c.Close()
+	return c.Set(context.Background(), &client.Item{Key: flags.Arg(0), Value: value, Flags: uint32(*item_flags),
+													Expiration: int32(*exptime)})
+}
+
+// Private method, which passes arguments to "lru_crawler" command of each server.
+func (t *tool) crawler(args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	return t.each(func(c *client.Client) error {
+		return c.Crawler(context.Background(), args...)
+	})
+}
+
+func main() {
+	if err := run(os.Args[1 : ], os.Stdin, os.Stdout); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"server"
+	"strings"
+	"testing"
+)
+
+var test_address = "127.0.0.1:60012"
+
+func TestDiff(t *testing.T) {
+	changes := diff(map[string] string{"cmd_get": "10", "version": "1.0", "rusage_user": "0.5"},
+					map[string] string{"cmd_get": "15", "version": "1.1", "rusage_user": "0.75", "new": "1"})
+	if !reflect.DeepEqual(changes, map[string] float64{"cmd_get": 5, "version": 0.10000000000000009, "rusage_user": 0.25}) {
+		t.Fatalf("Unexpected changes: %v", changes)
+	}
+	if formatNumber(1e6) != "1000000" || formatNumber(0.25) != "0.25" {
+		t.Fatalf("Unexpected formatting of numbers.")
+	}
+}
+
+func TestTool(t *testing.T) {
+	srv := server.NewServer("60012", "", "", 1024, false, false, 0, 1 << 20)
+	if err := srv.RunServer(); err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer srv.StopServer()
+	dir, err := ioutil.TempDir("", "memorango_tool")
+	if err != nil {
+		t.Fatalf("Impossible to create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var output bytes.Buffer
+	tool := func(input string, args ...string) error {
+		output.Reset()
+		return run(append([]string{"-s", test_address, "-timeout", "1s"}, args...), strings.NewReader(input), &output)
+	}
+	if err := tool("value", "set", "-flags", "3", "user:1", "-"); err != nil {
+		t.Fatalf("Unexpected error of set: %s", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "source"), []byte("other"), 0644)
+	if err := tool("", "set", "order:1", filepath.Join(dir, "source")); err != nil {
+		t.Fatalf("Unexpected error of set: %s", err)
+	}
+	if err := tool("", "get", "user:1"); err != nil || output.String() != "value" {
+		t.Fatalf("Unexpected result of get: %q, %v", output.String(), err)
+	}
+	if err := tool("", "get", "order:1", filepath.Join(dir, "target")); err != nil {
+		t.Fatalf("Unexpected error of get: %s", err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, "target")); string(content) != "other" {
+		t.Fatalf("Unexpected content of file: %q", content)
+	}
+	var keys []string
+	if err := tool("", "-json", "keys", "-prefix", "user:"); err != nil || json.Unmarshal(output.Bytes(), &keys) != nil ||
+	   !reflect.DeepEqual(keys, []string{"user:1"}) {
+		t.Fatalf("Unexpected keys: %q, %v", output.String(), err)
+	}
+	if err := tool("", "dump"); err != nil || strings.Count(output.String(), "\n") != 3 ||
+	   !strings.HasPrefix(output.String(), "SERVER  ") {
+		t.Fatalf("Unexpected dump: %q, %v", output.String(), err)
+	}
+	var changes map[string] map[string] float64
+	if err := tool("", "-json", "stats", "-diff", "10ms"); err != nil || json.Unmarshal(output.Bytes(), &changes) != nil ||
+	   changes[test_address]["cmd_set"] != 0 || changes[test_address]["bytes_read"] == 0 {
+		t.Fatalf("Unexpected changes of statistic: %q, %v", output.String(), err)
+	}
+	if err := tool("", "crawler", "tocrawl", "7"); err != nil {
+		t.Fatalf("Unexpected error of crawler: %s", err)
+	}
+	if err := tool("", "settings"); err != nil || !strings.Contains(output.String(), "lru_crawler_tocrawl  7\n") {
+		t.Fatalf("Unexpected settings: %q, %v", output.String(), err)
+	}
+	if err := tool("no\n", "flush"); err == nil {
+		t.Fatalf("Flushing wasn't cancelled.")
+	}
+	if err := tool("y\n", "flush"); err != nil {
+		t.Fatalf("Unexpected error of flush: %s", err)
+	}
+	if err := tool("", "get", "user:1"); err == nil {
+		t.Fatalf("Items weren't flushed.")
+	}
+	if err := tool("", "unknown"); err != ErrUsage {
+		t.Fatalf("Unknown command was accepted: %v", err)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	source := server.NewServer("60013", "", "", 1024, false, false, 0, 1 << 20)
+	target := server.NewServer("60014", "", "", 1024, false, false, 0, 1 << 20)
+	if source.RunServer() != nil || target.RunServer() != nil {
+		t.Fatalf("Servers weren't run.")
+	}
+	defer source.StopServer()
+	defer target.StopServer()
+	dir, err := ioutil.TempDir("", "memorango_tool")
+	if err != nil {
+		t.Fatalf("Impossible to create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dump")
+	var output bytes.Buffer
+	tool := func(address string, args ...string) error {
+		output.Reset()
+		return run(append([]string{"-s", address, "-json"}, args...), strings.NewReader(""), &output)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "value"), []byte("value"), 0644)
+	tool("127.0.0.1:60013", "set", "-flags", "5", "-exptime", "3600", "key", filepath.Join(dir, "value"))
+	tool("127.0.0.1:60013", "set", "other", filepath.Join(dir, "value"))
+	if err := tool("127.0.0.1:60013", "dump", "-o", path); err != nil || output.String() != "{\n  \"dumped\": 2,\n  \"missed\": 0\n}\n" {
+		t.Fatalf("Unexpected result of dump: %q, %v", output.String(), err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path + ".partial", data[ : len(data) - 1], 0644)
+	if err := tool("127.0.0.1:60014", "restore", path + ".partial"); err == nil {
+		t.Fatalf("Truncated dump was restored.")
+	}
+	if err := tool("127.0.0.1:60014", "keys"); err != nil || output.String() != "[]\n" {
+		t.Fatalf("Truncated dump was restored partially: %q, %v", output.String(), err)
+	}
+	if err := tool("127.0.0.1:60014", "restore", "-rate", "1000", path); err != nil ||
+	   output.String() != "{\n  \"expired\": 0,\n  \"restored\": 2\n}\n" {
+		t.Fatalf("Unexpected result of restore: %q, %v", output.String(), err)
+	}
+	var metas []map[string] interface{}
+	if err := tool("127.0.0.1:60014", "dump"); err != nil || json.Unmarshal(output.Bytes(), &metas) != nil || len(metas) != 2 {
+		t.Fatalf("Unexpected items: %q, %v", output.String(), err)
+	}
+	for _, meta := range metas {
+		if exp := int64(meta["exp"].(float64)); (meta["key"] == "key") != (exp > 0) {
+			t.Fatalf("Expiration wasn't restored: %v", meta)
+		}
+	}
+	if err := tool("127.0.0.1:60014", "get", "key"); err != nil || !strings.Contains(output.String(), "\"Flags\": 5") {
+		t.Fatalf("Unexpected item: %q, %v", output.String(), err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"client"
+	"context"
+	"dumpfile"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Defines the maximal expiration time, which is interpreted by servers as number of seconds from now
+// (greater one is UNIX timestamp).
+const MAX_RELATIVE_EXPTIME = 60 * 60 * 24 * 30
+
+// Private method, which dumps all live items of servers into file at passed path: keys and expiration times
+// are listed by metadump, and values, flags and unique ids are fetched by multi-key gets while metadump is read
+// (batch of keys per request), so metadata of all items isn't kept in memory. Items, which disappeared meanwhile,
+// are skipped.
+func (t *tool) dumpItems(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer, err := dumpfile.NewWriter(file)
+	if err != nil {
+		return err
+	}
+	var missed = 0
+	err = t.each(func(c *client.Client) error {
+		var batch []*client.Meta
+		// Function fetches items of collected batch and writes them to dump.
+		fetch := func() error {
+			keys := make([]string, len(batch))
+			for i, meta := range batch {
+				keys[i] = meta.Key
+			}
+			items, err := c.GetsMulti(context.Background(), keys)
+			if err != nil {
+				return err
+			}
+			for _, meta := range batch {
+				item, exists := items[meta.Key]
+				if !exists {
+					missed ++
+					continue
+				}
+				var exptime = meta.Expiration
+				if exptime < 0 {
+					exptime = 0
+				}
+				err = writer.Write(&dumpfile.Item{Key: item.Key, Flags: item.Flags, Exptime: exptime, Cas: item.Cas,
+												  Value: item.Value})
+				if err != nil {
+					return err
+				}
+			}
+			batch = batch[ : 0]
+			return nil
+		}
+		var failed error
+		err := c.Metadump(context.Background(), func(meta *client.Meta) {
+			if failed != nil {
+				return
+			}
+			batch = append(batch, meta)
+			if len(batch) == client.MAX_KEYS_PER_GET {
+				failed = fetch()
+			}
+		})
+		if err == nil {
+			err = failed
+		}
+		if err == nil {
+			err = fetch()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if t.json {
+		return t.printJSON(map[string] uint64{"dumped": writer.Count(), "missed": uint64(missed)})
+	}
+	fmt.Fprintf(t.output, "Dumped %d items to %s (%d items disappeared while dumping).\n", writer.Count(), path, missed)
+	return nil
+}
+
+// Private method, which stores items of dump passed in arguments into servers. Dump is verified before
+// any item is stored, so truncated or damaged dump isn't restored partially. Items keep remaining time
+// of their life; expired ones are skipped.
+func (t *tool) restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.SetOutput(t.output)
+	rate := flags.Int("rate", 0, "Maximal number of stored items per second; 0 means no limit.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *rate < 0 {
+		return ErrUsage
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = dumpfile.Verify(file); err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader, err := dumpfile.NewReader(file)
+	if err != nil {
+		return err
+	}
+	c := t.cluster()
+	defer c.Close()
+	var restored, expired = 0, 0
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Second / time.Duration(*rate)
+	}
+	next := time.Now()
+	for {
+		item, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		var expiration = item.Exptime
+		if expiration > 0 {
+			now := time.Now().Unix()
+			if expiration <= now {
+				expired ++
+				continue
+			}
+			if expiration - now <= MAX_RELATIVE_EXPTIME {
+				expiration -= now
+			}
+		}
+		if wait := time.Until(next); wait > 0 {
+			time.Sleep(wait)
+		} else {
+			// slow server shouldn't lead to burst of stored items afterwards.
+			next = time.Now()
+		}
+		next = next.Add(interval)
+		err = c.Set(context.Background(), &client.Item{Key: item.Key, Value: item.Value, Flags: item.Flags,
+													   Expiration: int32(expiration)})
+		if err != nil {
+			return err
+		}
+		restored ++
+	}
+	if t.json {
+		return t.printJSON(map[string] int{"restored": restored, "expired": expired})
+	}
+	fmt.Fprintf(t.output, "Restored %d items (%d expired items were skipped).\n", restored, expired)
+	return nil
+}
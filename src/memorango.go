@@ -1,89 +1,341 @@
 package main
 
 import (
+	"config"
+	"daemon"
 	"fmt"
 	"flag"
 	//"sync"
 	"server"
 	"tools"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
 )
 
+// Options of server, which are passed by command line or configuration file.
+type options struct {
+	tcp_port *string
+	memory_amount_mb *int
+	daemonize *bool
+	listen_ip *string
+	max_connections *int
+	udp_port *string
+	disable_cas *bool
+	disable_flush *bool
+	help *bool
+	verbose *bool
+	deep_verbose *bool
+	slowlog_threshold *int
+	slowlog_length *int
+	prefix_delimiter *string
+	replica_of *string
+	aof_path *string
+	aof_fsync *string
+	ext_path *string
+	ext_size *int
+	ext_page_size *int
+	ext_item_size *int
+	ext_compact_under *int
+	compress_threshold *int
+	compress_level *int
+	lease_timeout *int
+	lease_grace *int
+	lru_crawler *bool
+	lru_crawler_sleep *int
+	lru_crawler_tocrawl *uint
+	track_sizes *bool
+	config_path *string
+	pidfile *string
+	user *string
+	log_file *string
+}
+
+// Options, which are applied to running server on SIGHUP (by their names in effective configuration).
+var reloadable = []string{"verbose", "deep_verbose", "max_connections", "memory", "slowlog_threshold",
+						  "lease_timeout", "lease_grace", "compress_threshold", "compress_level",
+						  "lru_crawler", "lru_crawler_sleep", "lru_crawler_tocrawl", "track_sizes"}
+
+// Options, which contain paths and should be passed to daemon as absolute ones.
+var paths = []string{"aof", "ext-path", "config", "P", "log-file"}
+
+// Function defines options of server in passed set of flags.
+func defineOptions(flags *flag.FlagSet) *options {
+	return &options{
+		tcp_port: flags.String("p", "11211", "TCP Port to listen (non required - default port is 11211)"),
+		memory_amount_mb: flags.Int("m", 0, "Amount of memory to allocate (MiB)"),
+		daemonize: flags.Bool("d", false, "Run process as background"),
+		// unix_socket: flags.String("s", "", "Unix socket path to listen on (disables network support)"),
+		// unix_perms: flags.String("a", "", "Permissions (in octal format) for Unix socket created with -s option"),
+		listen_ip: flags.String("l", "", "Listen on specified ip addr only; default to any address."),
+		max_connections: flags.Int("c", 1024, "Use max simultaneous connections;"),
+		udp_port: flags.String("U", "", "UDP Port to listen (default is empty string - which means it is turned off)"),
+		disable_cas: flags.Bool("C", false, "Disabling of cas command support."),
+		disable_flush: flags.Bool("F", false, "Disabling of flush_all command support."),
+		help: flags.Bool("h", false, "Show usage manual and list of options."),
+		verbose: flags.Bool("v", false, "Turning verbosity on. This option includes errors and warnings only."),
+		deep_verbose: flags.Bool("vv", false, "Turning deep verbosity on. This option includes requests, responses and same output as simple verbosity."),
+		slowlog_threshold: flags.Int("slowlog-threshold", 10000, "Requests slower than this amount of microseconds are recorded to slow log; 0 turns it off."),
+		slowlog_length: flags.Int("slowlog-len", 128, "Maximal number of entries kept by slow log."),
+		prefix_delimiter: flags.String("D", ":", "Use <char> as the delimiter between key prefixes and IDs for \"stats detail\" and namespaces (\"ns_flush\"); empty one means default \":\"."),
+		replica_of: flags.String("replicaof", "", "Run as replica of primary with specified address <host:port>."),
+		aof_path: flags.String("aof", "", "Log mutations to append-only file at specified path and restore storage from it on start."),
+		aof_fsync: flags.String("aof-fsync", "everysec", "Fsync policy of append-only file: always, everysec or no."),
+		ext_path: flags.String("ext-path", "", "Move large values evicted from memory to extstore file at specified path."),
+		ext_size: flags.Int("ext-size", 1024, "Size of extstore file in megabytes."),
+		ext_page_size: flags.Int("ext-page-size", 8, "Size of extstore page in megabytes."),
+		ext_item_size: flags.Int("ext-item-size", 512, "Minimal size of value (in bytes), which is moved to extstore."),
+		ext_compact_under: flags.Int("ext-compact-under", 50, "Percent of live data in extstore page, below which page is compacted instead of being evicted."),
+		compress_threshold: flags.Int("compress-threshold", 0, "Compress values not smaller than this amount of bytes; 0 turns compression off."),
+		compress_level: flags.Int("compress-level", 1, "Level of flate compression from 1 (the fastest) to 9 (the best)."),
+		lease_timeout: flags.Int("lease-timeout", 10, "Release leases of lget, which weren't used by lset, after this amount of seconds."),
+		lease_grace: flags.Int("lease-grace", 0, "Consider items stale during the last seconds of their life, so lget grants lease to recompute them."),
+		lru_crawler: flags.Bool("lru-crawler", false, "Run LRU crawler, which reclaims memory of expired items in background."),
+		lru_crawler_sleep: flags.Int("lru-crawler-sleep", 100, "Sleep of LRU crawler between its passes in microseconds (up to 1000000)."),
+		lru_crawler_tocrawl: flags.Uint("lru-crawler-tocrawl", 100, "Amount of items checked by LRU crawler per pass."),
+		track_sizes: flags.Bool("track-sizes", false, "Collect histogram of item sizes for \"stats sizes\" (it's updated on each storing and deletion)."),
+		config_path: flags.String("config", "", "Read options from configuration file at specified path; command line options take precedence."),
+		pidfile: flags.String("P", "", "Save pid of process to specified file, which is locked while server is running."),
+		user: flags.String("u", "", "Switch to specified user after listener is established."),
+		log_file: flags.String("log-file", "", "Redirect output of daemon (-d) to specified file; default is to discard it."),
+	}
+}
+
+// Function parses passed command line arguments and configuration file, if it is specified by them.
+// Returns set of flags, parsed options and names of options, which were passed on command line.
+func parseOptions(args []string, error_handling flag.ErrorHandling) (*flag.FlagSet, *options, map[string] bool, error) {
+	flags := flag.NewFlagSet("memorango", error_handling)
+	opts := defineOptions(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, nil, err
+	}
+	explicit := config.Explicit(flags)
+	if len(*opts.config_path) > 0 {
+		configuration, err := config.Load(*opts.config_path)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err = configuration.Apply(flags, explicit); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return flags, opts, explicit, nil
+}
+
+// Function returns verbosity level of server by passed options.
+func verbosity(opts *options) int {
+	if *opts.deep_verbose {
+		return 2
+	} else if *opts.verbose {
+		return 1
+	}
+	return 0
+}
+
+// Function re-reads configuration file for reload: options passed on command line keep their values from passed
+// set of flags (command line isn't parsed again), and the rest ones are set by the file or get default values.
+func reloadOptions(flags *flag.FlagSet, explicit map[string] bool) (*flag.FlagSet, *options, error) {
+	reloaded := flag.NewFlagSet("memorango", flag.ContinueOnError)
+	opts := defineOptions(reloaded)
+	for name := range explicit {
+		if err := reloaded.Set(name, flags.Lookup(name).Value.String()); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(*opts.config_path) > 0 {
+		configuration, err := config.Load(*opts.config_path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = configuration.Apply(reloaded, explicit); err != nil {
+			return nil, nil, err
+		}
+	}
+	return reloaded, opts, nil
+}
+
+// Function re-reads configuration file and applies reloadable options to running server. Changes of other
+// options are reported and ignored until restart. Returns effective configuration of server after reload
+// and names of ignored options.
+func reload(_server *server.Server, flags *flag.FlagSet, explicit map[string] bool,
+			effective map[string] string) (map[string] string, []string) {
+	reloaded, opts, err := reloadOptions(flags, explicit)
+	if err != nil {
+		fmt.Println("Impossible to reload configuration:", err)
+		return effective, nil
+	}
+	if *opts.memory_amount_mb <= 0 {
+		fmt.Println("Impossible to reload configuration with incorrect specified amount of available data.")
+		return effective, nil
+	}
+	current := config.Effective(reloaded)
+	var ignored []string
+	for _, name := range config.Changed(effective, current) {
+		if !tools.In(name, reloadable) {
+			fmt.Println("Warning: option", name, "can't be changed without restart of server.")
+			current[name] = effective[name]
+			ignored = append(ignored, name)
+		}
+	}
+	err = _server.Reconfigure(verbosity(opts), *opts.max_connections, int64(*opts.memory_amount_mb)*1024*1024)
+	if err != nil {
+		fmt.Println("Impossible to change amount of memory:", err)
+		return effective, ignored
+	}
+	_server.Stat.SlowLog.SetThreshold(time.Duration(*opts.slowlog_threshold) * time.Microsecond)
+	_server.SetLeases(time.Duration(*opts.lease_timeout) * time.Second, time.Duration(*opts.lease_grace) * time.Second)
+	if err := _server.SetCompression(*opts.compress_threshold, *opts.compress_level); err != nil {
+		fmt.Println("Impossible to change compression:", err)
+	}
+	if err := _server.SetCrawler(*opts.lru_crawler, *opts.lru_crawler_sleep, *opts.lru_crawler_tocrawl); err != nil {
+		fmt.Println("Impossible to change LRU crawler:", err)
+	}
+	_server.SetTrackSizes(*opts.track_sizes)
+	_server.SetConfig(current)
+	fmt.Println("Configuration was reloaded.")
+	return current, ignored
+}
+
 func main() {
-	tcp_port := flag.String("p", "11211", "TCP Port to listen (non required - default port is 11211)")
-	memory_amount_mb := flag.Int("m", 0, "Amount of memory to allocate (MiB)")
-	daemonize := flag.Bool("d", false, "Run process as background")
-	// unix_socket := flag.String("s", "", "Unix socket path to listen on (disables network support)")
-	// unix_perms := flag.String("a", "", "Permissions (in octal format) for Unix socket created with -s option")
-	listen_ip := flag.String("l", "", "Listen on specified ip addr only; default to any address.")
-	max_connections := flag.Int("c", 1024, "Use max simultaneous connections;")
-	udp_port := flag.String("U", "", "UDP Port to listen (default is empty string - which means it is turned off)")
-	disable_cas := flag.Bool("C", false, "Disabling of cas command support.")
-	disable_flush := flag.Bool("F", false, "Disabling of flush_all command support.")
-	help := flag.Bool("h", false, "Show usage manual and list of options.")
-	verbose := flag.Bool("v", false, "Turning verbosity on. This option includes errors and warnings only.")
-	deep_verbose := flag.Bool("vv", false, "Turning deep verbosity on. This option includes requests, responses and same output as simple verbosity.")
-	flag.Parse()
+	flags, opts, explicit, err := parseOptions(os.Args[1:], flag.ExitOnError)
+	if err != nil {
+		fmt.Println("Impossible to read configuration:", err)
+		return
+	}
 
-	if *help {
+	if *opts.help {
 		// TODO: It should be spread in future.
 		fmt.Println("MemoranGo - memory caching service.\nusage:\nmemorango -m <memory_to_alloc> [-CvhFvvd]\n"+
-				"\t[-l <listen_ip>] [-c <limit_connections>] [-p <tcp_port>] [-U <udp_port>]")
+				"\t[-l <listen_ip>] [-c <limit_connections>] [-p <tcp_port>] [-U <udp_port>]\n"+
+				"\t[-D <char>] [-slowlog-threshold <microseconds>] [-slowlog-len <entries>]\n"+
+				"\t[-replicaof <host:port>] [-aof <path>] [-aof-fsync always|everysec|no]\n"+
+				"\t[-ext-path <path>] [-ext-size <megabytes>] [-ext-page-size <megabytes>] [-ext-item-size <bytes>]\n"+
+				"\t[-ext-compact-under <percent>] [-compress-threshold <bytes>] [-compress-level <1-9>]\n"+
+				"\t[-lease-timeout <seconds>] [-lease-grace <seconds>]\n"+
+				"\t[-lru-crawler] [-lru-crawler-sleep <microseconds>] [-lru-crawler-tocrawl <items>] [-track-sizes]\n"+
+				"\t[-config <path>] [-P <pidfile>] [-u <user>] [-log-file <path>]")
 		return
 	}
 
-	if *memory_amount_mb <= 0 {
+	if *opts.memory_amount_mb <= 0 {
 		fmt.Println("Impossible to run server with incorrect specified amount of available data.")
 		return
 	}
 
-	var verbosity = 0
-	if *deep_verbose {
-		verbosity = 2
-	} else if *verbose {
-		verbosity = 1
+	if *opts.daemonize {
+		if pid, locked := daemon.Locked(*opts.pidfile); locked {
+			fmt.Printf("Server is already running with pid %d.\n", pid)
+			return
+		}
+		// daemon gets the same command line options, so it reads the same configuration file,
+		// but it shouldn't be daemonized again.
+		var transacted_options = []string{}
+		var names []string
+		for name := range explicit {
+			if name != "d" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := flags.Lookup(name).Value.String()
+			if tools.In(name, paths) && len(value) > 0 {
+				value, _ = filepath.Abs(value)
+			}
+			transacted_options = append(transacted_options, "-" + name + "=" + value)
+		}
+		transacted_options = append(transacted_options, "-d=false")
+		pid, err := daemon.Start(transacted_options, *opts.log_file)
+		if err != nil {
+			fmt.Println("Impossible to run daemon:", err)
+			return
+		}
+		fmt.Printf("Run %s daemon with pid %d at 127.0.0.1:%s with %d MiB allowed memory.\n", tools.VERSION, pid,
+				   *opts.tcp_port, *opts.memory_amount_mb)
+		return
 	}
 
-
-	if *daemonize {
-		var transacted_options = []string{}
-		dir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
-		transacted_options = append(transacted_options, filepath.Join(dir, os.Args[0]), "-p", *tcp_port, "-m",
-									tools.IntToString(int64(*memory_amount_mb)),
-									"-c", tools.IntToString(int64(*max_connections)))
-		if len(*listen_ip) > 0 {
-			transacted_options = append(transacted_options, "-l", *listen_ip)
-		}
-		if len(*udp_port) > 0 {
-			transacted_options = append(transacted_options, "-U", *udp_port)
-		}
-		if *disable_cas {
-			transacted_options = append(transacted_options, "-C")
-		}
-		if *disable_flush {
-			transacted_options = append(transacted_options, "-F")
-		}
-		if verbosity == 1 {
-			transacted_options = append(transacted_options, "-v")
-		} else if verbosity == 2 {
-			transacted_options = append(transacted_options, "-vv")
-		}
-		transacted_options = append(transacted_options, "&")
-		fmt.Printf("Run %s daemon at 127.0.0.1:%s with %d MiB allowed memory.\n", tools.VERSION, *tcp_port, *memory_amount_mb)
-		cmd := exec.Command("/usr/bin/nohup", transacted_options...)
-		start_err := cmd.Start()
-		if start_err != nil {
-			fmt.Println("Status: ", start_err)
-		}
-	} else {
-		fmt.Printf("%d Run %s on 127.0.0.1:%s with %d MiB allowed memory.\n",
-			os.Getpid(), tools.VERSION, *tcp_port, *memory_amount_mb)
-		_server := server.NewServer(*tcp_port, *udp_port, *listen_ip, *max_connections, *disable_cas, *disable_flush,
-									verbosity, int64(*memory_amount_mb)*1024*1024 /* let's convert to bytes */)
-		_server.RunServer()
-		defer _server.StopServer()
-		_server.Wait()
+	if len(*opts.pidfile) > 0 {
+		pidfile, err := daemon.Lock(*opts.pidfile)
+		if err != nil {
+			fmt.Println("Impossible to lock pidfile:", err)
+			return
+		}
+		defer pidfile.Remove()
+	}
+	fmt.Printf("%d Run %s on 127.0.0.1:%s with %d MiB allowed memory.\n",
+		os.Getpid(), tools.VERSION, *opts.tcp_port, *opts.memory_amount_mb)
+	_server := server.NewServer(*opts.tcp_port, *opts.udp_port, *opts.listen_ip, *opts.max_connections,
+								*opts.disable_cas, *opts.disable_flush, verbosity(opts),
+								int64(*opts.memory_amount_mb)*1024*1024 /* let's convert to bytes */)
+	_server.SetPrefixDelimiter(*opts.prefix_delimiter)
+	_server.SetTrackSizes(*opts.track_sizes)
+	_server.SetSlowLog(time.Duration(*opts.slowlog_threshold) * time.Microsecond, *opts.slowlog_length)
+	_server.SetLeases(time.Duration(*opts.lease_timeout) * time.Second, time.Duration(*opts.lease_grace) * time.Second)
+	if len(*opts.replica_of) > 0 {
+		_server.SetReplicaOf(*opts.replica_of)
+	}
+	if len(*opts.ext_path) > 0 {
+		err := _server.SetExtStore(*opts.ext_path, int64(*opts.ext_size) * 1024 * 1024,
+								   int64(*opts.ext_page_size) * 1024 * 1024, *opts.ext_item_size,
+								   float64(*opts.ext_compact_under) / 100)
+		if err != nil {
+			fmt.Println("Impossible to create extstore:", err)
+			return
+		}
+	}
+	if *opts.compress_threshold > 0 {
+		if err := _server.SetCompression(*opts.compress_threshold, *opts.compress_level); err != nil {
+			fmt.Println("Impossible to turn compression on:", err)
+			return
+		}
+	}
+	if *opts.lru_crawler {
+		if err := _server.SetCrawler(true, *opts.lru_crawler_sleep, *opts.lru_crawler_tocrawl); err != nil {
+			fmt.Println("Impossible to run LRU crawler:", err)
+			return
+		}
+	}
+	if len(*opts.aof_path) > 0 {
+		if err := _server.SetAppendLog(*opts.aof_path, *opts.aof_fsync); err != nil {
+			fmt.Println("Impossible to open append-only file:", err)
+			return
+		}
+	}
+	effective := config.Effective(flags)
+	_server.SetConfig(effective)
+	if err := _server.RunServer(); err != nil {
+		return
+	}
+	if len(*opts.user) > 0 {
+		if err := daemon.SetUser(*opts.user); err != nil {
+			fmt.Println("Impossible to switch user:", err)
+			_server.StopServer()
+			return
+		}
+	}
+	if _, err := daemon.Notify(daemon.READY + "\nMAINPID=" + strconv.Itoa(os.Getpid())); err != nil {
+		fmt.Println("Impossible to notify systemd:", err)
+	}
+	stopped := make(chan bool)
+	defer close(stopped)
+	if _, err := daemon.StartWatchdog(_server.Healthy, stopped); err != nil {
+		fmt.Println("Impossible to start watchdog:", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	for received := range signals {
+		if received != syscall.SIGHUP {
+			break
+		}
+		daemon.Notify(daemon.RELOADING)
+		effective, _ = reload(_server, flags, explicit, effective)
+		daemon.Notify(daemon.READY)
 	}
+	daemon.Notify(daemon.STOPPING)
+	_server.StopServer()
 }
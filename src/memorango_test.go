@@ -0,0 +1,50 @@
+package main
+
+import (
+	"config"
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"server"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	file, err := ioutil.TempFile("", "memorango_config")
+	if err != nil {
+		t.Fatalf("Impossible to create file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("slowlog-threshold = 100\nport = 11311\nlease-grace = 1\n")
+	file.Close()
+	flags, opts, explicit, err := parseOptions([]string{"-config", file.Name(), "-m", "1", "-lease-timeout", "7"},
+											   flag.ContinueOnError)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	_server := server.NewServer(*opts.tcp_port, "", "", 1024, false, false, 0, 1024 * 1024)
+	_server.SetSlowLog(time.Duration(*opts.slowlog_threshold) * time.Microsecond, *opts.slowlog_length)
+	effective := config.Effective(flags)
+	// command line isn't parsed again on reload.
+	args := os.Args
+	os.Args = []string{"memorango", "-m", "-1"}
+	defer func() { os.Args = args }()
+	ioutil.WriteFile(file.Name(), []byte("slowlog-threshold = 200\nport = 11312\nlease-timeout = 5\ntrack-sizes = true\n"), 0644)
+	current, ignored := reload(_server, flags, explicit, effective)
+	if !reflect.DeepEqual(ignored, []string{"port"}) {
+		t.Fatalf("Unexpected ignored options: %v", ignored)
+	}
+	if current["port"] != "11311" || current["slowlog_threshold"] != "200" || current["memory"] != "1" ||
+	   current["lease_timeout"] != "7" || current["lease_grace"] != "0" || current["track_sizes"] != "true" {
+		t.Fatalf("Unexpected effective configuration: %v", current)
+	}
+	if _server.Stat.SlowLog.Threshold() != 200 * time.Microsecond {
+		t.Fatalf("Reloadable option wasn't applied.")
+	}
+	ioutil.WriteFile(file.Name(), []byte("lease-grace = soon\n"), 0644)
+	if after, ignored := reload(_server, flags, explicit, current); !reflect.DeepEqual(after, current) || ignored != nil {
+		t.Fatalf("Invalid configuration was applied: %v, %v", after, ignored)
+	}
+}
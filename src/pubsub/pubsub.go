@@ -0,0 +1,164 @@
+/*
+Package implements subscriptions to events of storage for near-cache clients.
+
+Client sends "subscribe [prefix=<prefix>] [events=<event>[,<event>...]]" command and server answers with "OK" line,
+after which the connection becomes stream of "EVENT <event> <key>" lines, where event is one of "set", "delete",
+"evict", "expire" or "flush" (flushing of all items, without key). Events are queued for each subscriber without
+blocking of writers: when queue of subscriber overflows, events are dropped and the next delivered line is
+"LOST <number>", so client should discard its whole near-cache. Subscription ends when client closes connection
+or sends any line.
+*/
+package pubsub
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"tools"
+	"tools/cache"
+	"tools/stream"
+)
+
+const (
+	// Command, which turns connection into stream of events.
+	SUBSCRIBE = "subscribe"
+	// Defines default number of events, which may be queued for single subscriber.
+	DEFAULT_BUFFER_SIZE = 4096
+	// Prefixes of lines of stream.
+	EVENT_PREFIX = "EVENT "
+	LOST_PREFIX = "LOST "
+)
+
+// Error, which is returned when arguments of subscription are invalid.
+var ErrInvalidFilter = errors.New("Invalid arguments of subscription.")
+
+// List of events, which may be subscribed to.
+var events = []string{cache.EVENT_SET, cache.EVENT_DELETE, cache.EVENT_EVICT, cache.EVENT_EXPIRE, cache.EVENT_FLUSH}
+
+// Structure of filter of events: prefix of keys and kinds of events; empty values match everything.
+// Flushing of all items matches any prefix.
+type Filter struct {
+	Prefix string
+	Events []string
+}
+
+// Function parses arguments of "subscribe" command into filter.
+func ParseFilter(args []string) (Filter, error) {
+	var filter Filter
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "prefix="):
+			filter.Prefix = arg[len("prefix=") : ]
+		case strings.HasPrefix(arg, "events="):
+			filter.Events = strings.Split(arg[len("events=") : ], ",")
+			for _, event := range filter.Events {
+				if !tools.In(event, events) {
+					return filter, ErrInvalidFilter
+				}
+			}
+		default:
+			return filter, ErrInvalidFilter
+		}
+	}
+	return filter, nil
+}
+
+// Private method of filter, which returns true if event matches it.
+func (f *Filter) match(event string, key string) bool {
+	if len(f.Events) > 0 && !tools.In(event, f.Events) {
+		return false
+	}
+	return event == cache.EVENT_FLUSH || strings.HasPrefix(key, f.Prefix)
+}
+
+// Function encodes event of storage into line of stream.
+func eventLine(event string, key string) string {
+	if len(key) == 0 {
+		return EVENT_PREFIX + event + "\r\n"
+	}
+	return EVENT_PREFIX + event + " " + key + "\r\n"
+}
+
+// Function encodes number of dropped events into marker line of stream.
+func lostLine(lost uint64) string {
+	return LOST_PREFIX + tools.UIntToString(lost) + "\r\n"
+}
+
+// Structure of subscriber: its filter and stream of events.
+type Subscriber struct {
+	filter Filter
+	queue *stream.Stream
+}
+
+// Public method of subscriber, which returns channel of lines of its events.
+func (s *Subscriber) Lines() <-chan string {
+	return s.queue.Lines()
+}
+
+// Hub of subscriptions, which is listener of storage (implementation of cache.Listener).
+type Hub struct {
+	subscribers map[*Subscriber] bool
+	mutex sync.Mutex
+	// Maximal number of events queued for single subscriber.
+	BufferSize int
+	published uint64
+	lost uint64
+}
+
+// Function creates hub of subscriptions.
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscriber] bool), BufferSize: DEFAULT_BUFFER_SIZE}
+}
+
+// Implementation of cache.Listener: queues event for matching subscribers without blocking.
+func (h *Hub) Changed(event string, key string, size int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for s := range h.subscribers {
+		if !s.filter.match(event, key) {
+			continue
+		}
+		if s.queue.Push(eventLine(event, key)) {
+			h.published ++
+		} else {
+			h.lost ++
+		}
+	}
+}
+
+// Function registers subscriber with passed filter.
+func (h *Hub) Subscribe(filter Filter) *Subscriber {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := &Subscriber{filter: filter, queue: stream.New(h.BufferSize, lostLine)}
+	h.subscribers[s] = true
+	return s
+}
+
+// Function discards registration of subscriber.
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.subscribers, s)
+}
+
+// Function serves connection of subscriber, after "subscribe" command with passed filter was read from it.
+// Passed reader is watched for closing of connection or any line from client, which ends subscription.
+func (h *Hub) Serve(connection net.Conn, reader io.Reader, filter Filter) error {
+	s := h.Subscribe(filter)
+	defer h.Unsubscribe(s)
+	return s.queue.Serve(connection, reader)
+}
+
+// Function returns lines of statistic of subscriptions: number of subscribers, delivered and dropped events.
+func (h *Hub) Stats() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return []string{
+		"pubsub_subscribers " + tools.IntToString(int64(len(h.subscribers))),
+		"pubsub_events " + tools.UIntToString(h.published),
+		"pubsub_lost " + tools.UIntToString(h.lost),
+	}
+}
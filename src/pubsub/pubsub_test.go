@@ -0,0 +1,51 @@
+package pubsub
+
+import (
+	"testing"
+	"tools/cache"
+)
+
+func TestFilterParsing(t *testing.T) {
+	filter, err := ParseFilter([]string{"prefix=user:", "events=set,delete"})
+	if err != nil || filter.Prefix != "user:" || len(filter.Events) != 2 {
+		t.Fatalf("Unexpected filter: %v, %s", filter, err)
+	}
+	if !filter.match("set", "user:1") || filter.match("evict", "user:1") || filter.match("set", "other") {
+		t.Fatalf("Unexpected matching of filter.")
+	}
+	if _, err := ParseFilter([]string{"events=set,unknown"}); err != ErrInvalidFilter {
+		t.Fatalf("Unknown event was accepted.")
+	}
+	if _, err := ParseFilter([]string{"user:"}); err != ErrInvalidFilter {
+		t.Fatalf("Unknown argument was accepted.")
+	}
+}
+
+func TestLostEvents(t *testing.T) {
+	hub := NewHub()
+	hub.BufferSize = 2
+	slow := hub.Subscribe(Filter{})
+	filtered := hub.Subscribe(Filter{Events: []string{cache.EVENT_DELETE}})
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		hub.Changed(cache.EVENT_SET, key, 1)
+	}
+	hub.Changed(cache.EVENT_DELETE, "k1", 1)
+	if line := <-filtered.Lines(); line != "EVENT delete k1\r\n" || len(filtered.Lines()) != 0 {
+		t.Fatalf("Unexpected event of filtered subscriber: %q", line)
+	}
+	for _, expected := range []string{"EVENT set k1\r\n", "EVENT set k2\r\n"} {
+		if line := <-slow.Lines(); line != expected {
+			t.Fatalf("Unexpected event: %q, expected %q", line, expected)
+		}
+	}
+	hub.Changed(cache.EVENT_FLUSH, "", 0)
+	for _, expected := range []string{"LOST 3\r\n", "EVENT flush\r\n"} {
+		if line := <-slow.Lines(); line != expected {
+			t.Fatalf("Unexpected event: %q, expected %q", line, expected)
+		}
+	}
+	hub.Unsubscribe(slow)
+	if stats := hub.Stats(); stats[0] != "pubsub_subscribers 1" || stats[1] != "pubsub_events 4" || stats[2] != "pubsub_lost 3" {
+		t.Fatalf("Unexpected statistic: %v", stats)
+	}
+}
@@ -0,0 +1,198 @@
+package replication
+
+import (
+	"bufio"
+	"embedded"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tools"
+	"tools/protocol"
+)
+
+const (
+	// Defines default number of mutations, which may be queued for single replica.
+	DEFAULT_BACKLOG = 65536
+	// Defines default period of heartbeat.
+	DEFAULT_HEARTBEAT_PERIOD = time.Second
+)
+
+// Structure of connected replica, which observes mutations of primary's cache.
+type replica struct {
+	address string
+	queue chan *embedded.Mutation
+	offset uint64 // number of queued mutations
+	acked uint64 // number of mutations, which replica has applied
+	overflow int32
+	since int64
+}
+
+// Implementation of embedded.Observer: queues mutation without blocking; it's encoded by serving goroutine.
+func (r *replica) Mutated(mutation *embedded.Mutation) {
+	select {
+	case r.queue <- mutation:
+		atomic.AddUint64(&r.offset, 1)
+	default:
+		atomic.StoreInt32(&r.overflow, 1)
+	}
+}
+
+// Primary side of replication, which serves replication connections.
+type Primary struct {
+	cache *embedded.Cache
+	replicas map[*replica] bool
+	mutex sync.Mutex
+	// Maximal number of mutations queued for single replica.
+	Backlog int
+	// Period of heartbeat lines.
+	HeartbeatPeriod time.Duration
+}
+
+// Function creates primary, which replicates mutations made through passed cache.
+func NewPrimary(cache *embedded.Cache) *Primary {
+	return &Primary{
+		cache: cache,
+		replicas: make(map[*replica] bool),
+		Backlog: DEFAULT_BACKLOG,
+		HeartbeatPeriod: DEFAULT_HEARTBEAT_PERIOD,
+	}
+}
+
+// Function serves replication connection, after "replicate" command was read from it; passed reader is used
+// for reading of acknowledgements.
+// Function returns when connection gets broken, replica disconnects or its backlog overflows.
+func (p *Primary) Serve(connection net.Conn, reader io.Reader) error {
+	r := &replica{
+		address: connection.RemoteAddr().String(),
+		queue: make(chan *embedded.Mutation, p.Backlog),
+		since: time.Now().Unix(),
+	}
+	keys := p.cache.Subscribe(r)
+	defer p.cache.Unsubscribe(r)
+	p.mutex.Lock()
+	p.replicas[r] = true
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.replicas, r)
+		p.mutex.Unlock()
+	}()
+
+	closed := make(chan error, 1)
+	go func() {
+		buffered := bufio.NewReader(reader)
+		for {
+			line, err := buffered.ReadString('\n')
+			if err != nil {
+				closed <- err
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if strings.HasPrefix(line, ACK_PREFIX) {
+				if offset, err := tools.StringToUInt64(line[len(ACK_PREFIX) : ]); err == nil {
+					atomic.StoreUint64(&r.acked, offset)
+				}
+			}
+		}
+	}()
+
+	writer := bufio.NewWriter(connection)
+	if err := p.sync(r, keys, writer); err != nil {
+		return err
+	}
+	heartbeat := time.NewTicker(p.HeartbeatPeriod)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case mutation := <-r.queue:
+			protocol.WriteMutation(writer, mutation)
+			// let's write all queued mutations at once.
+			for pending := len(r.queue); pending > 0; pending -- {
+				protocol.WriteMutation(writer, <-r.queue)
+			}
+		case now := <-heartbeat.C:
+			writer.WriteString(PING_PREFIX + tools.UIntToString(atomic.LoadUint64(&r.offset)) + " " +
+							   tools.IntToString(now.UnixNano()) + "\r\n")
+		case err := <-closed:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if atomic.LoadInt32(&r.overflow) != 0 {
+			return ErrBacklogOverflow
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// Private method, which writes snapshot of items with passed keys to replica in batches, so lock of cache isn't held
+// for the whole snapshot. Mutations made meanwhile are queued: the ones, which were made before batch was read,
+// are written before it (since batch reflects them and overwrites their result), and the rest after it.
+// Number of written mutations is sent at the end of synchronization, so replica continues to count them.
+func (p *Primary) sync(r *replica, keys []string, writer *bufio.Writer) error {
+	writer.WriteString(FULLRESYNC + "\r\n")
+	var written uint64
+	for start := 0; start < len(keys); start += embedded.SNAPSHOT_BATCH {
+		end := start + embedded.SNAPSHOT_BATCH
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var barrier uint64
+		batch := p.cache.Snapshot(keys[start : end], func() {
+			barrier = atomic.LoadUint64(&r.offset)
+		})
+		for ; written < barrier; written ++ {
+			protocol.WriteMutation(writer, <-r.queue)
+		}
+		for _, item := range batch {
+			protocol.WriteMutation(writer, item.Mutation())
+		}
+		if atomic.LoadInt32(&r.overflow) != 0 {
+			return ErrBacklogOverflow
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	// the rest of queued mutations is written by caller as regular stream.
+	writer.WriteString(SYNCED_PREFIX + tools.UIntToString(written) + "\r\n")
+	return writer.Flush()
+}
+
+// Function returns lines of statistic of connected replicas:
+// number of replicas and address, number of queued and acknowledged mutations (and difference between them),
+// and length of backlog of each replica.
+func (p *Primary) Stats() []string {
+	p.mutex.Lock()
+	var replicas []*replica
+	for r := range p.replicas {
+		replicas = append(replicas, r)
+	}
+	p.mutex.Unlock()
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].address < replicas[j].address
+	})
+	result := []string{"connected_replicas " + tools.IntToString(int64(len(replicas)))}
+	for i, r := range replicas {
+		prefix := "replica" + tools.IntToString(int64(i)) + ":"
+		offset, acked := atomic.LoadUint64(&r.offset), atomic.LoadUint64(&r.acked)
+		var lag uint64
+		if offset > acked {
+			lag = offset - acked
+		}
+		result = append(result, prefix + "addr " + r.address,
+						prefix + "connected_secs " + tools.IntToString(time.Now().Unix() - r.since),
+						prefix + "offset " + tools.UIntToString(offset),
+						prefix + "acked_offset " + tools.UIntToString(acked),
+						prefix + "lag " + tools.UIntToString(lag),
+						prefix + "backlog " + tools.IntToString(int64(len(r.queue))))
+	}
+	return result
+}
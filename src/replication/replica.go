@@ -0,0 +1,212 @@
+package replication
+
+import (
+	"bufio"
+	"embedded"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"tools"
+	"tools/protocol"
+)
+
+const (
+	// Defines default period between attempts to connect to primary.
+	DEFAULT_RETRY_PERIOD = time.Second
+	// Defines timeout of establishing of connection to primary.
+	DIAL_TIMEOUT = time.Second * 5
+)
+
+// Replica side of replication, which keeps passed cache in sync with primary.
+type Replica struct {
+	primary string
+	cache *embedded.Cache
+	// Period between attempts to connect to primary.
+	RetryPeriod time.Duration
+	status string
+	offset uint64 // number of applied mutations since the last full resync
+	primary_offset uint64 // offset of primary reported by the last heartbeat
+	lag time.Duration // delay of the last heartbeat
+	resyncs uint64
+	last_error string
+	connection net.Conn
+	running bool
+	done chan bool
+	mutex sync.Mutex
+}
+
+// Function creates replica of primary with passed address ("host:port"), which applies mutations to passed cache.
+func NewReplica(primary string, cache *embedded.Cache) *Replica {
+	return &Replica{
+		primary: primary,
+		cache: cache,
+		RetryPeriod: DEFAULT_RETRY_PERIOD,
+		status: "down",
+	}
+}
+
+// Function runs replication loop within goroutine.
+func (r *Replica) Start() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.running {
+		return
+	}
+	r.running = true
+	r.done = make(chan bool)
+	go r.run(r.done)
+}
+
+// Function stops replication loop and breaks connection to primary. Data, which was replicated, is kept.
+func (r *Replica) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.running {
+		return
+	}
+	r.running = false
+	close(r.done)
+	if r.connection != nil {
+		r.connection.Close()
+	}
+}
+
+// Private method, which connects to primary and reconnects after failures until replica will be stopped.
+func (r *Replica) run(done chan bool) {
+	for {
+		err := r.sync(done)
+		r.mutex.Lock()
+		r.status = "down"
+		r.connection = nil
+		if err != nil {
+			r.last_error = err.Error()
+		}
+		r.mutex.Unlock()
+		select {
+		case <-done:
+			return
+		case <-time.After(r.RetryPeriod):
+		}
+	}
+}
+
+// Private method, which makes full resync with primary and then applies its stream until connection breaks.
+func (r *Replica) sync(done chan bool) error {
+	connection, err := net.DialTimeout("tcp", r.primary, DIAL_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	r.mutex.Lock()
+	select {
+	case <-done:
+		r.mutex.Unlock()
+		return nil
+	default:
+	}
+	r.connection = connection
+	r.status = "sync"
+	r.mutex.Unlock()
+
+	if _, err := connection.Write([]byte(REPLICATE + "\r\n")); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(connection)
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if line != FULLRESYNC {
+		return errors.New("Unexpected response of primary: " + line)
+	}
+	r.cache.FlushAll()
+	var offset uint64
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, SYNCED_PREFIX) {
+			if offset, err = tools.StringToUInt64(line[len(SYNCED_PREFIX) : ]); err != nil {
+				return errors.New("Malformed end of snapshot: " + line)
+			}
+			break
+		}
+		if _, err := protocol.ApplyMutation(line, reader, r.cache); err != nil {
+			return err
+		}
+	}
+	r.mutex.Lock()
+	r.status = "up"
+	r.offset = offset
+	r.resyncs ++
+	r.mutex.Unlock()
+
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, PING_PREFIX) {
+			fields := strings.Fields(line[len(PING_PREFIX) : ])
+			if len(fields) != 2 {
+				return errors.New("Malformed heartbeat: " + line)
+			}
+			primary_offset, err_offset := tools.StringToUInt64(fields[0])
+			ts, err_ts := tools.StringToInt64(fields[1])
+			if err_offset != nil || err_ts != nil {
+				return errors.New("Malformed heartbeat: " + line)
+			}
+			r.mutex.Lock()
+			r.primary_offset = primary_offset
+			r.lag = time.Since(time.Unix(0, ts))
+			offset := r.offset
+			r.mutex.Unlock()
+			if _, err := connection.Write([]byte(ACK_PREFIX + tools.UIntToString(offset) + "\r\n")); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := protocol.ApplyMutation(line, reader, r.cache); err != nil {
+			return err
+		}
+		r.mutex.Lock()
+		r.offset ++
+		r.mutex.Unlock()
+	}
+}
+
+// Function reads line of replication stream without terminator.
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Function returns lines of statistic of replica: address of primary, state of link ("sync", "up" or "down"),
+// number of applied mutations, offset of primary and lag reported by the last heartbeat, and number of full resyncs.
+func (r *Replica) Stats() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var lag uint64
+	if r.primary_offset > r.offset {
+		lag = r.primary_offset - r.offset
+	}
+	result := []string{
+		"primary " + r.primary,
+		"primary_link_status " + r.status,
+		"offset " + tools.UIntToString(r.offset),
+		"primary_offset " + tools.UIntToString(r.primary_offset),
+		"lag " + tools.UIntToString(lag),
+		"lag_us " + tools.IntToString(r.lag.Microseconds()),
+		"resyncs " + tools.UIntToString(r.resyncs),
+	}
+	if len(r.last_error) > 0 {
+		result = append(result, "last_error " + r.last_error)
+	}
+	return result
+}
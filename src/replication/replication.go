@@ -0,0 +1,36 @@
+/*
+Package implements asynchronous primary-to-replica replication of storage.
+
+Replica connects to primary's regular port and sends "replicate" command. Primary answers with "FULLRESYNC" line
+followed by snapshot of alive items as "set" commands and "SYNCED <offset>" line, and then streams each mutation,
+which is made through its cache, as one of "set", "append", "prepend", "touch", "delete", "flush_all", "ns_flush"
+or "invalidate_tag" commands with "noreply" and absolute expiration timestamps. Append and prepend carry only
+concatenated data; values produced by incr/decr/cas are replicated as plain "set".
+Snapshot is read in batches, so mutations made during synchronization are interleaved with it; offset of
+"SYNCED" line is number of such mutations. Periodically primary sends "PING <offset> <unix nanoseconds>" line, where offset is number of mutations
+queued for the replica, and replica answers with "ACK <offset>" line, where offset is number of applied mutations.
+
+If replica can't keep up and its backlog overflows, primary breaks replication connection. Replica reconnects
+after RetryPeriod and makes full resync again.
+*/
+package replication
+
+import (
+	"errors"
+)
+
+const (
+	// Command, which is sent by replica to start replication.
+	REPLICATE = "replicate"
+	// The first line of replication stream.
+	FULLRESYNC = "FULLRESYNC"
+	// Prefix of line, which ends snapshot.
+	SYNCED_PREFIX = "SYNCED "
+	// Prefix of heartbeat line of replication stream.
+	PING_PREFIX = "PING "
+	// Prefix of acknowledgement line, which is sent by replica.
+	ACK_PREFIX = "ACK "
+)
+
+// Error, which is returned when replica doesn't consume stream fast enough.
+var ErrBacklogOverflow = errors.New("replication backlog overflow")
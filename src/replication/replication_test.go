@@ -0,0 +1,189 @@
+package replication
+
+import (
+	"testing"
+	"bufio"
+	"embedded"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Function runs listener, which serves replication connections by passed primary.
+func runPrimary(t *testing.T, primary *Primary) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listener wasn't established: %s", err)
+	}
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer connection.Close()
+				reader := bufio.NewReader(connection)
+				if line, err := readLine(reader); err != nil || line != REPLICATE {
+					return
+				}
+				primary.Serve(connection, reader)
+			}()
+		}
+	}()
+	return listener
+}
+
+// Function waits until passed condition becomes true.
+func waitFor(condition func() bool) bool {
+	for i := 0; i < 200; i ++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond * time.Duration(10))
+	}
+	return false
+}
+
+func TestReplicationResyncAndStream(t *testing.T) {
+	primary_cache := embedded.New(1 << 20)
+	primary_cache.Set(&embedded.Item{Key: "snapshot", Value: []byte("value"), Flags: 1, TTL: time.Hour})
+	primary_cache.Set(&embedded.Item{Key: "expired", Value: []byte("value"), TTL: -time.Second})
+	primary := NewPrimary(primary_cache)
+	primary.HeartbeatPeriod = time.Millisecond * 50
+	listener := runPrimary(t, primary)
+	defer listener.Close()
+
+	replica_cache := embedded.New(1 << 20)
+	replica_cache.Set(&embedded.Item{Key: "stale", Value: []byte("value")})
+	replica := NewReplica(listener.Addr().String(), replica_cache)
+	replica.Start()
+	defer replica.Stop()
+	if !waitFor(func() bool { return strings.Contains(strings.Join(replica.Stats(), ","), "status up") }) {
+		t.Fatalf("Replica wasn't synchronized: %v", replica.Stats())
+	}
+	item, err := replica_cache.Get("snapshot")
+	if err != nil || string(item.Value) != "value" || item.Flags != 1 || item.Expiration.IsZero() {
+		t.Fatalf("Snapshot wasn't applied: %v, %s", item, err)
+	}
+	if _, err := replica_cache.Get("stale"); err != embedded.ErrNotFound {
+		t.Fatalf("Replica wasn't flushed before resync")
+	}
+	if _, err := replica_cache.Get("expired"); err != embedded.ErrNotFound {
+		t.Fatalf("Expired item was replicated")
+	}
+
+	primary_cache.Set(&embedded.Item{Key: "counter", Value: []byte("10")})
+	primary_cache.Increment("counter", 5)
+	primary_cache.Append("snapshot", []byte("_tail"))
+	primary_cache.Delete("snapshot")
+	primary_cache.Set(&embedded.Item{Key: "touched", Value: []byte("value")})
+	primary_cache.Touch("touched", time.Hour)
+	if !waitFor(func() bool {
+		item, err := replica_cache.Get("touched")
+		return err == nil && !item.Expiration.IsZero()
+	}) {
+		t.Fatalf("Mutations weren't replicated")
+	}
+	if item, err := replica_cache.Get("counter"); err != nil || string(item.Value) != "15" {
+		t.Fatalf("Unexpected value of counter: %v, %s", item, err)
+	}
+	if _, err := replica_cache.Get("snapshot"); err != embedded.ErrNotFound {
+		t.Fatalf("Deletion wasn't replicated")
+	}
+	// heartbeat delivers offset of primary and acknowledgement of replica.
+	if !waitFor(func() bool { return strings.Contains(strings.Join(primary.Stats(), ","), "acked_offset 6") }) {
+		t.Fatalf("Offset wasn't acknowledged: %v", primary.Stats())
+	}
+	if !strings.Contains(strings.Join(replica.Stats(), ","), "primary_offset 6") {
+		t.Fatalf("Unexpected statistic of replica: %v", replica.Stats())
+	}
+	primary_cache.FlushAll()
+	if !waitFor(func() bool { _, err := replica_cache.Get("counter"); return err == embedded.ErrNotFound }) {
+		t.Fatalf("Flushing wasn't replicated")
+	}
+}
+
+func TestReplicationReconnection(t *testing.T) {
+	primary_cache := embedded.New(1 << 20)
+	primary := NewPrimary(primary_cache)
+	primary.Backlog = 1
+	listener := runPrimary(t, primary)
+	defer listener.Close()
+
+	replica_cache := embedded.New(1 << 20)
+	replica := NewReplica(listener.Addr().String(), replica_cache)
+	replica.RetryPeriod = time.Millisecond * 10
+	replica.Start()
+	defer replica.Stop()
+	if !waitFor(func() bool { return strings.Contains(strings.Join(primary.Stats(), ","), "connected_replicas 1") }) {
+		t.Fatalf("Replica wasn't connected: %v", primary.Stats())
+	}
+	// backlog overflows, so primary breaks connection and replica makes full resync.
+	for i := 0; i < 1000; i ++ {
+		primary_cache.Set(&embedded.Item{Key: "key" + string(rune('a' + i % 26)), Value: []byte("value")})
+	}
+	primary_cache.Set(&embedded.Item{Key: "last", Value: []byte("value")})
+	if !waitFor(func() bool {
+		_, err := replica_cache.Get("last")
+		return err == nil && strings.Contains(strings.Join(replica.Stats(), ","), "resyncs 2")
+	}) {
+		t.Fatalf("Replica wasn't resynchronized: %v", replica.Stats())
+	}
+	replica.Stop()
+	if !waitFor(func() bool { return strings.Contains(strings.Join(primary.Stats(), ","), "connected_replicas 0") }) {
+		t.Fatalf("Replica wasn't disconnected: %v", primary.Stats())
+	}
+	if _, err := replica_cache.Get("last"); err != nil {
+		t.Fatalf("Replicated data was discarded after stopping")
+	}
+}
+
+func TestReplicationSyncDuringMutations(t *testing.T) {
+	primary_cache := embedded.New(64 << 20)
+	keys := embedded.SNAPSHOT_BATCH * 40
+	// snapshot doesn't fit into buffers of connection, so it's written while mutations are made.
+	value := []byte(strings.Repeat("v", 1000))
+	for i := 0; i < keys; i ++ {
+		primary_cache.Set(&embedded.Item{Key: "key" + strconv.Itoa(i), Value: value})
+	}
+	primary := NewPrimary(primary_cache)
+	primary.HeartbeatPeriod = time.Millisecond * 50
+	listener := runPrimary(t, primary)
+	defer listener.Close()
+
+	replica_cache := embedded.New(64 << 20)
+	replica := NewReplica(listener.Addr().String(), replica_cache)
+	replica.Start()
+	defer replica.Stop()
+	// appends aren't idempotent, so ones made during synchronization should be applied exactly once.
+	var rounds int
+	for ; rounds < 10000 && !strings.Contains(strings.Join(replica.Stats(), ","), "status up"); rounds ++ {
+		for i := 0; i < 100; i ++ {
+			primary_cache.Append("key" + strconv.Itoa((rounds * 101 + i * 97) % keys), []byte("!"))
+		}
+		primary_cache.Set(&embedded.Item{Key: "new" + strconv.Itoa(rounds), Value: []byte("value")})
+		primary_cache.Delete("key" + strconv.Itoa(rounds % keys))
+	}
+	primary_cache.Set(&embedded.Item{Key: "last", Value: []byte("value")})
+	if !waitFor(func() bool { _, err := replica_cache.Get("last"); return err == nil }) {
+		t.Fatalf("Replica wasn't synchronized: %v", replica.Stats())
+	}
+	for i := 0; i < keys; i ++ {
+		key := "key" + strconv.Itoa(i)
+		expected, err_expected := primary_cache.Get(key)
+		item, err := replica_cache.Get(key)
+		if err != err_expected || (err == nil && string(item.Value) != string(expected.Value)) {
+			t.Fatalf("Item %s diverged: %v, %v, %v, %v", key, item, expected, err, err_expected)
+		}
+	}
+	for round := 0; round < rounds; round ++ {
+		if _, err := replica_cache.Get("new" + strconv.Itoa(round)); err != nil {
+			t.Fatalf("Item stored during synchronization wasn't replicated")
+		}
+	}
+	if !waitFor(func() bool { return strings.Contains(strings.Join(primary.Stats(), ","), "replica0:lag 0") }) {
+		t.Fatalf("Offsets of replica and primary diverged: %v, %v", replica.Stats(), primary.Stats())
+	}
+}
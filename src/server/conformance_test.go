@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"tools"
+	"tools/protocol"
+)
+
+var conformance_port = "60017"
+
+// Step of scripted session: request is sent as is and response should match byte by byte.
+// Empty response means that server shouldn't answer (e.g. noreply). Placeholder {cas} of response
+// matches unique id of item, which is substituted for {cas} of the following requests.
+// Placeholder {stats} matches any number of "STAT <name> <value>" lines, whose order and values aren't fixed.
+type conformanceStep struct {
+	request string
+	response string
+}
+
+// Divergences from memcached, which were found by the sessions below and fixed, and sessions, which cover them:
+//
+//	cas of item modified after gets answers EXISTS (and counts cas_badval)      "cas"
+//	empty value (data block of zero length) is read and stored                  "set"
+//	delete rejects arguments besides legacy zero hold time and noreply          "delete"
+//	invalid delta and non-numeric value are reported by memcached's wording     "incr and decr"
+//	invalid exptime of touch is reported by memcached's wording                 "touch"
+//	unknown sub command of stats answers ERROR                                  "stats"
+//	invalid number of flush_all is reported as bad command line format          "other"
+//	empty line answers ERROR                                                    "errors"
+//	invalid numbers of storage commands are reported as bad command line format "errors"
+//	too long keys are reported as bad command line format                       "errors", "key length"
+//	key of maximal length is accepted as the last token of request              "key length"
+//	bad data chunk is reported instead of closing of connection                 "errors"
+//
+// Commands of protocol.txt, which aren't supported and answer ERROR as unknown ones (session "unsupported"):
+// verbosity, gat and gats, stats slabs. Responses of stats, stats settings and stats items carry
+// memorango's own set of fields, so sessions check their format only.
+
+// Scripted sessions, which follow memcached's protocol.txt section by section.
+var conformance_sessions = map[string] []conformanceStep{
+	// Storage commands
+	"set": {
+		{"set foo 5 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"get foo\r\n", "VALUE foo 5 3\r\nbar\r\nEND\r\n"},
+		{"set foo 4294967295 0 4\r\nbarb\r\n", "STORED\r\n"},
+		{"get foo\r\n", "VALUE foo 4294967295 4\r\nbarb\r\nEND\r\n"},
+		{"set foo 0 0 0\r\n\r\n", "STORED\r\n"},
+		{"get foo\r\n", "VALUE foo 0 0\r\n\r\nEND\r\n"},
+		{"set foo 0 0 3 noreply\r\nbaz\r\n", ""},
+		{"get foo\r\n", "VALUE foo 0 3\r\nbaz\r\nEND\r\n"},
+		{"set foo 0 -1 3\r\nbar\r\n", "STORED\r\n"},
+		{"get foo\r\n", "END\r\n"},
+	},
+	"add": {
+		{"add foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"add foo 0 0 3\r\nbaz\r\n", "NOT_STORED\r\n"},
+		{"add foo 0 0 3 noreply\r\nbaz\r\n", ""},
+		{"get foo\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n"},
+	},
+	"replace": {
+		{"replace foo 0 0 3\r\nbar\r\n", "NOT_STORED\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"replace foo 1 0 3\r\nbaz\r\n", "STORED\r\n"},
+		{"replace foo 2 0 3 noreply\r\nqux\r\n", ""},
+		{"get foo\r\n", "VALUE foo 2 3\r\nqux\r\nEND\r\n"},
+	},
+	"append and prepend": {
+		{"append foo 0 0 3\r\nbar\r\n", "NOT_STORED\r\n"},
+		{"prepend foo 0 0 3\r\nbar\r\n", "NOT_STORED\r\n"},
+		{"set foo 7 0 3\r\nbar\r\n", "STORED\r\n"},
+		// flags and exptime of append and prepend are ignored.
+		{"append foo 1 0 3\r\nbaz\r\n", "STORED\r\n"},
+		{"prepend foo 2 0 3\r\nfoo\r\n", "STORED\r\n"},
+		{"append foo 0 0 1 noreply\r\n!\r\n", ""},
+		{"get foo\r\n", "VALUE foo 7 10\r\nfoobarbaz!\r\nEND\r\n"},
+	},
+	"cas": {
+		{"cas foo 0 0 3 42\r\nbar\r\n", "NOT_FOUND\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"gets foo\r\n", "VALUE foo 0 3 {cas}\r\nbar\r\nEND\r\n"},
+		{"cas foo 0 0 3 1\r\nbaz\r\n", "EXISTS\r\n"},
+		{"cas foo 0 0 3 {cas}\r\nbaz\r\n", "STORED\r\n"},
+		{"gets foo\r\n", "VALUE foo 0 3 {cas}\r\nbaz\r\nEND\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"cas foo 0 0 3 {cas}\r\nqux\r\n", "EXISTS\r\n"},
+		{"cas foo 0 0 3 1 noreply\r\nqux\r\n", ""},
+		{"get foo\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n"},
+	},
+	// Retrieval command
+	"get": {
+		{"get foo\r\n", "END\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"set baz 1 0 3\r\nqux\r\n", "STORED\r\n"},
+		{"get foo missing baz foo\r\n", "VALUE foo 0 3\r\nbar\r\nVALUE baz 1 3\r\nqux\r\nVALUE foo 0 3\r\nbar\r\nEND\r\n"},
+		{"gets missing\r\n", "END\r\n"},
+	},
+	// Deletion
+	"delete": {
+		{"delete foo\r\n", "NOT_FOUND\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"delete foo 5\r\n", protocol.BAD_DELETE_FORMAT},
+		{"delete foo\r\n", "DELETED\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"delete foo 0\r\n", "DELETED\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"delete foo noreply\r\n", ""},
+		{"delete foo 0 noreply\r\n", ""},
+		{"get foo\r\n", "END\r\n"},
+	},
+	// Increment/Decrement
+	"incr and decr": {
+		{"incr foo 1\r\n", "NOT_FOUND\r\n"},
+		{"decr foo 1\r\n", "NOT_FOUND\r\n"},
+		{"set foo 3 0 2\r\n10\r\n", "STORED\r\n"},
+		{"incr foo 5\r\n", "15\r\n"},
+		{"decr foo 100\r\n", "0\r\n"},
+		{"incr foo 18446744073709551615\r\n", "18446744073709551615\r\n"},
+		{"incr foo 2\r\n", "1\r\n"},
+		{"incr foo 9 noreply\r\n", ""},
+		{"get foo\r\n", "VALUE foo 3 2\r\n10\r\nEND\r\n"},
+		{"incr foo abc\r\n", protocol.INVALID_DELTA},
+		{"incr foo -1\r\n", protocol.INVALID_DELTA},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"incr foo 1\r\n", protocol.NON_NUMERIC},
+		{"decr foo 1\r\n", protocol.NON_NUMERIC},
+		{"incr foo\r\n", "ERROR\r\n"},
+	},
+	// Touch
+	"touch": {
+		{"touch foo 10\r\n", "NOT_FOUND\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"touch foo 10\r\n", "TOUCHED\r\n"},
+		{"touch foo 10 noreply\r\n", ""},
+		{"touch foo abc\r\n", protocol.INVALID_EXPTIME},
+		{"touch foo\r\n", "ERROR\r\n"},
+		{"get foo\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n"},
+		{"touch foo -1\r\n", "TOUCHED\r\n"},
+		{"get foo\r\n", "END\r\n"},
+	},
+	// Statistics
+	"stats": {
+		{"stats reset\r\n", "RESET\r\n"},
+		{"stats unknown\r\n", "ERROR\r\n"},
+		{"stats detail\r\n", "CLIENT_ERROR usage: stats detail on|off|dump\r\n"},
+		{"stats detail maybe\r\n", "CLIENT_ERROR usage: stats detail on|off|dump\r\n"},
+		{"stats\r\n", "{stats}END\r\n"},
+		{"stats settings\r\n", "{stats}END\r\n"},
+		{"stats items\r\n", "{stats}END\r\n"},
+		{"stats conns\r\n", "{stats}END\r\n"},
+		{"stats slowlog\r\n", "{stats}END\r\n"},
+	},
+	"stats sizes": {
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"stats sizes\r\n", "STAT sizes_status disabled\r\nEND\r\n"},
+		{"stats sizes_enable\r\n", "OK\r\n"},
+		{"stats sizes\r\n", "STAT 32 1\r\nEND\r\n"},
+		{"stats sizes_disable\r\n", "OK\r\n"},
+		{"stats sizes\r\n", "STAT sizes_status disabled\r\nEND\r\n"},
+	},
+	// Other commands
+	"other": {
+		{"version\r\n", "VERSION " + tools.VERSION + "\r\n"},
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"flush_all\r\n", "OK\r\n"},
+		{"get foo\r\n", "END\r\n"},
+		{"flush_all 0\r\n", "OK\r\n"},
+		{"flush_all noreply\r\n", ""},
+		{"flush_all abc\r\n", protocol.BAD_FORMAT},
+	},
+	// Error strings
+	"errors": {
+		{"unknown\r\n", "ERROR\r\n"},
+		{"\r\n", "ERROR\r\n"},
+		{"set foo\r\n", "ERROR\r\n"},
+		{"get\r\n", "ERROR\r\n"},
+		// data block of rejected command is taken for the next command, as memcached does.
+		{"set foo abc 0 3\r\nbar\r\n", protocol.BAD_FORMAT + "ERROR\r\n"},
+		{"set foo 0 0 -1\r\n", protocol.BAD_FORMAT},
+		{"set foo 0 0 3\r\nbarbaz\r\n", protocol.BAD_DATA_CHUNK + "ERROR\r\n"},
+		{"get foo\r\n", "END\r\n"},
+		{"get " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + "\r\n", protocol.BAD_FORMAT},
+		{"set " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + " 0 0 3\r\nbar\r\n", protocol.BAD_FORMAT + "ERROR\r\n"},
+		{"set " + strings.Repeat("k", MAX_KEY_LENGTH) + " 0 0 3\r\nbar\r\n", "STORED\r\n"},
+	},
+	"key length": {
+		{"cas " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + " 0 0 3 1\r\nbar\r\n", protocol.BAD_FORMAT + "ERROR\r\n"},
+		{"incr " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + " 1\r\n", protocol.BAD_FORMAT},
+		{"decr " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + " 1\r\n", protocol.BAD_FORMAT},
+		{"touch " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + " 10\r\n", protocol.BAD_FORMAT},
+		{"delete " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + "\r\n", protocol.BAD_FORMAT},
+		{"set " + strings.Repeat("k", MAX_KEY_LENGTH) + " 0 0 1\r\n1\r\n", "STORED\r\n"},
+		{"incr " + strings.Repeat("k", MAX_KEY_LENGTH) + " 1\r\n", "2\r\n"},
+		{"touch " + strings.Repeat("k", MAX_KEY_LENGTH) + " 10\r\n", "TOUCHED\r\n"},
+		{"gets " + strings.Repeat("k", MAX_KEY_LENGTH) + "\r\n",
+		 "VALUE " + strings.Repeat("k", MAX_KEY_LENGTH) + " 0 1 {cas}\r\n2\r\nEND\r\n"},
+		{"cas " + strings.Repeat("k", MAX_KEY_LENGTH) + " 0 0 1 {cas}\r\n3\r\n", "STORED\r\n"},
+		{"delete " + strings.Repeat("k", MAX_KEY_LENGTH) + "\r\n", "DELETED\r\n"},
+	},
+	// Commands, which aren't supported (see the list above).
+	"unsupported": {
+		{"set foo 0 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"verbosity 1\r\n", "ERROR\r\n"},
+		{"verbosity 1 noreply\r\n", "ERROR\r\n"},
+		{"gat 10 foo\r\n", "ERROR\r\n"},
+		{"gats 10 foo\r\n", "ERROR\r\n"},
+		{"stats slabs\r\n", "ERROR\r\n"},
+		{"get foo\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n"},
+	},
+}
+
+// Function reads response to the step of session and checks it.
+// Returns unique id captured by {cas} placeholder or passed one.
+func conformanceResponse(t *testing.T, name string, reader *bufio.Reader, step conformanceStep, cas string) string {
+	var response string
+	stats := strings.Contains(step.response, "{stats}")
+	for lines := strings.Count(step.response, "\r\n"); lines > 0; lines -- {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Session %q: response to %q wasn't read: %q, %s", name, step.request, response + line, err)
+		}
+		response += line
+		// number of lines matched by {stats} isn't known, so they are read until the closing line.
+		if stats && lines == 1 && strings.HasPrefix(line, protocol.STAT_PREFIX) {
+			lines ++
+		}
+	}
+	pattern := strings.Replace(regexp.QuoteMeta(step.response), regexp.QuoteMeta("{cas}"), "([0-9]+)", -1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{stats}"), "(?:STAT [^ \r\n]+ [^\r\n]*\r\n)*", -1)
+	match := regexp.MustCompile("^" + pattern + "$").FindStringSubmatch(response)
+	if match == nil {
+		t.Fatalf("Session %q: unexpected response to %q: %q, expected %q", name, step.request, response, step.response)
+	}
+	if len(match) > 1 {
+		return match[1]
+	}
+	return cas
+}
+
+func TestServerConformance(t *testing.T) {
+	srv := NewServer(conformance_port, "", "", 1024, false, false, 0, 1 << 20)
+	if err := srv.RunServer(); err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer srv.StopServer()
+	for name, steps := range conformance_sessions {
+		connection, err := net.Dial("tcp", "127.0.0.1:" + conformance_port)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		reader := bufio.NewReader(connection)
+		steps = append([]conformanceStep{{"flush_all\r\n", "OK\r\n"}}, steps...)
+		var cas string
+		for _, step := range steps {
+			connection.SetDeadline(time.Now().Add(time.Second))
+			if _, err := connection.Write([]byte(strings.Replace(step.request, "{cas}", cas, -1))); err != nil {
+				t.Fatalf("Session %q: request %q wasn't sent: %s", name, step.request, err)
+			}
+			cas = conformanceResponse(t, name, reader, step, cas)
+		}
+		// nothing else should be sent, e.g. responses to noreply requests.
+		connection.SetDeadline(time.Now().Add(time.Millisecond * 50))
+		if data, err := reader.ReadString('\n'); len(data) > 0 || err == nil {
+			t.Fatalf("Session %q: unexpected response: %q", name, data)
+		}
+		connection.Close()
+	}
+}
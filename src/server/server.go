@@ -1,22 +1,29 @@
 package server
 
 import (
+	"aof"
 	"net"
 	"log"
 	"log/syslog"
+	"embedded"
+	"pubsub"
+	"replication"
+	"tools"
 	"tools/cache"
 	"tools/protocol"
+	"watch"
 	"io"
 	"os"
 	"bufio"
 	"errors"
 	"math/rand"
+	"runtime/debug"
 	"time"
 	statistic "tools/stat"
-	//"sync"
+	"sync"
 	"strings"
 	"io/ioutil"
-	"tools/stat"
+	"sync/atomic"
 )
 
 const (
@@ -24,6 +31,12 @@ const (
 	MAX_KEY_LENGTH = 250
 )
 
+// Errors of reading of request.
+var (
+	errKeyLength = errors.New("Maximal key length is exceeded.")
+	errTerminator = errors.New("Length was achieved, but terminator wasn't met.")
+)
+
 //TODO: Tests for logger
 // Structure for managing of output information during work of server.
 type ServerLogger struct {
@@ -39,20 +52,40 @@ type ServerLogger struct {
 // 2 - errors, warnings and info.
 func NewServerLogger(verbosity int) *ServerLogger {
 	var result ServerLogger
+	var err error
 	result.error = log.New(os.Stderr, "Error: ", log.Ldate | log.Ltime | log.Lshortfile)
+	result.warning = log.New(ioutil.Discard, "", 0)
+	result.info = log.New(ioutil.Discard, "", 0)
+	result.SetVerbosity(verbosity)
+	result.syslogger, err = syslog.NewLogger(syslog.LOG_ERR, log.Ldate | log.Ltime | log.Lshortfile)
+	if err != nil {
+		// system logger is unavailable (e.g. there is no syslog daemon), so its output is discarded.
+		result.syslogger = log.New(ioutil.Discard, "", log.Ldate | log.Ltime | log.Lshortfile)
+	}
+	result.syslogger.SetPrefix("MemoranGo ")
+	return &result
+}
+
+// Function changes verbosity of logger (see NewServerLogger). It's safe to call it while logger is used.
+func (l *ServerLogger) SetVerbosity(verbosity int) {
 	if verbosity >= 1 {
-		result.warning = log.New(os.Stdout, "Warning: ", log.Ldate | log.Ltime | log.Lshortfile)
+		l.warning.SetPrefix("Warning: ")
+		l.warning.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+		l.warning.SetOutput(os.Stdout)
 	} else {
-		result.warning = log.New(ioutil.Discard, "", 0)
+		l.warning.SetOutput(ioutil.Discard)
+		l.warning.SetPrefix("")
+		l.warning.SetFlags(0)
 	}
 	if verbosity == 2 {
-		result.info = log.New(os.Stdout, "Info: ", log.Ldate | log.Ltime)
+		l.info.SetPrefix("Info: ")
+		l.info.SetFlags(log.Ldate | log.Ltime)
+		l.info.SetOutput(os.Stdout)
 	} else {
-		result.info = log.New(ioutil.Discard, "", 0)
+		l.info.SetOutput(ioutil.Discard)
+		l.info.SetPrefix("")
+		l.info.SetFlags(0)
 	}
-	result.syslogger, _ = syslog.NewLogger(syslog.LOG_ERR, log.Ldate | log.Ltime | log.Lshortfile)
-	result.syslogger.SetPrefix("MemoranGo ")
-	return &result
 }
 
 // Display info-level
@@ -84,32 +117,34 @@ type Server struct {
 	tcp_socket net.Listener
 	connections map[string] net.Conn
 	storage *cache.LRUCache
+	cache *embedded.Cache // long-lived wrapper of storage, which notifies replicas about mutations
+	Primary *replication.Primary
+	Hub *pubsub.Hub // subscriptions of near-cache clients to events of storage
+	Watch *watch.Log // log of operations streamed by "watch" command
+	replica *replication.Replica
+	append_log *aof.Log
 	Stat *statistic.ServerStat
 	ThreadSync chan bool
 	threads int
 	Logger *ServerLogger
+	mutex sync.Mutex // guards connections, tcp_socket and threads
 }
 
-// Private method of server structure, which starts to listen tcp connection, cache it and delegate it to dispatcher.
-func (server *Server) runTCP() {
+// Private method of server structure, which accepts tcp connections from passed listener, caches them
+// and delegates them to dispatcher.
+func (server *Server) runTCP(listener net.Listener) {
 	defer server.free_chan()
-	port := server.tcp_port
 
 	rand.Seed(time.Now().Unix())
-	listener, err := net.Listen("tcp", ":" + port)
-	if err != nil {
-		server.Logger.Error("Couldn't establish listener:", err)
-		return
-	}
 	//var received_message []byte
-	server.tcp_socket = listener
 	for {
-		if server.tcp_socket == nil {
-			break
-		}
 		// Accept waits for incoming data and returns the next connection to the listener.
-		connection, err := server.tcp_socket.Accept()
+		connection, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				// listener was closed by stop()
+				break
+			}
 			server.Logger.Warning("Connection couldn't be accepted:", err)
 			continue
 		} else {
@@ -121,51 +156,76 @@ func (server *Server) runTCP() {
 					continue
 				}
 			}
+			server.mutex.Lock()
+			if server.tcp_socket == nil {
+				// server is being stopped
+				server.mutex.Unlock()
+				connection.Close()
+				break
+			}
 			if len(server.connections) >= server.connection_limit{
+				server.mutex.Unlock()
 				server.Logger.Error("Impossible connect to the server. There are too much active connections right now.")
 				connection.Close()
 				continue
 			}
 			addr := connection.RemoteAddr().String()
-			if server.connections[addr] == nil {
-				server.connections[addr] = connection
-				server.Stat.Connections[addr] = stat.NewConnStat(connection)
-				server.Stat.Current_connections ++
-			}
-			server.Stat.Total_connections ++
+			server.connections[addr] = connection
 			server.threads ++
-			go server.dispatch(connection.RemoteAddr().String())
+			server.mutex.Unlock()
+			server.Stat.AddConnection(connection)
+			atomic.AddUint32(&server.Stat.Total_connections, 1)
+			go server.dispatch(addr)
 		}
 	}
 }
 
 // Private method of server struct, which closes socket listener and stops serving.
+// Storage isn't flushed, since flush would be reported to watchers, subscribers and append-only log,
+// as if it was requested by client.
 func (server *Server) stop() {
+	server.mutex.Lock()
+	listener := server.tcp_socket
+	server.tcp_socket = nil
+	var connections = make(map[string] net.Conn, len(server.connections))
 	for address, connection := range server.connections {
+		connections[address] = connection
+	}
+	server.mutex.Unlock()
+	for address, connection := range connections {
 		if server.breakConnection(connection) {
 			server.Logger.Info("Close connection at", address)
 		} else {
 			server.Logger.Warning("Impossible to close connection at", address)
 		}
 	}
-	if server.tcp_socket != nil {
+	if listener != nil {
 //		for conn_type, socket := range server.tcp_socket {
 //			err := socket.Close()
 //			if err != nil {
 //				server.Logger.Error("Error occured during closing " + conn_type + " socket:", err)
 //			}
 //		}
-		err := server.tcp_socket.Close()
+		err := listener.Close()
 		if err != nil {
 			server.Logger.Error("Error occured during closing " + "tcp" + " socket:", err)
 		}
-		server.tcp_socket = nil
 	} else {
 		server.Logger.Error("Server can't be stoped, because socket is undefined.")
 	}
+	if server.replica != nil {
+		server.replica.Stop()
+	}
+	if server.append_log != nil {
+		if err := server.append_log.Close(); err != nil {
+			server.Logger.Error("Error occured during closing append-only log:", err)
+		}
+	}
 	server.Logger.Info("Waiting for ending process of goroutines...")
 	server.Wait()
-	server.storage.FlushAll()
+	if ext := server.storage.ExtStore(); ext != nil {
+		ext.Close()
+	}
 }
 
 // Private method of server, which dispatches active incoming connection.
@@ -177,83 +237,168 @@ func (server *Server) stop() {
 // Anyway, at the end connection will be broken up.
 func (server *Server) dispatch(address string) {
 	defer server.free_chan()
-	if server.Stat.Connections[address] != nil {
-		server.Stat.Connections[address].State = "conn_new_cmd"
-	}
+	server.Stat.SetConnectionState(address, "conn_new_cmd", false)
+	server.mutex.Lock()
 	connection := server.connections[address]
+	server.mutex.Unlock()
+	// malformed request mustn't stop the whole server, so only its connection is closed.
+	defer func() {
+		if err := recover(); err != nil {
+			server.Logger.Error("Panic during serving of connection", address, ":", err, "\n" + string(debug.Stack()))
+			server.breakConnection(connection)
+		}
+	}()
 	connectionReader := bufio.NewReader(connection)
 	// let's loop the process for open connection, until it will get closed.
 	for {
 		// let's read a header first
-		if server.Stat.Connections[address] != nil {
-			server.Stat.Connections[address].State = "conn_read"
-		}
+		server.Stat.SetConnectionState(address, "conn_read", false)
 		received_message, n, err := readRequest(connectionReader, -1)
 		if err != nil {
-			if server.Stat.Connections[address] != nil {
-				server.Stat.Connections[address].State = "conn_swallow"
-			}
+			server.Stat.SetConnectionState(address, "conn_swallow", false)
 			if err == io.EOF {
 				server.Logger.Info("Input stream has got EOF, and now is being closed.")
 				server.breakConnection(connection)
 				break
 			}
 			server.Logger.Warning("Dispatching error: ", err, " Message: ", received_message)
-			if !server.makeResponse(connection, []byte("ERROR\r\n"), 5){
+			var response = protocol.ERROR_TEMP
+			if err == errKeyLength {
+				// rest of line is skipped, so it isn't taken for the next request.
+				if _, err = connectionReader.ReadString('\n'); err != nil {
+					server.breakConnection(connection)
+					break
+				}
+				response = protocol.BAD_FORMAT
+			}
+			if !server.makeResponse(connection, []byte(response), len(response)){
 				break
 			}
 		} else {
-			if server.Stat.Connections[address] != nil {
-				server.Stat.Connections[address].Cmd_hit_ts = time.Now().Unix()
-			}
+			server.Stat.SetConnectionState(address, "conn_parse_cmd", true)
 			// Here the message should be handled
-			server.Stat.Read_bytes += uint64(n)
+			atomic.AddUint64(&server.Stat.Read_bytes, uint64(n))
 			parsed_request := protocol.ParseProtocolHeader(string(received_message[ : n - 2]))
 			server.Logger.Info("Header: ", *parsed_request)
 
 			if (parsed_request.Command() == "cas" || parsed_request.Command() == "gets") && server.cas_disabled ||
-			   parsed_request.Command() == "flush_all" && server.flush_disabled{
+			   (parsed_request.Command() == "flush_all" || parsed_request.Command() == "ns_flush") && server.flush_disabled{
 				err_msg := parsed_request.Command() + " command is forbidden."
 				server.Logger.Warning(err_msg)
-				if server.Stat.Connections[address] != nil {
-					server.Stat.Connections[address].State = "conn_write"
-				}
+				server.Stat.SetConnectionState(address, "conn_write", false)
 				err_msg = strings.Replace(protocol.CLIENT_ERROR_TEMP, "%s", err_msg, 1)
 				server.makeResponse(connection, []byte(err_msg), len(err_msg))
 				continue
 			}
 
-			if parsed_request.DataLen() > 0 {
-				if server.Stat.Connections[address] != nil {
-					server.Stat.Connections[address].State = "conn_nread"
+			if parsed_request.HasData() {
+				server.Stat.SetConnectionState(address, "conn_nread", false)
+				chunks, err := readData(connectionReader, parsed_request.DataLen())
+				if err == errTerminator {
+					server.Logger.Warning("Data block isn't terminated:", parsed_request.Command())
+					if parsed_request.Reply() {
+						server.makeResponse(connection, []byte(protocol.BAD_DATA_CHUNK), len(protocol.BAD_DATA_CHUNK))
+					}
+					continue
 				}
-				received_message, _, err := readRequest(connectionReader, parsed_request.DataLen())
 				if err != nil {
 					server.Logger.Error("Error occurred while reading data:", err)
 					server.breakConnection(connection)
 					break
 				}
-				parsed_request.SetData(received_message[0 : ])
+				parsed_request.SetChunks(chunks)
+			}
+			if parsed_request.Command() == replication.REPLICATE {
+				server.Logger.Info("Replica is connected:", address)
+				server.Stat.SetConnectionState(address, "conn_replicate", false)
+				stream_start := time.Now()
+				err := server.Primary.Serve(connection, connectionReader)
+				server.Logger.Warning("Replica is disconnected:", address, err)
+				server.Stat.Latency.Record(parsed_request.Command(), time.Since(stream_start), n, 0)
+				server.breakConnection(connection)
+				break
+			}
+			if parsed_request.Command() == pubsub.SUBSCRIBE {
+				filter, err := pubsub.ParseFilter(parsed_request.Keys())
+				if err != nil {
+					err_msg := strings.Replace(protocol.CLIENT_ERROR_TEMP, "%s", err.Error(), 1)
+					server.makeResponse(connection, []byte(err_msg), len(err_msg))
+					continue
+				}
+				server.Logger.Info("Subscriber is connected:", address)
+				server.Stat.SetConnectionState(address, "conn_subscribe", false)
+				stream_start := time.Now()
+				err = server.Hub.Serve(connection, connectionReader, filter)
+				server.Logger.Info("Subscriber is disconnected:", address, err)
+				server.Stat.Latency.Record(parsed_request.Command(), time.Since(stream_start), n, 0)
+				server.breakConnection(connection)
+				break
+			}
+			if parsed_request.Command() == watch.WATCH {
+				flags, err := watch.ParseFlags(parsed_request.Keys())
+				if err != nil {
+					err_msg := strings.Replace(protocol.CLIENT_ERROR_TEMP, "%s", err.Error(), 1)
+					server.makeResponse(connection, []byte(err_msg), len(err_msg))
+					continue
+				}
+				server.Logger.Info("Watcher is connected:", address)
+				server.Stat.SetConnectionState(address, "conn_watch", false)
+				stream_start := time.Now()
+				err = server.Watch.Serve(connection, connectionReader, flags)
+				server.Logger.Info("Watcher is disconnected:", address, err)
+				server.Stat.Latency.Record(parsed_request.Command(), time.Since(stream_start), n, 0)
+				server.breakConnection(connection)
+				break
+			}
+			if parsed_request.Metadump() {
+				// metadata of items is written by batches, so storage isn't locked for the whole dump.
+				server.Stat.SetConnectionState(address, "conn_write", false)
+				handling_start := time.Now()
+				written, err := protocol.WriteMetadump(connection, server.cache)
+				atomic.AddUint64(&server.Stat.Written_bytes, uint64(written))
+				server.Stat.Latency.Record(parsed_request.Command(), time.Since(handling_start), n, written)
+				if err != nil {
+					server.Logger.Warning("Error occurred during writing data to output stream:", err)
+					server.breakConnection(connection)
+					break
+				}
+				continue
 			}
 			server.Logger.Info("Start handling request:", *parsed_request)
-			response_message, err := parsed_request.HandleRequest(server.storage, server.Stat)
-			server.Logger.Info("Server is sending response:\n", string(response_message[0 : len(response_message)]))
+			handling_start := time.Now()
+			response_message, err := parsed_request.HandleBuffers(server.cache, server.Stat)
+			var response_length = 0
+			for _, buffer := range response_message {
+				response_length += len(buffer)
+			}
+			server.Logger.Info("Server is sending response of", response_length, "bytes.")
+			// response is recorded before writing, since writing consumes buffers.
+			if kind := watch.Kind(parsed_request.Command()); server.Watch.Active(kind) {
+				server.watchRequest(parsed_request, kind, response_message, response_length, address)
+			}
 			// if there is no flag "noreply" in the header:
 			if parsed_request.Reply() {
-				if server.Stat.Connections[address] != nil {
-					server.Stat.Connections[address].State = "conn_write"
-				}
-				server.makeResponse(connection, response_message, len(response_message))
+				server.Stat.SetConnectionState(address, "conn_write", false)
+				server.makeBuffersResponse(connection, response_message)
 			}
+			handling_duration := time.Since(handling_start)
+			server.Stat.SlowLog.Record(parsed_request.Command(), len(parsed_request.Keys()), parsed_request.DataLen(),
+									   address, handling_duration)
+			read_bytes, written_bytes := n, 0
+			if parsed_request.HasData() {
+				read_bytes += parsed_request.DataLen() + 2
+			}
+			if parsed_request.Reply() {
+				written_bytes = response_length
+			}
+			server.Stat.Latency.Record(parsed_request.Command(), handling_duration, read_bytes, written_bytes)
 			if err != nil {
 				server.Logger.Error("Impossible to send response:", err)
 				server.breakConnection(connection)
 				break
 			}
 		}
-		if server.Stat.Connections[address] != nil {
-			server.Stat.Connections[address].State = "conn_waiting"
-		}
+		server.Stat.SetConnectionState(address, "conn_waiting", false)
 	}
 }
 
@@ -283,14 +428,15 @@ func readRequest(reader *bufio.Reader, length int) ([]byte, int, error){
 				return buffer[ : len(buffer) - 2], counter, nil
 			} else {
 				if length != -1 {
-					return buffer, counter, errors.New("Length was achieved, but terminator wasn't met.")
+					return buffer, counter, errTerminator
 				}
 			}
 		}
-		if read != ' ' && length == -1 /* in case of header of unknown length */{
+		// terminator isn't a part of the last token, so key of maximal length may end the request.
+		if read != ' ' && read != '\r' && read != '\n' && length == -1 /* in case of header of unknown length */{
 			token_counter ++
 			if token_counter > MAX_KEY_LENGTH {
-				return buffer, counter, errors.New("Maximal key length is exceeded.")
+				return buffer, counter, errKeyLength
 			}
 		} else {
 			token_counter = 0
@@ -299,17 +445,52 @@ func readRequest(reader *bufio.Reader, length int) ([]byte, int, error){
 	}
 }
 
+// Function reads data block of passed length followed by \r\n terminator into chain of chunks
+// of tools.CHUNK_SIZE bytes, so large value isn't read into single allocation.
+func readData(reader *bufio.Reader, length int) ([][]byte, error) {
+	var chunks = make([][]byte, 0, length / tools.CHUNK_SIZE + 1)
+	for length > 0 {
+		size := length
+		if size > tools.CHUNK_SIZE {
+			size = tools.CHUNK_SIZE
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, chunk)
+		length -= size
+	}
+	terminator := make([]byte, 2)
+	if _, err := io.ReadFull(reader, terminator); err != nil {
+		return chunks, err
+	}
+	if string(terminator) != "\r\n" {
+		return chunks, errTerminator
+	}
+	return chunks, nil
+}
+
 // Function discards a channel and decrease counter of active channels.
+// Notification is dropped if nobody awaits it and buffer of channel is full, since Wait rechecks the counter anyway.
 func (server *Server) free_chan(){
+	server.mutex.Lock()
 	server.threads --
-	server.ThreadSync <- true
+	server.mutex.Unlock()
+	select {
+	case server.ThreadSync <- true:
+	default:
+	}
 }
 
 // Function awaits of freeing all busy channels.
 func (server *Server) Wait(){
 	for{
-		if server.threads > 0 {
-			server.Logger.Info(server.threads, "active channels at the moment. Waiting for busy goroutine.")
+		server.mutex.Lock()
+		threads := server.threads
+		server.mutex.Unlock()
+		if threads > 0 {
+			server.Logger.Info(threads, "active channels at the moment. Waiting for busy goroutine.")
 			<-server.ThreadSync
 		} else {
 			break
@@ -321,26 +502,23 @@ func (server *Server) Wait(){
 func (server *Server) breakConnection(connection net.Conn) bool {
 	if connection == nil { return false }
 	address := connection.RemoteAddr().String()
-	if server.Stat.Connections[address] != nil {
-		server.Stat.Connections[address].State = "conn_closing"
-		defer delete(server.Stat.Connections, address)
-	}
+	server.Stat.SetConnectionState(address, "conn_closing", false)
+	defer server.Stat.RemoveConnection(address)
 
-	if server.tcp_socket == nil{
-		return false
-	}
-	delete(server.connections, connection.RemoteAddr().String())
+	server.mutex.Lock()
+	delete(server.connections, address)
+	server.mutex.Unlock()
 	err := connection.Close()
 	if err != nil {
 		server.Logger.Warning("Impossible to break connection:", err)
 		return false
 	}
-	server.Stat.Current_connections --
 	return true
 }
 
 // Private method writes a byte-string to connection output stream.
 // Function receives connection, message and length of this message.
+// Returns false if response wasn't written, connection is broken up in this case.
 func (server *Server) makeResponse(connection net.Conn, response_message []byte, length int) bool {
 	if connection == nil {
 		return false
@@ -348,9 +526,26 @@ func (server *Server) makeResponse(connection net.Conn, response_message []byte,
 	length, err := connection.Write(response_message[0 : length])
 	if err != nil {
 		server.Logger.Warning("Error occurred during writing data to output stream:", err)
-		return server.breakConnection(connection)
+		server.breakConnection(connection)
+		return false
+	}
+	atomic.AddUint64(&server.Stat.Written_bytes, uint64(length))
+	return true
+}
+
+// Function does the same as makeResponse, but writes sequence of buffers (e.g. chunks of large values)
+// without joining them.
+func (server *Server) makeBuffersResponse(connection net.Conn, buffers net.Buffers) bool {
+	if connection == nil {
+		return false
+	}
+	length, err := buffers.WriteTo(connection)
+	if err != nil {
+		server.Logger.Warning("Error occurred during writing data to output stream:", err)
+		server.breakConnection(connection)
+		return false
 	}
-	server.Stat.Written_bytes += uint64(length)
+	atomic.AddUint64(&server.Stat.Written_bytes, uint64(length))
 	return true
 }
 
@@ -375,27 +570,224 @@ func NewServer(tcp_port string, udp_port string, address string, max_connections
 	server.connection_limit = max_connections
 	server.listen_address = address
 	server.storage = cache.New(bytes_of_memory)
+	server.cache = embedded.Wrap(server.storage)
+	server.Primary = replication.NewPrimary(server.cache)
 	server.connections = make(map[string] net.Conn)
 	server.Stat = statistic.New(bytes_of_memory, tcp_port, udp_port, max_connections, verbosity, cas, flush)
+	server.Stat.Replication = server.replicationStats
+	server.Hub = pubsub.NewHub()
+	server.storage.AddListener(server.Hub)
+	server.Stat.PubSub = server.Hub.Stats
+	server.Watch = watch.NewLog()
+	server.storage.AddListener(server.Watch)
 	server.Logger = NewServerLogger(verbosity)
 	return server
 }
 
-// Public function runs loops with all available protocols
-func (server *Server) RunServer() {
+// Public method of server, which replaces slow log by new one with passed threshold and maximal number of entries.
+// Requests, which took more time than threshold, will be recorded and become available via "stats slowlog".
+func (server *Server) SetSlowLog(threshold time.Duration, length int) {
+	server.Stat.SlowLog = statistic.NewSlowLog(threshold, length)
+}
+
+// Public method of server, which sets delimiter between key prefix and the rest of key for "stats detail"
+// and namespaces ("ns_flush"). Previously collected per-prefix statistic is discarded; empty delimiter means
+// the default one (":") for both of them.
+func (server *Server) SetPrefixDelimiter(delimiter string) {
+	server.Stat.Detail = statistic.NewDetailStat(delimiter)
+	server.cache.Locked(func(storage *cache.LRUCache) {
+		storage.SetNamespaceDelimiter(server.Stat.Detail.Delimiter())
+	})
+}
+
+// Public method of server, which turns it into replica of primary with passed address ("host:port").
+// Replication starts along with server.
+func (server *Server) SetReplicaOf(primary string) {
+	server.replica = replication.NewReplica(primary, server.cache)
+}
+
+// Public method of server, which opens append-only log at passed path with passed fsync policy
+// ("always", "everysec" or "no"), restores storage from it and logs further mutations of storage.
+// It should be called before RunServer.
+func (server *Server) SetAppendLog(path string, policy string) error {
+	append_log, err := aof.Open(path, policy, server.cache)
+	if err != nil {
+		return err
+	}
+	server.append_log = append_log
+	server.Stat.AppendLog = server.append_log.Stats
+	return nil
+}
+
+// Public method of server, which attaches extstore in file at passed path with passed total size and page size
+// (in bytes): values not smaller than item_size bytes are moved there instead of eviction, and page is compacted
+// instead of eviction when share of its live data is below compact_under.
+// It should be called before RunServer.
+func (server *Server) SetExtStore(path string, size int64, page_size int64, item_size int, compact_under float64) error {
+	ext, err := cache.NewExtStore(path, size, page_size)
+	if err != nil {
+		return err
+	}
+	ext.MinItemSize = item_size
+	ext.CompactUnder = compact_under
+	server.cache.Locked(func(storage *cache.LRUCache) {
+		storage.SetExtStore(ext)
+	})
+	return nil
+}
+
+// Public method of server, which turns on compression of values not smaller than passed threshold (in bytes)
+// with passed level of flate; zero threshold turns it off. Returns error if level is invalid.
+func (server *Server) SetCompression(threshold int, level int) error {
+	var compression *cache.Compression
+	if threshold > 0 {
+		var err error
+		if compression, err = cache.NewCompression(threshold, level); err != nil {
+			return err
+		}
+	}
+	server.cache.Locked(func(storage *cache.LRUCache) {
+		storage.SetCompression(compression)
+	})
+	return nil
+}
+
+// Public method of server, which sets timeout of leases of "lget" and grace period of items, during which they're
+// considered stale, so "lget" grants lease to recompute them.
+func (server *Server) SetLeases(timeout time.Duration, grace time.Duration) {
+	server.cache.Locked(func(storage *cache.LRUCache) {
+		storage.Leases().Timeout = int64(timeout / time.Second)
+		storage.Leases().Grace = int64(grace / time.Second)
+	})
+}
+
+// Public method of server, which changes settings, which may be applied while server is running:
+// verbosity, limit of simultaneous connections and memory limit (bytes); least recently used items are evicted,
+// if they don't fit into new limit. Returns error if memory limit is invalid.
+func (server *Server) Reconfigure(verbosity int, max_connections int, bytes_of_memory int64) error {
+	var resized bool
+	server.cache.Locked(func(storage *cache.LRUCache) {
+		resized = storage.Resize(bytes_of_memory)
+	})
+	if !resized {
+		return errors.New("Invalid amount of memory.")
+	}
+	server.Logger.SetVerbosity(verbosity)
+	server.mutex.Lock()
+	server.connection_limit = max_connections
+	server.mutex.Unlock()
+	server.Stat.Reconfigure(verbosity, max_connections, bytes_of_memory)
+	return nil
+}
+
+// Public method of server, which sets parameters of LRU crawler: sleep between its passes (microseconds)
+// and amount of items checked per pass; crawler is started or stopped according to passed param enabled.
+func (server *Server) SetCrawler(enabled bool, sleep int, items_per_run uint) error {
+	var err error
+	server.cache.Locked(func(storage *cache.LRUCache) {
+		if err = storage.Crawler.SetSleep(sleep); err != nil {
+			return
+		}
+		storage.Crawler.ItemsPerRun = items_per_run
+		if !enabled {
+			storage.DisableCrawler()
+		} else if !storage.Crawler.Enabled() {
+			err = storage.EnableCrawler()
+		}
+	})
+	return err
+}
+
+// Public method of server, which turns on or off histogram of item sizes ("stats sizes").
+func (server *Server) SetTrackSizes(enabled bool) {
+	server.cache.Locked(func(storage *cache.LRUCache) {
+		if enabled {
+			storage.EnableSizes()
+		} else {
+			storage.DisableSizes()
+		}
+	})
+}
+
+// Public method of server, which sets effective configuration shown by "stats settings".
+func (server *Server) SetConfig(config map[string] string) {
+	server.Stat.SetConfig(config)
+}
+
+// Private method of server, which records handled request to log of "watch" command.
+// Size of data is recorded for mutations and size of response - for fetchers.
+func (server *Server) watchRequest(request *protocol.Ascii_protocol_enum, kind int, response net.Buffers,
+								   response_length int, address string) {
+	var key, status string
+	if keys := request.Keys(); len(keys) > 0 {
+		key = keys[0]
+	}
+	if len(response) > 0 {
+		status = string(response[0])
+		if end := strings.Index(status, "\r\n"); end >= 0 {
+			status = status[ : end]
+		}
+	}
+	size := request.DataLen()
+	if kind == watch.FETCHERS {
+		size = response_length
+	}
+	server.Watch.Command(request.Command(), key, status, size, address)
+}
+
+// Private method of server, which returns lines of "stats replication": role of server,
+// statistic of connected replicas and, for replica, statistic of link to primary.
+func (server *Server) replicationStats() []string {
+	var result []string
+	if server.replica != nil {
+		result = append(result, "role replica")
+		result = append(result, server.replica.Stats()...)
+	} else {
+		result = append(result, "role primary")
+	}
+	return append(result, server.Primary.Stats()...)
+}
+
+// Public function runs loops with all available protocols.
+// Listeners are established before return, so returned error means that server couldn't be run.
+func (server *Server) RunServer() error {
 //	server.sockets = make(map[string] net.Listener)
+	listener, err := net.Listen("tcp", ":" + server.tcp_port)
+	if err != nil {
+		server.Logger.Error("Couldn't establish listener:", err)
+		return err
+	}
 	var additional_threads = 1 // for tcp
 //	if len(server.udp_port) > 0 {
 //		additional_threads ++
 //	}
 	server.ThreadSync = make(chan bool, server.connection_limit + additional_threads)
+	server.mutex.Lock()
+	server.tcp_socket = listener
 	server.threads += additional_threads
-	go server.runTCP()
+	server.mutex.Unlock()
+	go server.runTCP(listener)
+	if server.replica != nil {
+		server.replica.Start()
+	}
 //TODO: UDP support and unix sockets
 //	if len(server.udp_port) > 0 {
 //		server.threads ++
 //		go server.run("udp")
 //	}
+	return nil
+}
+
+// Public method of server, which checks that server accepts connections and its storage isn't blocked
+// (call doesn't return until lock of storage is acquired).
+func (server *Server) Healthy() bool {
+	server.mutex.Lock()
+	listening := server.tcp_socket != nil
+	server.mutex.Unlock()
+	if listening {
+		server.cache.Locked(func(storage *cache.LRUCache) {})
+	}
+	return listening
 }
 
 // Public function receives the pointer to server structure, stops the server and inform about it.
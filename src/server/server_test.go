@@ -9,7 +9,13 @@ import (
 	"os"
 	"bufio"
 	"log"
+	"io"
+	"strconv"
+	"strings"
+	"tools"
 	"tools/protocol"
+	"tools/cache"
+	"io/ioutil"
 )
 
 var test_port = "60000"
@@ -63,14 +69,15 @@ func TestServerConsistenceAndConnections(t *testing.T){
 	if err != nil {
 		t.Fatalf("Server wasn't run: %s", err)
 	}
-	if srv.tcp_port != test_port || srv.sockets == nil || len(srv.sockets) != 1 || srv.storage == nil {
-		t.Fatalf("Unexpected consistence: %s, %s, %s", srv.tcp_port, srv.sockets, srv.storage)
+	if srv.tcp_port != test_port || srv.tcp_socket == nil || srv.storage == nil {
+		t.Fatalf("Unexpected consistence: %s, %s, %s", srv.tcp_port, srv.tcp_socket, srv.storage)
 	}
 	var test_msg = []byte("Test1\r\n")
 	_, err = connection.Write(test_msg)
 	if err != nil {
 		t.Fatalf("Stream is unavailable to transmit data: ", err)
 	}
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while connection will be accepted
 	remote_connection := srv.connections[connection.LocalAddr().String()]
 	if len(srv.connections) != 1 || remote_connection == nil {
 		t.Fatalf("Connection wasn't cached: ", len(srv.connections))
@@ -97,6 +104,10 @@ func TestServerResponseAndConnections(t *testing.T){
 	if err != nil {
 		t.Fatalf("Stream is unavailable to transmit data: ", err)
 	}
+	var error_response = make([]byte, len(protocol.ERROR_TEMP)) // response to the test message
+	if _, err = io.ReadFull(connection, error_response); err != nil || string(error_response) != protocol.ERROR_TEMP {
+		t.Fatalf("Unexpected response to the test message: %v %q", err, error_response)
+	}
 	remote_connection := srv.connections[connection.LocalAddr().String()]
 	if !srv.makeResponse(remote_connection, []byte("TestResponse"), 12){
 		t.Fatalf("Server is unavailable to make response.")
@@ -381,3 +392,346 @@ func TestServerWaitSuite(t *testing.T) {
 		t.Fatalf("Unexpected waiting behaviour: wait had to finish immidiatelly: %d.", end-start)
 	}
 }
+
+func TestServerReplication(t *testing.T) {
+	fmt.Println("TestServerReplication")
+	primary := NewServer("60005", "", "", 1024, false, false, 0, 1024)
+	primary.RunServer()
+	defer primary.StopServer()
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while goroutines will start
+	connection, err := net.Dial("tcp", "127.0.0.1:60005")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer connection.Close()
+	reader := bufio.NewReader(connection)
+	connection.Write([]byte("set snapshot 0 0 4\r\nTEST\r\n"))
+	if line, _ := reader.ReadString('\n'); line != protocol.STORED {
+		t.Fatalf("Unexpected response: %q", line)
+	}
+
+	replica := NewServer("60006", "", "", 1024, false, false, 0, 1024)
+	replica.SetReplicaOf("127.0.0.1:60005")
+	replica.RunServer()
+	defer replica.StopServer()
+	connection.Write([]byte("set key 0 0 4\r\nTEST\r\nincr counter 1\r\nset counter 0 0 1\r\n5\r\nincr counter 1\r\n"))
+	for _, expected := range []string{protocol.STORED, protocol.NOT_FOUND, protocol.STORED, "6\r\n"} {
+		if line, _ := reader.ReadString('\n'); line != expected {
+			t.Fatalf("Unexpected response: %q, expected %q", line, expected)
+		}
+	}
+	replica_connection, err := net.Dial("tcp", "127.0.0.1:60006")
+	if err != nil {
+		t.Fatalf("Replica wasn't run: %s", err)
+	}
+	defer replica_connection.Close()
+	replica_reader := bufio.NewReader(replica_connection)
+	var response string
+	for i := 0; i < 100; i ++ {
+		replica_connection.Write([]byte("get snapshot key counter\r\n"))
+		response = ""
+		for !strings.HasSuffix(response, protocol.END) {
+			line, err := replica_reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			response += line
+		}
+		if response == "VALUE snapshot 0 4\r\nTEST\r\nVALUE key 0 4\r\nTEST\r\nVALUE counter 0 1\r\n6\r\nEND\r\n" {
+			break
+		}
+		time.Sleep(time.Millisecond * time.Duration(10))
+	}
+	if response != "VALUE snapshot 0 4\r\nTEST\r\nVALUE key 0 4\r\nTEST\r\nVALUE counter 0 1\r\n6\r\nEND\r\n" {
+		t.Fatalf("Data wasn't replicated: %q", response)
+	}
+	replica_connection.Write([]byte("stats replication\r\n"))
+	response = ""
+	for !strings.HasSuffix(response, protocol.END) {
+		line, _ := replica_reader.ReadString('\n')
+		response += line
+	}
+	if !strings.Contains(response, "STAT role replica\r\n") || !strings.Contains(response, "STAT primary_link_status up\r\n") {
+		t.Fatalf("Unexpected statistic of replication: %q", response)
+	}
+	if lines := primary.Stat.Replication(); len(lines) < 2 || lines[1] != "connected_replicas 1" {
+		t.Fatalf("Unexpected statistic of primary: %v", lines)
+	}
+}
+
+func TestServerAppendLog(t *testing.T) {
+	fmt.Println("TestServerAppendLog")
+	path := os.TempDir() + "/memorango_test.aof"
+	os.Remove(path)
+	defer os.Remove(path)
+	srv := NewServer("60007", "", "", 1024, false, false, 0, 1024)
+	if err := srv.SetAppendLog(path, "always"); err != nil {
+		t.Fatalf("Append-only log wasn't opened: %s", err)
+	}
+	srv.RunServer()
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while goroutines will start
+	connection, err := net.Dial("tcp", "127.0.0.1:60007")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	reader := bufio.NewReader(connection)
+	connection.Write([]byte("set key 0 3600 4\r\nTEST\r\nset deleted 0 0 4\r\nTEST\r\ndelete deleted\r\n"))
+	for _, expected := range []string{protocol.STORED, protocol.STORED, protocol.DELETED} {
+		if line, _ := reader.ReadString('\n'); line != expected {
+			t.Fatalf("Unexpected response: %q, expected %q", line, expected)
+		}
+	}
+	connection.Close()
+	srv.StopServer()
+
+	srv = NewServer("60007", "", "", 1024, false, false, 0, 1024)
+	if err := srv.SetAppendLog(path, "no"); err != nil {
+		t.Fatalf("Append-only log wasn't replayed: %s", err)
+	}
+	srv.RunServer()
+	defer srv.StopServer()
+	time.Sleep(time.Millisecond * time.Duration(10))
+	connection, err = net.Dial("tcp", "127.0.0.1:60007")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer connection.Close()
+	reader = bufio.NewReader(connection)
+	connection.Write([]byte("get key deleted\r\nstats aof\r\n"))
+	var response string
+	for strings.Count(response, protocol.END) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		response += line
+	}
+	if !strings.HasPrefix(response, "VALUE key 0 4\r\nTEST\r\nEND\r\n") || !strings.Contains(response, "STAT aof_fsync no\r\n") {
+		t.Fatalf("Unexpected response: %q", response)
+	}
+}
+
+func TestServerLargeValues(t *testing.T) {
+	fmt.Println("TestServerLargeValues")
+	srv := NewServer("60008", "", "", 1024, false, false, 0, 64 << 20)
+	srv.RunServer()
+	defer srv.StopServer()
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while goroutines will start
+	connection, err := net.Dial("tcp", "127.0.0.1:60008")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer connection.Close()
+	reader := bufio.NewReader(connection)
+	value := bytes.Repeat([]byte("0123456789"), 150000)
+	connection.Write([]byte("set key 0 0 " + strconv.Itoa(len(value)) + "\r\n"))
+	connection.Write(append(value, "\r\n"...))
+	connection.Write([]byte("append key 0 0 4\r\ntail\r\nget key\r\n"))
+	for _, expected := range []string{protocol.STORED, protocol.STORED, "VALUE key 0 " + strconv.Itoa(len(value) + 4) + "\r\n"} {
+		if line, _ := reader.ReadString('\n'); line != expected {
+			t.Fatalf("Unexpected response: %q, expected %q", line, expected)
+		}
+	}
+	data := make([]byte, len(value) + 4 + 2)
+	if _, err := io.ReadFull(reader, data); err != nil || !bytes.Equal(data, append(value, "tail\r\n"...)) {
+		t.Fatalf("Unexpected value: %s", err)
+	}
+	if line, _ := reader.ReadString('\n'); line != protocol.END {
+		t.Fatalf("Unexpected end of response: %q", line)
+	}
+}
+
+func TestServerSubscribe(t *testing.T) {
+	fmt.Println("TestServerSubscribe")
+	srv := NewServer("60009", "", "", 1024, false, false, 0, 64 << 20)
+	srv.RunServer()
+	defer srv.StopServer()
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while goroutines will start
+	subscriber, err := net.Dial("tcp", "127.0.0.1:60009")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer subscriber.Close()
+	events := bufio.NewReader(subscriber)
+	subscriber.Write([]byte("subscribe events=unknown\r\nsubscribe prefix=user: events=set,delete,flush\r\n"))
+	for _, expected := range []string{"CLIENT_ERROR", "OK"} {
+		if line, _ := events.ReadString('\n'); !strings.HasPrefix(line, expected) {
+			t.Fatalf("Unexpected response: %q, expected %q", line, expected)
+		}
+	}
+	client, err := net.Dial("tcp", "127.0.0.1:60009")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer client.Close()
+	responses := bufio.NewReader(client)
+	client.Write([]byte("set user:1 0 0 1\r\n1\r\nset other 0 0 1\r\n1\r\ndelete user:1\r\nflush_all\r\n"))
+	for _, expected := range []string{protocol.STORED, protocol.STORED, protocol.DELETED, protocol.OK} {
+		if line, _ := responses.ReadString('\n'); line != expected {
+			t.Fatalf("Unexpected response: %q, expected %q", line, expected)
+		}
+	}
+	for _, expected := range []string{"EVENT set user:1\r\n", "EVENT delete user:1\r\n", "EVENT flush\r\n"} {
+		if line, _ := events.ReadString('\n'); line != expected {
+			t.Fatalf("Unexpected event: %q, expected %q", line, expected)
+		}
+	}
+}
+
+func TestServerWatch(t *testing.T) {
+	fmt.Println("TestServerWatch")
+	srv := NewServer("60010", "", "", 1024, false, false, 0, 64 << 20)
+	srv.RunServer()
+	defer srv.StopServer()
+	time.Sleep(time.Millisecond * time.Duration(10)) // Let's wait a bit while goroutines will start
+	watcher, err := net.Dial("tcp", "127.0.0.1:60010")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer watcher.Close()
+	lines := bufio.NewReader(watcher)
+	watcher.Write([]byte("watch unknown\r\nwatch fetchers mutations\r\n"))
+	for _, expected := range []string{"CLIENT_ERROR", "OK"} {
+		if line, _ := lines.ReadString('\n'); !strings.HasPrefix(line, expected) {
+			t.Fatalf("Unexpected response: %q, expected %q", line, expected)
+		}
+	}
+	client, err := net.Dial("tcp", "127.0.0.1:60010")
+	if err != nil {
+		t.Fatalf("Server wasn't run: %s", err)
+	}
+	defer client.Close()
+	responses := bufio.NewReader(client)
+	client.Write([]byte("set key 0 0 5\r\nvalue\r\nget key missed\r\nversion\r\n"))
+	for i := 0; i < 4; i ++ {
+		responses.ReadString('\n')
+	}
+	address := client.LocalAddr().String()
+	for _, expected := range []string{" type=item_store cmd=set key=key status=STORED size=5 client=" + address + "\r\n",
+									  " type=item_get cmd=get key=key status=found size=27 client=" + address + "\r\n"} {
+		if line, _ := lines.ReadString('\n'); !strings.HasSuffix(line, expected) {
+			t.Fatalf("Unexpected line: %q, expected %q", line, expected)
+		}
+	}
+}
+
+func TestServerReconfigure(t *testing.T) {
+	srv := NewServer("60011", "", "", 1024, false, false, 0, 1024)
+	srv.SetConfig(map[string] string{"memory": "1", "maxconns": "1024"})
+	if srv.Reconfigure(2, 10, 0) == nil {
+		t.Fatalf("Invalid amount of memory was accepted.")
+	}
+	if err := srv.Reconfigure(2, 10, 2048); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := srv.SetCrawler(true, 100, 10); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer srv.SetCrawler(false, 100, 10)
+	var settings map[string] string
+	srv.cache.Locked(func(storage *cache.LRUCache) {
+		settings = srv.Stat.Settings(storage)
+	})
+	if settings["maxbytes"] != "2048" || settings["maxconns"] != "10" || settings["verbosity"] != "2" ||
+	   settings["memory"] != "1" || settings["lru_crawler"] != "true" || settings["lru_crawler_tocrawl"] != "10" {
+		t.Fatalf("Unexpected settings: %v", settings)
+	}
+	if srv.connection_limit != 10 || srv.Logger.info.Flags() != log.Ldate | log.Ltime {
+		t.Fatalf("Settings weren't applied.")
+	}
+}
+
+func TestServerPrefixDelimiter(t *testing.T) {
+	srv := NewServer("60011", "", "", 1024, false, false, 0, 1024)
+	srv.SetPrefixDelimiter("")
+	var stats []string
+	srv.cache.Locked(func(storage *cache.LRUCache) {
+		storage.Set(tools.NewStoredData([]byte("1"), "user:1"), 0, 0, 0)
+		stats = storage.NamespacesStats()
+	})
+	if srv.Stat.Detail.Delimiter() != ":" || len(stats) == 0 || stats[0] != "ns:user:items 1" {
+		t.Fatalf("Empty delimiter doesn't mean the default one: %q, %v", srv.Stat.Detail.Delimiter(), stats)
+	}
+}
+
+// Connection, which panics on reading.
+type panicConnection struct {
+	net.Conn
+}
+
+func (c panicConnection) Read(data []byte) (int, error) {
+	panic("malformed request")
+}
+
+// Function dispatches passed connection by server as accepted one; address of connection should be unique.
+// If server isn't run, so channel awaited by Wait is created here.
+func testDispatch(server *Server, connection net.Conn) {
+	server.mutex.Lock()
+	if server.ThreadSync == nil {
+		server.ThreadSync = make(chan bool, 1)
+	}
+	server.connections[connection.RemoteAddr().String()] = connection
+	server.threads ++
+	server.mutex.Unlock()
+	server.Stat.AddConnection(connection)
+	go server.dispatch(connection.RemoteAddr().String())
+}
+
+func TestServerPanicRecovery(t *testing.T) {
+	srv := NewServer("60018", "", "", 1024, false, false, 0, 1024)
+	var errors bytes.Buffer
+	srv.Logger.error.SetOutput(&errors)
+	client, connection := net.Pipe()
+	testDispatch(srv, panicConnection{connection})
+	srv.Wait()
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Connection wasn't closed: %v", err)
+	}
+	if len(srv.connections) != 0 || srv.Stat.Current_connections != 0 ||
+	   !strings.Contains(errors.String(), "Panic during serving of connection pipe : malformed request") {
+		t.Fatalf("Panic wasn't handled: %v, %q", srv.connections, errors.String())
+	}
+	// server continues to serve other connections.
+	client, connection = net.Pipe()
+	defer client.Close()
+	testDispatch(srv, connection)
+	client.Write([]byte("version\r\n"))
+	if line, err := bufio.NewReader(client).ReadString('\n'); err != nil || !strings.HasPrefix(line, "VERSION ") {
+		t.Fatalf("Unexpected response: %q, %v", line, err)
+	}
+}
+
+func FuzzDispatch(f *testing.F) {
+	for _, session := range []string{"set key 0 0 5\r\nvalue\r\nget key\r\n", "cas key 0 0 1 42 noreply\r\nx\r\n",
+									 "gets a b c\r\ndelete a 0\r\n", "incr key 1\r\ntouch key 10\r\n",
+									 "lru_crawler metadump all\r\nstats detail dump\r\n", "stats\r\nstats sizes\r\n",
+									 "set key 0 0 3\r\nvalue\r\n", "\r\n\r\nquit\r\n", "lget key\r\nlset key 0 0 1 1\r\nx\r\n",
+									 "subscribe prefix=a\r\n", "watch mutations\r\n\r\n", "set key 0 0 -1\r\n"} {
+		f.Add([]byte(session))
+	}
+	srv := NewServer("60019", "", "", 1024, false, false, 0, 1 << 20)
+	var errors bytes.Buffer
+	srv.Logger.error.SetOutput(&errors)
+	f.Fuzz(func(t *testing.T, session []byte) {
+		client, connection := net.Pipe()
+		testDispatch(srv, connection)
+		go io.Copy(ioutil.Discard, client)
+		// server may stop reading (e.g. after quit), so writing isn't awaited for long.
+		client.SetWriteDeadline(time.Now().Add(time.Second))
+		client.Write(session)
+		client.Close()
+		finished := make(chan bool)
+		go func() {
+			srv.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Serving of session %q wasn't finished after closing of connection.", session)
+		}
+		if strings.Contains(errors.String(), "Panic") {
+			t.Fatalf("Session %q caused panic: %s", session, errors.String())
+		}
+	})
+}
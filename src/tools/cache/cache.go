@@ -5,6 +5,8 @@ package cache
 
 import (
 	"container/list"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +26,8 @@ type LRUCacheItem struct {
 	listElement *list.Element
 	touched bool
 	ts int64
+	generation uint64 // generation of namespace, which item was stored in
+	tags []string
 }
 
 // Structure for storage statistics.
@@ -40,12 +44,23 @@ type LRUCacheStat struct {
 
 // Implementation of LRUCache itself.
 // Structure consists max allowed size of memory, collection of elements and list for defining of recently usages.
+// Methods of LRUCache aren't synchronized: when storage is shared among goroutines, its lock should be held
+// by caller (see Lock/Unlock methods); crawler acquires it by itself.
 type LRUCache struct {
+	mutex sync.Mutex
 	capacity int64 // bytes
 	items map[string] *LRUCacheItem
 	list *list.List
 	Stats *LRUCacheStat
 	Crawler *LRUCrawler
+	sizes *SizesHistogram
+	ext *ExtStore
+	compression atomic.Value // *Compression, which is read by callers without lock
+	ns *Namespaces
+	tags map[string] map[*LRUCacheItem] bool // indexes of items by their tags
+	leases *Leases
+	listeners []Listener
+	observers []Observer
 }
 
 // Private method of LRUCache for promoting item to the top of list.
@@ -55,28 +70,54 @@ func (c *LRUCache) promote(item *LRUCacheItem) {
 }
 
 // Private method of LRUCache for releasing of memory.
-// Function receives amount of items to dispose. These items will be discarded from the tail of list.
+// Function receives amount of items to dispose. These items will be discarded from the tail of list;
+// if extstore is attached, large values are moved there instead, leaving headers in place.
+// Headers of moved values are discarded only when there are no other items to release.
 // Amount == -1 - flushes all.
 func (c *LRUCache) prune(amount int) {
 	var counter = 0
-	for{
-		if amount != -1 && counter == amount { return }
-		tail := c.list.Back()
-		if tail == nil{ return }
-		item := c.list.Remove(tail).(*LRUCacheItem)
-		if amount != -1 {
-			c.Stats.Evictions ++
-			if !item.touched {
-				c.Stats.Evicted_unfetched ++
+	for _, headers := range []bool{false, true} {
+		if headers && counter > 0 {
+			return
+		}
+		for element := c.list.Back(); element != nil; {
+			if amount != -1 && counter == amount { return }
+			item := element.Value.(*LRUCacheItem)
+			element = element.Prev()
+			if amount != -1 {
+				if _, moved := item.Cacheable.(*extHeader); moved != headers {
+					continue
+				}
+				if c.demote(item) {
+					counter ++
+					continue
+				}
+				c.Stats.Evictions ++
+				if !item.touched {
+					c.Stats.Evicted_unfetched ++
+				}
+				c.emit(EVENT_EVICT, item)
 			}
+			c.unlink(item)
+			counter ++
 		}
-		delete(c.items, item.Cacheable.Key())
-		c.capacity += int64(item.Cacheable.Size())
-		counter ++
-		c.Stats.Current_items --
 	}
 }
 
+// Private method of LRUCache, which discards passed item from collection and list of recentness,
+// releases occupied memory and updates storage statistic.
+func (c *LRUCache) unlink(item *LRUCacheItem) {
+	c.list.Remove(item.listElement)
+	delete(c.items, item.Cacheable.Key())
+	c.nsAccount(item, -1)
+	c.nsRelease(item.Cacheable.Key())
+	c.untag(item)
+	releaseExt(item.Cacheable)
+	c.capacity += int64(item.Cacheable.Size())
+	c.sizes.remove(item.Cacheable.Size())
+	c.Stats.Current_items --
+}
+
 // Public method of LRUCache, which retrieving data from it by received param "key"
 // and returns pointer to structure LRUCacheItem with flags, data, id and exptime.
 // If data is expired function will remove it and will return nil.
@@ -90,6 +131,12 @@ func (c *LRUCache) Get(key string) *LRUCacheItem {
 	if c.deleteExpired(item.Cacheable) {
 		return nil
 	}
+	// value moved to extstore could be lost, when its page was evicted
+	if header, moved := item.Cacheable.(*extHeader); moved && !header.store.available(header) {
+		c.emit(EVENT_EVICT, item)
+		c.unlink(item)
+		return nil
+	}
 	c.promote(item)
 	return item
 }
@@ -98,11 +145,16 @@ func (c *LRUCache) Get(key string) *LRUCacheItem {
 // Function receives item (with built-in size and key), flags for item, expiration timestamp and unique id.
 // Function will update an item in cache, if such item does exist.
 // Also function automatically can discard last 50 items if there is no space for new one.
+// Values are stored as passed: caller compresses them beforehand (see Compression.Compress).
 // Function returns true if item was stored or false if there was no space for it.
 func (c *LRUCache) Set(Cacheable Cacheable, flags int, expiration_ts int64, cas_unique int64) bool {
 	if c.capacity < int64(Cacheable.Size()) {
 		c.Stats.Outofmem ++
 		c.prune(50)
+		// moving of values to extstore could release not enough memory, so let's discard their headers
+		if c.capacity < int64(Cacheable.Size()) && c.ext != nil {
+			c.prune(50)
+		}
 	}
 	//still not enough room, fail
 	if c.capacity < int64(Cacheable.Size()) {
@@ -111,11 +163,21 @@ func (c *LRUCache) Set(Cacheable Cacheable, flags int, expiration_ts int64, cas_
 	item, exists := c.items[Cacheable.Key()]
 	if exists {
 		old_size := item.Cacheable.Size()
+		c.nsAccount(item, -1)
+		if header, moved := item.Cacheable.(*extHeader); moved {
+			if same, _ := Cacheable.(*extHeader); same != header {
+				header.store.release(header)
+			}
+		}
 		item.Cacheable = Cacheable
 		item.Cas_unique = cas_unique
 		item.Flags = flags
 		item.Exptime = expiration_ts
 		c.capacity -= int64(Cacheable.Size() - old_size)
+		c.sizes.remove(old_size)
+		c.sizes.add(Cacheable.Size())
+		c.nsAssign(item)
+		c.nsAccount(item, 1)
 		c.promote(item)
 	} else {
 		item = &LRUCacheItem{
@@ -128,10 +190,14 @@ func (c *LRUCache) Set(Cacheable Cacheable, flags int, expiration_ts int64, cas_
 		}
 		item.listElement = c.list.PushFront(item)
 		c.items[Cacheable.Key()] = item
+		c.nsAssign(item)
+		c.nsAccount(item, 1)
 		c.capacity -= int64(Cacheable.Size())
+		c.sizes.add(Cacheable.Size())
 		c.Stats.Current_items ++
 		c.Stats.Total_items ++
 	}
+	c.emit(EVENT_SET, item)
 	return true
 }
 
@@ -140,9 +206,8 @@ func (c *LRUCache) Set(Cacheable Cacheable, flags int, expiration_ts int64, cas_
 func (c *LRUCache) Flush(key string) bool {
 	item, exists := c.items[key]
 	if exists {
-		c.list.Remove(item.listElement)
-		delete(c.items, key)
-		c.Stats.Current_items --
+		c.emit(EVENT_DELETE, item)
+		c.unlink(item)
 		return true
 	} else { return false }
 }
@@ -150,6 +215,7 @@ func (c *LRUCache) Flush(key string) bool {
 // Public method of LRUCache, which discard all items in cache.
 func (c *LRUCache) FlushAll(){
 	c.prune(-1)
+	c.emit(EVENT_FLUSH, nil)
 }
 
 // Public method of LRUCache, which sets Cas_unique field's value to passed param cas
@@ -164,6 +230,56 @@ func (c *LRUCache) SetCas(key string, cas int64) bool {
 	return false
 }
 
+// Public method of LRUCache, which zeroes cumulative counters of storage statistic.
+// Values, which describe current state of storage (volume, number of items), are kept.
+func (c *LRUCache) ResetStats() {
+	c.Stats.Evictions = 0
+	c.Stats.Expired_unfetched = 0
+	c.Stats.Evicted_unfetched = 0
+	c.Stats.Total_items = 0
+	c.Stats.Crawler_reclaimed = 0
+	c.Stats.Outofmem = 0
+}
+
+// Public method of LRUCache, which calls passed function for each stored item (including expired ones),
+// from the least recently used one to the most recently used one.
+// Passed function mustn't modify the storage.
+func (c *LRUCache) Walk(fn func(item *LRUCacheItem)) {
+	for element := c.list.Back(); element != nil; element = element.Prev() {
+		fn(element.Value.(*LRUCacheItem))
+	}
+}
+
+// Public method of LRUCache, which returns item by key as is: it isn't promoted, expired or checked in extstore.
+// Returns nil if item is missing.
+func (c *LRUCache) Peek(key string) *LRUCacheItem {
+	return c.items[key]
+}
+
+// Public method of LRUCache, which acquires exclusive access to the storage.
+func (c *LRUCache) Lock() {
+	c.mutex.Lock()
+}
+
+// Public method of LRUCache, which releases exclusive access to the storage.
+func (c *LRUCache) Unlock() {
+	c.mutex.Unlock()
+}
+
+// Public method of LRUCache, which changes max allowed size of memory (bytes). If stored items don't fit into
+// new size, the least recently used ones are evicted. Returns false if size is invalid.
+func (c *LRUCache) Resize(volume int64) bool {
+	if volume <= 0 {
+		return false
+	}
+	c.capacity += volume - c.Stats.Volume
+	c.Stats.Volume = volume
+	for c.capacity < 0 && c.list.Len() > 0 {
+		c.prune(1)
+	}
+	return true
+}
+
 // Getter for private capacity param
 func (c *LRUCache) Capacity() int64 {
 	return c.capacity
@@ -180,28 +296,44 @@ func New(capacity int64 /* bytes */) *LRUCache {
 		list: list.New(),
 		Stats: &LRUCacheStat{capacity, 0, 0, 0, 0, 0, 0, 0},
 		Crawler: NewCrawler(),
+		sizes: newSizesHistogram(),
+		leases: NewLeases(),
 	}
 }
 
 // Private method of LRUCache, for flushing expired items.
-// Function receives an item to check. If it does exist and it's timestamp is less than Now, item will be discarded and
-// function will return true, otherwise false.
+// Function receives an item to check. If it does exist and it's timestamp is less than Now (or its namespace was
+// flushed since it was stored), item will be discarded and function will return true, otherwise false.
 func (c *LRUCache) deleteExpired(Cacheable Cacheable) bool {
 	item, exists := c.items[Cacheable.Key()]
 	if exists {
 		if item.Exptime < time.Now().Unix() && item.Exptime != 0 {
-			c.list.Remove(item.listElement)
 			if !item.touched {
 				c.Stats.Expired_unfetched ++
 			}
-			delete(c.items, item.Cacheable.Key())
-			c.Stats.Current_items --
+			c.emit(EVENT_EXPIRE, item)
+			c.unlink(item)
+			return true
+		}
+		if c.Stale(item) {
+			c.emit(EVENT_EXPIRE, item)
+			c.unlink(item)
 			return true
 		}
 	}
 	return false
 }
 
+// Getter for ts field: timestamp of storing of item.
+func (item *LRUCacheItem) Timestamp() int64 {
+	return item.ts
+}
+
+// Getter for touched field: returns true if item was fetched since it was stored.
+func (item *LRUCacheItem) Fetched() bool {
+	return item.touched
+}
+
 // Function returns a timestamp of oldest stored item.
 func (s *LRUCache) Oldest() int64 {
 	if s.list.Back() == nil {
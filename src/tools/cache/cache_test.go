@@ -4,6 +4,8 @@ import (
 	"testing"
 	"tools"
 	"time"
+	"os"
+	"strings"
 )
 
 func TestCacheCreationSuite1(t *testing.T){
@@ -245,3 +247,385 @@ func TestCrawlerExpiring(t *testing.T){
 		t.Fatalf("Unexpected crawler's behavior: cache has %d items.", end_len)
 	}
 }
+
+func TestCacheSizesHistogram(t *testing.T){
+	cache := New(1000)
+	if cache.SizesEnabled() || cache.Sizes() != nil {
+		t.Fatalf("Histogram of sizes is turned on by default.")
+	}
+	cache.EnableSizes()
+	cache.Set(tools.NewStoredData(make([]byte, 10), "key1"), 0, 0, 0)
+	cache.Set(tools.NewStoredData(make([]byte, 32), "key2"), 0, 0, 0)
+	cache.Set(tools.NewStoredData(make([]byte, 33), "key3"), 0, 0, 0)
+	sizes := cache.Sizes()
+	if len(sizes) != 2 || sizes[32] != 2 || sizes[64] != 1 {
+		t.Fatalf("Unexpected histogram: %v", sizes)
+	}
+	cache.Set(tools.NewStoredData(make([]byte, 100), "key1"), 0, 0, 0)
+	cache.Flush("key2")
+	sizes = cache.Sizes()
+	if len(sizes) != 2 || sizes[64] != 1 || sizes[128] != 1 {
+		t.Fatalf("Unexpected histogram after updating: %v", sizes)
+	}
+	cache.FlushAll()
+	if len(cache.Sizes()) != 0 || cache.Capacity() != 1000 {
+		t.Fatalf("Unexpected histogram after flushing: %v", cache.Sizes())
+	}
+}
+
+func TestCacheSizesEnablingDisabling(t *testing.T){
+	cache := New(1000)
+	cache.DisableSizes()
+	cache.Set(tools.NewStoredData(make([]byte, 10), "key1"), 0, 0, 0)
+	if cache.SizesEnabled() || cache.Sizes() != nil {
+		t.Fatalf("Histogram wasn't disabled.")
+	}
+	cache.EnableSizes()
+	if !cache.SizesEnabled() || cache.Sizes()[32] != 1 {
+		t.Fatalf("Histogram wasn't rebuilt after enabling: %v", cache.Sizes())
+	}
+}
+
+func TestCacheCapacityAfterFlushing(t *testing.T){
+	cache := New(10)
+	cache.Set(tools.NewStoredData([]byte("TEST"), "key1"), 0, 0, 0)
+	cache.Set(tools.NewStoredData([]byte("TEST"), "key2"), 0, 1111111, 0)
+	cache.Flush("key1")
+	cache.Get("key2")
+	if cache.Capacity() != 10 || cache.Stats.Current_items != 0 {
+		t.Fatalf("Memory wasn't released: %d", cache.Capacity())
+	}
+}
+
+func TestExtStoreDemotion(t *testing.T){
+	path := os.TempDir() + "/memorango_extstore_test"
+	ext, err := NewExtStore(path, 3 * 1024, 1024)
+	if err != nil {
+		t.Fatalf("Extstore wasn't created: %s", err)
+	}
+	defer ext.Close()
+	ext.MinItemSize = 100
+	cache := New(2500)
+	cache.SetExtStore(ext)
+	cache.Set(tools.NewStoredData(make([]byte, 50), "small"), 0, 0, 0)
+	for _, key := range []string{"big1", "big2", "big3"} {
+		value := make([]byte, 1000)
+		copy(value, key)
+		if !cache.Set(tools.NewStoredData(value, key), 0, 0, 0) {
+			t.Fatalf("Item %s wasn't stored.", key)
+		}
+	}
+	if cache.Get("small") != nil || cache.Stats.Evictions != 1 {
+		t.Fatalf("Small item wasn't evicted.")
+	}
+	if cache.Capacity() != 2500 - 1000 - 2 * EXT_HEADER_SIZE {
+		t.Fatalf("Unexpected capacity: %d", cache.Capacity())
+	}
+	item := cache.Get("big1")
+	if item == nil || string(tools.ExtractStoredData(item.Cacheable)[ : 4]) != "big1" {
+		t.Fatalf("Value wasn't read from extstore.")
+	}
+	if stats := strings.Join(ext.Stats(), ","); !strings.Contains(stats, "extstore_hits 1,") ||
+	   !strings.Contains(stats, "extstore_objects 2,") || !strings.Contains(stats, "extstore_bytes_written 2000,") {
+		t.Fatalf("Unexpected statistic: %s", stats)
+	}
+	cache.Flush("big1")
+	cache.Set(tools.NewStoredData([]byte("TEST"), "big2"), 0, 0, 0)
+	if stats := strings.Join(ext.Stats(), ","); !strings.Contains(stats, "extstore_objects 0,") {
+		t.Fatalf("Values weren't released: %s", stats)
+	}
+	cache.FlushAll()
+	if cache.Capacity() != 2500 {
+		t.Fatalf("Memory wasn't released: %d", cache.Capacity())
+	}
+}
+
+func TestExtStoreCompaction(t *testing.T){
+	path := os.TempDir() + "/memorango_extstore_test"
+	ext, err := NewExtStore(path, 2 * 1024, 1024)
+	if err != nil {
+		t.Fatalf("Extstore wasn't created: %s", err)
+	}
+	defer ext.Close()
+	ext.MinItemSize = 100
+	cache := New(1 << 20)
+	cache.SetExtStore(ext)
+	set := func(key string, size int) {
+		value := make([]byte, size)
+		copy(value, key)
+		cache.Set(tools.NewStoredData(value, key), 0, 0, 0)
+		cache.prune(1)
+	}
+	set("a1", 600)
+	set("a2", 300)
+	cache.Flush("a1")
+	set("b1", 600)
+	// the first page is reused: its live value is compacted
+	set("b2", 600)
+	if stats := strings.Join(ext.Stats(), ","); !strings.Contains(stats, "extstore_compactions 1,") ||
+	   !strings.Contains(stats, "extstore_bytes_compacted 300,") {
+		t.Fatalf("Page wasn't compacted: %s", stats)
+	}
+	for _, key := range []string{"a2", "b1", "b2"} {
+		if item := cache.Get(key); item == nil || string(tools.ExtractStoredData(item.Cacheable)[ : 2]) != key {
+			t.Fatalf("Value of %s wasn't read from extstore.", key)
+		}
+	}
+	// the second page is mostly alive, so it's evicted
+	set("c1", 600)
+	if cache.Get("b1") != nil {
+		t.Fatalf("Value of evicted page was returned.")
+	}
+	if stats := strings.Join(ext.Stats(), ","); !strings.Contains(stats, "extstore_pages_evicted 1,") ||
+	   !strings.Contains(stats, "extstore_items_lost 1") || !strings.Contains(stats, "extstore_misses 1,") {
+		t.Fatalf("Page wasn't evicted: %s", stats)
+	}
+	if _, err := NewExtStore(path, 1024, 1024); err != ErrExtStoreSize {
+		t.Fatalf("Extstore of single page was created.")
+	}
+}
+
+func TestCacheCompression(t *testing.T){
+	if _, err := NewCompression(0, 42); err == nil {
+		t.Fatalf("Invalid level of compression was accepted.")
+	}
+	compression, _ := NewCompression(100, 1)
+	cache := New(10000)
+	cache.SetCompression(compression)
+	json := []byte(strings.Repeat(`{"id":1,"name":"value","tags":["a","b"]},`, 50))
+	cache.Set(cache.Compression().Compress(tools.NewStoredData(json, "json")), 0, 0, 0)
+	random := make([]byte, 1000)
+	for i := range random {
+		random[i] = byte(tools.GenerateCasId())
+	}
+	cache.Set(cache.Compression().Compress(tools.NewStoredData(random, "random")), 0, 0, 0)
+	cache.Set(cache.Compression().Compress(tools.NewStoredData([]byte("TEST"), "small")), 0, 0, 0)
+	used := 10000 - cache.Capacity()
+	if used >= int64(len(json)) / 5 + 1000 + 4 {
+		t.Fatalf("Value wasn't compressed: %d bytes are used", used)
+	}
+	for key, value := range map[string][]byte{"json": json, "random": random, "small": []byte("TEST")} {
+		if item := cache.Get(key); item == nil || string(tools.ExtractStoredData(item.Cacheable)) != string(value) {
+			t.Fatalf("Unexpected value of %s.", key)
+		}
+	}
+	stats := strings.Join(compression.Stats(), ",")
+	if !strings.Contains(stats, "compression_items 1,") || !strings.Contains(stats, "compression_skipped 1,") ||
+	   !strings.Contains(stats, "compression_bytes_in " + tools.IntToString(int64(len(json))) + ",") ||
+	   !strings.Contains(stats, "decompressions 1,") {
+		t.Fatalf("Unexpected statistic: %s", stats)
+	}
+	// chunked value is compressed as a whole without joining.
+	large := []byte(strings.Repeat("large value ", tools.CHUNK_SIZE / 6))
+	compressed := compression.Compress(tools.NewChunkedData(tools.Chunk(large), "large"))
+	if _, ok := compressed.(*compressedData); !ok || string(tools.ExtractStoredData(compressed)) != string(large) {
+		t.Fatalf("Chunked value wasn't compressed.")
+	}
+	var none *Compression
+	if none.Compress(tools.NewStoredData(json, "json")).Size() != len(json) {
+		t.Fatalf("Value was compressed without compression.")
+	}
+	cache.FlushAll()
+	if cache.Capacity() != 10000 {
+		t.Fatalf("Memory wasn't released: %d", cache.Capacity())
+	}
+}
+
+func TestExtStoreCompressedValues(t *testing.T){
+	path := os.TempDir() + "/memorango_extstore_test"
+	ext, err := NewExtStore(path, 3 * 1024, 1024)
+	if err != nil {
+		t.Fatalf("Extstore wasn't created: %s", err)
+	}
+	defer ext.Close()
+	ext.MinItemSize = 50
+	compression, _ := NewCompression(100, 1)
+	cache := New(1 << 20)
+	cache.SetExtStore(ext)
+	cache.SetCompression(compression)
+	json := []byte(strings.Repeat(`{"id":1,"name":"value","tags":["a","b"]},`, 50))
+	cache.Set(compression.Compress(tools.NewStoredData(json, "json")), 0, 0, 0)
+	size := cache.Get("json").Cacheable.Size()
+	cache.prune(1)
+	// compressed payload is moved to extstore as is.
+	if stats := strings.Join(ext.Stats(), ","); !strings.Contains(stats, "extstore_objects 1,") ||
+	   !strings.Contains(stats, "extstore_bytes_written " + tools.IntToString(int64(size)) + ",") {
+		t.Fatalf("Compressed value wasn't moved: %d, %s", size, stats)
+	}
+	if item := cache.Get("json"); item == nil || string(tools.ExtractStoredData(item.Cacheable)) != string(json) {
+		t.Fatalf("Value wasn't decompressed after reading from extstore.")
+	}
+}
+
+func TestCacheNamespaces(t *testing.T){
+	cache := New(10000)
+	if cache.FlushNamespace("user") || cache.NamespacesStats() != nil {
+		t.Fatalf("Namespaces are turned on by default.")
+	}
+	cache.Set(tools.NewStoredData([]byte("1"), "user:1"), 0, 0, 0)
+	cache.SetNamespaceDelimiter(":")
+	cache.Set(tools.NewStoredData([]byte("22"), "user:2"), 0, 0, 0)
+	cache.Set(tools.NewStoredData([]byte("333"), "session:1"), 0, 0, 0)
+	cache.Set(tools.NewStoredData([]byte("4444"), "plain"), 0, 0, 0)
+	stats := strings.Join(cache.NamespacesStats(), ",")
+	if stats != "ns:session:items 1,ns:session:bytes 3,ns:session:flushes 0," +
+	            "ns:user:items 2,ns:user:bytes 3,ns:user:flushes 0,ns_stale_items 0,ns_stale_bytes 0" {
+		t.Fatalf("Unexpected statistic: %s", stats)
+	}
+	if !cache.FlushNamespace("unknown") || strings.Join(cache.NamespacesStats(), ",") != stats {
+		t.Fatalf("Flush of unknown namespace changed statistic: %v", cache.NamespacesStats())
+	}
+	if !cache.FlushNamespace("user") {
+		t.Fatalf("Namespace wasn't flushed.")
+	}
+	stats = strings.Join(cache.NamespacesStats(), ",")
+	if !strings.Contains(stats, "ns:user:items 0,ns:user:bytes 0,ns:user:flushes 1,") ||
+	   !strings.HasSuffix(stats, "ns_stale_items 2,ns_stale_bytes 3") {
+		t.Fatalf("Unexpected statistic after flush: %s", stats)
+	}
+	if cache.Get("user:1") != nil || cache.Get("session:1") == nil || cache.Get("plain") == nil {
+		t.Fatalf("Unexpected items after flush.")
+	}
+	cache.Set(tools.NewStoredData([]byte("new"), "user:2"), 0, 0, 0)
+	if item := cache.Get("user:2"); item == nil || string(tools.ExtractStoredData(item.Cacheable)) != "new" {
+		t.Fatalf("Item stored after flush isn't available.")
+	}
+	stats = strings.Join(cache.NamespacesStats(), ",")
+	if !strings.Contains(stats, "ns:user:items 1,ns:user:bytes 3,") || !strings.HasSuffix(stats, "ns_stale_items 0,ns_stale_bytes 0") {
+		t.Fatalf("Unexpected statistic after reuse: %s", stats)
+	}
+	cache.SetNamespaceDelimiter("")
+	if cache.NamespacesStats() != nil || cache.Get("user:2") == nil {
+		t.Fatalf("Namespaces weren't turned off.")
+	}
+}
+
+func TestCacheNamespacesEviction(t *testing.T){
+	cache := New(2000)
+	cache.SetNamespaceDelimiter(":")
+	for i := 0; i < 1000; i ++ {
+		cache.Set(tools.NewStoredData([]byte("value"), "user" + tools.IntToString(int64(i)) + ":key"), 0, 0, 0)
+	}
+	if len(cache.ns.namespaces) != len(cache.items) || len(cache.ns.namespaces) >= 1000 {
+		t.Fatalf("Namespaces of evicted items weren't forgotten: %d namespaces, %d items",
+				 len(cache.ns.namespaces), len(cache.items))
+	}
+	cache.Set(tools.NewStoredData([]byte("value"), "user:1"), 0, 0, 0)
+	cache.Set(tools.NewStoredData([]byte("value"), "user:2"), 0, 0, 0)
+	cache.FlushNamespace("user")
+	cache.Flush("user:1")
+	if _, exists := cache.ns.namespaces["user"]; !exists || cache.Stale(cache.Peek("user:2")) == false {
+		t.Fatalf("Namespace with stale item was forgotten.")
+	}
+	cache.Flush("user:2")
+	if _, exists := cache.ns.namespaces["user"]; exists || cache.ns.stale_items != 0 {
+		t.Fatalf("Namespace without items wasn't forgotten.")
+	}
+	cache.FlushAll()
+	if len(cache.ns.namespaces) != 0 {
+		t.Fatalf("Namespaces weren't forgotten after flush of all items: %d", len(cache.ns.namespaces))
+	}
+}
+
+func TestCacheTags(t *testing.T){
+	cache := New(100)
+	if cache.Tag("missed", []string{"a"}) {
+		t.Fatalf("Missed item was tagged.")
+	}
+	for i, key := range []string{"k1", "k2", "k3", "k4"} {
+		cache.Set(tools.NewStoredData([]byte("0123456789"), key), 0, 0, 0)
+		cache.Tag(key, []string{"all", "t" + tools.IntToString(int64(i % 2))})
+	}
+	if tags, references := cache.TagsCount(); tags != 3 || references != 8 {
+		t.Fatalf("Unexpected number of tags: %d, %d", tags, references)
+	}
+	if n := cache.InvalidateTag("t0"); n != 2 || cache.Get("k1") != nil || cache.Get("k3") != nil || cache.Get("k2") == nil {
+		t.Fatalf("Unexpected invalidation of tag: %d", n)
+	}
+	cache.Tag("k2", []string{"other"})
+	if tags, references := cache.TagsCount(); tags != 3 || references != 3 {
+		t.Fatalf("Unexpected number of tags after retagging: %d, %d", tags, references)
+	}
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k4"), 0, 1, 0)
+	if cache.Get("k4") != nil {
+		t.Fatalf("Expired item is available.")
+	}
+	for i := 0; i < 10; i ++ {
+		cache.Set(tools.NewStoredData([]byte("0123456789"), "e" + tools.IntToString(int64(i))), 0, 0, 0)
+	}
+	if cache.Get("k2") != nil {
+		t.Fatalf("Item wasn't evicted.")
+	}
+	if tags, references := cache.TagsCount(); tags != 0 || references != 0 {
+		t.Fatalf("Indexes of tags leaked: %d, %d", tags, references)
+	}
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k5"), 0, 0, 0)
+	cache.Tag("k5", []string{"a", "a"})
+	cache.FlushAll()
+	if tags, references := cache.TagsCount(); tags != 0 || references != 0 || cache.InvalidateTag("a") != 0 {
+		t.Fatalf("Indexes of tags leaked after flush: %d, %d", tags, references)
+	}
+}
+
+func TestCacheLeases(t *testing.T){
+	leases := New(100).Leases()
+	token := leases.Acquire("key", false)
+	if token == 0 || leases.Acquire("key", false) != 0 || leases.Acquire("key", true) != 0 {
+		t.Fatalf("Lease wasn't granted exclusively.")
+	}
+	if leases.Release("key", token + 1) || !leases.Release("key", token) || leases.Release("key", token) {
+		t.Fatalf("Unexpected releasing of lease.")
+	}
+	leases.Timeout = 0
+	for i := 0; i < LEASE_SWEEP_THRESHOLD + 1; i ++ {
+		leases.Acquire(tools.IntToString(int64(i)), false)
+	}
+	if len(leases.leases) != 1 {
+		t.Fatalf("Timed out leases weren't swept: %d", len(leases.leases))
+	}
+	stats := strings.Join(leases.Stats(), ",")
+	if !strings.Contains(stats, "leases_granted 1026,leases_waits 1,leases_stale 1,leases_released 1,leases_rejected 2") {
+		t.Fatalf("Unexpected statistic: %s", stats)
+	}
+}
+
+type recorder []string
+
+func (r *recorder) Changed(event string, key string, size int) {
+	*r = append(*r, event + " " + key)
+}
+
+func TestCacheListeners(t *testing.T){
+	events := new(recorder)
+	cache := New(30)
+	cache.AddListener(events)
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k1"), 0, 0, 0)
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k2"), 0, 1, 0)
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k3"), 0, 0, 0)
+	cache.Get("k2")
+	cache.Set(tools.NewStoredData([]byte("01234567890123456789"), "k4"), 0, 0, 0)
+	cache.Flush("k4")
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k5"), 0, 0, 0)
+	cache.FlushAll()
+	cache.RemoveListener(events)
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k6"), 0, 0, 0)
+	expected := "set k1,set k2,set k3,expire k2,evict k1,evict k3,set k4,delete k4,set k5,flush "
+	if strings.Join(*events, ",") != expected {
+		t.Fatalf("Unexpected events: %s", strings.Join(*events, ","))
+	}
+}
+
+func TestCacheResize(t *testing.T){
+	cache := New(30)
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k1"), 0, 0, 0)
+	cache.Set(tools.NewStoredData([]byte("0123456789"), "k2"), 0, 0, 0)
+	if cache.Resize(0) || cache.Stats.Volume != 30 {
+		t.Fatalf("Invalid size was accepted.")
+	}
+	if !cache.Resize(60) || cache.Stats.Volume != 60 || cache.Capacity() != 40 {
+		t.Fatalf("Unexpected capacity after growth: %d", cache.Capacity())
+	}
+	if !cache.Resize(15) || cache.Capacity() < 0 || cache.Get("k1") != nil || cache.Get("k2") == nil {
+		t.Fatalf("The least recently used item wasn't evicted: %d", cache.Capacity())
+	}
+}
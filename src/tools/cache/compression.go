@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tools"
+)
+
+const (
+	// Defines default minimal size of value (in bytes), which is compressed.
+	DEFAULT_COMPRESSION_THRESHOLD = 1024
+)
+
+// Structure of compression of values, which keeps its settings and statistic.
+// Values are compressed by flate; value, which doesn't become smaller, is kept as is.
+type Compression struct {
+	threshold int
+	level int
+	writers sync.Pool
+	compressed uint64 // number of compressed values
+	skipped uint64 // number of values, which weren't compressed since they didn't become smaller
+	bytes_in uint64
+	bytes_out uint64
+	compress_ns uint64
+	decompressions uint64
+	decompress_ns uint64
+}
+
+// Function creates compression of values not smaller than passed threshold (in bytes) with passed level of flate
+// (from flate.BestSpeed to flate.BestCompression). Returns error if level is invalid.
+func NewCompression(threshold int, level int) (*Compression, error) {
+	if _, err := flate.NewWriter(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+	return &Compression{threshold: threshold, level: level}, nil
+}
+
+// Structure of compressed value. It implements Cacheable and Valuable interfaces: size of item is size of
+// compressed data, and value is decompressed on demand.
+type compressedData struct {
+	key string
+	data []byte
+	length int // size of original value
+	compression *Compression
+}
+
+// The public method for generalization of interface.
+// Returns key of item.
+func (d *compressedData) Key() string {
+	return d.key
+}
+
+// The public method for generalization of interface.
+// Returns amount of compressed bytes.
+func (d *compressedData) Size() int {
+	return len(d.data)
+}
+
+// The public method of compressed data, which returns decompressed value or nil if data is corrupted.
+func (d *compressedData) Value() []byte {
+	return d.compression.decompress(d)
+}
+
+// Public method of compression, which returns compressed version of passed value (tools.StoredData or
+// tools.ChunkedData), if it's large enough and compressible; otherwise cacheable is returned as is.
+// Nil compression returns cacheable as is. Values are compressed by caller before storage is locked,
+// since LRUCache.Set stores them as passed.
+func (z *Compression) Compress(Cacheable Cacheable) Cacheable {
+	if z == nil || Cacheable.Size() < z.threshold {
+		return Cacheable
+	}
+	var chunks [][]byte
+	switch value := Cacheable.(type) {
+	case tools.StoredData:
+		chunks = [][]byte{value.Value()}
+	case tools.ChunkedData:
+		chunks = value.Chunks()
+	default:
+		return Cacheable // already compressed or moved to extstore
+	}
+	start := time.Now()
+	var buffer bytes.Buffer
+	writer, _ := z.writers.Get().(*flate.Writer)
+	if writer == nil {
+		writer, _ = flate.NewWriter(&buffer, z.level)
+	} else {
+		writer.Reset(&buffer)
+	}
+	for _, chunk := range chunks {
+		writer.Write(chunk)
+	}
+	writer.Close()
+	z.writers.Put(writer)
+	atomic.AddUint64(&z.compress_ns, uint64(time.Since(start)))
+	if buffer.Len() >= Cacheable.Size() {
+		atomic.AddUint64(&z.skipped, 1)
+		return Cacheable
+	}
+	atomic.AddUint64(&z.compressed, 1)
+	atomic.AddUint64(&z.bytes_in, uint64(Cacheable.Size()))
+	atomic.AddUint64(&z.bytes_out, uint64(buffer.Len()))
+	return &compressedData{key: Cacheable.Key(), data: buffer.Bytes(), length: Cacheable.Size(), compression: z}
+}
+
+// Private method, which decompresses passed data.
+func (z *Compression) decompress(data *compressedData) []byte {
+	start := time.Now()
+	reader := flate.NewReader(bytes.NewReader(data.data))
+	value := make([]byte, 0, data.length)
+	buffer := bytes.NewBuffer(value)
+	_, err := buffer.ReadFrom(reader)
+	reader.Close()
+	atomic.AddUint64(&z.decompressions, 1)
+	atomic.AddUint64(&z.decompress_ns, uint64(time.Since(start)))
+	if err != nil {
+		return nil
+	}
+	return buffer.Bytes()
+}
+
+// Function returns lines of statistic of compression: settings, number of compressed and skipped values,
+// sizes of values before and after compression and their ratio, number of decompressions
+// and time spent (in microseconds).
+func (z *Compression) Stats() []string {
+	bytes_in, bytes_out := atomic.LoadUint64(&z.bytes_in), atomic.LoadUint64(&z.bytes_out)
+	var ratio float64
+	if bytes_out > 0 {
+		ratio = float64(bytes_in) / float64(bytes_out)
+	}
+	return []string{
+		"compression_threshold " + tools.IntToString(int64(z.threshold)),
+		"compression_level " + tools.IntToString(int64(z.level)),
+		"compression_items " + tools.UIntToString(atomic.LoadUint64(&z.compressed)),
+		"compression_skipped " + tools.UIntToString(atomic.LoadUint64(&z.skipped)),
+		"compression_bytes_in " + tools.UIntToString(bytes_in),
+		"compression_bytes_out " + tools.UIntToString(bytes_out),
+		"compression_ratio " + strconv.FormatFloat(ratio, 'f', 2, 64),
+		"compression_usec " + tools.UIntToString(atomic.LoadUint64(&z.compress_ns) / 1000),
+		"decompressions " + tools.UIntToString(atomic.LoadUint64(&z.decompressions)),
+		"decompression_usec " + tools.UIntToString(atomic.LoadUint64(&z.decompress_ns) / 1000),
+	}
+}
+
+// Public method of LRUCache, which sets compression of values stored since now (see Compression.Compress).
+// Nil turns it off; values, which were already compressed, are kept compressed.
+func (c *LRUCache) SetCompression(compression *Compression) {
+	c.compression.Store(compression)
+}
+
+// Getter for compression field. It may be called without lock of storage.
+func (c *LRUCache) Compression() *Compression {
+	compression, _ := c.compression.Load().(*Compression)
+	return compression
+}
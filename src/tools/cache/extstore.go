@@ -0,0 +1,356 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+	"tools"
+)
+
+const (
+	// Defines amount of memory (in bytes), which is accounted for item, whose value was moved to extstore.
+	EXT_HEADER_SIZE = 48
+	// Defines default size of extstore page in bytes.
+	DEFAULT_EXT_PAGE_SIZE = 8 * 1024 * 1024
+	// Defines default minimal size of value, which may be moved to extstore.
+	DEFAULT_EXT_ITEM_SIZE = 512
+	// Defines default share of live data in page, below which page is compacted instead of being evicted.
+	DEFAULT_EXT_COMPACT_UNDER = 0.5
+)
+
+// Error, which is returned when passed sizes of extstore are invalid.
+var ErrExtStoreSize = errors.New("Size of extstore should hold at least two pages.")
+
+// Structure of extstore page: region of file, which is filled sequentially and reused as a whole.
+type extPage struct {
+	id int
+	written int64 // bytes
+	live int64 // bytes of values, which are still referenced
+	headers map[*extHeader] bool
+}
+
+// Private method of page, which forgets its content.
+func (p *extPage) reset() {
+	p.written = 0
+	p.live = 0
+	p.headers = make(map[*extHeader] bool)
+}
+
+// Structure, which stays in memory in place of value moved to extstore.
+// It implements Cacheable and Valuable interfaces: value is read from disk on demand.
+// Compressed value is moved as is and decompressed after reading.
+type extHeader struct {
+	key string
+	store *ExtStore
+	page *extPage
+	offset int64
+	length int
+	lost bool // page of value was evicted
+	compressed bool
+	compression *Compression // compression of value, if it's compressed
+	raw_length int // size of decompressed value
+}
+
+// The public method for generalization of interface.
+// Returns key of item.
+func (h *extHeader) Key() string {
+	return h.key
+}
+
+// The public method for generalization of interface.
+// Returns amount of memory accounted for header.
+func (h *extHeader) Size() int {
+	return EXT_HEADER_SIZE
+}
+
+// The public method of header, which reads value from extstore. Returns nil if value was lost.
+func (h *extHeader) Value() []byte {
+	value := h.store.read(h)
+	if value == nil || !h.compressed {
+		return value
+	}
+	return h.compression.decompress(&compressedData{key: h.key, data: value, length: h.raw_length,
+													compression: h.compression})
+}
+
+// Implementation of second-tier storage for large values evicted from memory (similar to memcached's extstore).
+// File is split into pages of equal size; values are appended to the current page. When all pages are used,
+// page with the least amount of live data is reused: its live values are compacted into it again if their share
+// is below CompactUnder, otherwise they are lost.
+// File is scratch space: it's truncated on creation and removed by Close.
+type ExtStore struct {
+	path string
+	file *os.File
+	page_size int64
+	pages []*extPage
+	free []*extPage
+	current *extPage
+	// Minimal size of value (in bytes), which is moved to extstore instead of eviction.
+	MinItemSize int
+	// Share of live data in page (from 0 to 1), below which page is compacted instead of being evicted.
+	CompactUnder float64
+	items_written uint64
+	bytes_written uint64
+	hits uint64
+	misses uint64
+	bytes_read uint64
+	compactions uint64
+	bytes_compacted uint64
+	pages_evicted uint64
+	items_lost uint64
+	mutex sync.Mutex
+}
+
+// Function creates extstore in file at passed path with passed total size and size of page (in bytes).
+func NewExtStore(path string, size int64, page_size int64) (*ExtStore, error) {
+	if page_size <= 0 || size / page_size < 2 {
+		return nil, ErrExtStoreSize
+	}
+	file, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE | os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	ext := &ExtStore{
+		path: path,
+		file: file,
+		page_size: page_size,
+		MinItemSize: DEFAULT_EXT_ITEM_SIZE,
+		CompactUnder: DEFAULT_EXT_COMPACT_UNDER,
+	}
+	for id := int(size / page_size) - 1; id >= 0; id -- {
+		page := &extPage{id: id}
+		page.reset()
+		ext.pages = append(ext.pages, page)
+		ext.free = append(ext.free, page)
+	}
+	return ext, nil
+}
+
+// Private method, which writes value to the current page and returns header, which refers to it.
+// Returns false if value doesn't fit into page or can't be written.
+func (e *ExtStore) store(key string, value []byte) (*extHeader, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	length := int64(len(value))
+	if length > e.page_size || e.file == nil {
+		return nil, false
+	}
+	if e.current == nil || e.current.written + length > e.page_size {
+		e.nextPage()
+		if e.current.written + length > e.page_size {
+			return nil, false
+		}
+	}
+	page := e.current
+	if _, err := e.file.WriteAt(value, int64(page.id) * e.page_size + page.written); err != nil {
+		return nil, false
+	}
+	header := &extHeader{key: key, store: e, page: page, offset: page.written, length: len(value)}
+	page.headers[header] = true
+	page.written += length
+	page.live += length
+	e.items_written ++
+	e.bytes_written += uint64(length)
+	return header, true
+}
+
+// Private method, which switches writing to free page or, if there is no such one, reuses page with the least
+// amount of live data. Mutex should be held by caller.
+func (e *ExtStore) nextPage() {
+	if len(e.free) > 0 {
+		e.current = e.free[len(e.free) - 1]
+		e.free = e.free[ : len(e.free) - 1]
+		return
+	}
+	var victim *extPage
+	for _, page := range e.pages {
+		if page != e.current && (victim == nil || page.live < victim.live) {
+			victim = page
+		}
+	}
+	e.current = victim
+	if float64(victim.live) < e.CompactUnder * float64(e.page_size) {
+		e.compact(victim)
+		return
+	}
+	for header := range victim.headers {
+		header.lost = true
+		e.items_lost ++
+	}
+	e.pages_evicted ++
+	victim.reset()
+}
+
+// Private method, which rewrites live values of page from its beginning. Values, which can't be read, are lost.
+// Mutex should be held by caller.
+func (e *ExtStore) compact(page *extPage) {
+	base := int64(page.id) * e.page_size
+	values := make(map[*extHeader] []byte, len(page.headers))
+	for header := range page.headers {
+		value := make([]byte, header.length)
+		if _, err := e.file.ReadAt(value, base + header.offset); err != nil {
+			header.lost = true
+			e.items_lost ++
+			continue
+		}
+		values[header] = value
+	}
+	page.reset()
+	for header, value := range values {
+		if _, err := e.file.WriteAt(value, base + page.written); err != nil {
+			header.lost = true
+			e.items_lost ++
+			continue
+		}
+		header.offset = page.written
+		page.headers[header] = true
+		page.written += int64(len(value))
+		page.live += int64(len(value))
+		e.bytes_compacted += uint64(len(value))
+	}
+	e.compactions ++
+}
+
+// Private method, which reads value referred by header.
+func (e *ExtStore) read(header *extHeader) []byte {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if header.lost || e.file == nil {
+		e.misses ++
+		return nil
+	}
+	value := make([]byte, header.length)
+	if _, err := e.file.ReadAt(value, int64(header.page.id) * e.page_size + header.offset); err != nil {
+		e.misses ++
+		return nil
+	}
+	e.hits ++
+	e.bytes_read += uint64(header.length)
+	return value
+}
+
+// Private method, which checks that value referred by header wasn't lost; lost value is counted as miss.
+func (e *ExtStore) available(header *extHeader) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if header.lost {
+		e.misses ++
+	}
+	return !header.lost
+}
+
+// Private method, which discards value referred by header. Page without live data becomes free.
+func (e *ExtStore) release(header *extHeader) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if header.lost {
+		return
+	}
+	header.lost = true
+	page := header.page
+	delete(page.headers, header)
+	page.live -= int64(header.length)
+	if len(page.headers) == 0 && page != e.current {
+		page.reset()
+		e.free = append(e.free, page)
+	}
+}
+
+// Function closes and removes file of extstore. Values, which weren't released, become lost.
+func (e *ExtStore) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	os.Remove(e.path)
+	return err
+}
+
+// Function returns lines of statistic of extstore: geometry of file, amount of live data,
+// counters of writes, reads (hits and misses), compactions and evictions of pages.
+func (e *ExtStore) Stats() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	var objects, used int64
+	for _, page := range e.pages {
+		objects += int64(len(page.headers))
+		used += page.live
+	}
+	return []string{
+		"extstore_page_size " + tools.IntToString(e.page_size),
+		"extstore_pages_total " + tools.IntToString(int64(len(e.pages))),
+		"extstore_pages_free " + tools.IntToString(int64(len(e.free))),
+		"extstore_min_item_size " + tools.IntToString(int64(e.MinItemSize)),
+		"extstore_objects " + tools.IntToString(objects),
+		"extstore_bytes_used " + tools.IntToString(used),
+		"extstore_items_written " + tools.UIntToString(e.items_written),
+		"extstore_bytes_written " + tools.UIntToString(e.bytes_written),
+		"extstore_hits " + tools.UIntToString(e.hits),
+		"extstore_misses " + tools.UIntToString(e.misses),
+		"extstore_bytes_read " + tools.UIntToString(e.bytes_read),
+		"extstore_compactions " + tools.UIntToString(e.compactions),
+		"extstore_bytes_compacted " + tools.UIntToString(e.bytes_compacted),
+		"extstore_pages_evicted " + tools.UIntToString(e.pages_evicted),
+		"extstore_items_lost " + tools.UIntToString(e.items_lost),
+	}
+}
+
+// Private method of LRUCache, which moves value of passed item to extstore, leaving header in memory.
+// Returns false if item isn't suitable for it (small, expired or already moved) or extstore failed.
+func (c *LRUCache) demote(item *LRUCacheItem) bool {
+	if c.ext == nil {
+		return false
+	}
+	size := item.Cacheable.Size()
+	if _, moved := item.Cacheable.(*extHeader); moved || size < c.ext.MinItemSize || size <= EXT_HEADER_SIZE {
+		return false
+	}
+	if item.Exptime != 0 && item.Exptime < time.Now().Unix() {
+		return false
+	}
+	var value []byte
+	compressed, is_compressed := item.Cacheable.(*compressedData)
+	if is_compressed {
+		value = compressed.data
+	} else if value = tools.ExtractStoredData(item.Cacheable); value == nil {
+		return false
+	}
+	header, ok := c.ext.store(item.Cacheable.Key(), value)
+	if !ok {
+		return false
+	}
+	if is_compressed {
+		header.compressed = true
+		header.compression = compressed.compression
+		header.raw_length = compressed.length
+	}
+	c.nsAccount(item, -1)
+	item.Cacheable = header
+	c.nsAccount(item, 1)
+	c.capacity += int64(size - EXT_HEADER_SIZE)
+	c.sizes.remove(size)
+	c.sizes.add(EXT_HEADER_SIZE)
+	return true
+}
+
+// Private function, which releases extstore's value of passed cacheable, if it was moved there.
+func releaseExt(Cacheable Cacheable) {
+	if header, moved := Cacheable.(*extHeader); moved {
+		header.store.release(header)
+	}
+}
+
+// Public method of LRUCache, which attaches extstore for large values, which would be evicted otherwise.
+// Nil detaches it; values, which were already moved, are kept in previous extstore.
+func (c *LRUCache) SetExtStore(ext *ExtStore) {
+	c.ext = ext
+}
+
+// Getter for ext field.
+func (c *LRUCache) ExtStore() *ExtStore {
+	return c.ext
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"time"
+	"tools"
+)
+
+const (
+	// Defines default time (in seconds), after which lease is released, if value wasn't stored with its token.
+	DEFAULT_LEASE_TIMEOUT = 10
+	// Defines minimal number of leases, which triggers sweeping of timed out ones.
+	LEASE_SWEEP_THRESHOLD = 1024
+)
+
+// Structure of lease: permission of single client to recompute value of missed or stale item.
+type lease struct {
+	token int64
+	deadline int64
+}
+
+// Structure of leases of storage, which keeps their settings and statistic.
+type Leases struct {
+	leases map[string] lease
+	sweep int // number of leases, which triggers sweeping of timed out ones
+	// Time (in seconds), after which lease is released, if value wasn't stored with its token.
+	Timeout int64
+	// Last seconds of life of item, during which it is considered stale: value is still returned, but the first
+	// client gets lease to recompute it. Zero means that leases are granted only on misses.
+	Grace int64
+	granted uint64
+	waits uint64
+	stale uint64 // number of stale values returned to clients, which didn't get lease
+	released uint64
+	rejected uint64 // number of values, which weren't stored, since lease was lost
+}
+
+// Function creates leases with default timeout and without grace period.
+func NewLeases() *Leases {
+	return &Leases{leases: make(map[string] lease), sweep: LEASE_SWEEP_THRESHOLD, Timeout: DEFAULT_LEASE_TIMEOUT}
+}
+
+// Public method of leases, which returns true if passed item is stale: it's alive, but in grace period.
+func (l *Leases) Stale(item *LRUCacheItem) bool {
+	return item.Exptime != 0 && time.Now().Unix() >= item.Exptime - l.Grace
+}
+
+// Public method of leases, which grants lease for passed key, if it isn't held by other client.
+// Returns token of lease or zero if lease is held.
+// Stale value of item (if there is one) should be passed to count it in statistic, when lease isn't granted.
+func (l *Leases) Acquire(key string, stale bool) int64 {
+	now := time.Now().Unix()
+	if held, exists := l.leases[key]; exists && held.deadline > now {
+		if stale {
+			l.stale ++
+		} else {
+			l.waits ++
+		}
+		return 0
+	}
+	if len(l.leases) >= l.sweep {
+		for held_key, held := range l.leases {
+			if held.deadline <= now {
+				delete(l.leases, held_key)
+			}
+		}
+		l.sweep = 2 * len(l.leases)
+		if l.sweep < LEASE_SWEEP_THRESHOLD {
+			l.sweep = LEASE_SWEEP_THRESHOLD
+		}
+	}
+	granted := lease{token: tools.GenerateCasId(), deadline: now + l.Timeout}
+	for granted.token == 0 {
+		granted.token = tools.GenerateCasId()
+	}
+	l.leases[key] = granted
+	l.granted ++
+	return granted.token
+}
+
+// Public method of leases, which releases lease of passed key, if it's held with passed token.
+// Returns false if lease was lost (timed out or revoked), so value shouldn't be stored.
+func (l *Leases) Release(key string, token int64) bool {
+	held, exists := l.leases[key]
+	if !exists || held.token != token || held.deadline <= time.Now().Unix() {
+		l.rejected ++
+		return false
+	}
+	delete(l.leases, key)
+	l.released ++
+	return true
+}
+
+// Public method of leases, which revokes lease of passed key (e.g. when item is deleted),
+// so value computed by its holder won't be stored.
+func (l *Leases) Revoke(key string) {
+	delete(l.leases, key)
+}
+
+// Public method of leases, which revokes all leases.
+func (l *Leases) RevokeAll() {
+	l.leases = make(map[string] lease)
+	l.sweep = LEASE_SWEEP_THRESHOLD
+}
+
+// Function returns lines of statistic of leases: settings, number of held leases, granted leases, clients,
+// which were asked to wait or received stale value, and values stored or rejected by token of lease.
+func (l *Leases) Stats() []string {
+	return []string{
+		"lease_timeout " + tools.IntToString(l.Timeout),
+		"lease_grace " + tools.IntToString(l.Grace),
+		"leases_active " + tools.IntToString(int64(len(l.leases))),
+		"leases_granted " + tools.UIntToString(l.granted),
+		"leases_waits " + tools.UIntToString(l.waits),
+		"leases_stale " + tools.UIntToString(l.stale),
+		"leases_released " + tools.UIntToString(l.released),
+		"leases_rejected " + tools.UIntToString(l.rejected),
+	}
+}
+
+// Getter for leases field.
+func (c *LRUCache) Leases() *Leases {
+	return c.leases
+}
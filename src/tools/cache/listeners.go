@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"time"
+)
+
+// Kinds of events of storage, which are passed to listeners.
+const (
+	EVENT_SET = "set"
+	EVENT_DELETE = "delete"
+	EVENT_EVICT = "evict"
+	EVENT_EXPIRE = "expire"
+	EVENT_FLUSH = "flush"
+)
+
+// Interface of listener of storage events: storing of item (EVENT_SET), its deletion (EVENT_DELETE, including
+// invalidation by tag), eviction (EVENT_EVICT), expiration (EVENT_EXPIRE, including invalidation of namespace)
+// and flushing of all items (EVENT_FLUSH with empty key).
+// Changed is called under lock of storage, so it mustn't block or access the storage.
+type Listener interface {
+	Changed(event string, key string, size int)
+}
+
+// Public method of LRUCache, which registers listener of its events.
+func (c *LRUCache) AddListener(listener Listener) {
+	c.listeners = append(c.listeners, listener)
+}
+
+// Public method of LRUCache, which discards registration of listener.
+func (c *LRUCache) RemoveListener(listener Listener) {
+	for i, registered := range c.listeners {
+		if registered == listener {
+			c.listeners = append(c.listeners[ : i], c.listeners[i + 1 : ]...)
+			return
+		}
+	}
+}
+
+// Private method of LRUCache, which passes event of passed item (nil for EVENT_FLUSH) to listeners.
+func (c *LRUCache) emit(event string, item *LRUCacheItem) {
+	if len(c.listeners) == 0 {
+		return
+	}
+	var key string
+	var size int
+	if item != nil {
+		key, size = item.Cacheable.Key(), item.Cacheable.Size()
+	}
+	for _, listener := range c.listeners {
+		listener.Changed(event, key, size)
+	}
+}
+
+// Structure of mutation of storage, which is passed to observers. Unlike events of listeners, mutations are
+// reported by wrappers of storage (see package embedded), which made them, with data required to repeat them.
+type Mutation struct {
+	// Kind of mutation: "set", "append", "prepend", "touch", "delete", "flush_all",
+	// "ns_flush" (Key is name of namespace then) or "invalidate_tag" (Key is tag then).
+	Command string
+	Key string
+	// Resulting value of item for "set" and only concatenated data for "append" and "prepend".
+	Value []byte
+	// Value as chain of chunks, which is passed instead of Value for large values, so they aren't joined.
+	Chunks [][]byte
+	// Flags, expiration and tags of item (for "set" and "touch").
+	Flags uint32
+	Expiration time.Time
+	Tags []string
+}
+
+// Interface of observer of mutations (e.g. append-only log or replication).
+// Mutated is called under lock of storage in order of mutations, so it mustn't block or access the storage.
+// Expiration and eviction of items aren't reported.
+type Observer interface {
+	Mutated(mutation *Mutation)
+}
+
+// Interface of observer, which completes handling of mutations without lock of storage, e.g. syncs them to disk.
+// Committed is called after lock is released by method, which could make mutations, before it returns.
+type Committer interface {
+	Committed()
+}
+
+// Public method of LRUCache, which registers observer of its mutations. Observers are kept by storage,
+// so mutations made through any wrapper of the storage are reported to them.
+func (c *LRUCache) AddObserver(observer Observer) {
+	// list is copied, since it may be iterated without lock (see Observers).
+	observers := make([]Observer, 0, len(c.observers) + 1)
+	c.observers = append(append(observers, c.observers...), observer)
+}
+
+// Public method of LRUCache, which discards registration of observer.
+func (c *LRUCache) RemoveObserver(observer Observer) {
+	for i, registered := range c.observers {
+		if registered == observer {
+			observers := make([]Observer, 0, len(c.observers) - 1)
+			c.observers = append(append(observers, c.observers[ : i]...), c.observers[i + 1 : ]...)
+			return
+		}
+	}
+}
+
+// Getter for observers field. Returned list isn't modified later, so it may be iterated after lock is released.
+func (c *LRUCache) Observers() []Observer {
+	return c.observers
+}
@@ -1,9 +1,9 @@
 package cache
 
 import (
+	"container/list"
 	"time"
 	"errors"
-	"sync"
 )
 
 const (
@@ -16,6 +16,7 @@ type LRUCrawler struct {
 	sleep_period uint32
 	enabled bool
 	ItemsPerRun uint
+	run uint64 // identifier of current launch of main loop
 }
 
 // Crawler's constructor.
@@ -40,18 +41,18 @@ func (c *LRUCrawler) SetSleep(duration int) error {
 }
 
 // Function turns on crawler and runs main loop within thread.
+// Crawler can't be started if amount of items per run isn't specified.
+// If storage is shared among goroutines, its lock should be held by caller.
 func (c *LRUCache) EnableCrawler() error {
 	if c.Crawler.enabled {
 		return errors.New("Crawler is already in use.")
 	}
-	c.Crawler.enabled = true
-	var crawl_sync sync.WaitGroup
-	crawl_sync.Add(1)
-	go c.crawl(&crawl_sync)
-	crawl_sync.Wait()
-	if !c.Crawler.enabled {
+	if c.Crawler.ItemsPerRun == 0 {
 		return errors.New("Failed to start crawler.")
 	}
+	c.Crawler.enabled = true
+	c.Crawler.run ++
+	go c.crawl(c.Crawler.run)
 	return nil
 }
 
@@ -72,34 +73,37 @@ func (c *LRUCache) DisableCrawler() {
 
 // Function loops an infinite cycle and runs through the LRU cache by specified amount of items per loop,
 // then falls asleep specified amount of time and runs again, until enabled field will be false
-// whether other fields will be corrupted.
-func (c *LRUCache) crawl(w_group *sync.WaitGroup) {
-	defer w_group.Done()
-	defer c.DisableCrawler()
-	current_list_elem := c.list.Back()
-	if current_list_elem == nil || c.Crawler.ItemsPerRun == 0 {
-		return
-	}
-	w_group.Done()
-	w_group.Add(1) // to make counter positive
+// or crawler will be restarted (passed param run identifies current launch).
+// Lock of storage is held during each pass and released while crawler sleeps.
+func (c *LRUCache) crawl(run uint64) {
+	var current_list_elem *list.Element
 	for {
-		if !c.Crawler.enabled || c.Crawler.ItemsPerRun  == 0 {
+		c.Lock()
+		if c.Crawler.run != run {
+			c.Unlock()
+			return
+		}
+		if !c.Crawler.enabled || c.Crawler.ItemsPerRun == 0 {
 			c.DisableCrawler()
+			c.Unlock()
 			return
 		}
 		for i := uint(0); i < c.Crawler.ItemsPerRun; i ++ {
 			if current_list_elem != nil {
 				item := current_list_elem.Value
+				// element, which was removed from list, has no previous one, so crawler starts from the tail again.
+				current_list_elem = current_list_elem.Prev()
 				if item != nil {
 					if c.deleteExpired(item.(*LRUCacheItem).Cacheable) {
 						c.Stats.Crawler_reclaimed ++
 					}
 				}
-				current_list_elem = current_list_elem.Prev()
 			} else {
 				current_list_elem = c.list.Back()
 			}
 		}
-		time.Sleep(time.Microsecond * time.Duration(c.Crawler.sleep_period))
+		sleep := time.Microsecond * time.Duration(c.Crawler.sleep_period)
+		c.Unlock()
+		time.Sleep(sleep)
 	}
 }
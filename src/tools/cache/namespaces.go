@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"tools"
+)
+
+// Structure of namespace: its generation, which is increased by flushing, statistic of its current items
+// and number of items of previous generations, which weren't discarded yet.
+type namespace struct {
+	generation uint64
+	items int64
+	bytes int64
+	stale_items int64
+}
+
+// Structure of namespaces of storage. Key belongs to namespace, which is its prefix before delimiter.
+// Items of namespace remember its generation at the moment of storing, so flushing of namespace is done
+// by increasing its generation, and items of previous generations are discarded lazily (on retrieval or by crawler).
+// Namespace is forgotten along with its last item (of any generation), so number of namespaces is bounded
+// by number of stored items.
+type Namespaces struct {
+	delimiter string
+	namespaces map[string] *namespace
+	stale_items int64
+	stale_bytes int64
+}
+
+// Private method of LRUCache, which returns namespace of passed key or nil if key doesn't belong to any.
+// Namespace is created, if passed param create is true.
+func (c *LRUCache) namespace(key string, create bool) *namespace {
+	if c.ns == nil {
+		return nil
+	}
+	index := strings.Index(key, c.ns.delimiter)
+	if index <= 0 {
+		return nil
+	}
+	ns, exists := c.ns.namespaces[key[ : index]]
+	if !exists && create {
+		ns = new(namespace)
+		c.ns.namespaces[key[ : index]] = ns
+	}
+	return ns
+}
+
+// Private method of LRUCache, which binds item to the current generation of its namespace.
+func (c *LRUCache) nsAssign(item *LRUCacheItem) {
+	if ns := c.namespace(item.Cacheable.Key(), true); ns != nil {
+		item.generation = ns.generation
+	}
+}
+
+// Private method of LRUCache, which adds item to statistic of its namespace (sign = 1) or discounts it (sign = -1).
+// Items of previous generations are accounted as stale ones.
+func (c *LRUCache) nsAccount(item *LRUCacheItem, sign int64) {
+	ns := c.namespace(item.Cacheable.Key(), false)
+	if ns == nil {
+		return
+	}
+	size := int64(item.Cacheable.Size())
+	if item.generation == ns.generation {
+		ns.items += sign
+		ns.bytes += sign * size
+	} else {
+		ns.stale_items += sign
+		c.ns.stale_items += sign
+		c.ns.stale_bytes += sign * size
+	}
+}
+
+// Private method of LRUCache, which forgets namespace of passed key, if its last item was discarded.
+// Generation of namespace isn't needed then, since there are no items of previous generations.
+func (c *LRUCache) nsRelease(key string) {
+	ns := c.namespace(key, false)
+	if ns != nil && ns.items == 0 && ns.stale_items == 0 {
+		delete(c.ns.namespaces, key[ : strings.Index(key, c.ns.delimiter)])
+	}
+}
+
+// Public method of LRUCache, which returns true if passed item belongs to flushed generation of its namespace.
+func (c *LRUCache) Stale(item *LRUCacheItem) bool {
+	ns := c.namespace(item.Cacheable.Key(), false)
+	return ns != nil && item.generation != ns.generation
+}
+
+// Public method of LRUCache, which invalidates all items of passed namespace in constant time: generation
+// of namespace is increased, and items of previous one are discarded lazily (on retrieval or by crawler).
+// Unknown namespace (including forgotten one) has no items, so nothing is done and no entry is created for it.
+// Returns false if namespaces are turned off.
+func (c *LRUCache) FlushNamespace(name string) bool {
+	if c.ns == nil {
+		return false
+	}
+	ns, exists := c.ns.namespaces[name]
+	if !exists {
+		return true
+	}
+	ns.stale_items += ns.items
+	c.ns.stale_items += ns.items
+	c.ns.stale_bytes += ns.bytes
+	ns.items = 0
+	ns.bytes = 0
+	ns.generation ++
+	return true
+}
+
+// Public method of LRUCache, which turns on namespaces with passed delimiter between namespace and the rest of key;
+// empty delimiter turns them off. Statistic is rebuilt from currently stored items, and all of them become
+// items of the current generation of their namespaces.
+func (c *LRUCache) SetNamespaceDelimiter(delimiter string) {
+	if len(delimiter) == 0 {
+		c.ns = nil
+		return
+	}
+	c.ns = &Namespaces{delimiter: delimiter, namespaces: make(map[string] *namespace)}
+	for _, item := range c.items {
+		item.generation = 0
+		c.nsAssign(item)
+		c.nsAccount(item, 1)
+	}
+}
+
+// Public method of LRUCache, which returns lines of statistic of namespaces: number of items, their size
+// and number of flushes of each namespace (sorted by name), followed by number and size of flushed items,
+// which weren't discarded yet. Namespaces without items aren't listed. Returns nil if namespaces are turned off.
+func (c *LRUCache) NamespacesStats() []string {
+	if c.ns == nil {
+		return nil
+	}
+	names := make([]string, 0, len(c.ns.namespaces))
+	for name := range c.ns.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []string
+	for _, name := range names {
+		ns := c.ns.namespaces[name]
+		result = append(result, "ns:" + name + ":items " + tools.IntToString(ns.items),
+						"ns:" + name + ":bytes " + tools.IntToString(ns.bytes),
+						"ns:" + name + ":flushes " + tools.UIntToString(ns.generation))
+	}
+	return append(result, "ns_stale_items " + tools.IntToString(c.ns.stale_items),
+				  "ns_stale_bytes " + tools.IntToString(c.ns.stale_bytes))
+}
@@ -0,0 +1,90 @@
+package cache
+
+const (
+	// Defines the width of histogram's bucket in bytes, as it's done in memcached.
+	SIZES_BUCKET_WIDTH = 32
+)
+
+// Structure implements histogram of sizes of stored items.
+// Each bucket is identified by the upper bound of sizes, which it counts, and keeps number of items of such size.
+type SizesHistogram struct {
+	enabled bool
+	buckets map[int] uint64
+}
+
+// Constructor of disabled and empty histogram. As in memcached, histogram is turned off by default, since it's
+// updated on each storing and deletion of item (see EnableSizes).
+func newSizesHistogram() *SizesHistogram {
+	return &SizesHistogram{
+		enabled: false,
+		buckets: make(map[int] uint64),
+	}
+}
+
+// Function returns upper bound of bucket for passed size.
+func sizesBucket(size int) int {
+	bucket := size / SIZES_BUCKET_WIDTH
+	if size % SIZES_BUCKET_WIDTH != 0 {
+		bucket ++
+	}
+	return bucket * SIZES_BUCKET_WIDTH
+}
+
+// Private method, which counts item of passed size.
+func (h *SizesHistogram) add(size int) {
+	if h == nil || !h.enabled {
+		return
+	}
+	h.buckets[sizesBucket(size)] ++
+}
+
+// Private method, which discounts item of passed size.
+func (h *SizesHistogram) remove(size int) {
+	if h == nil || !h.enabled {
+		return
+	}
+	bucket := sizesBucket(size)
+	if h.buckets[bucket] <= 1 {
+		delete(h.buckets, bucket)
+	} else {
+		h.buckets[bucket] --
+	}
+}
+
+// Public method of LRUCache, which turns on histogram of item sizes.
+// Since histogram is maintained incrementally, it's rebuilt from currently stored items.
+func (c *LRUCache) EnableSizes() {
+	if c.sizes.enabled {
+		return
+	}
+	c.sizes.enabled = true
+	c.sizes.buckets = make(map[int] uint64)
+	for _, item := range c.items {
+		c.sizes.add(item.Cacheable.Size())
+	}
+}
+
+// Public method of LRUCache, which turns off histogram of item sizes and discards collected data.
+func (c *LRUCache) DisableSizes() {
+	c.sizes.enabled = false
+	c.sizes.buckets = make(map[int] uint64)
+}
+
+// Getter for enabled field of sizes histogram.
+func (c *LRUCache) SizesEnabled() bool {
+	return c.sizes.enabled
+}
+
+// Public method of LRUCache, which returns copy of sizes histogram:
+// upper bound of bucket (in bytes) is mapped to number of items.
+// Empty buckets are omitted; if histogram is disabled, function returns nil.
+func (c *LRUCache) Sizes() map[int] uint64 {
+	if !c.sizes.enabled {
+		return nil
+	}
+	result := make(map[int] uint64, len(c.sizes.buckets))
+	for bucket, number := range c.sizes.buckets {
+		result[bucket] = number
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package cache
+
+// Private method of LRUCache, which discards passed item from indexes of its tags.
+func (c *LRUCache) untag(item *LRUCacheItem) {
+	for _, tag := range item.tags {
+		if index := c.tags[tag]; index != nil {
+			delete(index, item)
+			if len(index) == 0 {
+				delete(c.tags, tag)
+			}
+		}
+	}
+	item.tags = nil
+}
+
+// Public method of LRUCache, which replaces tags of stored item with passed key; empty list discards them.
+// Tags are kept when item is updated by Set, and item is discarded from their indexes, when it's removed
+// from storage (deleted, evicted, expired or flushed).
+// Returns false if item doesn't exist.
+func (c *LRUCache) Tag(key string, tags []string) bool {
+	item, exists := c.items[key]
+	if !exists {
+		return false
+	}
+	c.untag(item)
+	for _, tag := range tags {
+		if c.tags == nil {
+			c.tags = make(map[string] map[*LRUCacheItem] bool)
+		}
+		index := c.tags[tag]
+		if index == nil {
+			index = make(map[*LRUCacheItem] bool)
+			c.tags[tag] = index
+		}
+		if !index[item] {
+			index[item] = true
+			item.tags = append(item.tags, tag)
+		}
+	}
+	return true
+}
+
+// Getter for tags field. Returned list mustn't be modified.
+func (item *LRUCacheItem) Tags() []string {
+	return item.tags
+}
+
+// Public method of LRUCache, which discards all items with passed tag.
+// Returns number of discarded items.
+func (c *LRUCache) InvalidateTag(tag string) int {
+	index := c.tags[tag]
+	count := len(index)
+	for item := range index {
+		c.emit(EVENT_DELETE, item)
+		c.unlink(item)
+	}
+	return count
+}
+
+// Public method of LRUCache, which returns number of tags in use and number of references of items to them.
+func (c *LRUCache) TagsCount() (tags int, references int) {
+	for _, index := range c.tags {
+		references += len(index)
+	}
+	return len(c.tags), references
+}
@@ -0,0 +1,129 @@
+package tools
+
+// Defines size of chunk, which values larger than it are split into.
+const CHUNK_SIZE = 512 * 1024
+
+// Interface of cacheable data, which exposes its value as chain of chunks.
+type Chunked interface {
+	Chunks() [][]byte
+}
+
+// The realization of Cacheable interface for large values, which are kept as chain of chunks,
+// so appending or prepending of data doesn't copy the whole value.
+// Chunks mustn't be modified after creation: they may be shared between versions of value.
+type ChunkedData struct {
+	chunks [][]byte
+	size int
+	key string
+}
+
+// The public method for generalization of interface.
+// Returns key of item.
+func (container ChunkedData) Key() string {
+	return container.key
+}
+
+// The public method for generalization of interface.
+// Returns amount of value's bytes.
+func (container ChunkedData) Size() int {
+	return container.size
+}
+
+// The public method of ChunkedData, which returns value joined into single byte-string.
+func (container ChunkedData) Value() []byte {
+	value := make([]byte, 0, container.size)
+	for _, chunk := range container.chunks {
+		value = append(value, chunk...)
+	}
+	return value
+}
+
+// The public method of ChunkedData, which returns chain of chunks of value.
+// Chunks are limited by their length, so appending to them doesn't affect stored value.
+func (container ChunkedData) Chunks() [][]byte {
+	result := make([][]byte, len(container.chunks))
+	for i, chunk := range container.chunks {
+		result[i] = chunk[ : len(chunk) : len(chunk)]
+	}
+	return result
+}
+
+// Function creates instance of ChunkedData from received chain of chunks and key.
+func NewChunkedData(chunks [][]byte, key string) ChunkedData {
+	var size = 0
+	result := make([][]byte, 0, len(chunks))
+	for _, chunk := range chunks {
+		if len(chunk) > 0 {
+			result = append(result, chunk[ : len(chunk) : len(chunk)])
+			size += len(chunk)
+		}
+	}
+	return ChunkedData{chunks: result, size: size, key: key}
+}
+
+// Function splits passed value into chunks of CHUNK_SIZE bytes without copying.
+func Chunk(value []byte) [][]byte {
+	var chunks = make([][]byte, 0, len(value) / CHUNK_SIZE + 1)
+	for len(value) > CHUNK_SIZE {
+		chunks = append(chunks, value[ : CHUNK_SIZE : CHUNK_SIZE])
+		value = value[CHUNK_SIZE : ]
+	}
+	return append(chunks, value[ : len(value) : len(value)])
+}
+
+// Function is supposed to extract chain of chunks from Cacheable interface, which implements Chunked or Valuable
+// interface; value of Valuable is returned as single chunk.
+// If it is impossible there will be returned a nil.
+func ExtractChunks(object interface {}) [][]byte {
+	if chunked, ok := object.(Chunked); ok {
+		return chunked.Chunks()
+	}
+	if value := ExtractStoredData(object); value != nil {
+		return [][]byte{value}
+	}
+	return nil
+}
+
+// The public method of ChunkedData, which returns new version of value with passed chunks of data appended
+// (or prepended). Chunks of this version are shared with the new one, so only passed data is copied:
+// appended data fills the last chunk up to CHUNK_SIZE in place and the rest goes to new chunks of CHUNK_SIZE.
+// Since bytes are written only beyond length of existing versions, they aren't affected.
+// Only the latest version of value should be concatenated.
+func (container ChunkedData) Concat(data [][]byte, prepend bool) ChunkedData {
+	result := ChunkedData{size: container.size, key: container.key}
+	if prepend {
+		result.chunks = make([][]byte, 0, len(data) + len(container.chunks))
+		for _, piece := range data {
+			if len(piece) > 0 {
+				result.chunks = append(result.chunks, Chunk(piece)...)
+				result.size += len(piece)
+			}
+		}
+		result.chunks = append(result.chunks, container.chunks...)
+		return result
+	}
+	result.chunks = make([][]byte, len(container.chunks), len(container.chunks) + len(data) + 1)
+	copy(result.chunks, container.chunks)
+	for _, piece := range data {
+		result.size += len(piece)
+		for len(piece) > 0 {
+			last := len(result.chunks) - 1
+			if last < 0 || len(result.chunks[last]) >= CHUNK_SIZE {
+				result.chunks = append(result.chunks, make([]byte, 0, CHUNK_SIZE))
+				last ++
+			} else if cap(result.chunks[last]) < CHUNK_SIZE {
+				// chunk, which may be shared with caller, is copied once into own chunk of full size.
+				chunk := make([]byte, len(result.chunks[last]), CHUNK_SIZE)
+				copy(chunk, result.chunks[last])
+				result.chunks[last] = chunk
+			}
+			room := CHUNK_SIZE - len(result.chunks[last])
+			if room > len(piece) {
+				room = len(piece)
+			}
+			result.chunks[last] = append(result.chunks[last], piece[ : room]...)
+			piece = piece[room : ]
+		}
+	}
+	return result
+}
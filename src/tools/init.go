@@ -5,7 +5,6 @@ package tools
 
 import (
 	"strconv"
-	"reflect"
 	"time"
 	"math/rand"
 )
@@ -13,6 +12,11 @@ import (
 // Current version string.
 const VERSION = "MemoranGo v1.01"
 
+// Interface of cacheable data, which exposes its value as byte-string.
+type Valuable interface {
+	Value() []byte
+}
+
 // The realization of Cacheable interface.
 type StoredData struct {
 	value []byte
@@ -80,12 +84,11 @@ func UIntToString(num uint64) string {
 	return strconv.FormatUint(num, 10)
 }
 
-// Function is supposed to convert data from (primary) Cacheable interface or any other interface, which was generalized
-// by StoredData type, back to StoredData and return byte-string value of it.
+// Function is supposed to extract byte-string value from (primary) Cacheable interface or any other interface,
+// which implements Valuable interface (e.g. StoredData).
 // If it is impossible there will be returned a nil.
 func ExtractStoredData(object interface {}) []byte {
-	if reflect.TypeOf(object) == reflect.TypeOf(StoredData{}){
-		val, _ := object.(StoredData)
+	if val, ok := object.(Valuable); ok {
 		return val.Value()
 	}
 	return nil
@@ -3,6 +3,7 @@ package protocol
 import (
 	"tools"
 	"strings"
+	"strconv"
 	"errors"
 )
 
@@ -15,12 +16,37 @@ const (
 	STORED = "STORED\r\n"
 	NOT_STORED = "NOT_STORED\r\n"
 	EXIST = "EXISTS\r\n"
+	DELETED = "DELETED\r\n"
+	TOUCHED = "TOUCHED\r\n"
+	OK = "OK\r\n"
+	END = "END\r\n"
+	RESET = "RESET\r\n"
+	// Error responses, which are worded as in memcached.
+	BAD_FORMAT = "CLIENT_ERROR bad command line format\r\n"
+	BAD_DELETE_FORMAT = "CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n"
+	BAD_DATA_CHUNK = "CLIENT_ERROR bad data chunk\r\n"
+	INVALID_DELTA = "CLIENT_ERROR invalid numeric delta argument\r\n"
+	INVALID_EXPTIME = "CLIENT_ERROR invalid exptime argument\r\n"
+	NON_NUMERIC = "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	OUT_OF_MEMORY = "SERVER_ERROR out of memory storing object\r\n"
+	// Prefixes of lines of retrieved items, statistic and version.
+	VALUE_PREFIX = "VALUE "
+	STAT_PREFIX = "STAT "
+	VERSION_PREFIX = "VERSION "
+	// Prefixes of lines of lease-get, which report granted lease, stale value or held lease of missing item.
+	LEASE_PREFIX = "LEASE "
+	STALE_PREFIX = "STALE "
+	WAIT_PREFIX = "WAIT "
+	// Defines the maximal length of key.
+	MAX_KEY_LENGTH = 250
 )
 
 // Specified groups of commands, which are helpful for destination handling of request.
-var storage_commands = []string{"set", "add", "replace", "append", "prepend", "cas",}
-var retrieve_commands = []string{"get", "gets",}
-var other_commands = []string{"delete", "touch", "flush_all", "version", "quit", "incr", "decr", "stats", "lru_crawler"}
+var storage_commands = []string{"set", "add", "replace", "append", "prepend", "cas", "lset",}
+var retrieve_commands = []string{"get", "gets", "lget",}
+var other_commands = []string{"delete", "touch", "flush_all", "version", "quit", "incr", "decr", "stats", "lru_crawler",
+							  "replicate", "ns_flush", "invalidate_tag", "subscribe",
+							  "watch"}
 
 // Enumeration of protocol tokens.
 type Ascii_protocol_enum struct {
@@ -33,6 +59,8 @@ type Ascii_protocol_enum struct {
 	noreply bool		// optional parameter instructs the server to not send the reply.
 	data_string []byte	// chunk of arbitrary 8-bit data of length <bytes>
 	error string		// error, which appears when something goes wrong, normally is empty string ""
+	chunks [][]byte		// data of length <bytes> as chain of chunks, if it was received so
+	tags []string		// optional tags of stored item, passed as "tags=<tag>[,<tag>...]".
 }
 
 // Public function, which parse string of input data by tokens of protocol's header and join them into one enumeration.
@@ -53,8 +81,18 @@ func ParseProtocolHeader(header string) *Ascii_protocol_enum{
 			return &Ascii_protocol_enum{error: ERROR_TEMP}
 		}
 	} else {
-		return &Ascii_protocol_enum{error: strings.Replace(CLIENT_ERROR_TEMP, "%s", "Input command line is empty", 1)}
+		return &Ascii_protocol_enum{error: ERROR_TEMP}
+	}
+}
+
+// Function checks that passed keys aren't longer than MAX_KEY_LENGTH.
+func validKeys(keys []string) bool {
+	for _, key := range keys {
+		if len(key) > MAX_KEY_LENGTH {
+			return false
+		}
 	}
+	return true
 }
 
 // Function for parsing of storage group of commands.
@@ -69,21 +107,33 @@ func parseStorageCommands(args []string/*, data_block string*/) *Ascii_protocol_
 	//protocol.data_string = []byte(data_block)
 	protocol.command = args[0]
 	protocol.key = []string{args[1],}
-	protocol.flags, err = tools.StringToInt32(args[2])
-	protocol.exptime, err = tools.StringToInt64(args[3])
-	protocol.exptime = tools.ToTimeStampFromNow(protocol.exptime)
-	protocol.bytes, err = tools.StringToInt32(args[4])
-	if args[0] == "cas" {
-		if len(args) < 6 {
-			err = errors.New("invalid arguments number")
-		}
-		protocol.cas_unique, err = tools.StringToInt64(args[5])
-		if len(args) == 7 {
-			protocol.noreply = (args[6] == "noreply")
+	// token of lease is passed to lset in place of cas unique.
+	if (args[0] == "cas" || args[0] == "lset") && len(args) < 6 {
+		return &Ascii_protocol_enum{error: ERROR_TEMP}
+	}
+	flags, flags_err := strconv.ParseUint(args[2], 10, 32)
+	exptime, exptime_err := tools.StringToInt64(args[3])
+	length, length_err := tools.StringToInt32(args[4])
+	if flags_err != nil || exptime_err != nil || length_err != nil || length < 0 || !validKeys(protocol.key) {
+		return &Ascii_protocol_enum{error: BAD_FORMAT}
+	}
+	protocol.flags, protocol.bytes = int(flags), length
+	protocol.exptime = tools.ToTimeStampFromNow(exptime)
+	var optional = args[5 : ]
+	if args[0] == "cas" || args[0] == "lset" {
+		if protocol.cas_unique, err = tools.StringToInt64(args[5]); err != nil {
+			return &Ascii_protocol_enum{error: BAD_FORMAT}
 		}
-	} else {
-		if len(args) == 6 {
-			protocol.noreply = (args[5] == "noreply")
+		optional = args[6 : ]
+	}
+	for _, arg := range optional {
+		if arg == "noreply" {
+			protocol.noreply = true
+		} else if strings.HasPrefix(arg, "tags=") && len(arg) > len("tags=") {
+			protocol.tags = strings.Split(arg[len("tags=") : ], ",")
+			if tools.In("", protocol.tags) {
+				err = errors.New("invalid tags")
+			}
 		}
 	}
 	if err != nil {
@@ -100,6 +150,9 @@ func parseRetrieveCommands(args []string) *Ascii_protocol_enum {
 	if len(args) < 2 || tools.In("", args) {
 		return &Ascii_protocol_enum{error: ERROR_TEMP}
 	}
+	if !validKeys(args[1 : ]) {
+		return &Ascii_protocol_enum{error: BAD_FORMAT}
+	}
 	protocol.command = args[0]
 	protocol.noreply = false
 	protocol.key = args[1 : ]
@@ -122,6 +175,10 @@ func parseOtherCommands(args []string) *Ascii_protocol_enum {
 	case "delete":
 		if len(args) < 2 {
 			err = errors.New("invalid arguments number")
+		} else if len(args) > 2 && !(len(args) == 3 && (args[2] == "0" || protocol.noreply) ||
+								  len(args) == 4 && args[2] == "0" && protocol.noreply) {
+			// the only allowed argument after key besides noreply is legacy zero hold time.
+			return &Ascii_protocol_enum{error: BAD_DELETE_FORMAT, noreply: protocol.noreply}
 		} else {
 			protocol.key = []string{args[1], }
 		}
@@ -130,11 +187,15 @@ func parseOtherCommands(args []string) *Ascii_protocol_enum {
 			err = errors.New("invalid arguments number")
 		} else {
 			protocol.key = []string{args[1], }
-			protocol.exptime, err = tools.StringToInt64(args[2])
+			if protocol.exptime, err = tools.StringToInt64(args[2]); err != nil {
+				return &Ascii_protocol_enum{error: INVALID_EXPTIME, noreply: protocol.noreply}
+			}
 		}
 	case "flush_all":
-		if len(args) >= 2 {
-			protocol.exptime, err = tools.StringToInt64(args[1])
+		if len(args) >= 2 && args[1] != "noreply" {
+			if protocol.exptime, err = tools.StringToInt64(args[1]); err != nil {
+				return &Ascii_protocol_enum{error: BAD_FORMAT, noreply: protocol.noreply}
+			}
 		}
 	case "incr", "decr":
 		if len(args) < 3 {
@@ -144,7 +205,13 @@ func parseOtherCommands(args []string) *Ascii_protocol_enum {
 			protocol.data_string = []byte(args[2])
 		}
 		protocol.noreply = (args[len(args) - 1] == "noreply")
-	case "stats":
+	case "ns_flush", "invalidate_tag":
+		if len(args) < 2 || args[1] == "noreply" {
+			err = errors.New("invalid arguments number")
+		} else {
+			protocol.key = []string{args[1], }
+		}
+	case "stats", "subscribe", "watch":
 		protocol.key = args[1:]
 	case "lru_crawler":
 		if len(args) < 2 {
@@ -158,5 +225,8 @@ func parseOtherCommands(args []string) *Ascii_protocol_enum {
 	if err != nil {
 		return &Ascii_protocol_enum{error: ERROR_TEMP}
 	}
+	if tools.In(protocol.command, []string{"delete", "touch", "incr", "decr"}) && !validKeys(protocol.key) {
+		return &Ascii_protocol_enum{error: BAD_FORMAT}
+	}
 	return protocol
 }
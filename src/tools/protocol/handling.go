@@ -1,11 +1,18 @@
 package protocol
 
 import (
+	"bytes"
+	"embedded"
+	"io"
+	"net"
+	"net/url"
 	"tools/cache"
 	"tools/stat"
 	"tools"
 	"strings"
 	"errors"
+	"sort"
+	"time"
 )
 
 // Public method of Ascii_protocol_enum operates with received storage: retrieves, discards, sets or updates items,
@@ -14,10 +21,28 @@ import (
 // of processing request.
 // Returns response to client as byte-string and error/nil.
 // If process was successful, there will be returned nil instead of error, otherwise it will be returned specified error.
-func (enum *Ascii_protocol_enum) HandleRequest(storage *cache.LRUCache, stats *stat.ServerStat) ([]byte, error) {
+// Storage is accessed through passed embedded cache, so request may be handled concurrently with others and
+// observers of its storage (append-only log, replicas) are notified about mutations.
+func (enum *Ascii_protocol_enum) Handle(storage *embedded.Cache, stats *stat.ServerStat) ([]byte, error) {
+	buffers, err := enum.HandleBuffers(storage, stats)
+	if buffers == nil {
+		return nil, err
+	}
+	return bytes.Join(buffers, nil), err
+}
+
+// Public method of Ascii_protocol_enum, which does the same as Handle, but returns response as sequence of buffers:
+// values of retrieved items are passed as chains of their chunks, so response may be written to connection
+// (e.g. by WriteTo method of net.Buffers) without joining of large values.
+func (enum *Ascii_protocol_enum) HandleBuffers(storage *embedded.Cache, stats *stat.ServerStat) (net.Buffers, error) {
 	var err error
 	if len(enum.error) > 0 {
-		return []byte(enum.error), nil
+		return net.Buffers{[]byte(enum.error)}, nil
+	}
+	// commands below address items by key, so enumeration without keys is rejected.
+	if len(enum.key) == 0 && (tools.In(enum.command, storage_commands) || tools.In(enum.command, retrieve_commands) ||
+	   tools.In(enum.command, []string{"delete", "touch", "incr", "decr", "ns_flush", "invalidate_tag", "lru_crawler"})) {
+		return net.Buffers{[]byte(ERROR_TEMP)}, nil
 	}
 	var result string
 	switch enum.command {
@@ -25,6 +50,8 @@ func (enum *Ascii_protocol_enum) HandleRequest(storage *cache.LRUCache, stats *s
 		result, err = enum.set(storage)
 	case "cas":
 		result, err = enum.cas(storage)
+	case "lset":
+		result, err = enum.lset(storage)
 	case "add":
 		result, err = enum.add(storage)
 	case "replace":
@@ -37,191 +64,246 @@ func (enum *Ascii_protocol_enum) HandleRequest(storage *cache.LRUCache, stats *s
 		result, err = enum.fold(storage, 1)
 	case "decr":
 		result, err = enum.fold(storage, -1)
-	case "get":
-		result, err = enum.get(storage, false)
-	case "gets":
-		result, err = enum.get(storage, true)
+	case "get", "gets":
+		buffers := enum.get(storage, stats, enum.command == "gets")
+		if stats != nil {
+			// response of missed items consists of END only.
+			if len(buffers) == 1 {
+				result = END
+			}
+			enum.RecordStats(stats, result)
+		}
+		return buffers, nil
+	case "lget":
+		return enum.lget(storage, stats), nil
 	case "touch":
 		result, err = enum.touch(storage)
 	case "delete":
 		result, err = enum.delete(storage)
 	case "flush_all":
 		result, err = enum.flush_all(storage)
+	case "ns_flush":
+		result, err = enum.ns_flush(storage)
+	case "invalidate_tag":
+		result, err = enum.invalidate_tag(storage)
 	case "lru_crawler":
-		return []byte(enum.lru_crawler(storage)), nil
+		if enum.Metadump() {
+			var buffer bytes.Buffer
+			WriteMetadump(&buffer, storage)
+			return net.Buffers{buffer.Bytes()}, nil
+		}
+		storage.Locked(func(storage *cache.LRUCache) {
+			result = enum.lru_crawler(storage)
+		})
+		return net.Buffers{[]byte(result)}, nil
 	case "stats":
 		if stats != nil {
-			return []byte(enum.stat(storage, stats)), nil
+			storage.Locked(func(storage *cache.LRUCache) {
+				result = enum.stat(storage, stats)
+			})
+			return net.Buffers{[]byte(result)}, nil
 		} else {
 			return nil, errors.New("Statistic is not supported.")
 		}
 	case "version":
-		return []byte("VERSION " + tools.VERSION + "\r\n"), nil
+		return net.Buffers{[]byte(VERSION_PREFIX + tools.VERSION + "\r\n")}, nil
+	case "replicate", "subscribe", "watch":
+		// streams of replication, events and log are served by server itself.
+		return net.Buffers{[]byte(ERROR_TEMP)}, nil
 	case "quit":
 		return nil, errors.New("Exit.")
 	}
 	if stats != nil {
 		enum.RecordStats(stats, result)
 	}
-	return []byte(result), err
+	return net.Buffers{[]byte(result)}, err
 }
 
 // Storage commands
 
-// Implements set method
-func (enum *Ascii_protocol_enum) set(storage *cache.LRUCache) (string, error){
-	if storage.Set(tools.NewStoredData(enum.data_string, enum.key[0]), enum.flags, enum.exptime, 0) {
-		return STORED, nil
-	} else {
-		return strings.Replace(SERVER_ERROR_TEMP, "%s", "Not enough memory", 1), errors.New("SERVER_ERROR")
+// Function converts error of storage into response of protocol.
+// Passed param missed is the response, which is sent if item is missing or wasn't stored.
+func response(err error, success string, missed string) (string, error) {
+	switch err {
+	case nil:
+		return success, nil
+	case embedded.ErrNoMemory:
+		return OUT_OF_MEMORY, errors.New("SERVER_ERROR")
+	case embedded.ErrNotNumeric:
+		return NON_NUMERIC, nil
+	case embedded.ErrExists:
+		return EXIST, nil
+	default:
+		return missed, nil
 	}
 }
 
-// Implements add method
-func (enum *Ascii_protocol_enum) add(storage *cache.LRUCache) (string, error) {
-	if storage.Get(enum.key[0]) != nil {
-		return NOT_STORED, nil
+// Function builds item of storage from fields of enumeration.
+func (enum *Ascii_protocol_enum) item() *embedded.Item {
+	item := &embedded.Item{
+		Key: enum.key[0],
+		Value: enum.data_string,
+		Chunks: enum.chunks,
+		Flags: uint32(enum.flags),
+		Cas: uint64(enum.cas_unique),
+		Tags: enum.tags,
 	}
-	return enum.set(storage)
+	if enum.exptime != 0 {
+		item.Expiration = time.Unix(enum.exptime, 0)
+	}
+	return item
 }
 
-// Utility method, for joining common parts of prepend/append methods.
-// Receives additional parameters: existing_item - item retrieved from cache, uses for inheritance such params as flags,
-// cas, exptime; pending_data - new concatenated data.
-func (enum *Ascii_protocol_enum) pending(storage *cache.LRUCache,
-										 existed_item *cache.LRUCacheItem, pending_data []byte) (string, error) {
-	enum.bytes = len(pending_data)
-	enum.SetData(pending_data)
-	enum.exptime = existed_item.Exptime
-	enum.cas_unique = existed_item.Cas_unique
-	enum.flags = existed_item.Flags
-	return enum.set(storage)
+// Implements set method
+func (enum *Ascii_protocol_enum) set(storage *embedded.Cache) (string, error){
+	return response(storage.Set(enum.item()), STORED, NOT_STORED)
 }
 
-// Implements prepend method
-func (enum *Ascii_protocol_enum) prepend(storage *cache.LRUCache) (string, error) {
-	existed_item := storage.Get(enum.key[0])
-	if existed_item == nil {
-		return NOT_STORED, nil
-	}
-	existed_data := tools.ExtractStoredData(existed_item.Cacheable)
-	if existed_data == nil {
-		return NOT_STORED, nil
-	}
+// Implements add method
+func (enum *Ascii_protocol_enum) add(storage *embedded.Cache) (string, error) {
+	return response(storage.Add(enum.item()), STORED, NOT_STORED)
+}
 
-	return enum.pending(storage, existed_item, append(enum.data_string, existed_data...)) // some kind of golang magic
+// Implements prepend method
+func (enum *Ascii_protocol_enum) prepend(storage *embedded.Cache) (string, error) {
+	return response(storage.PrependChunks(enum.key[0], enum.data()), STORED, NOT_STORED)
 }
 
 // Implements append method
-func (enum *Ascii_protocol_enum) append(storage *cache.LRUCache) (string, error) {
-	existed_item := storage.Get(enum.key[0])
-	if existed_item == nil {
-		return NOT_STORED, nil
-	}
-	existed_data := tools.ExtractStoredData(existed_item.Cacheable)
-	if existed_data == nil {
-		return NOT_STORED, nil
-	}
-	return enum.pending(storage, existed_item, append(existed_data, enum.data_string...)) // ...
+func (enum *Ascii_protocol_enum) append(storage *embedded.Cache) (string, error) {
+	return response(storage.AppendChunks(enum.key[0], enum.data()), STORED, NOT_STORED)
 }
 
 // Implements replace method
-func (enum *Ascii_protocol_enum) replace(storage *cache.LRUCache) (string, error) {
-	if storage.Get(enum.key[0]) == nil {
-		return NOT_STORED, nil
-	}
-	return enum.set(storage)
+func (enum *Ascii_protocol_enum) replace(storage *embedded.Cache) (string, error) {
+	return response(storage.Replace(enum.item()), STORED, NOT_STORED)
 }
 
 // Implementation of Check And Set method
-func (enum *Ascii_protocol_enum) cas(storage *cache.LRUCache) (string, error) {
-	existed_item := storage.Get(enum.key[0])
-	if existed_item != nil {
-		if existed_item.Cas_unique == enum.cas_unique && existed_item.Cas_unique != 0{
-			return enum.set(storage)
-		}
-	}
-	return NOT_FOUND, nil
+func (enum *Ascii_protocol_enum) cas(storage *embedded.Cache) (string, error) {
+	return response(storage.CompareAndSwap(enum.item()), STORED, NOT_FOUND)
+}
+
+// Implements storing of value with token of lease
+func (enum *Ascii_protocol_enum) lset(storage *embedded.Cache) (string, error) {
+	return response(storage.LeaseSet(enum.item(), uint64(enum.cas_unique)), STORED, NOT_STORED)
 }
 
 // Retrieving commands
 
 // Implements get method
+// Passed stats param (possibly nil) is used for recording of per-prefix statistic.
 // Passed boolean param cas - defines of returning cas_unique
-func (enum *Ascii_protocol_enum) get(storage *cache.LRUCache, cas bool) (string, error) {
-	var result = ""
+// Response consists of header, chunks of value and terminator of each found item, followed by END.
+func (enum *Ascii_protocol_enum) get(storage *embedded.Cache, stats *stat.ServerStat, cas bool) net.Buffers {
+	var result net.Buffers
 	for _, value := range enum.key{
-		item := storage.Get(value)
-		if item != nil {
-			data := tools.ExtractStoredData(item.Cacheable)
-			if data == nil {
-				continue
+		item, err := storage.GetChunked(value)
+		if stats != nil {
+			if stats.Detail != nil {
+				stats.Detail.RecordGet(value, err == nil)
 			}
-			result += "VALUE " + value + " " + tools.IntToString(int64(item.Flags)) + " " + tools.IntToString(int64(len(data)))
-			if cas {
-				cas_id := tools.GenerateCasId()
-				storage.SetCas(value, cas_id)
-				result += " " + tools.IntToString(cas_id)
+			stats.HotReads.Touch(value)
+		}
+		if err != nil {
+			continue
+		}
+		var size = 0
+		for _, chunk := range item.Chunks {
+			size += len(chunk)
+		}
+		header := VALUE_PREFIX + value + " " + tools.UIntToString(uint64(item.Flags)) + " " + tools.IntToString(int64(size))
+		if cas {
+			header += " " + tools.UIntToString(item.Cas)
+		}
+		result = append(result, []byte(header + "\r\n"))
+		result = append(result, item.Chunks...)
+		result = append(result, []byte("\r\n"))
+	}
+	return append(result, []byte(END))
+}
+
+// Implements get method with leases.
+// Found item is returned as by get; if it's missing or stale, item is followed by "LEASE <key> <token>" line,
+// when lease was granted, or by "STALE <key>" line (if item is stale) or "WAIT <key>" line (if item is missing),
+// when lease is held by other client.
+func (enum *Ascii_protocol_enum) lget(storage *embedded.Cache, stats *stat.ServerStat) net.Buffers {
+	var result net.Buffers
+	for _, key := range enum.key {
+		lease := storage.LeaseGet(key)
+		if stats != nil {
+			if stats.Detail != nil {
+				stats.Detail.RecordGet(key, lease.Item != nil)
 			}
-			result += "\r\n"
-			result += string(data) + "\r\n"
+			stats.HotReads.Touch(key)
+		}
+		if lease.Item != nil {
+			result = append(result, []byte(VALUE_PREFIX + key + " " + tools.UIntToString(uint64(lease.Item.Flags)) + " " +
+											tools.IntToString(int64(len(lease.Item.Value))) + "\r\n"),
+							lease.Item.Value, []byte("\r\n"))
+		}
+		switch {
+		case lease.Token != 0:
+			result = append(result, []byte(LEASE_PREFIX + key + " " + tools.UIntToString(lease.Token) + "\r\n"))
+		case lease.Stale:
+			result = append(result, []byte(STALE_PREFIX + key + "\r\n"))
+		case lease.Item == nil:
+			result = append(result, []byte(WAIT_PREFIX + key + "\r\n"))
 		}
 	}
-	return result + "END\r\n", nil
+	return append(result, []byte(END))
 }
 
 // Other commands
 
 // Implements touch method
-func (enum *Ascii_protocol_enum) touch(storage *cache.LRUCache) (string, error) {
-	if item := storage.Get(enum.key[0]); item == nil {
-		return NOT_FOUND, nil
-	} else {
-		if !storage.Set(item.Cacheable, item.Flags, enum.exptime, item.Cas_unique){
-			return strings.Replace(SERVER_ERROR_TEMP, "%s", "Not enough memory", 1), errors.New("SERVER_ERROR")
-		}
-		return "TOUCHED\r\n", nil
+func (enum *Ascii_protocol_enum) touch(storage *embedded.Cache) (string, error) {
+	var expiration time.Time
+	if enum.exptime != 0 {
+		expiration = time.Unix(enum.exptime, 0)
 	}
+	return response(storage.TouchAt(enum.key[0], expiration), TOUCHED, NOT_FOUND)
 }
 
 // Implements delete method
-func (enum *Ascii_protocol_enum) delete(storage *cache.LRUCache) (string, error) {
-	if storage.Flush(enum.key[0]){
-		return "DELETED\r\n", nil
-	}
-	return NOT_FOUND, nil
+func (enum *Ascii_protocol_enum) delete(storage *embedded.Cache) (string, error) {
+	return response(storage.Delete(enum.key[0]), DELETED, NOT_FOUND)
 }
 
 // Implements flush all method
-func (enum *Ascii_protocol_enum) flush_all(storage *cache.LRUCache) (string, error) {
+func (enum *Ascii_protocol_enum) flush_all(storage *embedded.Cache) (string, error) {
 	storage.FlushAll()
-	return "OK\r\n", nil
+	return OK, nil
+}
+
+// Implements flush of namespace
+func (enum *Ascii_protocol_enum) ns_flush(storage *embedded.Cache) (string, error) {
+	if !storage.FlushNamespace(enum.key[0]) {
+		return strings.Replace(CLIENT_ERROR_TEMP, "%s", "Namespaces are turned off.", 1), nil
+	}
+	return OK, nil
+}
+
+// Implements invalidation of items with tag
+func (enum *Ascii_protocol_enum) invalidate_tag(storage *embedded.Cache) (string, error) {
+	storage.InvalidateTag(enum.key[0])
+	return OK, nil
 }
 
 // Utility method, for joining common parts of incr/decr methods.
 // Receives additional param sign, which defines operation: -1 or 1
-func (enum *Ascii_protocol_enum) fold(storage *cache.LRUCache, sign int) (string, error) {
-	if item := storage.Get(enum.key[0]); item != nil && (sign == 1 || sign == -1) {
-		existed_data := tools.ExtractStoredData(item.Cacheable)
-		if existed_data != nil {
-			evaluated_data_for_existed, err_for_existed := tools.StringToInt64(string(existed_data))
-			evaluated_data_for_passed, err_for_passed := tools.StringToUInt64(string(enum.data_string))
-			if err_for_existed == nil && err_for_passed == nil {
-				var result string
-				if sign > 0 {
-					result = tools.IntToString(evaluated_data_for_existed + int64(evaluated_data_for_passed))
-				} else {
-					result = tools.IntToString(evaluated_data_for_existed - int64(evaluated_data_for_passed))
-				}
-				if storage.Set(tools.NewStoredData([]byte(result), enum.key[0]), item.Flags, item.Exptime, 0) {
-					return result+"\r\n", nil
-				}
-				return strings.Replace(SERVER_ERROR_TEMP, "%s", "Not enough memory", 1), errors.New("SERVER_ERROR")
-			}
-			return ERROR_TEMP, nil
-		}
+func (enum *Ascii_protocol_enum) fold(storage *embedded.Cache, sign int) (string, error) {
+	delta, err := tools.StringToUInt64(string(enum.data_string))
+	if err != nil {
+		return INVALID_DELTA, nil
 	}
-	return NOT_FOUND, nil
+	var value uint64
+	if sign > 0 {
+		value, err = storage.Increment(enum.key[0], delta)
+	} else {
+		value, err = storage.Decrement(enum.key[0], delta)
+	}
+	return response(err, tools.UIntToString(value) + "\r\n", NOT_FOUND)
 }
 
 // Implements fetching of statistic without arguments.
@@ -229,13 +311,13 @@ func (enum *Ascii_protocol_enum) stat(storage *cache.LRUCache, stats *stat.Serve
 	var result = ""
 	if len(enum.key) == 0 {
 		for key, value := range stats.Serialize(storage) {
-			result += "STAT " + key + " " + value + "\r\n"
+			result += STAT_PREFIX + key + " " + value + "\r\n"
 		}
 	} else {
 		switch enum.key[0] {
 		case "settings":
 			for key, value := range stats.Settings(storage) {
-				result += "STAT " + key + " " + value + "\r\n"
+				result += STAT_PREFIX + key + " " + value + "\r\n"
 			}
 		case "items":
 			for key, value := range stats.Items(storage) {
@@ -243,11 +325,124 @@ func (enum *Ascii_protocol_enum) stat(storage *cache.LRUCache, stats *stat.Serve
 			}
 		case "conns":
 			for _, value := range stats.Conns() {
-				result += "STAT " + value + "\r\n"
+				result += STAT_PREFIX + value + "\r\n"
+			}
+		case "slowlog":
+			for _, value := range stats.SlowLogEntries() {
+				result += STAT_PREFIX + value + "\r\n"
 			}
+		case "reset":
+			stats.Reset(storage)
+			return RESET
+		case "detail":
+			return enum.stat_detail(stats)
+		case "sizes":
+			sizes := stats.Sizes(storage)
+			var buckets []int
+			for key := range sizes {
+				if bucket, err := tools.StringToInt32(key); err == nil {
+					buckets = append(buckets, bucket)
+				} else {
+					result += STAT_PREFIX + key + " " + sizes[key] + "\r\n"
+				}
+			}
+			sort.Ints(buckets)
+			for _, bucket := range buckets {
+				key := tools.IntToString(int64(bucket))
+				result += STAT_PREFIX + key + " " + sizes[key] + "\r\n"
+			}
+		case "hotkeys":
+			number := stat.DEFAULT_HOTKEYS_NUMBER
+			if len(enum.key) > 1 {
+				var err error
+				number, err = tools.StringToInt32(enum.key[1])
+				if err != nil || number <= 0 {
+					return strings.Replace(CLIENT_ERROR_TEMP, "%s", "Invalid value of passed param.", 1)
+				}
+			}
+			for _, value := range stats.HotKeys(number) {
+				result += STAT_PREFIX + value + "\r\n"
+			}
+		case "latency":
+			for _, value := range stats.Latencies() {
+				result += STAT_PREFIX + value + "\r\n"
+			}
+		case "replication":
+			if stats.Replication != nil {
+				for _, value := range stats.Replication() {
+					result += STAT_PREFIX + value + "\r\n"
+				}
+			}
+		case "extstore":
+			if ext := storage.ExtStore(); ext != nil {
+				for _, value := range ext.Stats() {
+					result += STAT_PREFIX + value + "\r\n"
+				}
+			}
+		case "namespaces":
+			// number of namespaces isn't limited, so lines are joined by builder rather than concatenation.
+			var lines strings.Builder
+			for _, value := range storage.NamespacesStats() {
+				lines.WriteString(STAT_PREFIX + value + "\r\n")
+			}
+			result = lines.String()
+		case "leases":
+			for _, value := range storage.Leases().Stats() {
+				result += STAT_PREFIX + value + "\r\n"
+			}
+		case "compression":
+			if compression := storage.Compression(); compression != nil {
+				for _, value := range compression.Stats() {
+					result += STAT_PREFIX + value + "\r\n"
+				}
+			}
+		case "pubsub":
+			if stats.PubSub != nil {
+				for _, value := range stats.PubSub() {
+					result += STAT_PREFIX + value + "\r\n"
+				}
+			}
+		case "aof":
+			if stats.AppendLog != nil {
+				for _, value := range stats.AppendLog() {
+					result += STAT_PREFIX + value + "\r\n"
+				}
+			}
+		case "sizes_enable":
+			storage.EnableSizes()
+			return OK
+		case "sizes_disable":
+			storage.DisableSizes()
+			return OK
+		default:
+			return ERROR_TEMP
+		}
+	}
+	return result + END
+}
+
+// Implements sub command "detail" of stats, which turns on/off and dumps per-prefix statistic.
+func (enum *Ascii_protocol_enum) stat_detail(stats *stat.ServerStat) string {
+	if len(enum.key) < 2 || stats.Detail == nil {
+		return strings.Replace(CLIENT_ERROR_TEMP, "%s", "usage: stats detail on|off|dump", 1)
+	}
+	switch enum.key[1] {
+	case "on":
+		stats.Detail.Enable(true)
+		return OK
+	case "off":
+		stats.Detail.Enable(false)
+		return OK
+	case "dump":
+		// number of prefixes isn't limited, so lines are joined by builder rather than concatenation.
+		var result strings.Builder
+		for _, value := range stats.Detail.Dump() {
+			result.WriteString(value + "\r\n")
 		}
+		return result.String() + END
+	default:
+		return strings.Replace(CLIENT_ERROR_TEMP, "%s", "usage: stats detail on|off|dump", 1)
 	}
-	return result + "END\r\n"
 }
 
 //
@@ -258,10 +453,10 @@ func (enum *Ascii_protocol_enum) lru_crawler(storage *cache.LRUCache) string {
 		if err != nil {
 			return strings.Replace(CLIENT_ERROR_TEMP, "%s", err.Error(), 1)
 		}
-		return "OK\r\n"
+		return OK
 	case "disable":
 		storage.DisableCrawler()
-		return "OK\r\n"
+		return OK
 	case "tocrawl":
 		if len(enum.key) < 2 {
 			return strings.Replace(CLIENT_ERROR_TEMP, "%s", "Wrong parameters number.", 1)
@@ -271,7 +466,7 @@ func (enum *Ascii_protocol_enum) lru_crawler(storage *cache.LRUCache) string {
 			return strings.Replace(CLIENT_ERROR_TEMP, "%s", "Invalid value of passed param.", 1)
 		}
 		storage.Crawler.ItemsPerRun = uint(amount)
-		return "OK\r\n"
+		return OK
 	case "sleep":
 		if len(enum.key) < 2 {
 			return strings.Replace(CLIENT_ERROR_TEMP, "%s", "Wrong parameters number.", 1)
@@ -284,12 +479,73 @@ func (enum *Ascii_protocol_enum) lru_crawler(storage *cache.LRUCache) string {
 		if err != nil {
 			return strings.Replace(CLIENT_ERROR_TEMP, "%s", err.Error(), 1)
 		}
-		return "OK\r\n"
+		return OK
+	case "metadump":
+		// "metadump all" is handled by WriteMetadump without lock of storage.
+		return strings.Replace(CLIENT_ERROR_TEMP, "%s", "Only \"metadump all\" is supported.", 1)
 	default:
 		return strings.Replace(CLIENT_ERROR_TEMP, "%s", "Command is not implemented.", 1)
 	}
 }
 
+// Public method of Ascii_protocol_enum, which returns true for "lru_crawler metadump all" request.
+// Its response may be long, so server writes it by WriteMetadump directly to connection.
+func (enum *Ascii_protocol_enum) Metadump() bool {
+	return len(enum.error) == 0 && enum.command == "lru_crawler" && len(enum.key) >= 2 &&
+		   enum.key[0] == "metadump" && enum.key[1] == "all"
+}
+
+// Function writes metadata of all live items of storage to passed writer as memcached "lru_crawler metadump" does:
+// line "key=<escaped key> exp=<expiration timestamp or -1> la=<timestamp> cas=<unique> fetch=<yes|no> cls=1
+// size=<bytes>" for each item, from the least recently used one, followed by END line.
+// Items are read by batches and each batch is written after the lock of storage is released, so long dump
+// doesn't block other requests; items changed during the dump are listed as they are at reading of their batch.
+// Last access of items isn't tracked, so "la" is timestamp of storing of item. Returns number of written bytes.
+func WriteMetadump(writer io.Writer, storage *embedded.Cache) (int, error) {
+	keys := storage.Keys()
+	var written = 0
+	var batch bytes.Buffer
+	for start := 0; start < len(keys); start += embedded.SNAPSHOT_BATCH {
+		end := start + embedded.SNAPSHOT_BATCH
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch.Reset()
+		storage.Locked(func(storage *cache.LRUCache) {
+			now := time.Now().Unix()
+			for _, key := range keys[start : end] {
+				item := storage.Peek(key)
+				if item == nil || (item.Exptime > 0 && item.Exptime <= now) || storage.Stale(item) {
+					continue
+				}
+				batch.WriteString(metadumpLine(item))
+			}
+		})
+		n, err := writer.Write(batch.Bytes())
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	n, err := io.WriteString(writer, END)
+	return written + n, err
+}
+
+// Function returns line of metadump of passed item.
+func metadumpLine(item *cache.LRUCacheItem) string {
+	var exptime = item.Exptime
+	if exptime == 0 {
+		exptime = -1
+	}
+	var fetched = "no"
+	if item.Fetched() {
+		fetched = "yes"
+	}
+	return "key=" + url.QueryEscape(item.Cacheable.Key()) + " exp=" + tools.IntToString(exptime) +
+		   " la=" + tools.IntToString(item.Timestamp()) + " cas=" + tools.IntToString(item.Cas_unique) +
+		   " fetch=" + fetched + " cls=1 size=" + tools.IntToString(int64(item.Cacheable.Size())) + "\r\n"
+}
+
 // Utilities
 
 // Returns true if there was no "noreply" param in request.
@@ -297,15 +553,48 @@ func (enum *Ascii_protocol_enum) Reply() bool {
 	return !enum.noreply
 }
 
+// Returns keys of request.
+func (enum *Ascii_protocol_enum) Keys() []string {
+	return enum.key
+}
+
 // Returns amount of bytes specified for data byte-string.
 func (enum *Ascii_protocol_enum) DataLen() int {
 	return enum.bytes
 }
 
+// Returns true if data block follows header of request, even an empty one.
+func (enum *Ascii_protocol_enum) HasData() bool {
+	return len(enum.error) == 0 && tools.In(enum.command, storage_commands)
+}
+
+// Sets data of specified length to enumeration as chain of chunks.
+func (enum *Ascii_protocol_enum) SetChunks(chunks [][]byte) bool {
+	var size = 0
+	for _, chunk := range chunks {
+		size += len(chunk)
+	}
+	if enum.bytes == size {
+		enum.chunks = chunks
+		enum.data_string = nil
+		return true
+	}
+	return false
+}
+
+// Returns data of request as chain of chunks.
+func (enum *Ascii_protocol_enum) data() [][]byte {
+	if enum.chunks != nil {
+		return enum.chunks
+	}
+	return [][]byte{enum.data_string}
+}
+
 // Sets data byte-string of specified length to enumeration.
 func (enum *Ascii_protocol_enum) SetData(data []byte) bool {
 	if enum.bytes == len(data) {
 		enum.data_string = data[0 : ]
+		enum.chunks = nil
 		return true
 	}
 	return false
@@ -313,23 +602,37 @@ func (enum *Ascii_protocol_enum) SetData(data []byte) bool {
 
 // Function checks was the passed param res successful whether not.
 func IsMissed(res string) bool {
-	return (res == NOT_FOUND || res == ERROR_TEMP || res == "END\r\n")
+	return (res == NOT_FOUND || res == ERROR_TEMP || res == END)
 }
 
 // Function increases fields of passed structure stats, if some of commands or passed param res were matched to required.
 func (enum *Ascii_protocol_enum) RecordStats(stats *stat.ServerStat, res string) {
 	if tools.In(enum.command, []string{"get", "set", "delete", "touch", }){
-		stats.Commands["cmd_" + enum.command] ++
+		stats.Count("cmd_" + enum.command)
 	}
-	if tools.In(enum.command, []string{"get", "delete", "incr", "decr", "cas", "touch", }){
+	// mismatch of unique id of cas and errors of arguments are neither hits nor misses.
+	if tools.In(enum.command, []string{"get", "delete", "incr", "decr", "cas", "touch", }) && res != EXIST &&
+	   !strings.HasPrefix(res, "CLIENT_ERROR") {
 		if IsMissed(res){
-			stats.Commands[enum.command + "_misses"] ++
+			stats.Count(enum.command + "_misses")
 		} else {
-			stats.Commands[enum.command + "_hits"] ++
+			stats.Count(enum.command + "_hits")
 		}
 	}
-	if enum.command == "cas" && res == NOT_FOUND {
-		stats.Commands["cas_badval"] ++
+	if enum.command == "cas" && res == EXIST {
+		stats.Count("cas_badval")
+	}
+	if tools.In(enum.command, storage_commands) || tools.In(enum.command, []string{"incr", "decr", "touch", "delete"}) {
+		if len(enum.key) > 0 {
+			stats.HotWrites.Touch(enum.key[0])
+		}
+	}
+	if stats.Detail != nil && len(enum.key) > 0 {
+		if tools.In(enum.command, storage_commands) {
+			stats.Detail.RecordSet(enum.key[0])
+		} else if enum.command == "delete" {
+			stats.Detail.RecordDelete(enum.key[0])
+		}
 	}
 }
 
@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"embedded"
+	"errors"
+	"io"
+	"strings"
+	"tools"
+)
+
+// Error, which is returned when command of mutation stream can't be parsed or applied.
+var ErrMalformedMutation = errors.New("Malformed command of mutation stream.")
+
+// Function encodes mutation of embedded cache into command of ascii protocol ("set", "append", "prepend", "touch",
+// "delete", "flush_all", "ns_flush" or "invalidate_tag") with "noreply" and absolute expiration timestamp,
+// so it may be applied later with the same result.
+func EncodeMutation(mutation *embedded.Mutation) []byte {
+	var buffer bytes.Buffer
+	WriteMutation(&buffer, mutation)
+	return buffer.Bytes()
+}
+
+// Function does the same as EncodeMutation, but writes command to passed writer, so data of large values
+// isn't copied. Returns error of writer.
+func WriteMutation(writer io.Writer, mutation *embedded.Mutation) error {
+	var exptime int64
+	if !mutation.Expiration.IsZero() {
+		exptime = mutation.Expiration.Unix()
+	}
+	var header string
+	switch mutation.Command {
+	case "set", "append", "prepend":
+		chunks := mutation.Chunks
+		if chunks == nil {
+			chunks = [][]byte{mutation.Value}
+		}
+		var length = 0
+		for _, chunk := range chunks {
+			length += len(chunk)
+		}
+		header = strings.Join([]string{mutation.Command, mutation.Key, tools.UIntToString(uint64(mutation.Flags)),
+									   tools.IntToString(exptime), tools.IntToString(int64(length)), "noreply"}, " ")
+		if len(mutation.Tags) > 0 {
+			header += " tags=" + strings.Join(mutation.Tags, ",")
+		}
+		if _, err := io.WriteString(writer, header + "\r\n"); err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			if _, err := writer.Write(chunk); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(writer, "\r\n")
+		return err
+	case "touch":
+		header = "touch " + mutation.Key + " " + tools.IntToString(exptime)
+	case "delete", "ns_flush", "invalidate_tag":
+		header = mutation.Command + " " + mutation.Key
+	case "flush_all":
+		header = "flush_all"
+	default:
+		return nil
+	}
+	_, err := io.WriteString(writer, header + " noreply\r\n")
+	return err
+}
+
+// Function applies command of mutation stream with passed header (without terminator) to storage;
+// data block of "set", "append" and "prepend" commands is read from passed reader.
+// Returns number of bytes read from reader and error: io.ErrUnexpectedEOF if data block is incomplete,
+// ErrMalformedMutation if command is invalid. Lack of memory or missed item don't cause error.
+func ApplyMutation(header string, reader *bufio.Reader, storage *embedded.Cache) (int, error) {
+	request := ParseProtocolHeader(header)
+	var read = 0
+	if tools.In(request.Command(), []string{"set", "append", "prepend"}) && len(request.error) == 0 {
+		data := make([]byte, request.DataLen() + 2)
+		n, err := io.ReadFull(reader, data)
+		read += n
+		if err == io.EOF {
+			return read, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return read, err
+		}
+		if string(data[request.DataLen() : ]) != "\r\n" {
+			return read, ErrMalformedMutation
+		}
+		request.SetData(data[ : request.DataLen()])
+	}
+	if len(request.error) > 0 || !tools.In(request.Command(), []string{"set", "append", "prepend", "touch", "delete",
+															   "flush_all", "ns_flush", "invalidate_tag"}) {
+		return read, ErrMalformedMutation
+	}
+	if _, err := request.Handle(storage, nil); err != nil && err.Error() != "SERVER_ERROR" {
+		return read, err
+	}
+	return read, nil
+}
@@ -2,7 +2,12 @@ package protocol
 
 import (
 	"testing"
+	"bufio"
+	"bytes"
+	"embedded"
+	"io"
 	"reflect"
+	"strconv"
 	"time"
 	"fmt"
 	"strings"
@@ -282,6 +287,10 @@ func TestParsingSuiteOther(t *testing.T){
 		"flush_all", nil, 0, 0, 0, 0, nil, false, "") {
 		t.Fatalf("The parser works incorrect.")
 	}
+	if !matchEnumFields(ParseProtocolHeader("flush_all noreply"),
+		"flush_all", nil, 0, 0, 0, 0, nil, true, "") {
+		t.Fatalf("The parser works incorrect.")
+	}
 	if !matchEnumFields(ParseProtocolHeader("version"),
 		"version", nil, 0, 0, 0, 0, nil, false, "") {
 		t.Fatalf("The parser works incorrect.")
@@ -302,7 +311,7 @@ func TestParsingErrors(t *testing.T){
 		t.Fatalf("The parser works incorrect.")
 	}
 	if !matchEnumFields(ParseProtocolHeader(""),
-		"", nil, 0, 0, 0, 0, nil, false, strings.Replace(CLIENT_ERROR_TEMP, "%s", "Input command line is empty", 1)) {
+		"", nil, 0, 0, 0, 0, nil, false, ERROR_TEMP) {
 		t.Fatalf("The parser works incorrect.")
 	}
 	if !matchEnumFields(ParseProtocolHeader("set 1"),
@@ -310,7 +319,7 @@ func TestParsingErrors(t *testing.T){
 		t.Fatalf("The parser works incorrect.")
 	}
 	if !matchEnumFields(ParseProtocolHeader("cas a b c d e f"),
-		"", nil, 0, 0, 0, 0, nil, false, ERROR_TEMP) {
+		"", nil, 0, 0, 0, 0, nil, false, BAD_FORMAT) {
 		t.Fatalf("The parser works incorrect.")
 	}
 	if !matchEnumFields(ParseProtocolHeader("get"),
@@ -321,39 +330,56 @@ func TestParsingErrors(t *testing.T){
 		"", nil, 0, 0, 0, 0, nil, false, ERROR_TEMP) {
 		t.Fatalf("The parser works incorrect.")
 	}
-
+	for header, expected := range map[string] string{
+		"set key -1 0 5": BAD_FORMAT,
+		"set key 0 0 -5": BAD_FORMAT,
+		"set key 0 x 5": BAD_FORMAT,
+		"set " + strings.Repeat("k", MAX_KEY_LENGTH + 1) + " 0 0 5": BAD_FORMAT,
+		"get key " + strings.Repeat("k", MAX_KEY_LENGTH + 1): BAD_FORMAT,
+		"delete key 5": BAD_DELETE_FORMAT,
+		"delete key 0 0": BAD_DELETE_FORMAT,
+		"touch key x": INVALID_EXPTIME,
+		"flush_all x": BAD_FORMAT,
+		"delete key 0": "",
+		"delete key 0 noreply": "",
+		"set " + strings.Repeat("k", MAX_KEY_LENGTH) + " 4294967295 0 5": "",
+	} {
+		if enum := ParseProtocolHeader(header); enum.error != expected {
+			t.Fatalf("Unexpected error of %q: %q", header, enum.error)
+		}
+	}
 }
 
 func TestEnumReply1(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, ""}
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, "", nil, nil}
 	if testEnum.Reply(){
 		t.Fatalf("Wrong behavior of Reply() function.")
 	}
 }
 
 func TestEnumReply2(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, false, nil, ""}
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, false, nil, "", nil, nil}
 	if !testEnum.Reply(){
 		t.Fatalf("Wrong behavior of Reply() function.")
 	}
 }
 
 func TestEnumDataLen(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, ""}
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, "", nil, nil}
 	if testEnum.DataLen() != 42 {
 		t.Fatalf("Wrong behavior of DataLen() function.")
 	}
 }
 
 func TestEnumSetData1(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, ""}
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, "", nil, nil}
 	if !testEnum.SetData(make([]byte, 42)){
 		t.Fatalf("Wrong behavior of SetData() function.")
 	}
 }
 
 func TestEnumSetData2(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, ""}
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, true, nil, "", nil, nil}
 	if testEnum.SetData(make([]byte, 41)){
 		t.Fatalf("Wrong behavior of SetData() function.")
 	}
@@ -361,8 +387,8 @@ func TestEnumSetData2(t *testing.T){
 
 func TestHandlingSuiteSet1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -370,9 +396,9 @@ func TestHandlingSuiteSet1(t *testing.T){
 
 func TestHandlingSuiteSet2(t *testing.T){
 	var storage = cache.New(4)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, false, make([]byte, 42), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
-	if err == nil || string(res) != strings.Replace(SERVER_ERROR_TEMP, "%s", "Not enough memory", 1) {
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, false, make([]byte, 42), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
+	if err == nil || string(res) != OUT_OF_MEMORY {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
 }
@@ -382,8 +408,8 @@ func TestHandlingSuiteCas1(t *testing.T){
 	if !storage.Set(tools.NewStoredData([]byte("test1"), "key"), 0, 0, 424242) {
 		t.Fatalf("Unexpecting behavior ")
 	}
-	var testEnum = Ascii_protocol_enum{"cas", []string{"key", }, 1, 0, 5, 424242, false, []byte("TEST2"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"cas", []string{"key", }, 1, 0, 5, 424242, false, []byte("TEST2"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -394,22 +420,22 @@ func TestHandlingSuiteCas2(t *testing.T){
 	if !storage.Set(tools.NewStoredData([]byte("test1"), "key"), 0, 0, 0) {
 		t.Fatalf("Unexpecting behavior ")
 	}
-	var testEnum = Ascii_protocol_enum{"cas", []string{"key", }, 1, 0, 42, 424242, false, make([]byte, 42), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
-	if err != nil || string(res) != NOT_FOUND {
+	var testEnum = Ascii_protocol_enum{"cas", []string{"key", }, 1, 0, 42, 424242, false, make([]byte, 42), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
+	if err != nil || string(res) != EXIST {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
 }
 
 func TestHandlingSuiteAdd1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"add", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"add", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -417,13 +443,13 @@ func TestHandlingSuiteAdd1(t *testing.T){
 
 func TestHandlingSuiteAdd2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 22, 0, false, make([]byte, 22), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 22, 0, false, make([]byte, 22), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"add", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"add", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "NOT_STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -431,13 +457,13 @@ func TestHandlingSuiteAdd2(t *testing.T){
 
 func TestHandlingSuiteReplace1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"replace", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"replace", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "NOT_STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -445,13 +471,13 @@ func TestHandlingSuiteReplace1(t *testing.T){
 
 func TestHandlingSuiteReplace2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, false, make([]byte, 42), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 42, 0, false, make([]byte, 42), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"replace", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"replace", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -459,13 +485,13 @@ func TestHandlingSuiteReplace2(t *testing.T){
 
 func TestHandlingSuiteAppend1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"append", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"append", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "NOT_STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -473,13 +499,13 @@ func TestHandlingSuiteAppend1(t *testing.T){
 
 func TestHandlingSuiteAppend2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"append", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"append", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -491,13 +517,13 @@ func TestHandlingSuiteAppend2(t *testing.T){
 
 func TestHandlingSuitePrepend1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"prepend", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"prepend", []string{"key1", }, 0, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "NOT_STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -505,13 +531,13 @@ func TestHandlingSuitePrepend1(t *testing.T){
 
 func TestHandlingSuitePrepend2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	testEnum = Ascii_protocol_enum{"prepend", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"prepend", []string{"key", }, 0, 0, 5, 0, false, []byte("TEST2"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "STORED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -523,10 +549,10 @@ func TestHandlingSuitePrepend2(t *testing.T){
 
 func TestHandlingSuiteGet1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"get", []string{"key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"get", []string{"key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "VALUE key 1 4\r\nTEST\r\nEND\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -534,10 +560,10 @@ func TestHandlingSuiteGet1(t *testing.T){
 
 func TestHandlingSuiteGet2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"get", []string{"not_key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"get", []string{"not_key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "END\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -545,10 +571,10 @@ func TestHandlingSuiteGet2(t *testing.T){
 
 func TestHandlingSuiteGets1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"gets", []string{"key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"gets", []string{"key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	item := storage.Get("key")
 	if item == nil {
 		t.Fatalf("Item wasn't stored")
@@ -565,10 +591,10 @@ func TestHandlingSuiteGets1(t *testing.T){
 
 func TestHandlingSuiteGets2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"gets", []string{"not_key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"gets", []string{"not_key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "END\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -576,12 +602,12 @@ func TestHandlingSuiteGets2(t *testing.T){
 
 func TestHandlingSuiteGetMultiple(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key1", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"set", []string{"key2", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"get", []string{"key1", "key2", }, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key1", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"set", []string{"key2", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"get", []string{"key1", "key2", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "VALUE key1 1 4\r\nTEST\r\nVALUE key2 1 4\r\nTEST\r\nEND\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -589,15 +615,15 @@ func TestHandlingSuiteGetMultiple(t *testing.T){
 
 func TestHandlingSuiteIncrDecr1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 3, 0, false, []byte("123"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"incr", []string{"key", }, 0, 0, 0, 0, false, []byte("100"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 3, 0, false, []byte("123"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"incr", []string{"key", }, 0, 0, 0, 0, false, []byte("100"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "223\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, string(res))
 	}
-	testEnum = Ascii_protocol_enum{"decr", []string{"key", }, 0, 0, 0, 0, false, []byte("100"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"decr", []string{"key", }, 0, 0, 0, 0, false, []byte("100"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "123\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, string(res))
 	}
@@ -605,26 +631,42 @@ func TestHandlingSuiteIncrDecr1(t *testing.T){
 
 func TestHandlingSuiteIncrDecr2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 6, 0, false, []byte("3.1459"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"incr", []string{"key", }, 0, 0, 0, 0, false, []byte("100"), ""}
-	res, err := testEnum.HandleRequest(storage, nil)
-	if err != nil || string(res) != "ERROR\r\n" {
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 6, 0, false, []byte("3.1459"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"incr", []string{"key", }, 0, 0, 0, 0, false, []byte("100"), "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
+	if err != nil || string(res) != NON_NUMERIC {
 		t.Fatalf("Unexpected returned values of handling: ", err, string(res))
 	}
-	testEnum = Ascii_protocol_enum{"decr", []string{"key1", }, 0, 0, 0, 0, false, []byte("100"), ""}
-	res, err = testEnum.HandleRequest(storage, nil)
+	testEnum = Ascii_protocol_enum{"decr", []string{"key1", }, 0, 0, 0, 0, false, []byte("100"), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "NOT_FOUND\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, string(res))
 	}
 }
 
+func TestHandlingSuiteIncrDecr3(t *testing.T){
+	var storage = embedded.Wrap(cache.New(42))
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 20, 0, false, []byte("18446744073709551615"), "", nil, nil}
+	testEnum.Handle(storage, nil)
+	testEnum = Ascii_protocol_enum{"incr", []string{"key", }, 0, 0, 0, 0, false, []byte("2"), "", nil, nil}
+	res, err := testEnum.Handle(storage, nil)
+	if err != nil || string(res) != "1\r\n" {
+		t.Fatalf("Increment should wrap around at 64 bits: %s, %q", err, res)
+	}
+	testEnum = Ascii_protocol_enum{"decr", []string{"key", }, 0, 0, 0, 0, false, []byte("100"), "", nil, nil}
+	res, err = testEnum.Handle(storage, nil)
+	if err != nil || string(res) != "0\r\n" {
+		t.Fatalf("Decrement shouldn't go below zero: %s, %q", err, res)
+	}
+}
+
 func TestHandlingSuiteTouch1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"touch", []string{"key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"touch", []string{"key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "TOUCHED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -632,10 +674,10 @@ func TestHandlingSuiteTouch1(t *testing.T){
 
 func TestHandlingSuiteTouch2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"touch", []string{"not_key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"touch", []string{"not_key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != NOT_FOUND {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -643,10 +685,10 @@ func TestHandlingSuiteTouch2(t *testing.T){
 
 func TestHandlingSuiteDelete1(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"delete", []string{"key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"delete", []string{"key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "DELETED\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -654,10 +696,10 @@ func TestHandlingSuiteDelete1(t *testing.T){
 
 func TestHandlingSuiteDelete2(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), ""}
-	testEnum.HandleRequest(storage, nil)
-	testEnum = Ascii_protocol_enum{"delete", []string{"not_key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 4, 0, false, []byte("TEST"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), nil)
+	testEnum = Ascii_protocol_enum{"delete", []string{"not_key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != NOT_FOUND {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -665,45 +707,151 @@ func TestHandlingSuiteDelete2(t *testing.T){
 
 func TestHandlingSuiteFlushAll(t *testing.T){
 	var storage = cache.New(42)
-	var testEnum = Ascii_protocol_enum{"flush_all", nil, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(storage, nil)
+	var testEnum = Ascii_protocol_enum{"flush_all", nil, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(embedded.Wrap(storage), nil)
 	if err != nil || string(res) != "OK\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
 }
 
 func TestHandlingSuiteVersion(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"version", nil, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(nil, nil)
+	var testEnum = Ascii_protocol_enum{"version", nil, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(nil, nil)
 	if err != nil || string(res) != "VERSION "+ tools.VERSION +"\r\n" {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
 }
 
 func TestHandlingSuiteQuit(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"quit", nil, 0, 0, 0, 0, false, nil, ""}
-	res, err := testEnum.HandleRequest(nil, nil)
+	var testEnum = Ascii_protocol_enum{"quit", nil, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err := testEnum.Handle(nil, nil)
 	if err == nil || res != nil {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
 }
 
 func TestHandlingStatistic(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"stats", nil, 0, 0, 0, 0, false, nil, ""}
+	var testEnum = Ascii_protocol_enum{"stats", nil, 0, 0, 0, 0, false, nil, "", nil, nil}
 	var stats = stat.New(42, "9999", "8888", 1024, 2, true, true)
 	var storage = cache.New(42)
 
-	res, err := testEnum.HandleRequest(storage, stats)
+	res, err := testEnum.Handle(embedded.Wrap(storage), stats)
 	if err != nil || res == nil {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
 }
 
+func TestHandlingStatisticSlowLog(t *testing.T){
+	var stats = stat.New(42, "9999", "8888", 1024, 2, true, true)
+	var storage = cache.New(42)
+	stats.SlowLog.Record("get", 3, 0, "127.0.0.1:42", time.Second)
+	res, err := ParseProtocolHeader("stats slowlog").Handle(embedded.Wrap(storage), stats)
+	if err != nil || !strings.HasPrefix(string(res), "STAT 1 ts=") ||
+	   !strings.HasSuffix(string(res), " client=127.0.0.1:42 command=get keys=3 bytes=0\r\nEND\r\n") {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, err = ParseProtocolHeader("stats reset").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "RESET\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, err = ParseProtocolHeader("stats slowlog").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "END\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+}
+
+func TestHandlingStatisticDetail(t *testing.T){
+	var stats = stat.New(42, "9999", "8888", 1024, 2, true, true)
+	var storage = cache.New(42)
+	for _, request := range []string{"stats detail", "stats detail all"} {
+		res, _ := ParseProtocolHeader(request).Handle(embedded.Wrap(storage), stats)
+		if string(res) != strings.Replace(CLIENT_ERROR_TEMP, "%s", "usage: stats detail on|off|dump", 1) {
+			t.Fatalf("Unexpected returned value of handling: %q", res)
+		}
+	}
+	res, err := ParseProtocolHeader("stats detail on").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "OK\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	var testEnum = Ascii_protocol_enum{"set", []string{"user:1", }, 0, 0, 2, 0, false, []byte("42"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), stats)
+	ParseProtocolHeader("get user:1 user:2").Handle(embedded.Wrap(storage), stats)
+	ParseProtocolHeader("delete user:2").Handle(embedded.Wrap(storage), stats)
+	res, err = ParseProtocolHeader("stats detail dump").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "PREFIX user get 2 hit 1 set 1 del 1\r\nEND\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, err = ParseProtocolHeader("stats reset").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "RESET\r\n" || len(stats.Commands) != 0 {
+		t.Fatalf("Unexpected returned values of handling: %v %q %v", err, res, stats.Commands)
+	}
+	res, err = ParseProtocolHeader("stats detail dump").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "END\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+}
+
+func TestHandlingStatisticSizes(t *testing.T){
+	var stats = stat.New(100, "9999", "8888", 1024, 2, true, true)
+	var storage = cache.New(100)
+	storage.Set(tools.NewStoredData(make([]byte, 40), "key1"), 0, 0, 0)
+	storage.Set(tools.NewStoredData(make([]byte, 4), "key2"), 0, 0, 0)
+	storage.Set(tools.NewStoredData(make([]byte, 4), "key3"), 0, 0, 0)
+	res, err := ParseProtocolHeader("stats sizes").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "STAT sizes_status disabled\r\nEND\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, err = ParseProtocolHeader("stats sizes_enable").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "OK\r\n" || !storage.SizesEnabled() {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, err = ParseProtocolHeader("stats sizes").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "STAT 32 2\r\nSTAT 64 1\r\nEND\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, err = ParseProtocolHeader("stats sizes_disable").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "OK\r\n" || storage.SizesEnabled() {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+}
+
+func TestHandlingStatisticHotKeys(t *testing.T){
+	var stats = stat.New(100, "9999", "8888", 1024, 2, true, true)
+	var storage = cache.New(100)
+	var testEnum = Ascii_protocol_enum{"set", []string{"key1", }, 0, 0, 2, 0, false, []byte("42"), "", nil, nil}
+	testEnum.Handle(embedded.Wrap(storage), stats)
+	ParseProtocolHeader("get key1 key2").Handle(embedded.Wrap(storage), stats)
+	ParseProtocolHeader("get key1").Handle(embedded.Wrap(storage), stats)
+	res, err := ParseProtocolHeader("stats hotkeys 1").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "STAT reads:1 key1 2\r\nSTAT writes:1 key1 1\r\nEND\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, err = ParseProtocolHeader("stats hotkeys").Handle(embedded.Wrap(storage), stats)
+	if err != nil || string(res) != "STAT reads:1 key1 2\r\nSTAT reads:2 key2 1\r\nSTAT writes:1 key1 1\r\nEND\r\n" {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+	res, _ = ParseProtocolHeader("stats hotkeys zero").Handle(embedded.Wrap(storage), stats)
+	if string(res) != strings.Replace(CLIENT_ERROR_TEMP, "%s", "Invalid value of passed param.", 1) {
+		t.Fatalf("Unexpected returned value of handling: %q", res)
+	}
+}
+
+func TestHandlingStatisticLatency(t *testing.T){
+	var stats = stat.New(100, "9999", "8888", 1024, 2, true, true)
+	var storage = cache.New(100)
+	stats.Latency.Record("append", time.Millisecond, 20, 8)
+	res, err := ParseProtocolHeader("stats latency").Handle(embedded.Wrap(storage), stats)
+	if err != nil || !strings.HasPrefix(string(res), "STAT append:count 1\r\nSTAT append:p50_us ") ||
+	   !strings.HasSuffix(string(res), "STAT append:bytes_read 20\r\nSTAT append:bytes_written 8\r\nEND\r\n") {
+		t.Fatalf("Unexpected returned values of handling: %v %q", err, res)
+	}
+}
+
 func TestHandlingStatsRecording(t *testing.T){
-	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 2, 0, true, []byte("42"), ""}
+	var testEnum = Ascii_protocol_enum{"set", []string{"key", }, 1, 0, 2, 0, true, []byte("42"), "", nil, nil}
 	stats := stat.New(42, "9999", "8888", 1024, 2, true, true)
 	var storage = cache.New(42)
-	res, err := testEnum.HandleRequest(storage, stats)
+	res, err := testEnum.Handle(embedded.Wrap(storage), stats)
 	if err != nil || res == nil {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -712,8 +860,8 @@ func TestHandlingStatsRecording(t *testing.T){
 	}
 
 	stats = stat.New(42, "9999", "8888", 1024, 2, true, true)
-	testEnum = Ascii_protocol_enum{"get", []string{"not_key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err = testEnum.HandleRequest(storage, stats)
+	testEnum = Ascii_protocol_enum{"get", []string{"not_key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), stats)
 	if err != nil || res == nil {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -722,8 +870,8 @@ func TestHandlingStatsRecording(t *testing.T){
 	}
 
 	stats = stat.New(42, "9999", "8888", 1024, 2, true, true)
-	testEnum = Ascii_protocol_enum{"get", []string{"key", }, 0, 0, 0, 0, false, nil, ""}
-	res, err = testEnum.HandleRequest(storage, stats)
+	testEnum = Ascii_protocol_enum{"get", []string{"key", }, 0, 0, 0, 0, false, nil, "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), stats)
 	if err != nil || res == nil {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
@@ -732,12 +880,13 @@ func TestHandlingStatsRecording(t *testing.T){
 	}
 
 	stats = stat.New(42, "9999", "8888", 1024, 2, true, true)
-	testEnum = Ascii_protocol_enum{"cas", []string{"key", }, 1, 0, 42, 424242, false, make([]byte, 42), ""}
-	res, err = testEnum.HandleRequest(storage, stats)
+	testEnum = Ascii_protocol_enum{"cas", []string{"key", }, 1, 0, 42, 424242, false, make([]byte, 42), "", nil, nil}
+	res, err = testEnum.Handle(embedded.Wrap(storage), stats)
 	if err != nil || res == nil {
 		t.Fatalf("Unexpected returned values of handling: ", err, res)
 	}
-	if stats.Commands == nil || stats.Commands["cas_badval"] != 1 {
+	if stats.Commands == nil || string(res) != EXIST || stats.Commands["cas_badval"] != 1 || stats.Commands["cas_misses"] != 0 ||
+	   stats.Commands["cas_hits"] != 0 {
 		t.Fatalf("Wrong stats handling: ", stats.Commands)
 	}
 }
@@ -747,3 +896,271 @@ func TestHandlingMissing(t *testing.T){
 		t.Fatalf("Invalid behavior of function.")
 	}
 }
+
+func TestMutationEncoding(t *testing.T) {
+	expiration := time.Unix(4242424242, 0)
+	set := string(EncodeMutation(&embedded.Mutation{Command: "set", Key: "key", Value: []byte("value"), Flags: 42,
+													 Expiration: expiration}))
+	if set != "set key 42 4242424242 5 noreply\r\nvalue\r\n" {
+		t.Fatalf("Unexpected encoding of set: %q", set)
+	}
+	appended := string(EncodeMutation(&embedded.Mutation{Command: "append", Key: "key",
+														   Chunks: [][]byte{[]byte("val"), []byte("ue")}}))
+	if appended != "append key 0 0 5 noreply\r\nvalue\r\n" {
+		t.Fatalf("Unexpected encoding of append: %q", appended)
+	}
+	if touch := string(EncodeMutation(&embedded.Mutation{Command: "touch", Key: "key"})); touch != "touch key 0 noreply\r\n" {
+		t.Fatalf("Unexpected encoding of touch: %q", touch)
+	}
+	if del := string(EncodeMutation(&embedded.Mutation{Command: "delete", Key: "key"})); del != "delete key noreply\r\n" {
+		t.Fatalf("Unexpected encoding of delete: %q", del)
+	}
+	if flush := string(EncodeMutation(&embedded.Mutation{Command: "flush_all"})); flush != "flush_all noreply\r\n" {
+		t.Fatalf("Unexpected encoding of flush_all: %q", flush)
+	}
+}
+
+func TestMutationApplying(t *testing.T) {
+	storage := embedded.New(1 << 20)
+	n, err := ApplyMutation("set key 42 4242424242 5 noreply", bufio.NewReader(strings.NewReader("value\r\n")), storage)
+	if err != nil || n != 7 {
+		t.Fatalf("Mutation wasn't applied: %d, %s", n, err)
+	}
+	item, err := storage.Get("key")
+	if err != nil || string(item.Value) != "value" || item.Flags != 42 || item.Expiration.Unix() != 4242424242 {
+		t.Fatalf("Unexpected item: %v, %s", item, err)
+	}
+	if _, err := ApplyMutation("set key 0 0 5 noreply", bufio.NewReader(strings.NewReader("val")), storage); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Incomplete data block wasn't detected: %s", err)
+	}
+	if _, err := ApplyMutation("set key 0 0 5 noreply", bufio.NewReader(strings.NewReader("value!!")), storage); err != ErrMalformedMutation {
+		t.Fatalf("Malformed data block wasn't detected: %s", err)
+	}
+	if _, err := ApplyMutation("get key", nil, storage); err != ErrMalformedMutation {
+		t.Fatalf("Non-mutating command was applied: %s", err)
+	}
+	if _, err := ApplyMutation("prepend key 0 0 1 noreply", bufio.NewReader(strings.NewReader("!\r\n")), storage); err != nil {
+		t.Fatalf("Prepend wasn't applied: %s", err)
+	}
+	if item, err := storage.Get("key"); err != nil || string(item.Value) != "!value" {
+		t.Fatalf("Unexpected item: %v, %s", item, err)
+	}
+	if _, err := ApplyMutation("append missed 0 0 1 noreply", bufio.NewReader(strings.NewReader("!\r\n")), storage); err != nil {
+		t.Fatalf("Append to missed item caused error: %s", err)
+	}
+	if _, err := ApplyMutation("delete missed noreply", nil, storage); err != nil {
+		t.Fatalf("Deletion of missed item caused error: %s", err)
+	}
+}
+
+func TestHandlingNamespaceFlush(t *testing.T) {
+	storage := embedded.New(1 << 20)
+	stats := stat.New(42, "9999", "8888", 1024, 2, true, true)
+	if enum := ParseProtocolHeader("ns_flush"); enum.error == "" {
+		t.Fatalf("Command without namespace was accepted.")
+	}
+	enum := ParseProtocolHeader("ns_flush user")
+	if enum.error != "" || enum.Command() != "ns_flush" || !reflect.DeepEqual(enum.key, []string{"user"}) {
+		t.Fatalf("Unexpected parsing: %v", enum)
+	}
+	if res, err := enum.Handle(storage, stats); err != nil || string(res) != "CLIENT_ERROR Namespaces are turned off.\r\n" {
+		t.Fatalf("Unexpected result with turned off namespaces: %q, %s", res, err)
+	}
+	storage.Locked(func(storage *cache.LRUCache) {
+		storage.SetNamespaceDelimiter(":")
+	})
+	storage.Set(&embedded.Item{Key: "user:1", Value: []byte("1")})
+	storage.Set(&embedded.Item{Key: "other:1", Value: []byte("1")})
+	if res, err := enum.Handle(storage, stats); err != nil || string(res) != OK {
+		t.Fatalf("Unexpected result of flush: %q, %s", res, err)
+	}
+	if _, err := storage.Get("user:1"); err != embedded.ErrNotFound {
+		t.Fatalf("Item of flushed namespace is available: %s", err)
+	}
+	if _, err := storage.Get("other:1"); err != nil {
+		t.Fatalf("Item of other namespace was flushed: %s", err)
+	}
+	if _, err := ApplyMutation("ns_flush other noreply", nil, storage); err != nil {
+		t.Fatalf("Flush of namespace wasn't applied: %s", err)
+	}
+	if _, err := storage.Get("other:1"); err != embedded.ErrNotFound {
+		t.Fatalf("Item of other namespace is available: %s", err)
+	}
+	mutation := &embedded.Mutation{Command: "ns_flush", Key: "user"}
+	if encoded := string(EncodeMutation(mutation)); encoded != "ns_flush user noreply\r\n" {
+		t.Fatalf("Unexpected encoding: %q", encoded)
+	}
+}
+
+func TestHandlingTags(t *testing.T) {
+	storage := embedded.New(1 << 20)
+	stats := stat.New(42, "9999", "8888", 1024, 2, true, true)
+	enum := ParseProtocolHeader("set key 0 0 5 tags=a,b noreply")
+	if enum.error != "" || !enum.noreply || !reflect.DeepEqual(enum.tags, []string{"a", "b"}) {
+		t.Fatalf("Unexpected parsing: %v", enum)
+	}
+	if enum = ParseProtocolHeader("cas key 0 0 5 42 noreply tags=c"); enum.error != "" || enum.cas_unique != 42 ||
+	   !enum.noreply || !reflect.DeepEqual(enum.tags, []string{"c"}) {
+		t.Fatalf("Unexpected parsing of cas: %v", enum)
+	}
+	if enum = ParseProtocolHeader("set key 0 0 5 tags=a,,b"); enum.error == "" {
+		t.Fatalf("Empty tag was accepted.")
+	}
+	n, err := ApplyMutation("set key 0 0 5 noreply tags=a,b", bufio.NewReader(strings.NewReader("value\r\n")), storage)
+	if err != nil || n != 7 {
+		t.Fatalf("Tagged item wasn't stored: %d, %s", n, err)
+	}
+	if item, err := storage.Get("key"); err != nil || !reflect.DeepEqual(item.Tags, []string{"a", "b"}) {
+		t.Fatalf("Unexpected item: %v, %s", item, err)
+	}
+	set := string(EncodeMutation(&embedded.Mutation{Command: "set", Key: "key", Value: []byte("value"), Tags: []string{"a", "b"}}))
+	if set != "set key 0 0 5 noreply tags=a,b\r\nvalue\r\n" {
+		t.Fatalf("Unexpected encoding of tagged item: %q", set)
+	}
+	enum = ParseProtocolHeader("invalidate_tag b")
+	if res, err := enum.Handle(storage, stats); err != nil || string(res) != OK {
+		t.Fatalf("Unexpected result of invalidation: %q, %s", res, err)
+	}
+	if _, err := storage.Get("key"); err != embedded.ErrNotFound {
+		t.Fatalf("Tagged item wasn't invalidated: %s", err)
+	}
+	if invalidate := string(EncodeMutation(&embedded.Mutation{Command: "invalidate_tag", Key: "b"})); invalidate != "invalidate_tag b noreply\r\n" {
+		t.Fatalf("Unexpected encoding of invalidation: %q", invalidate)
+	}
+}
+
+func TestHandlingLeases(t *testing.T) {
+	storage := embedded.New(1 << 20)
+	stats := stat.New(42, "9999", "8888", 1024, 2, true, true)
+	res, _ := ParseProtocolHeader("lget key").Handle(storage, stats)
+	if !strings.HasPrefix(string(res), "LEASE key ") || !strings.HasSuffix(string(res), "\r\nEND\r\n") {
+		t.Fatalf("Lease wasn't granted: %q", res)
+	}
+	token := strings.Fields(string(res))[2]
+	if res, _ := ParseProtocolHeader("lget key").Handle(storage, stats); string(res) != "WAIT key\r\nEND\r\n" {
+		t.Fatalf("Unexpected response to waiting client: %q", res)
+	}
+	enum := ParseProtocolHeader("lset key 42 0 5 " + token)
+	if enum.error != "" {
+		t.Fatalf("Unexpected error of parsing: %s", enum.error)
+	}
+	enum.SetData([]byte("value"))
+	if res, err := enum.Handle(storage, stats); err != nil || string(res) != STORED {
+		t.Fatalf("Value wasn't stored with token: %q, %s", res, err)
+	}
+	if res, _ := enum.Handle(storage, stats); string(res) != NOT_STORED {
+		t.Fatalf("Value was stored with released token: %q", res)
+	}
+	if res, _ := ParseProtocolHeader("lget key").Handle(storage, stats); string(res) != "VALUE key 42 5\r\nvalue\r\nEND\r\n" {
+		t.Fatalf("Unexpected response of hit: %q", res)
+	}
+	storage.Locked(func(storage *cache.LRUCache) {
+		storage.Leases().Grace = 10
+	})
+	storage.Set(&embedded.Item{Key: "key", Value: []byte("value"), TTL: time.Second})
+	ParseProtocolHeader("lget key").Handle(storage, stats)
+	if res, _ := ParseProtocolHeader("lget key").Handle(storage, stats); string(res) != "VALUE key 0 5\r\nvalue\r\nSTALE key\r\nEND\r\n" {
+		t.Fatalf("Unexpected response with stale value: %q", res)
+	}
+}
+
+func TestHandlingMetadump(t *testing.T) {
+	storage := embedded.New(1 << 20)
+	stats := stat.New(42, "9999", "8888", 1024, 2, true, true)
+	storage.Set(&embedded.Item{Key: "a key", Value: []byte("value")})
+	storage.Set(&embedded.Item{Key: "expiring", Value: []byte("value"), TTL: time.Hour})
+	storage.Set(&embedded.Item{Key: "expired", Value: []byte("value"), TTL: -time.Second})
+	storage.Get("expiring")
+	res, err := ParseProtocolHeader("lru_crawler metadump all").Handle(storage, stats)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	lines := strings.Split(string(res), "\r\n")
+	if len(lines) != 4 || lines[2] != "END" || lines[3] != "" ||
+	   !strings.HasPrefix(lines[0], "key=a+key exp=-1 la=") || !strings.HasSuffix(lines[0], " fetch=no cls=1 size=5") ||
+	   !strings.HasPrefix(lines[1], "key=expiring exp=" + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)) ||
+	   !strings.Contains(lines[1], " fetch=yes ") {
+		t.Fatalf("Unexpected metadump: %q", res)
+	}
+	if res, _ = ParseProtocolHeader("lru_crawler metadump hash").Handle(storage, stats); !strings.HasPrefix(string(res), "CLIENT_ERROR") {
+		t.Fatalf("Unsupported metadump was accepted: %q", res)
+	}
+}
+
+// Writer, which stores item to storage on each write, so it would be blocked by lock of storage held by caller.
+type lockingWriter struct {
+	storage *embedded.Cache
+	writes int
+	bytes.Buffer
+}
+
+func (w *lockingWriter) Write(data []byte) (int, error) {
+	w.writes ++
+	w.storage.Set(&embedded.Item{Key: "written" + strconv.Itoa(w.writes), Value: data[ : 1]})
+	return w.Buffer.Write(data)
+}
+
+func TestMetadumpBatches(t *testing.T) {
+	storage := embedded.New(1 << 20)
+	keys := embedded.SNAPSHOT_BATCH * 3
+	for i := 0; i < keys; i ++ {
+		storage.Set(&embedded.Item{Key: "key" + strconv.Itoa(i), Value: []byte("value")})
+	}
+	if !ParseProtocolHeader("lru_crawler metadump all").Metadump() || ParseProtocolHeader("lru_crawler metadump").Metadump() {
+		t.Fatalf("Unexpected detection of metadump.")
+	}
+	writer := &lockingWriter{storage: storage}
+	written, err := WriteMetadump(writer, storage)
+	if err != nil || written != writer.Len() || writer.writes != 3 {
+		t.Fatalf("Unexpected result of metadump: %d bytes, %d writes, %s", written, writer.writes, err)
+	}
+	lines := strings.Split(writer.String(), "\r\n")
+	if len(lines) != keys + 2 || !strings.HasPrefix(lines[keys - 1], "key=key" + strconv.Itoa(keys - 1) + " ") ||
+	   lines[keys] != "END" {
+		t.Fatalf("Unexpected metadump: %d lines", len(lines))
+	}
+}
+
+// Header of fuzzed input may contain several requests separated by new lines, which are handled in turn
+// (data is passed to each request, which has data), so sequences of commands are fuzzed as well.
+func FuzzHandle(f *testing.F) {
+	for _, header := range []string{"set key 0 0 5", "cas key 0 0 5 42 noreply", "lset key 0 0 5 1", "append key 0 0 5",
+									"get key other", "gets key", "lget key", "delete key 0 noreply", "touch key 10",
+									"incr key 1", "decr key 1 noreply", "flush_all 0", "ns_flush ns", "invalidate_tag tag",
+									"stats", "stats detail on", "stats hotkeys 5", "lru_crawler tocrawl 5",
+									"lru_crawler metadump all", "lru_crawler", "stats ", " ", "noreply", "version", "quit",
+									"set key 0 0 5\nappend key 0 0 5\nget key", "stats detail on\nget a:1 b:1\nstats detail dump"} {
+		f.Add(header, []byte("value"))
+	}
+	f.Fuzz(func(t *testing.T, header string, data []byte) {
+		// each input gets its own storage and statistic, so its result doesn't depend on previous inputs.
+		storage := embedded.New(1 << 20)
+		stats := stat.New(1 << 20, "9999", "8888", 1024, 0, true, true)
+		defer storage.Locked(func(storage *cache.LRUCache) {
+			storage.DisableCrawler()
+		})
+		for _, line := range strings.Split(header, "\n") {
+			request := ParseProtocolHeader(strings.TrimSuffix(line, "\r"))
+			if request.HasData() && request.DataLen() > 1 << 16 {
+				continue
+			}
+			if request.HasData() && !request.SetData(data) {
+				request.SetData(make([]byte, request.DataLen()))
+			}
+			res, err := request.Handle(storage, stats)
+			if err == nil && len(res) == 0 && request.Command() != "quit" {
+				t.Fatalf("Empty response to %q", line)
+			}
+		}
+	})
+}
+
+func TestHandlingWithoutKeys(t *testing.T) {
+	storage := cache.New(1024)
+	for _, command := range []string{"set", "cas", "get", "lget", "delete", "touch", "incr", "ns_flush", "lru_crawler"} {
+		enum := Ascii_protocol_enum{command: command}
+		if res, err := enum.Handle(embedded.Wrap(storage), nil); err != nil || string(res) != ERROR_TEMP {
+			t.Fatalf("Unexpected response to %s without keys: %q, %v", command, res, err)
+		}
+	}
+}
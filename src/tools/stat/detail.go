@@ -0,0 +1,140 @@
+package stat
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"tools"
+)
+
+const (
+	// Defines default delimiter between prefix and the rest of key.
+	DEFAULT_PREFIX_DELIMITER = ":"
+)
+
+// Structure keeps counters of requests related to single key prefix.
+type PrefixStat struct {
+	Get uint64
+	Hit uint64
+	Set uint64
+	Del uint64
+}
+
+// Structure implements per-prefix statistics, which are known as "stats detail" in memcached.
+// Key prefix is a part of key before the first delimiter. Keys without delimiter aren't tracked.
+type DetailStat struct {
+	enabled bool
+	delimiter string
+	prefixes map[string] *PrefixStat
+	mutex sync.Mutex
+}
+
+// Constructor of detail statistics, which receives delimiter of key prefixes.
+// Statistics are turned off after creation.
+func NewDetailStat(delimiter string) *DetailStat {
+	if len(delimiter) == 0 {
+		delimiter = DEFAULT_PREFIX_DELIMITER
+	}
+	return &DetailStat{
+		enabled: false,
+		delimiter: delimiter,
+		prefixes: make(map[string] *PrefixStat),
+	}
+}
+
+// Function turns on or off collecting of detail statistics.
+func (d *DetailStat) Enable(enabled bool) {
+	d.mutex.Lock()
+	d.enabled = enabled
+	d.mutex.Unlock()
+}
+
+// Getter for enabled field.
+func (d *DetailStat) Enabled() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.enabled
+}
+
+// Getter for delimiter field.
+func (d *DetailStat) Delimiter() string {
+	return d.delimiter
+}
+
+// Private method returns counters of prefix of passed key, or nil if they shouldn't be recorded.
+// Method has to be called under lock.
+func (d *DetailStat) find(key string) *PrefixStat {
+	if !d.enabled {
+		return nil
+	}
+	pos := strings.Index(key, d.delimiter)
+	if pos == -1 {
+		return nil
+	}
+	prefix := key[ : pos]
+	counters, exists := d.prefixes[prefix]
+	if !exists {
+		counters = new(PrefixStat)
+		d.prefixes[prefix] = counters
+	}
+	return counters
+}
+
+// Function records retrieving of key; hit defines whether key was found.
+func (d *DetailStat) RecordGet(key string, hit bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if counters := d.find(key); counters != nil {
+		counters.Get ++
+		if hit {
+			counters.Hit ++
+		}
+	}
+}
+
+// Function records storing of key.
+func (d *DetailStat) RecordSet(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if counters := d.find(key); counters != nil {
+		counters.Set ++
+	}
+}
+
+// Function records deletion of key.
+func (d *DetailStat) RecordDelete(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if counters := d.find(key); counters != nil {
+		counters.Del ++
+	}
+}
+
+// Function discards all collected counters.
+func (d *DetailStat) Reset() {
+	d.mutex.Lock()
+	d.prefixes = make(map[string] *PrefixStat)
+	d.mutex.Unlock()
+}
+
+// Function serialize sub command of stats "detail dump".
+// Lines are sorted by prefix.
+func (d *DetailStat) Dump() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var prefixes []string
+	for prefix := range d.prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var arr []string
+	for _, prefix := range prefixes {
+		counters := d.prefixes[prefix]
+		arr = append(arr, "PREFIX " + prefix +
+					 " get " + tools.UIntToString(counters.Get) +
+					 " hit " + tools.UIntToString(counters.Hit) +
+					 " set " + tools.UIntToString(counters.Set) +
+					 " del " + tools.UIntToString(counters.Del))
+	}
+	return arr
+}
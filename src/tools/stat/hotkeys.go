@@ -0,0 +1,228 @@
+package stat
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+	"tools"
+)
+
+const (
+	// Defines default number of tracked hot keys.
+	DEFAULT_HOTKEYS_CAPACITY = 100
+	// Defines default duration of sliding window for hot keys.
+	DEFAULT_HOTKEYS_WINDOW = time.Minute
+	// Defines default number of hot keys returned by "stats hotkeys".
+	DEFAULT_HOTKEYS_NUMBER = 10
+	// Dimensions of count-min sketch: number of hash functions and counters per each of them.
+	sketch_depth = 4
+	sketch_width = 2048
+)
+
+// Structure implements count-min sketch: probabilistic frequency table, which never underestimates.
+type countMinSketch struct {
+	counters [sketch_depth][sketch_width]uint32
+}
+
+// Private method returns indexes of counters of passed key for each row of sketch.
+// Indexes are derived from two halves of single 64-bit hash (double hashing).
+func (s *countMinSketch) indexes(key string) [sketch_depth]uint32 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	sum := hash.Sum64()
+	h1, h2 := uint32(sum), uint32(sum >> 32) | 1
+	var result [sketch_depth]uint32
+	for i := range result {
+		result[i] = (h1 + uint32(i) * h2) % sketch_width
+	}
+	return result
+}
+
+// Private method increases counters of key and returns its new estimation.
+func (s *countMinSketch) add(key string) uint32 {
+	var estimation uint32
+	for row, index := range s.indexes(key) {
+		s.counters[row][index] ++
+		if row == 0 || s.counters[row][index] < estimation {
+			estimation = s.counters[row][index]
+		}
+	}
+	return estimation
+}
+
+// Private method returns estimation of key's frequency.
+func (s *countMinSketch) estimate(key string) uint32 {
+	var estimation uint32
+	for row, index := range s.indexes(key) {
+		if row == 0 || s.counters[row][index] < estimation {
+			estimation = s.counters[row][index]
+		}
+	}
+	return estimation
+}
+
+// Structure describes key and its estimated number of hits.
+type HotKey struct {
+	Key string
+	Count uint64
+	index int
+}
+
+// Min-heap of hot keys, which satisfies heap.Interface.
+type hotKeysHeap []*HotKey
+
+func (h hotKeysHeap) Len() int { return len(h) }
+func (h hotKeysHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h hotKeysHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *hotKeysHeap) Push(x interface{}) {
+	item := x.(*HotKey)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *hotKeysHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old) - 1]
+	*h = old[ : len(old) - 1]
+	return item
+}
+
+// Structure implements tracker of the most frequent keys within sliding window.
+// Frequencies are estimated by two count-min sketches: for current and previous halves of window;
+// candidates are kept in min-heap of limited capacity, thus memory usage is constant.
+type TopK struct {
+	capacity int
+	window time.Duration
+	current *countMinSketch
+	previous *countMinSketch
+	rotated time.Time
+	keys hotKeysHeap
+	positions map[string] *HotKey
+	mutex sync.Mutex
+}
+
+// Constructor of top-K tracker.
+// Receives maximal number of tracked keys and duration of sliding window.
+func NewTopK(capacity int, window time.Duration) *TopK {
+	if capacity <= 0 {
+		capacity = DEFAULT_HOTKEYS_CAPACITY
+	}
+	if window <= 0 {
+		window = DEFAULT_HOTKEYS_WINDOW
+	}
+	return &TopK{
+		capacity: capacity,
+		window: window,
+		current: new(countMinSketch),
+		previous: new(countMinSketch),
+		rotated: time.Now(),
+		positions: make(map[string] *HotKey),
+	}
+}
+
+// Private method, which shifts sliding window if current half of window is over.
+// Method has to be called under lock.
+func (k *TopK) rotate() {
+	elapsed := time.Since(k.rotated)
+	if elapsed < k.window / 2 {
+		return
+	}
+	if elapsed >= k.window {
+		k.previous = new(countMinSketch)
+	} else {
+		k.previous = k.current
+	}
+	k.current = new(countMinSketch)
+	k.rotated = time.Now()
+	// candidates are reevaluated, forgotten keys are discarded.
+	keys := k.keys
+	k.keys = nil
+	k.positions = make(map[string] *HotKey)
+	for _, item := range keys {
+		item.Count = uint64(k.previous.estimate(item.Key))
+		if item.Count > 0 {
+			heap.Push(&k.keys, item)
+			k.positions[item.Key] = item
+		}
+	}
+}
+
+// Function registers single hit of passed key.
+func (k *TopK) Touch(key string) {
+	if k == nil {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.rotate()
+	count := uint64(k.current.add(key)) + uint64(k.previous.estimate(key))
+	if item, exists := k.positions[key]; exists {
+		item.Count = count
+		heap.Fix(&k.keys, item.index)
+		return
+	}
+	if len(k.keys) < k.capacity {
+		item := &HotKey{Key: key, Count: count}
+		heap.Push(&k.keys, item)
+		k.positions[key] = item
+	} else if k.keys[0].Count < count {
+		delete(k.positions, k.keys[0].Key)
+		k.keys[0].Key = key
+		k.keys[0].Count = count
+		k.positions[key] = k.keys[0]
+		heap.Fix(&k.keys, 0)
+	}
+}
+
+// Function returns up to n the most frequent keys of sliding window in descending order of their hits.
+func (k *TopK) Top(n int) []HotKey {
+	if k == nil {
+		return nil
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.rotate()
+	result := make([]HotKey, 0, len(k.keys))
+	for _, item := range k.keys {
+		result = append(result, HotKey{Key: item.Key, Count: item.Count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count == result[j].Count {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].Count > result[j].Count
+	})
+	if n >= 0 && n < len(result) {
+		result = result[ : n]
+	}
+	return result
+}
+
+// Function discards all tracked keys.
+func (k *TopK) Reset() {
+	k.mutex.Lock()
+	k.current = new(countMinSketch)
+	k.previous = new(countMinSketch)
+	k.rotated = time.Now()
+	k.keys = nil
+	k.positions = make(map[string] *HotKey)
+	k.mutex.Unlock()
+}
+
+// Function serialize sub command of stats "hotkeys".
+// Receives number of keys to return for reads and writes each.
+func (s *ServerStat) HotKeys(n int) []string {
+	var arr []string
+	for _, group := range []struct{ name string; tracker *TopK } {{"reads", s.HotReads}, {"writes", s.HotWrites}} {
+		for rank, item := range group.tracker.Top(n) {
+			arr = append(arr, group.name + ":" + tools.IntToString(int64(rank + 1)) + " " + item.Key + " " +
+						 tools.UIntToString(item.Count))
+		}
+	}
+	return arr
+}
@@ -0,0 +1,196 @@
+package stat
+
+import (
+	"math/bits"
+	"sort"
+	"sync/atomic"
+	"time"
+	"tools"
+)
+
+const (
+	// Each power of two of latency is split into 2^latency_sub_bits buckets, which gives precision about 12%.
+	latency_sub_bits = 3
+	latency_sub_buckets = 1 << latency_sub_bits
+	latency_buckets = (64 - latency_sub_bits + 1) * latency_sub_buckets
+	// Name of pseudo command, which collects requests of unknown commands.
+	UNKNOWN_COMMAND = "unknown"
+)
+
+// List of commands, which statistic of latency is collected for. Latency of streaming commands ("replicate",
+// "subscribe" and "watch") is duration of the whole stream.
+var latency_commands = []string{"set", "add", "replace", "append", "prepend", "cas", "get", "gets", "delete",
+								"touch", "flush_all", "version", "quit", "incr", "decr", "stats", "lru_crawler",
+								"lget", "lset", "ns_flush", "invalidate_tag", "replicate", "subscribe", "watch",
+								UNKNOWN_COMMAND}
+
+// Structure implements histogram of latencies and byte counters of single command.
+// All fields are updated atomically, so recording doesn't require any lock.
+type CommandLatency struct {
+	count uint64
+	total_ns uint64
+	bytes_read uint64
+	bytes_written uint64
+	buckets [latency_buckets]uint64
+}
+
+// Function returns index of histogram's bucket for passed duration in nanoseconds.
+func latencyBucket(ns uint64) int {
+	if ns < latency_sub_buckets {
+		return int(ns)
+	}
+	exponent := bits.Len64(ns) - 1
+	mantissa := (ns >> uint(exponent - latency_sub_bits)) & (latency_sub_buckets - 1)
+	return (exponent - latency_sub_bits + 1) * latency_sub_buckets + int(mantissa)
+}
+
+// Function returns middle value of bucket with passed index in nanoseconds.
+func latencyBucketValue(index int) uint64 {
+	if index < latency_sub_buckets {
+		return uint64(index)
+	}
+	exponent := uint(index / latency_sub_buckets + latency_sub_bits - 1)
+	mantissa := uint64(index % latency_sub_buckets)
+	width := uint64(1) << (exponent - latency_sub_bits)
+	return (latency_sub_buckets + mantissa) * width + width / 2
+}
+
+// Function records single request of command: its duration, amount of read bytes and bytes of response.
+func (l *CommandLatency) Record(duration time.Duration, read int, written int) {
+	if duration < 0 {
+		duration = 0
+	}
+	atomic.AddUint64(&l.count, 1)
+	atomic.AddUint64(&l.total_ns, uint64(duration))
+	atomic.AddUint64(&l.bytes_read, uint64(read))
+	atomic.AddUint64(&l.bytes_written, uint64(written))
+	atomic.AddUint64(&l.buckets[latencyBucket(uint64(duration))], 1)
+}
+
+// Function zeroes histogram and counters.
+func (l *CommandLatency) reset() {
+	atomic.StoreUint64(&l.count, 0)
+	atomic.StoreUint64(&l.total_ns, 0)
+	atomic.StoreUint64(&l.bytes_read, 0)
+	atomic.StoreUint64(&l.bytes_written, 0)
+	for i := range l.buckets {
+		atomic.StoreUint64(&l.buckets[i], 0)
+	}
+}
+
+// Function returns number of recorded requests.
+func (l *CommandLatency) Count() uint64 {
+	return atomic.LoadUint64(&l.count)
+}
+
+// Function returns average latency of recorded requests.
+func (l *CommandLatency) Mean() time.Duration {
+	count := atomic.LoadUint64(&l.count)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadUint64(&l.total_ns) / count)
+}
+
+// Function returns amount of bytes read for the command and written in response to it.
+func (l *CommandLatency) Bytes() (uint64, uint64) {
+	return atomic.LoadUint64(&l.bytes_read), atomic.LoadUint64(&l.bytes_written)
+}
+
+// Function returns estimations of latency for each of passed quantiles (values between 0 and 1).
+func (l *CommandLatency) Quantiles(quantiles ...float64) []time.Duration {
+	var histogram [latency_buckets]uint64
+	var total uint64
+	for i := range l.buckets {
+		histogram[i] = atomic.LoadUint64(&l.buckets[i])
+		total += histogram[i]
+	}
+	result := make([]time.Duration, len(quantiles))
+	if total == 0 {
+		return result
+	}
+	for n, quantile := range quantiles {
+		rank := uint64(quantile * float64(total))
+		if rank >= total {
+			rank = total - 1
+		}
+		var accumulated uint64
+		for i, number := range histogram {
+			accumulated += number
+			if accumulated > rank {
+				result[n] = time.Duration(latencyBucketValue(i))
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Structure keeps latency statistic for all supported commands.
+// Set of commands is fixed at creation, thus collection can be read without lock.
+type LatencyStat struct {
+	commands map[string] *CommandLatency
+}
+
+// Constructor of latency statistic.
+func NewLatencyStat() *LatencyStat {
+	result := &LatencyStat{commands: make(map[string] *CommandLatency, len(latency_commands))}
+	for _, command := range latency_commands {
+		result.commands[command] = new(CommandLatency)
+	}
+	return result
+}
+
+// Function records single request of command: its duration, amount of read bytes and bytes of response.
+// Unsupported commands are recorded as "unknown" one.
+func (s *LatencyStat) Record(command string, duration time.Duration, read int, written int) {
+	if s == nil {
+		return
+	}
+	latency, exists := s.commands[command]
+	if !exists {
+		latency = s.commands[UNKNOWN_COMMAND]
+	}
+	latency.Record(duration, read, written)
+}
+
+// Function returns statistic of passed command or nil if it isn't supported.
+func (s *LatencyStat) Command(command string) *CommandLatency {
+	return s.commands[command]
+}
+
+// Function zeroes statistic of all commands.
+func (s *LatencyStat) Reset() {
+	for _, latency := range s.commands {
+		latency.reset()
+	}
+}
+
+// Function serialize sub command of stats "latency".
+// Only commands, which were requested at least once, are serialized; they are sorted by name.
+func (s *ServerStat) Latencies() []string {
+	var arr []string
+	if s.Latency == nil {
+		return arr
+	}
+	var commands []string
+	for command, latency := range s.Latency.commands {
+		if latency.Count() > 0 {
+			commands = append(commands, command)
+		}
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		latency := s.Latency.commands[command]
+		quantiles := latency.Quantiles(0.5, 0.9, 0.99, 0.999)
+		read, written := latency.Bytes()
+		arr = append(arr, command + ":count " + tools.UIntToString(latency.Count()),
+					 command + ":p50_us " + tools.IntToString(int64(quantiles[0] / time.Microsecond)),
+					 command + ":p90_us " + tools.IntToString(int64(quantiles[1] / time.Microsecond)),
+					 command + ":p99_us " + tools.IntToString(int64(quantiles[2] / time.Microsecond)),
+					 command + ":p999_us " + tools.IntToString(int64(quantiles[3] / time.Microsecond)),
+					 command + ":bytes_read " + tools.UIntToString(read),
+					 command + ":bytes_written " + tools.UIntToString(written))
+	}
+	return arr
+}
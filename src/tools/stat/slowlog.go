@@ -0,0 +1,131 @@
+package stat
+
+import (
+	"sync"
+	"time"
+	"tools"
+)
+
+const (
+	// Defines default threshold, after which request is considered as slow.
+	DEFAULT_SLOWLOG_THRESHOLD = 10 * time.Millisecond
+	// Defines default number of entries kept by slow log.
+	DEFAULT_SLOWLOG_LENGTH = 128
+)
+
+// Structure describes a request, which took more time than allowed by threshold.
+type SlowLogEntry struct {
+	Id uint64
+	Ts int64
+	Command string
+	Keys int
+	Bytes int
+	Addr string
+	Duration time.Duration
+}
+
+// Structure implements ring buffer of slow requests.
+// When buffer is full, the oldest entry is overwritten by the newest one.
+type SlowLog struct {
+	threshold time.Duration
+	entries []SlowLogEntry
+	next int
+	size int
+	counter uint64
+	mutex sync.Mutex
+}
+
+// Constructor of slow log.
+// Receives threshold of duration, requests above which will be recorded, and maximal amount of kept entries.
+// Non positive threshold or length means that slow log is turned off.
+func NewSlowLog(threshold time.Duration, length int) *SlowLog {
+	if length < 0 {
+		length = 0
+	}
+	return &SlowLog{
+		threshold: threshold,
+		entries: make([]SlowLogEntry, length),
+	}
+}
+
+// Getter for threshold field.
+func (l *SlowLog) Threshold() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.threshold
+}
+
+// Function changes threshold of slow log.
+func (l *SlowLog) SetThreshold(threshold time.Duration) {
+	l.mutex.Lock()
+	l.threshold = threshold
+	l.mutex.Unlock()
+}
+
+// Function records request into the log if its duration exceeds threshold.
+// Returns true if request was recorded, otherwise false.
+func (l *SlowLog) Record(command string, keys int, bytes int, addr string, duration time.Duration) bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.threshold <= 0 || len(l.entries) == 0 || duration < l.threshold {
+		return false
+	}
+	l.counter ++
+	l.entries[l.next] = SlowLogEntry{
+		Id: l.counter,
+		Ts: time.Now().Unix(),
+		Command: command,
+		Keys: keys,
+		Bytes: bytes,
+		Addr: addr,
+		Duration: duration,
+	}
+	l.next = (l.next + 1) % len(l.entries)
+	if l.size < len(l.entries) {
+		l.size ++
+	}
+	return true
+}
+
+// Function returns copy of recorded entries starting from the newest one.
+func (l *SlowLog) Entries() []SlowLogEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	result := make([]SlowLogEntry, 0, l.size)
+	for i := 1; i <= l.size; i ++ {
+		result = append(result, l.entries[(l.next - i + len(l.entries)) % len(l.entries)])
+	}
+	return result
+}
+
+// Function discards all recorded entries.
+func (l *SlowLog) Reset() {
+	l.mutex.Lock()
+	for i := range l.entries {
+		l.entries[i] = SlowLogEntry{}
+	}
+	l.next = 0
+	l.size = 0
+	l.mutex.Unlock()
+}
+
+// Function serialize sub command of stats "slowlog"
+func (s *ServerStat) SlowLogEntries() []string {
+	var arr []string
+	if s.SlowLog == nil {
+		return arr
+	}
+	for _, entry := range s.SlowLog.Entries() {
+		arr = append(arr, tools.UIntToString(entry.Id) +
+				     " ts=" + tools.IntToString(entry.Ts) +
+				     " duration_us=" + tools.IntToString(int64(entry.Duration / time.Microsecond)) +
+				     " client=" + entry.Addr +
+				     " command=" + entry.Command +
+				     " keys=" + tools.IntToString(int64(entry.Keys)) +
+				     " bytes=" + tools.IntToString(int64(entry.Bytes)))
+	}
+	return arr
+}
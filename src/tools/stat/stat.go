@@ -12,6 +12,8 @@ import (
 	"tools/cache"
 	"tools"
 	"net"
+	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -36,6 +38,16 @@ type ServerStat struct {
 	Read_bytes uint64
 	Written_bytes uint64
 	Commands map[string] uint64
+	SlowLog *SlowLog
+	Detail *DetailStat
+	HotReads *TopK
+	HotWrites *TopK
+	Latency *LatencyStat
+	Replication func() []string // returns lines of replication statistic; nil if replication isn't set up
+	AppendLog func() []string // returns lines of statistic of append-only log; nil if it isn't set up
+	PubSub func() []string // returns lines of statistic of subscriptions to events of storage
+	config map[string] string // effective configuration, which is included into "stats settings"
+	mutex sync.Mutex
 }
 
 // Structure for logging statistics for connections bound with server.
@@ -77,6 +89,47 @@ func New(memory_amount int64, tcp_port string, udp_port string,
 		Read_bytes: 0,
 		Written_bytes: 0,
 		Commands: make(map[string] uint64),
+		SlowLog: NewSlowLog(DEFAULT_SLOWLOG_THRESHOLD, DEFAULT_SLOWLOG_LENGTH),
+		Detail: NewDetailStat(DEFAULT_PREFIX_DELIMITER),
+		HotReads: NewTopK(DEFAULT_HOTKEYS_CAPACITY, DEFAULT_HOTKEYS_WINDOW),
+		HotWrites: NewTopK(DEFAULT_HOTKEYS_CAPACITY, DEFAULT_HOTKEYS_WINDOW),
+		Latency: NewLatencyStat(),
+	}
+}
+
+// Function increases counter of command statistic with passed name.
+func (s *ServerStat) Count(name string) {
+	s.mutex.Lock()
+	s.Commands[name] ++
+	s.mutex.Unlock()
+}
+
+// Function zeroes all resettable counters of server and passed storage at once:
+// commands counters, amount of read and written bytes, total connections, storage's counters,
+// per-prefix statistics, hot keys, latencies of commands and slow log.
+func (s *ServerStat) Reset(storage *cache.LRUCache) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Commands = make(map[string] uint64)
+	atomic.StoreUint64(&s.Read_bytes, 0)
+	atomic.StoreUint64(&s.Written_bytes, 0)
+	atomic.StoreUint32(&s.Total_connections, 0)
+	if storage != nil {
+		storage.ResetStats()
+	}
+	if s.Detail != nil {
+		s.Detail.Reset()
+	}
+	if s.SlowLog != nil {
+		s.SlowLog.Reset()
+	}
+	if s.Latency != nil {
+		s.Latency.Reset()
+	}
+	for _, tracker := range []*TopK{s.HotReads, s.HotWrites} {
+		if tracker != nil {
+			tracker.Reset()
+		}
 	}
 }
 
@@ -107,6 +160,8 @@ func (s *ServerStat) rusage() (int64, int64, int64, int64) {
 
 // Function returns amount of used bytes to store items.
 func (s *ServerStat) bytes(capacity int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return int64(s.limit_maxbytes) - capacity
 }
 
@@ -124,29 +179,29 @@ func (s *ServerStat) Serialize(storage *cache.LRUCache) map[string] string {
 	dict["curr_items"] = tools.IntToString(int64(storage.Stats.Current_items))
 	dict["total_items"] = tools.IntToString(int64(storage.Stats.Total_items))
 	dict["bytes"] = tools.IntToString(s.bytes(storage.Capacity()))
-	dict["curr_connections"] = tools.IntToString(int64(s.Current_connections))
-	dict["total_connections"] = tools.IntToString(int64(s.Total_connections))
+	dict["curr_connections"] = tools.IntToString(int64(atomic.LoadUint32(&s.Current_connections)))
+	dict["total_connections"] = tools.IntToString(int64(atomic.LoadUint32(&s.Total_connections)))
 	dict["evictions"] = tools.IntToString(int64(storage.Stats.Evictions))
 	dict["expired_unfetched"] = tools.IntToString(int64(storage.Stats.Expired_unfetched))
 	dict["evicted_unfetched"] = tools.IntToString(int64(storage.Stats.Evicted_unfetched))
-	dict["bytes_read"] = tools.IntToString(int64(s.Read_bytes))
-	dict["bytes_written"] = tools.IntToString(int64(s.Written_bytes))
+	dict["bytes_read"] = tools.IntToString(int64(atomic.LoadUint64(&s.Read_bytes)))
+	dict["bytes_written"] = tools.IntToString(int64(atomic.LoadUint64(&s.Written_bytes)))
 	dict["goroutines"] = tools.IntToString(int64(runtime.NumGoroutine()))
 	dict["crawler_reclaimed"] = tools.IntToString(storage.Stats.Crawler_reclaimed)
+	s.mutex.Lock()
 	for key, value := range s.Commands {
 		dict[key] = tools.IntToString(int64(value))
 	}
+	s.mutex.Unlock()
 	return dict
 }
 
 // Function serialize sub command of stats "settings"
 func (s *ServerStat) Settings(storage *cache.LRUCache) map[string] string {
 	dict := make(map[string] string)
-	dict["maxbytes"] = tools.IntToString(storage.Capacity())
-	dict["maxconns"] = tools.IntToString(int64(s.Connections_limit))
+	dict["maxbytes"] = tools.IntToString(storage.Stats.Volume)
 	dict["tcpport"] = s.tcp
 	dict["udpport"] = s.udp
-	dict["verbosity"] = tools.IntToString(int64(s.verbosity))
 	dict["num_goroutines"] = tools.IntToString(int64(runtime.NumGoroutine()))
 	dict["evictions"] = "on" //TODO: to think about apportunity of another value.
 	if storage.Crawler.Enabled() {
@@ -166,13 +221,42 @@ func (s *ServerStat) Settings(storage *cache.LRUCache) map[string] string {
 	} else {
 		dict["flush_all_enabled"] = "true"
 	}
+	s.mutex.Lock()
+	dict["verbosity"] = tools.IntToString(int64(s.verbosity))
+	dict["maxconns"] = tools.IntToString(int64(s.Connections_limit))
+	for key, value := range s.config {
+		if _, exists := dict[key]; !exists {
+			dict[key] = value
+		}
+	}
+	s.mutex.Unlock()
 	return dict
 }
 
+// Function sets effective configuration of server, which is included into "stats settings"
+// (values of settings of statistic itself take precedence).
+func (s *ServerStat) SetConfig(config map[string] string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.config = config
+}
+
+// Function updates settings of statistic, which may be changed while server is running:
+// verbosity, limit of connections and memory limit (bytes).
+func (s *ServerStat) Reconfigure(verbosity int, conn_max int, memory_amount int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.verbosity = verbosity
+	s.Connections_limit = conn_max
+	s.limit_maxbytes = memory_amount
+}
+
 
 // Function serialize sub command of stats "conns"
 func (s *ServerStat) Conns() []string {
 	var arr []string
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	for _, value := range s.Connections {
 		arr = append(arr, "<NULL>:addr " + value.Addr,  "<NULL>:state " + value.State,
 				     "<NULL>:secs_since_last_cmd " + tools.IntToString(time.Now().Unix() - value.Cmd_hit_ts))
@@ -180,6 +264,40 @@ func (s *ServerStat) Conns() []string {
 	return arr
 }
 
+// Function registers statistic of new connection and increases number of current connections.
+func (s *ServerStat) AddConnection(connection net.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	address := connection.RemoteAddr().String()
+	if s.Connections[address] == nil {
+		s.Connections[address] = NewConnStat(connection)
+		atomic.AddUint32(&s.Current_connections, 1)
+	}
+}
+
+// Function discards statistic of connection with passed address and decreases number of current connections.
+func (s *ServerStat) RemoveConnection(address string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Connections[address] != nil {
+		delete(s.Connections, address)
+		atomic.AddUint32(&s.Current_connections, ^uint32(0))
+	}
+}
+
+// Function sets state of connection with passed address, if such connection is registered.
+// If touch param is true, the time of the most recent command of connection is updated as well.
+func (s *ServerStat) SetConnectionState(address string, state string, touch bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if connection := s.Connections[address]; connection != nil {
+		connection.State = state
+		if touch {
+			connection.Cmd_hit_ts = time.Now().Unix()
+		}
+	}
+}
+
 // Constructor for connection statistic
 func NewConnStat(connection net.Conn) *ConnectionStat {
 	return &ConnectionStat {
@@ -200,3 +318,18 @@ func (s *ServerStat) Items(storage *cache.LRUCache) map[string] string {
 	dict["outofmemory"] = tools.IntToString(storage.Stats.Outofmem)
 	return dict
 }
+
+// Serialization of sub command sizes.
+// Upper bound of each non-empty bucket of 32 bytes is mapped to number of stored items of such size.
+// If histogram is turned off, there will be returned single "sizes_status" field.
+func (s *ServerStat) Sizes(storage *cache.LRUCache) map[string] string {
+	dict := make(map[string] string)
+	if !storage.SizesEnabled() {
+		dict["sizes_status"] = "disabled"
+		return dict
+	}
+	for bucket, number := range storage.Sizes() {
+		dict[tools.IntToString(int64(bucket))] = tools.UIntToString(number)
+	}
+	return dict
+}
@@ -7,6 +7,7 @@ import (
 	"tools/cache"
 	"net"
 	"fmt"
+	"tools"
 )
 
 func TestNewServerStat(t *testing.T){
@@ -137,3 +138,202 @@ func TestConnectionsItems(t *testing.T) {
 		t.Fatalf("Unexpected length of returned value; expected 6.")
 	}
 }
+
+func TestSlowLogRecording(t *testing.T) {
+	slowlog := NewSlowLog(time.Millisecond, 2)
+	if slowlog.Record("get", 1, 0, "127.0.0.1:42", time.Microsecond) || len(slowlog.Entries()) != 0 {
+		t.Fatalf("Request below threshold was recorded.")
+	}
+	if !slowlog.Record("set", 1, 10, "127.0.0.1:42", time.Millisecond) ||
+	   !slowlog.Record("get", 2, 0, "127.0.0.1:42", 2 * time.Millisecond) ||
+	   !slowlog.Record("append", 1, 5, "127.0.0.1:43", 3 * time.Millisecond) {
+		t.Fatalf("Slow request wasn't recorded.")
+	}
+	entries := slowlog.Entries()
+	if len(entries) != 2 || entries[0].Command != "append" || entries[0].Id != 3 ||
+	   entries[1].Command != "get" || entries[1].Keys != 2 || entries[1].Duration != 2 * time.Millisecond {
+		t.Fatalf("Unexpected entries of slow log: %v", entries)
+	}
+	slowlog.Reset()
+	if len(slowlog.Entries()) != 0 {
+		t.Fatalf("Slow log wasn't reset.")
+	}
+}
+
+func TestSlowLogDisabled(t *testing.T) {
+	if NewSlowLog(0, 10).Record("get", 1, 0, "", time.Second) ||
+	   NewSlowLog(time.Millisecond, 0).Record("get", 1, 0, "", time.Second) {
+		t.Fatalf("Disabled slow log recorded request.")
+	}
+	var slowlog *SlowLog
+	if slowlog.Record("get", 1, 0, "", time.Second) {
+		t.Fatalf("Undefined slow log recorded request.")
+	}
+}
+
+func TestSlowLogSerialization(t *testing.T) {
+	stats := New(42, "9999", "8888", 1024, 2, true, true)
+	stats.SlowLog.Record("set", 1, 42, "127.0.0.1:42", time.Second)
+	res := stats.SlowLogEntries()
+	if len(res) != 1 || res[0] != "1 ts=" + tools.IntToString(stats.SlowLog.Entries()[0].Ts) +
+								 " duration_us=1000000 client=127.0.0.1:42 command=set keys=1 bytes=42" {
+		t.Fatalf("Unexpected serialization of slow log: %v", res)
+	}
+}
+
+func TestStatReset(t *testing.T) {
+	stats := New(42, "9999", "8888", 1024, 2, true, true)
+	storage := cache.New(42)
+	stats.Count("cmd_get")
+	stats.Read_bytes = 42
+	stats.Written_bytes = 42
+	stats.Total_connections = 2
+	stats.Current_connections = 1
+	storage.Stats.Evictions = 1
+	storage.Stats.Total_items = 1
+	stats.Detail.Enable(true)
+	stats.Detail.RecordSet("prefix:key")
+	stats.SlowLog.Record("get", 1, 0, "", time.Second)
+	stats.Reset(storage)
+	if len(stats.Commands) != 0 || stats.Read_bytes != 0 || stats.Written_bytes != 0 || stats.Total_connections != 0 ||
+	   stats.Current_connections != 1 || storage.Stats.Evictions != 0 || storage.Stats.Total_items != 0 ||
+	   len(stats.Detail.Dump()) != 0 || len(stats.SlowLog.Entries()) != 0 {
+		t.Fatalf("Unexpected state of statistic after reset: %v", stats)
+	}
+}
+
+func TestDetailStat(t *testing.T) {
+	detail := NewDetailStat("/")
+	detail.RecordSet("a/1")
+	if len(detail.Dump()) != 0 {
+		t.Fatalf("Disabled detail statistic recorded request.")
+	}
+	detail.Enable(true)
+	detail.RecordSet("a/1")
+	detail.RecordGet("a/1", true)
+	detail.RecordGet("a/2", false)
+	detail.RecordDelete("b/1")
+	detail.RecordGet("nodelimiter", true)
+	res := detail.Dump()
+	if len(res) != 2 || res[0] != "PREFIX a get 2 hit 1 set 1 del 0" || res[1] != "PREFIX b get 0 hit 0 set 0 del 1" {
+		t.Fatalf("Unexpected dump of detail statistic: %v", res)
+	}
+}
+
+func TestSizesSerialization(t *testing.T) {
+	stats := New(42, "9999", "8888", 1024, 2, true, true)
+	storage := cache.New(42)
+	storage.EnableSizes()
+	storage.Set(tools.NewStoredData([]byte("TEST"), "key"), 0, 0, 0)
+	res := stats.Sizes(storage)
+	if len(res) != 1 || res["32"] != "1" {
+		t.Fatalf("Unexpected serialization of sizes: %v", res)
+	}
+	storage.DisableSizes()
+	res = stats.Sizes(storage)
+	if len(res) != 1 || res["sizes_status"] != "disabled" {
+		t.Fatalf("Unexpected serialization of disabled sizes: %v", res)
+	}
+}
+
+func TestTopKTracking(t *testing.T) {
+	tracker := NewTopK(3, time.Minute)
+	for i := 0; i < 10; i ++ {
+		tracker.Touch("hot")
+	}
+	for i := 0; i < 5; i ++ {
+		tracker.Touch("warm")
+	}
+	for i := 0; i < 100; i ++ {
+		tracker.Touch("cold" + tools.IntToString(int64(i)))
+	}
+	top := tracker.Top(2)
+	if len(top) != 2 || top[0].Key != "hot" || top[0].Count < 10 || top[1].Key != "warm" || top[1].Count < 5 {
+		t.Fatalf("Unexpected hot keys: %v", top)
+	}
+	if len(tracker.Top(-1)) != 3 {
+		t.Fatalf("Tracker keeps more keys than allowed: %v", tracker.Top(-1))
+	}
+	tracker.Reset()
+	if len(tracker.Top(10)) != 0 {
+		t.Fatalf("Tracker wasn't reset.")
+	}
+}
+
+func TestTopKSlidingWindow(t *testing.T) {
+	tracker := NewTopK(10, time.Millisecond * time.Duration(20))
+	tracker.Touch("old")
+	time.Sleep(time.Millisecond * time.Duration(12))
+	tracker.Touch("new")
+	top := tracker.Top(10)
+	if len(top) != 2 {
+		t.Fatalf("Key of previous half of window was forgotten: %v", top)
+	}
+	time.Sleep(time.Millisecond * time.Duration(25))
+	if top = tracker.Top(10); len(top) != 0 {
+		t.Fatalf("Keys outside of window weren't forgotten: %v", top)
+	}
+}
+
+func TestHotKeysSerialization(t *testing.T) {
+	stats := New(42, "9999", "8888", 1024, 2, true, true)
+	stats.HotReads.Touch("a")
+	stats.HotReads.Touch("a")
+	stats.HotReads.Touch("b")
+	stats.HotWrites.Touch("c")
+	res := stats.HotKeys(1)
+	if len(res) != 2 || res[0] != "reads:1 a 2" || res[1] != "writes:1 c 1" {
+		t.Fatalf("Unexpected serialization of hot keys: %v", res)
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	for _, ns := range []uint64{0, 7, 8, 15, 16, 1000, 123456789, 1 << 62} {
+		value := latencyBucketValue(latencyBucket(ns))
+		if value > ns + ns / 8 + 1 || value + value / 8 + 1 < ns {
+			t.Fatalf("Bucket value %d is too far from recorded value %d", value, ns)
+		}
+	}
+	if latencyBucket(^uint64(0)) != latency_buckets - 1 {
+		t.Fatalf("The greatest latency is out of histogram.")
+	}
+}
+
+func TestLatencyQuantiles(t *testing.T) {
+	latency := NewLatencyStat()
+	for i := 1; i <= 1000; i ++ {
+		latency.Record("get", time.Duration(i) * time.Microsecond, 10, 20)
+	}
+	latency.Record("omfg", time.Second, 1, 1)
+	for _, command := range []string{"lget", "lset", "ns_flush", "invalidate_tag", "replicate", "subscribe", "watch"} {
+		latency.Record(command, time.Millisecond, 1, 1)
+		if latency.Command(command) == latency.Command(UNKNOWN_COMMAND) || latency.Command(command).Count() != 1 {
+			t.Fatalf("Command %s was recorded as unknown one.", command)
+		}
+	}
+	get := latency.Command("get")
+	quantiles := get.Quantiles(0.5, 0.99)
+	if get.Count() != 1000 || quantiles[0] < 450 * time.Microsecond || quantiles[0] > 550 * time.Microsecond ||
+	   quantiles[1] < 900 * time.Microsecond || quantiles[1] > 1100 * time.Microsecond {
+		t.Fatalf("Unexpected quantiles: %v", quantiles)
+	}
+	if read, written := get.Bytes(); read != 10000 || written != 20000 {
+		t.Fatalf("Unexpected byte counters: %d, %d", read, written)
+	}
+	if latency.Command(UNKNOWN_COMMAND).Count() != 1 {
+		t.Fatalf("Unknown command wasn't recorded.")
+	}
+	latency.Reset()
+	if get.Count() != 0 || get.Quantiles(0.5)[0] != 0 {
+		t.Fatalf("Latency statistic wasn't reset.")
+	}
+}
+
+func TestLatencySerialization(t *testing.T) {
+	stats := New(42, "9999", "8888", 1024, 2, true, true)
+	stats.Latency.Record("incr", time.Millisecond, 12, 4)
+	res := stats.Latencies()
+	if len(res) != 7 || res[0] != "incr:count 1" || res[5] != "incr:bytes_read 12" || res[6] != "incr:bytes_written 4" {
+		t.Fatalf("Unexpected serialization of latencies: %v", res)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Package implements streams of lines, which are served to connections of clients of streaming commands
+("subscribe" and "watch"). Lines are queued for each client without blocking of producers: when queue overflows,
+lines are dropped and the next delivered line is a marker with number of dropped lines, which is built by producer.
+*/
+package stream
+
+import (
+	"bufio"
+	"io"
+	"net"
+)
+
+// Structure of stream of single client: bounded queue of its lines.
+type Stream struct {
+	queue chan string
+	lost uint64 // number of lines dropped since the last delivered one
+	marker func(lost uint64) string
+}
+
+// Constructor of stream, which receives maximal number of queued lines and function, which builds marker line
+// with number of dropped lines.
+func New(size int, marker func(lost uint64) string) *Stream {
+	return &Stream{queue: make(chan string, size), marker: marker}
+}
+
+// Public method of stream, which returns channel of its queued lines.
+func (s *Stream) Lines() <-chan string {
+	return s.queue
+}
+
+// Public method of stream, which queues passed line without blocking and returns false if it was dropped.
+// Stream, which lost lines, gets marker as soon as there is room for it.
+// Calls of Push have to be serialized by caller.
+func (s *Stream) Push(line string) bool {
+	if s.lost > 0 {
+		select {
+		case s.queue <- s.marker(s.lost):
+			s.lost = 0
+		default:
+		}
+	}
+	if s.lost == 0 {
+		select {
+		case s.queue <- line:
+			return true
+		default:
+		}
+	}
+	s.lost ++
+	return false
+}
+
+// Public method of stream, which serves connection of its client, after streaming command was read from it:
+// answers "OK" and writes queued lines until client closes connection or sends any line to passed reader.
+func (s *Stream) Serve(connection net.Conn, reader io.Reader) error {
+	closed := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(reader).ReadString('\n')
+		closed <- err
+	}()
+	writer := bufio.NewWriter(connection)
+	writer.WriteString("OK\r\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case line := <-s.queue:
+			writer.WriteString(line)
+			// let's write all queued lines at once.
+			for pending := len(s.queue); pending > 0; pending -- {
+				writer.WriteString(<-s.queue)
+			}
+		case err := <-closed:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+}
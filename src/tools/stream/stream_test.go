@@ -0,0 +1,51 @@
+package stream
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"tools"
+)
+
+func TestStreamLostLines(t *testing.T) {
+	s := New(2, func(lost uint64) string { return "LOST " + tools.UIntToString(lost) + "\r\n" })
+	for i, line := range []string{"1\r\n", "2\r\n", "3\r\n", "4\r\n"} {
+		if s.Push(line) != (i < 2) {
+			t.Fatalf("Unexpected result of pushing of line %d.", i)
+		}
+	}
+	for _, expected := range []string{"1\r\n", "2\r\n"} {
+		if line := <-s.Lines(); line != expected {
+			t.Fatalf("Unexpected line: %q, expected %q", line, expected)
+		}
+	}
+	if !s.Push("5\r\n") {
+		t.Fatalf("Line was dropped after marker.")
+	}
+	for _, expected := range []string{"LOST 2\r\n", "5\r\n"} {
+		if line := <-s.Lines(); line != expected {
+			t.Fatalf("Unexpected line: %q, expected %q", line, expected)
+		}
+	}
+}
+
+func TestStreamServe(t *testing.T) {
+	server, client := net.Pipe()
+	s := New(10, nil)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(server, server)
+	}()
+	reader := bufio.NewReader(client)
+	if line, err := reader.ReadString('\n'); err != nil || line != "OK\r\n" {
+		t.Fatalf("Unexpected answer: %q, %s", line, err)
+	}
+	s.Push("line\r\n")
+	if line, err := reader.ReadString('\n'); err != nil || line != "line\r\n" {
+		t.Fatalf("Unexpected line: %q, %s", line, err)
+	}
+	client.Write([]byte("quit\r\n"))
+	if err := <-served; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
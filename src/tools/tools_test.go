@@ -158,3 +158,39 @@ func TestExtraction(t *testing.T){
 		t.Fatalf("The result is unexpected.")
 	}
 }
+
+func TestChunkedData(t *testing.T){
+	value := make([]byte, CHUNK_SIZE * 2 + 10)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	chunks := Chunk(value)
+	if len(chunks) != 3 || len(chunks[2]) != 10 || cap(chunks[0]) != CHUNK_SIZE {
+		t.Fatalf("Unexpected splitting of value into chunks.")
+	}
+	data := NewChunkedData(chunks, "key")
+	if data.Size() != len(value) || string(data.Value()) != string(value) || data.Key() != "key" {
+		t.Fatalf("The results of methods are unexpected.")
+	}
+	// the last chunk is copied once and filled in place by the next appending.
+	first := data.Concat([][]byte{[]byte("abc")}, false)
+	second := first.Concat([][]byte{[]byte("def")}, false)
+	if &first.chunks[2][0] != &second.chunks[2][0] || &first.chunks[0][0] != &data.chunks[0][0] {
+		t.Fatalf("Chunks weren't shared between versions.")
+	}
+	if string(first.Value()[len(value) : ]) != "abc" || string(second.Value()[len(value) : ]) != "abcdef" ||
+	   first.Size() != len(value) + 3 || len(ExtractChunks(second)) != 3 {
+		t.Fatalf("Unexpected result of appending.")
+	}
+	prepended := second.Concat([][]byte{[]byte("xyz")}, true)
+	if string(prepended.Value()[ : 3]) != "xyz" || prepended.Size() != second.Size() + 3 {
+		t.Fatalf("Unexpected result of prepending.")
+	}
+	large := first.Concat([][]byte{make([]byte, CHUNK_SIZE)}, false)
+	if len(large.chunks) != 4 || len(large.chunks[2]) != CHUNK_SIZE || large.Size() != first.Size() + CHUNK_SIZE {
+		t.Fatalf("Unexpected chunks after appending of large data.")
+	}
+	if chunks := ExtractChunks(NewStoredData([]byte("111"), "1")); len(chunks) != 1 || string(chunks[0]) != "111" {
+		t.Fatalf("Value of stored data wasn't extracted as single chunk.")
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Package implements memcached-compatible "watch" command: streaming of log of operations for live debugging.
+
+Client sends "watch [fetchers] [mutations] [evictions]" command (without arguments fetchers are watched) and server
+answers with "OK" line, after which the connection becomes read-only stream of lines like
+"ts=<unix time> gid=<id> type=<type> key=<key> ...", where type is "item_get" (retrieval commands),
+"item_store" (commands, which modify storage) or "eviction". Lines of commands also contain name of command,
+status (the first line of response), size (of data for mutations or of response for fetchers) and address
+of client. Lines are queued for each watcher without blocking of requests: when queue of watcher overflows,
+lines are skipped and the next delivered line is "ts=<unix time> gid=<id> type=skipped count=<number>".
+Stream ends when client closes connection or sends any line.
+*/
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tools"
+	"tools/cache"
+	"tools/stream"
+)
+
+// Flags of kinds of watched operations.
+const (
+	FETCHERS = 1 << iota
+	MUTATIONS
+	EVICTIONS
+)
+
+const (
+	// Command, which turns connection into stream of log.
+	WATCH = "watch"
+	// Defines default number of lines, which may be queued for single watcher.
+	DEFAULT_BUFFER_SIZE = 4096
+)
+
+// Error, which is returned when arguments of watch command are invalid.
+var ErrInvalidArguments = errors.New("Invalid arguments of watch.")
+
+// Names of kinds of operations, which are passed to watch command.
+var kinds = map[string] int{"fetchers": FETCHERS, "mutations": MUTATIONS, "evictions": EVICTIONS}
+
+// Commands, which are logged as fetchers and mutations.
+var fetchers = []string{"get", "gets", "lget"}
+var mutations = []string{"set", "add", "replace", "append", "prepend", "cas", "lset", "incr", "decr", "touch", "delete",
+						 "flush_all", "ns_flush", "invalidate_tag"}
+
+// Function parses arguments of watch command into flags of watched kinds of operations.
+func ParseFlags(args []string) (int, error) {
+	if len(args) == 0 {
+		return FETCHERS, nil
+	}
+	var flags = 0
+	for _, arg := range args {
+		kind, exists := kinds[arg]
+		if !exists {
+			return 0, ErrInvalidArguments
+		}
+		flags |= kind
+	}
+	return flags, nil
+}
+
+// Function returns kind of operation of passed command (FETCHERS or MUTATIONS) or zero if it isn't logged.
+func Kind(command string) int {
+	if tools.In(command, fetchers) {
+		return FETCHERS
+	}
+	if tools.In(command, mutations) {
+		return MUTATIONS
+	}
+	return 0
+}
+
+// Structure of watcher: its flags and stream of lines.
+type watcher struct {
+	flags int
+	queue *stream.Stream
+}
+
+// Log of operations, which is served to watchers. It's listener of storage (implementation of cache.Listener),
+// which reports evictions.
+type Log struct {
+	watchers map[*watcher] bool
+	mutex sync.Mutex
+	flags int32 // union of flags of current watchers
+	gid uint64
+	// Maximal number of lines queued for single watcher.
+	BufferSize int
+}
+
+// Function creates log of operations.
+func NewLog() *Log {
+	return &Log{watchers: make(map[*watcher] bool), BufferSize: DEFAULT_BUFFER_SIZE}
+}
+
+// Function returns true if operations of passed kind are watched by anybody, so they should be recorded.
+func (l *Log) Active(kind int) bool {
+	return int(atomic.LoadInt32(&l.flags)) & kind != 0
+}
+
+// Private method, which returns prefix of line with current time and global id.
+func (l *Log) prefix() string {
+	now := time.Now()
+	return fmt.Sprintf("ts=%d.%06d gid=%d", now.Unix(), now.Nanosecond() / 1000, atomic.AddUint64(&l.gid, 1))
+}
+
+// Private method, which queues line of operation of passed kind for watchers without blocking.
+func (l *Log) record(kind int, line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for w := range l.watchers {
+		if w.flags & kind != 0 {
+			w.queue.Push(line)
+		}
+	}
+}
+
+// Private method, which builds line with number of skipped lines.
+func (l *Log) skipped(count uint64) string {
+	return l.prefix() + " type=skipped count=" + tools.UIntToString(count) + "\r\n"
+}
+
+// Function records command of client with passed address: its name, key, status (the first line of response)
+// and size (of data or response). Commands, which aren't fetchers or mutations, are ignored.
+func (l *Log) Command(command string, key string, status string, size int, client string) {
+	kind := Kind(command)
+	if !l.Active(kind) {
+		return
+	}
+	var kind_name = "item_store"
+	if kind == FETCHERS {
+		kind_name = "item_get"
+		if strings.HasPrefix(status, "VALUE ") {
+			status = "found"
+		} else if status == "END" {
+			status = "not_found"
+		}
+	}
+	status = strings.Replace(status, " ", "_", -1)
+	var line = l.prefix() + " type=" + kind_name + " cmd=" + command
+	if len(key) > 0 {
+		line += " key=" + key
+	}
+	l.record(kind, line + " status=" + status + " size=" + tools.IntToString(int64(size)) + " client=" + client + "\r\n")
+}
+
+// Implementation of cache.Listener: records evictions of storage.
+func (l *Log) Changed(event string, key string, size int) {
+	if event != cache.EVENT_EVICT || !l.Active(EVICTIONS) {
+		return
+	}
+	l.record(EVICTIONS, l.prefix() + " type=eviction key=" + key + " size=" + tools.IntToString(int64(size)) + "\r\n")
+}
+
+// Private method, which recalculates union of flags of watchers. Mutex should be held by caller.
+func (l *Log) update() {
+	var flags = 0
+	for w := range l.watchers {
+		flags |= w.flags
+	}
+	atomic.StoreInt32(&l.flags, int32(flags))
+}
+
+// Function serves connection of watcher, after watch command with passed flags was read from it.
+// Passed reader is watched for closing of connection or any line from client, which ends the stream.
+func (l *Log) Serve(connection net.Conn, reader io.Reader, flags int) error {
+	w := &watcher{flags: flags, queue: stream.New(l.BufferSize, l.skipped)}
+	l.mutex.Lock()
+	l.watchers[w] = true
+	l.update()
+	l.mutex.Unlock()
+	defer func() {
+		l.mutex.Lock()
+		delete(l.watchers, w)
+		l.update()
+		l.mutex.Unlock()
+	}()
+	return w.queue.Serve(connection, reader)
+}
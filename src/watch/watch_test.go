@@ -0,0 +1,51 @@
+package watch
+
+import (
+	"strings"
+	"testing"
+	"tools/cache"
+	"tools/stream"
+)
+
+func TestFlagsParsing(t *testing.T) {
+	if flags, err := ParseFlags(nil); err != nil || flags != FETCHERS {
+		t.Fatalf("Unexpected default flags: %d, %s", flags, err)
+	}
+	if flags, err := ParseFlags([]string{"mutations", "evictions"}); err != nil || flags != MUTATIONS | EVICTIONS {
+		t.Fatalf("Unexpected flags: %d, %s", flags, err)
+	}
+	if _, err := ParseFlags([]string{"fetchers", "unknown"}); err != ErrInvalidArguments {
+		t.Fatalf("Unknown kind was accepted.")
+	}
+	if Kind("gets") != FETCHERS || Kind("incr") != MUTATIONS || Kind("stats") != 0 {
+		t.Fatalf("Unexpected kinds of commands.")
+	}
+}
+
+func TestSkippedLines(t *testing.T) {
+	log := NewLog()
+	w := &watcher{flags: MUTATIONS | EVICTIONS, queue: stream.New(2, log.skipped)}
+	log.watchers[w] = true
+	log.update()
+	if log.Active(FETCHERS) || !log.Active(EVICTIONS) {
+		t.Fatalf("Unexpected activity of log.")
+	}
+	log.Command("get", "key", "END", 5, "client")
+	log.Command("set", "key", "STORED", 5, "127.0.0.1:1234")
+	log.Changed(cache.EVENT_EVICT, "key", 5)
+	log.Changed(cache.EVENT_EXPIRE, "key", 5)
+	log.Command("delete", "key", "NOT_FOUND", 0, "127.0.0.1:1234")
+	log.Command("delete", "other", "NOT_FOUND", 0, "127.0.0.1:1234")
+	for _, expected := range []string{" type=item_store cmd=set key=key status=STORED size=5 client=127.0.0.1:1234\r\n",
+									  " type=eviction key=key size=5\r\n"} {
+		if line := <-w.queue.Lines(); !strings.HasPrefix(line, "ts=") || !strings.HasSuffix(line, expected) {
+			t.Fatalf("Unexpected line: %q, expected %q", line, expected)
+		}
+	}
+	log.Changed(cache.EVENT_EVICT, "other", 7)
+	for _, expected := range []string{" type=skipped count=2\r\n", " type=eviction key=other size=7\r\n"} {
+		if line := <-w.queue.Lines(); !strings.HasSuffix(line, expected) {
+			t.Fatalf("Unexpected line: %q, expected %q", line, expected)
+		}
+	}
+}